@@ -5,24 +5,29 @@ go 1.12
 require (
 	github.com/Azure/go-ansiterm v0.0.0-20170929234023-d6e3b3328b78 // indirect
 	github.com/Microsoft/go-winio v0.4.14 // indirect
+	github.com/aws/aws-dax-go v1.1.4
 	github.com/aws/aws-sdk-go v1.24.1
 	github.com/docker/distribution v2.7.1+incompatible // indirect
 	github.com/docker/docker v1.13.1
 	github.com/docker/go-connections v0.4.0
 	github.com/docker/go-units v0.4.0 // indirect
-	github.com/gogo/protobuf v1.3.0 // indirect
-	github.com/golang/protobuf v1.3.2
+	github.com/gofrs/uuid v4.4.0+incompatible // indirect
+	github.com/golang/protobuf v1.4.2 // minimum for go.opentelemetry.io/otel/exporters/otlp v0.13.0; APIv2-backed, generated Grafeas types use its compatibility layer
 	github.com/google/uuid v1.1.1
 	github.com/gorilla/mux v1.7.3 // indirect
 	github.com/grafeas/grafeas v0.1.3
 	github.com/morikuni/aec v0.0.0-20170113033406-39771216ff4c // indirect
 	github.com/opencontainers/go-digest v1.0.0-rc1 // indirect
 	github.com/opencontainers/image-spec v1.0.1 // indirect
-	github.com/sirupsen/logrus v1.4.2 // indirect
-	golang.org/x/net v0.0.0-20190918130420-a8b05e9114ab
-	golang.org/x/sys v0.0.0-20190919044723-0c1ff786ef13 // indirect
-	google.golang.org/genproto v0.0.0-20190916214212-f660b8655731
-	google.golang.org/grpc v1.23.1
+	github.com/prometheus/client_golang v1.2.1
+	github.com/sirupsen/logrus v1.4.2
+	go.opentelemetry.io/otel v0.13.0
+	go.opentelemetry.io/otel/exporters/otlp v0.13.0
+	go.opentelemetry.io/otel/exporters/stdout v0.13.0
+	go.opentelemetry.io/otel/sdk v0.13.0
+	golang.org/x/net v0.0.0-20191002035440-2ec189313ef0
+	google.golang.org/genproto v0.0.0-20200513103714-09dca8ec2884 // minimum for go.opentelemetry.io/otel/exporters/otlp v0.13.0
+	google.golang.org/grpc v1.32.0 // minimum for go.opentelemetry.io/otel/exporters/otlp v0.13.0
 	gopkg.in/yaml.v2 v2.2.4 // indirect
 	gotest.tools v2.2.0+incompatible // indirect
 )
@@ -0,0 +1,115 @@
+// Package awsrequest describes the DynamoDB calls made through the AWS SDK, for use by the handlers that
+// instrument them.
+package awsrequest
+
+import (
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ReturnConsumedCapacity sets ReturnConsumedCapacity to INDEXES on the input of the call, if it has that field
+// and it is not already set.  It is intended to be run as a Build handler.
+func ReturnConsumedCapacity(r *request.Request) {
+	input := reflect.ValueOf(r.Params)
+	if input.Kind() != reflect.Ptr || input.IsNil() {
+		return
+	}
+
+	field := input.Elem().FieldByName("ReturnConsumedCapacity")
+	if field.IsValid() && field.CanSet() && field.IsNil() {
+		field.Set(reflect.ValueOf(aws.String(dynamodb.ReturnConsumedCapacityIndexes)))
+	}
+}
+
+// ConsumedCapacity returns the ConsumedCapacity of the output of a call, which is either a single value or,
+// for batch and transactional calls, one per table.
+func ConsumedCapacity(output interface{}) []*dynamodb.ConsumedCapacity {
+	field := outputField(output, "ConsumedCapacity")
+	if !field.IsValid() {
+		return nil
+	}
+
+	switch capacity := field.Interface().(type) {
+	case *dynamodb.ConsumedCapacity:
+		if capacity != nil {
+			return []*dynamodb.ConsumedCapacity{capacity}
+		}
+	case []*dynamodb.ConsumedCapacity:
+		return capacity
+	}
+	return nil
+}
+
+// ItemCount returns the number of items returned by a call, if it returns items.
+func ItemCount(output interface{}) (int64, bool) {
+	if count := outputField(output, "Count"); count.IsValid() {
+		if n, ok := count.Interface().(*int64); ok && n != nil {
+			return *n, true
+		}
+	}
+
+	if items := outputField(output, "Items"); items.IsValid() && items.Kind() == reflect.Slice {
+		return int64(items.Len()), true
+	}
+
+	if item := outputField(output, "Item"); item.IsValid() && item.Kind() == reflect.Map {
+		if item.IsNil() {
+			return 0, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// TableName returns the table a call is made against.  Transactions are attributed to the table of their
+// first item.
+func TableName(r *request.Request) string {
+	if input, ok := r.Params.(*dynamodb.TransactWriteItemsInput); ok {
+		if len(input.TransactItems) == 0 {
+			return ""
+		}
+		item := input.TransactItems[0]
+		switch {
+		case item.Put != nil:
+			return aws.StringValue(item.Put.TableName)
+		case item.Delete != nil:
+			return aws.StringValue(item.Delete.TableName)
+		case item.Update != nil:
+			return aws.StringValue(item.Update.TableName)
+		case item.ConditionCheck != nil:
+			return aws.StringValue(item.ConditionCheck.TableName)
+		}
+		return ""
+	}
+
+	return inputString(r, "TableName")
+}
+
+// IndexName returns the index a query or scan is made against, or an empty string for calls against the table.
+func IndexName(r *request.Request) string {
+	return inputString(r, "IndexName")
+}
+
+func inputString(r *request.Request, name string) string {
+	input := reflect.ValueOf(r.Params)
+	if input.Kind() != reflect.Ptr || input.IsNil() {
+		return ""
+	}
+	if field := input.Elem().FieldByName(name); field.IsValid() {
+		if s, ok := field.Interface().(*string); ok {
+			return aws.StringValue(s)
+		}
+	}
+	return ""
+}
+
+func outputField(output interface{}, name string) reflect.Value {
+	value := reflect.ValueOf(output)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return value.Elem().FieldByName(name)
+}
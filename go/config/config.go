@@ -2,11 +2,157 @@ package config
 
 // DynamoDbConfig is the configuration for an AWS DynamoDB store.
 type DynamoDbConfig struct {
-	TableName string     `mapstructure:"table"`
-	AWS       *AwsConfig `mapstructure:"aws"`
+	TableName       string             `mapstructure:"table" json:"table"`
+	Namespace       string             `mapstructure:"namespace" json:"namespace"`
+	OccurrenceLinks string             `mapstructure:"occurrence_links" json:"occurrence_links"`
+	AWS             *AwsConfig         `mapstructure:"aws" json:"aws"`
+	Encryption      *EncryptionConfig  `mapstructure:"encryption" json:"encryption"`
+	Revisions       *RevisionsConfig   `mapstructure:"revisions" json:"revisions"`
+	Webhooks        []WebhookConfig    `mapstructure:"webhooks" json:"webhooks"`
+	ChangeFeed      *ChangeFeedConfig  `mapstructure:"change_feed" json:"change_feed"`
+	Cache           *CacheConfig       `mapstructure:"cache" json:"cache"`
+	Dax             *DaxConfig         `mapstructure:"dax" json:"dax"`
+	Metrics         *MetricsConfig     `mapstructure:"metrics" json:"metrics"`
+	Tracing         *TracingConfig     `mapstructure:"tracing" json:"tracing"`
+	Logging         *LoggingConfig     `mapstructure:"logging" json:"logging"`
+	Health          *HealthConfig      `mapstructure:"health" json:"health"`
+	Consistency     *ConsistencyConfig `mapstructure:"consistency" json:"consistency"`
+	Targets         []TargetConfig     `mapstructure:"targets" json:"targets"`
+	Routes          []RouteConfig      `mapstructure:"routes" json:"routes"`
 }
 
+// AwsConfig describes how to reach AWS.  Credentials are taken from the SDK's default chain (environment, shared
+// files, then the container or instance role) unless static keys or a profile are given, and a role is assumed
+// with them if role_arn is set.
 type AwsConfig struct {
-	Endpoint *string `mapstructure:"endpoint"`
-	Region   *string `mapstructure:"region"`
+	Endpoint             *string     `mapstructure:"endpoint" json:"endpoint"`
+	Region               *string     `mapstructure:"region" json:"region"`
+	Profile              string      `mapstructure:"profile" json:"profile"`                                 // Named profile of the shared config and credentials files
+	AccessKeyID          string      `mapstructure:"access_key_id" json:"access_key_id"`                     // Static access key, e.g. for a local stand-in of DynamoDB
+	SecretAccessKey      string      `mapstructure:"secret_access_key" json:"secret_access_key"`             // Secret of the static access key
+	SessionToken         string      `mapstructure:"session_token" json:"session_token"`                     // Session token of temporary static keys
+	RoleArn              string      `mapstructure:"role_arn" json:"role_arn"`                               // Role assumed with the credentials above, or with the web identity token
+	ExternalID           string      `mapstructure:"external_id" json:"external_id"`                         // External ID required by the trust policy of the role
+	RoleSessionName      string      `mapstructure:"role_session_name" json:"role_session_name"`             // Name of the role session, shown in CloudTrail.  Defaults to "grafeas-dynamodb"
+	WebIdentityTokenFile string      `mapstructure:"web_identity_token_file" json:"web_identity_token_file"` // Path to an OIDC token exchanged for the role, e.g. on EKS
+	StsEndpoint          string      `mapstructure:"sts_endpoint" json:"sts_endpoint"`                       // STS endpoint used to assume the role.  Defaults to that of the region
+	HTTP                 *HTTPConfig `mapstructure:"http" json:"http"`                                       // Tuning of the HTTP client that AWS is called with
+}
+
+// HTTPConfig tunes the HTTP client that AWS is called with, e.g. to reach a VPC endpoint through a proxy that
+// presents a certificate of a private CA.
+type HTTPConfig struct {
+	ConnectTimeout      string `mapstructure:"connect_timeout" json:"connect_timeout"`                 // Time allowed to connect and, separately, to complete the TLS handshake, e.g. "5s".  Defaults to "30s" and "10s"
+	ResponseTimeout     string `mapstructure:"response_timeout" json:"response_timeout"`               // Time allowed for the response headers once a request is sent, e.g. "10s".  No limit if empty
+	MaxIdleConnsPerHost int    `mapstructure:"max_idle_conns_per_host" json:"max_idle_conns_per_host"` // Idle connections kept open to each host.  Defaults to 2
+	ProxyURL            string `mapstructure:"proxy_url" json:"proxy_url"`                             // Proxy to connect through.  Defaults to the HTTPS_PROXY and NO_PROXY variables
+	CABundle            string `mapstructure:"ca_bundle" json:"ca_bundle"`                             // PEM file of the CAs trusted in place of the system's.  Takes precedence over AWS_CA_BUNDLE
+	ClientCertificate   string `mapstructure:"client_certificate" json:"client_certificate"`           // PEM file of the certificate presented to the endpoint
+	ClientKey           string `mapstructure:"client_key" json:"client_key"`                           // PEM file of the key of the client certificate
+}
+
+// TargetConfig is an additional table that projects can be routed to.  Settings that are not given are taken
+// from the top level of DynamoDbConfig.
+type TargetConfig struct {
+	Name       string            `mapstructure:"name" json:"name"`             // Identifies the target in routes.  "default" names the top level table
+	TableName  string            `mapstructure:"table" json:"table"`           // Name of the table
+	AWS        *AwsConfig        `mapstructure:"aws" json:"aws"`               // How to reach the table, e.g. in another region
+	Encryption *EncryptionConfig `mapstructure:"encryption" json:"encryption"` // How the table's payloads are encrypted
+}
+
+// RouteConfig sends the projects it matches to a target.  Routes are tried in order, and projects matched by
+// none are stored in the top level table.
+type RouteConfig struct {
+	Target        string `mapstructure:"target" json:"target"`                 // Name of the target
+	ProjectPrefix string `mapstructure:"project_prefix" json:"project_prefix"` // Matches project IDs starting with this prefix
+	ProjectRegex  string `mapstructure:"project_regex" json:"project_regex"`   // Matches project IDs matching this regular expression
+}
+
+// EncryptionConfig enables client-side envelope encryption of stored payloads.
+type EncryptionConfig struct {
+	Provider string `mapstructure:"provider" json:"provider"`     // Either "kms" or "local"
+	KmsKeyId string `mapstructure:"kms_key_id" json:"kms_key_id"` // KMS key ID, ARN or alias, used by the "kms" provider
+	KeyFile  string `mapstructure:"key_file" json:"key_file"`     // Path to the key file, used by the "local" provider
+}
+
+// RevisionsConfig controls the revision history kept for notes and occurrences.
+type RevisionsConfig struct {
+	Retention string `mapstructure:"retention" json:"retention"` // How long revisions are kept, e.g. "2160h".  Kept forever if empty
+}
+
+// CacheConfig enables an in-process cache of projects and notes.
+type CacheConfig struct {
+	Size int    `mapstructure:"size" json:"size"` // Maximum number of projects and notes cached.  Disabled if 0
+	TTL  string `mapstructure:"ttl" json:"ttl"`   // How long an entry is cached, e.g. "1m".  30s if empty
+}
+
+// DaxConfig routes eventually consistent queries through a DynamoDB Accelerator cluster.
+type DaxConfig struct {
+	Endpoint string `mapstructure:"endpoint" json:"endpoint"` // Cluster discovery endpoint, as host:port
+}
+
+// MetricsConfig configures the Prometheus metrics listener.
+type MetricsConfig struct {
+	Address string `mapstructure:"address" json:"address"` // Address to serve /metrics on, e.g. ":9090"
+}
+
+// TracingConfig exports OpenTelemetry spans for storage methods and the DynamoDB calls they make.
+type TracingConfig struct {
+	Exporter    string            `mapstructure:"exporter" json:"exporter"`         // One of "otlp", "stdout" or "file"
+	Endpoint    string            `mapstructure:"endpoint" json:"endpoint"`         // OTLP collector address, as host:port
+	Insecure    bool              `mapstructure:"insecure" json:"insecure"`         // Connect to the OTLP collector without TLS
+	Headers     map[string]string `mapstructure:"headers" json:"headers"`           // Headers sent to the OTLP collector
+	Path        string            `mapstructure:"path" json:"path"`                 // File the "file" exporter appends spans to
+	ServiceName string            `mapstructure:"service_name" json:"service_name"` // Defaults to "grafeas"
+	SampleRatio *float64          `mapstructure:"sample_ratio" json:"sample_ratio"` // Fraction of new traces sampled, defaults to 1
+}
+
+// WebhookConfig describes an endpoint that is sent occurrence events.
+type WebhookConfig struct {
+	Name           string   `mapstructure:"name" json:"name"`                       // Identifies the endpoint in logs and dead letters
+	URL            string   `mapstructure:"url" json:"url"`                         // Events are POSTed to this URL
+	Secret         string   `mapstructure:"secret" json:"secret"`                   // HMAC-SHA256 key used to sign each request body
+	Projects       []string `mapstructure:"projects" json:"projects"`               // Project IDs to send events for.  All projects if empty
+	NoteKinds      []string `mapstructure:"note_kinds" json:"note_kinds"`           // Occurrence kinds to send events for, e.g. "VULNERABILITY".  All kinds if empty
+	MinSeverity    string   `mapstructure:"min_severity" json:"min_severity"`       // Lowest vulnerability severity to send events for, e.g. "HIGH"
+	MaxAttempts    int      `mapstructure:"max_attempts" json:"max_attempts"`       // Attempts before a delivery is dead-lettered.  Defaults to 10
+	InitialBackoff string   `mapstructure:"initial_backoff" json:"initial_backoff"` // Delay before the first retry, doubled on each attempt.  Defaults to "1s"
+}
+
+// ChangeFeedConfig runs a consumer of the table's stream, delivering the changes made to projects, notes and
+// occurrences to sinks.
+type ChangeFeedConfig struct {
+	StreamArn    string             `mapstructure:"stream_arn" json:"stream_arn"`       // Stream of the top level table.  Defaults to its latest stream; routed targets always read their latest
+	ConsumerName string             `mapstructure:"consumer_name" json:"consumer_name"` // Distinguishes the checkpoints of consumers of the same stream.  Defaults to "grafeas"
+	PollInterval string             `mapstructure:"poll_interval" json:"poll_interval"` // How long to wait between passes once caught up, e.g. "5s".  Defaults to "1s"
+	Sinks        []ChangeSinkConfig `mapstructure:"sinks" json:"sinks"`                 // Where events are delivered, in order
+}
+
+// ChangeSinkConfig is a destination of change feed events.
+type ChangeSinkConfig struct {
+	Type   string `mapstructure:"type" json:"type"`     // Either "log" or "http"
+	URL    string `mapstructure:"url" json:"url"`       // Events are POSTed to this URL by the "http" sink
+	Secret string `mapstructure:"secret" json:"secret"` // HMAC-SHA256 key used to sign each request body of the "http" sink
+}
+
+// LoggingConfig configures the store's structured logger.
+type LoggingConfig struct {
+	Level          string `mapstructure:"level" json:"level"`                     // One of "debug", "info", "warn" or "error", defaults to "info"
+	Format         string `mapstructure:"format" json:"format"`                   // "text" or "json", defaults to "text"
+	RedactPayloads *bool  `mapstructure:"redact_payloads" json:"redact_payloads"` // Omit stored payloads from log entries, defaults to true
+}
+
+// HealthConfig configures the health and readiness listeners.
+type HealthConfig struct {
+	Address        string `mapstructure:"address" json:"address"`                 // Address to serve /healthz and /readyz on, e.g. ":8081"
+	GrpcAddress    string `mapstructure:"grpc_address" json:"grpc_address"`       // Address to serve the gRPC health service on, e.g. ":8082"
+	Interval       string `mapstructure:"interval" json:"interval"`               // How often the table is checked, defaults to "10s"
+	ThrottleWindow string `mapstructure:"throttle_window" json:"throttle_window"` // How long the store is degraded after a throttled call, defaults to "1m"
+}
+
+// ConsistencyConfig configures the background check of the rows linking occurrences to their notes.
+type ConsistencyConfig struct {
+	Interval string `mapstructure:"interval" json:"interval"` // How often the table is checked, defaults to "24h"
+	Segments int    `mapstructure:"segments" json:"segments"` // Segments of the table scanned in parallel, defaults to 4
+	Repair   bool   `mapstructure:"repair" json:"repair"`     // Repair the problems found, rather than only reporting them
 }
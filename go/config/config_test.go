@@ -59,6 +59,10 @@ func TestAWSConfigParsesOk(t *testing.T) {
 		t.Errorf("Unable to create DynamoDB from parsed configuration file, %s", err)
 	}
 
+	if dynamodbConfig.TableName != "Name_of_table_to_use_within_DynamoDB" {
+		t.Errorf("Table is incorrect, got '%s', expected 'Name_of_table_to_use_within_DynamoDB'", dynamodbConfig.TableName)
+	}
+
 	awsConfig := aws.Config{}
 	err = grafeasConfig.ConvertGenericConfigToSpecificType(dynamodbConfig.AWS, &awsConfig)
 	if err != nil {
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix prefixes the environment variables that override the settings of the configuration file.
+const EnvPrefix = "GRAFEAS_DYNAMODB"
+
+// ApplyEnvironment overrides the settings of c with the GRAFEAS_DYNAMODB_* variables of environ, given as
+// "KEY=value" as returned by os.Environ.  A setting's variable is named by its path in the configuration file,
+// upper cased and joined by underscores, e.g. GRAFEAS_DYNAMODB_AWS_REGION.  Elements of lists are addressed by
+// index, e.g. GRAFEAS_DYNAMODB_WEBHOOKS_0_URL, and may be added beyond the end of the list.  Lists of strings are
+// given comma separated, and maps as comma separated key=value pairs.  Variables that name no setting are
+// reported, so that a typo is not silently ignored.
+func (c *DynamoDbConfig) ApplyEnvironment(environ []string) error {
+	vars := map[string]string{}
+	for _, variable := range environ {
+		i := strings.Index(variable, "=")
+		if i > 0 && strings.HasPrefix(variable, EnvPrefix+"_") {
+			vars[variable[:i]] = variable[i+1:]
+		}
+	}
+
+	var errs Errors
+	applyEnvironment(reflect.ValueOf(c).Elem(), EnvPrefix, vars, &errs)
+
+	var unknown []string
+	for name := range vars {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs.addf("%s does not name a setting", name)
+	}
+
+	return errs.err()
+}
+
+// applyEnvironment sets v, or the settings within it, from the variables named after it, removing those used
+// from vars.
+func applyEnvironment(v reflect.Value, name string, vars map[string]string, errs *Errors) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			if !hasVariables(vars, name) {
+				return
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		applyEnvironment(v.Elem(), name, vars, errs)
+		return
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if key := v.Type().Field(i).Tag.Get("mapstructure"); key != "" {
+				applyEnvironment(v.Field(i), name+"_"+strings.ToUpper(key), vars, errs)
+			}
+		}
+		return
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			break
+		}
+		length := v.Len()
+		for hasVariables(vars, fmt.Sprintf("%s_%d", name, length)) {
+			length++
+		}
+		if length > v.Len() {
+			grown := reflect.MakeSlice(v.Type(), length, length)
+			reflect.Copy(grown, v)
+			v.Set(grown)
+		}
+		for i := 0; i < v.Len(); i++ {
+			applyEnvironment(v.Index(i), fmt.Sprintf("%s_%d", name, i), vars, errs)
+		}
+		return
+	}
+
+	value, ok := vars[name]
+	if !ok {
+		return
+	}
+	delete(vars, name)
+	if err := setValue(v, value); err != nil {
+		errs.addf("%s=%q %s", name, value, err)
+	}
+}
+
+// hasVariables reports whether any variable sets name or a setting within it.
+func hasVariables(vars map[string]string, name string) bool {
+	for variable := range vars {
+		if variable == name || strings.HasPrefix(variable, name+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("is not a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("is not an integer")
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("is not a number")
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var values []string
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	case reflect.Map:
+		pairs := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			i := strings.Index(pair, "=")
+			if i < 1 {
+				return errors.New("must be comma separated key=value pairs")
+			}
+			pairs[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+		}
+		v.Set(reflect.ValueOf(pairs))
+	default:
+		return errors.New("cannot be set from the environment")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	c := &config.DynamoDbConfig{
+		TableName: "from_file",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-1"), Profile: "dev"},
+		Webhooks:  []config.WebhookConfig{{Name: "security", URL: "https://example.com/a"}},
+	}
+
+	err := c.ApplyEnvironment([]string{
+		"HOME=/root",
+		"GRAFEAS_DYNAMODB_TABLE=from_env",
+		"GRAFEAS_DYNAMODB_AWS_REGION=eu-west-2",
+		"GRAFEAS_DYNAMODB_CACHE_SIZE=100",
+		"GRAFEAS_DYNAMODB_LOGGING_REDACT_PAYLOADS=false",
+		"GRAFEAS_DYNAMODB_TRACING_SAMPLE_RATIO=0.25",
+		"GRAFEAS_DYNAMODB_TRACING_HEADERS=api-key=secret, tenant=a",
+		"GRAFEAS_DYNAMODB_WEBHOOKS_0_PROJECTS=p1, p2",
+		"GRAFEAS_DYNAMODB_WEBHOOKS_1_NAME=audit",
+		"GRAFEAS_DYNAMODB_WEBHOOKS_1_URL=https://example.com/b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.TableName != "from_env" || aws.StringValue(c.AWS.Region) != "eu-west-2" || c.AWS.Profile != "dev" {
+		t.Errorf("Expected the environment to override the file, got %+v %+v", c, c.AWS)
+	}
+	if c.Cache == nil || c.Cache.Size != 100 {
+		t.Errorf("Expected the cache section to be created, got %+v", c.Cache)
+	}
+	if c.Logging == nil || c.Logging.RedactPayloads == nil || *c.Logging.RedactPayloads {
+		t.Errorf("Expected payloads not to be redacted, got %+v", c.Logging)
+	}
+	if c.Tracing == nil || *c.Tracing.SampleRatio != 0.25 ||
+		!reflect.DeepEqual(c.Tracing.Headers, map[string]string{"api-key": "secret", "tenant": "a"}) {
+		t.Errorf("Unexpected tracing settings, %+v", c.Tracing)
+	}
+	if len(c.Webhooks) != 2 || !reflect.DeepEqual(c.Webhooks[0].Projects, []string{"p1", "p2"}) ||
+		c.Webhooks[0].URL != "https://example.com/a" || c.Webhooks[1].Name != "audit" {
+		t.Errorf("Expected the first webhook to be changed and a second added, got %+v", c.Webhooks)
+	}
+}
+
+func TestApplyEnvironmentReportsEveryError(t *testing.T) {
+	c := &config.DynamoDbConfig{}
+	err := c.ApplyEnvironment([]string{
+		"GRAFEAS_DYNAMODB_CACHE_SIZE=lots",
+		"GRAFEAS_DYNAMODB_TABEL=typo",
+		"GRAFEAS_DYNAMODB_WEBHOOKS_2_NAME=gap",
+	})
+
+	errs, ok := err.(config.Errors)
+	if !ok || len(errs) != 3 {
+		t.Errorf("Expected three errors, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Errors lists every problem found in a configuration, so that they can all be fixed at once.
+type Errors []string
+
+func (e Errors) Error() string {
+	return fmt.Sprintf("Invalid dynamodb configuration: %s", strings.Join(e, "; "))
+}
+
+// err returns nil if there are no problems, so that an empty list is not mistaken for an error.
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *Errors) addf(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func (e *Errors) duration(field, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := time.ParseDuration(value); err != nil {
+		e.addf("%s %q is not a duration, e.g. \"30s\"", field, value)
+	}
+}
+
+// tableNamePattern is the naming rule of DynamoDB tables.
+var tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+// namespaceSeparators separate the parts of the store's keys and page tokens, so cannot appear in a namespace.
+const namespaceSeparators = "#&"
+
+// targetTokenSeparator separates the target from the rest of a page token listing across targets.
+const targetTokenSeparator = "|"
+
+// MaxWebhooks is the number of webhooks that can be configured.  A delivery for each webhook matching an
+// occurrence is written in the transaction that changes the occurrence, whose own rows take up to 4 of the 25
+// items DynamoDB allows in a transaction: the occurrence, its note link, its revision and the link to a note
+// it is moving from.
+const MaxWebhooks = 21
+
+var logLevels = []string{"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"}
+
+// Validate reports every setting that is missing, malformed or inconsistent with another.
+func (c *DynamoDbConfig) Validate() error {
+	var errs Errors
+
+	errs.table("table", c.TableName)
+	if strings.ContainsAny(c.Namespace, namespaceSeparators) {
+		errs.addf("namespace %q must not contain any of %q", c.Namespace, namespaceSeparators)
+	}
+	switch c.OccurrenceLinks {
+	case "", "full", "slim":
+	default:
+		errs.addf("occurrence_links %q must be \"full\" or \"slim\"", c.OccurrenceLinks)
+	}
+	if c.AWS != nil {
+		c.AWS.validate(&errs, "aws")
+	}
+	if c.Encryption != nil {
+		c.Encryption.validate(&errs, "encryption")
+	}
+	if c.Revisions != nil {
+		errs.duration("revisions.retention", c.Revisions.Retention)
+	}
+
+	if len(c.Webhooks) > MaxWebhooks {
+		errs.addf("webhooks must have at most %d webhooks, found %d", MaxWebhooks, len(c.Webhooks))
+	}
+	for i, webhook := range c.Webhooks {
+		field := fmt.Sprintf("webhooks[%d]", i)
+		if webhook.Name == "" {
+			errs.addf("%s.name must be set", field)
+		}
+		if u, err := url.Parse(webhook.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs.addf("%s.url %q must be an absolute URL", field, webhook.URL)
+		}
+		if webhook.MaxAttempts < 0 {
+			errs.addf("%s.max_attempts must not be negative", field)
+		}
+		errs.duration(field+".initial_backoff", webhook.InitialBackoff)
+	}
+
+	if c.ChangeFeed != nil {
+		errs.duration("change_feed.poll_interval", c.ChangeFeed.PollInterval)
+		if strings.Contains(c.ChangeFeed.ConsumerName, "#") {
+			errs.addf("change_feed.consumer_name %q must not contain \"#\"", c.ChangeFeed.ConsumerName)
+		}
+		if len(c.ChangeFeed.Sinks) == 0 {
+			errs.addf("change_feed.sinks must have at least one sink")
+		}
+		for i, sink := range c.ChangeFeed.Sinks {
+			field := fmt.Sprintf("change_feed.sinks[%d]", i)
+			switch sink.Type {
+			case "log":
+			case "http":
+				if u, err := url.Parse(sink.URL); err != nil || u.Scheme == "" || u.Host == "" {
+					errs.addf("%s.url %q must be an absolute URL", field, sink.URL)
+				}
+			default:
+				errs.addf("%s.type %q must be \"log\" or \"http\"", field, sink.Type)
+			}
+		}
+	}
+
+	if c.Cache != nil {
+		if c.Cache.Size < 0 {
+			errs.addf("cache.size must not be negative")
+		}
+		errs.duration("cache.ttl", c.Cache.TTL)
+	}
+	if c.Dax != nil && c.Dax.Endpoint == "" {
+		errs.addf("dax.endpoint must be set")
+	}
+	if c.Metrics != nil && c.Metrics.Address == "" {
+		errs.addf("metrics.address must be set")
+	}
+
+	if c.Tracing != nil {
+		switch c.Tracing.Exporter {
+		case "", "otlp", "stdout":
+		case "file":
+			if c.Tracing.Path == "" {
+				errs.addf("tracing.path must be set for the file exporter")
+			}
+		default:
+			errs.addf("tracing.exporter %q must be one of \"otlp\", \"stdout\" or \"file\"", c.Tracing.Exporter)
+		}
+		if c.Tracing.SampleRatio != nil && (*c.Tracing.SampleRatio < 0 || *c.Tracing.SampleRatio > 1) {
+			errs.addf("tracing.sample_ratio must be between 0 and 1, got %v", *c.Tracing.SampleRatio)
+		}
+	}
+
+	if c.Logging != nil {
+		if c.Logging.Level != "" && !contains(logLevels, strings.ToLower(c.Logging.Level)) {
+			errs.addf("logging.level %q must be one of %s", c.Logging.Level, strings.Join(logLevels, ", "))
+		}
+		if format := strings.ToLower(c.Logging.Format); format != "" && format != "text" && format != "json" {
+			errs.addf("logging.format %q must be \"text\" or \"json\"", c.Logging.Format)
+		}
+	}
+
+	if c.Health != nil {
+		errs.duration("health.interval", c.Health.Interval)
+		errs.duration("health.throttle_window", c.Health.ThrottleWindow)
+	}
+
+	if c.Consistency != nil {
+		errs.duration("consistency.interval", c.Consistency.Interval)
+		if c.Consistency.Segments < 0 {
+			errs.addf("consistency.segments must not be negative, got %d", c.Consistency.Segments)
+		}
+	}
+
+	targets := map[string]bool{"default": true}
+	for i, target := range c.Targets {
+		field := fmt.Sprintf("targets[%d]", i)
+		switch {
+		case target.Name == "" || strings.Contains(target.Name, targetTokenSeparator):
+			errs.addf("%s.name %q must be set and not contain %q", field, target.Name, targetTokenSeparator)
+		case targets[target.Name]:
+			errs.addf("%s.name %q is already used", field, target.Name)
+		}
+		targets[target.Name] = true
+		errs.table(field+".table", target.TableName)
+		if target.AWS != nil {
+			target.AWS.validate(&errs, field+".aws")
+		}
+		if target.Encryption != nil {
+			target.Encryption.validate(&errs, field+".encryption")
+		}
+	}
+
+	for i, route := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+		if !targets[route.Target] {
+			errs.addf("%s.target %q is not a target", field, route.Target)
+		}
+		if (route.ProjectPrefix == "") == (route.ProjectRegex == "") {
+			errs.addf("%s must have exactly one of project_prefix and project_regex", field)
+		}
+		if _, err := regexp.Compile(route.ProjectRegex); err != nil {
+			errs.addf("%s.project_regex is not a regular expression, %s", field, err)
+		}
+	}
+
+	return errs.err()
+}
+
+// Validate reports credential settings that are incomplete or cannot be used together, and a malformed
+// endpoint.
+func (c *AwsConfig) Validate() error {
+	var errs Errors
+	c.validate(&errs, "aws")
+	return errs.err()
+}
+
+func (c *AwsConfig) validate(errs *Errors, field string) {
+	if endpoint := stringValue(c.Endpoint); endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" {
+			errs.addf("%s.endpoint %q must be a URL, e.g. \"http://localhost:8000\"", field, endpoint)
+		}
+	}
+	if (c.AccessKeyID == "") != (c.SecretAccessKey == "") {
+		errs.addf("%s.access_key_id and %[1]s.secret_access_key must be set together", field)
+	}
+	if c.AccessKeyID != "" && c.Profile != "" {
+		errs.addf("only one of %s.access_key_id and %[1]s.profile may be set", field)
+	}
+	if c.RoleArn == "" && (c.WebIdentityTokenFile != "" || c.ExternalID != "" || c.RoleSessionName != "") {
+		errs.addf("%s.role_arn must be set to use web_identity_token_file, external_id or role_session_name", field)
+	}
+	if c.WebIdentityTokenFile != "" && c.ExternalID != "" {
+		errs.addf("%s.external_id cannot be used with web_identity_token_file", field)
+	}
+	if c.StsEndpoint != "" {
+		if u, err := url.Parse(c.StsEndpoint); err != nil || u.Scheme == "" {
+			errs.addf("%s.sts_endpoint %q must be a URL", field, c.StsEndpoint)
+		}
+	}
+	if c.HTTP != nil {
+		c.HTTP.validate(errs, field+".http")
+	}
+}
+
+func (c *HTTPConfig) validate(errs *Errors, field string) {
+	errs.duration(field+".connect_timeout", c.ConnectTimeout)
+	errs.duration(field+".response_timeout", c.ResponseTimeout)
+	if c.MaxIdleConnsPerHost < 0 {
+		errs.addf("%s.max_idle_conns_per_host must not be negative", field)
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs.addf("%s.proxy_url %q must be an absolute URL, e.g. \"http://proxy:3128\"", field, c.ProxyURL)
+		}
+	}
+	if (c.ClientCertificate == "") != (c.ClientKey == "") {
+		errs.addf("%s.client_certificate and %[1]s.client_key must be set together", field)
+	}
+}
+
+func (c *EncryptionConfig) validate(errs *Errors, field string) {
+	switch c.Provider {
+	case "":
+	case "kms":
+		if c.KmsKeyId == "" {
+			errs.addf("%s.kms_key_id must be set for the kms provider", field)
+		}
+	case "local":
+		if c.KeyFile == "" {
+			errs.addf("%s.key_file must be set for the local provider", field)
+		}
+	default:
+		errs.addf("%s.provider %q must be \"kms\" or \"local\"", field, c.Provider)
+	}
+}
+
+func (e *Errors) table(field, name string) {
+	switch {
+	case name == "":
+		e.addf("%s must be set", field)
+	case !tableNamePattern.MatchString(name):
+		e.addf("%s %q must be 3 to 255 letters, digits, '_', '-' or '.'", field, name)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
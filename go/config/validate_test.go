@@ -0,0 +1,89 @@
+package config_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+)
+
+// webhooks returns the given number of valid webhooks.
+func webhooks(count int) []config.WebhookConfig {
+	var webhooks []config.WebhookConfig
+	for i := 0; i < count; i++ {
+		webhooks = append(webhooks, config.WebhookConfig{Name: fmt.Sprintf("hook-%d", i), URL: "https://example.com/grafeas"})
+	}
+	return webhooks
+}
+
+func TestValidate(t *testing.T) {
+	valid := &config.DynamoDbConfig{
+		TableName: "grafeas",
+		Webhooks:  webhooks(config.MaxWebhooks),
+		AWS:       &config.AwsConfig{RoleArn: "arn:aws:iam::123456789012:role/grafeas", ExternalID: "shared-secret"},
+		Targets:   []config.TargetConfig{{Name: "regulated", TableName: "grafeas_regulated"}},
+		Routes:    []config.RouteConfig{{Target: "regulated", ProjectPrefix: "secure-"}},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected the configuration to be valid, got %s", err)
+	}
+
+	for _, test := range []struct {
+		config *config.DynamoDbConfig
+		errors []string
+	}{
+		{
+			config: &config.DynamoDbConfig{},
+			errors: []string{"table must be set"},
+		},
+		{
+			config: &config.DynamoDbConfig{
+				TableName:       "a",
+				Namespace:       "dev#1",
+				OccurrenceLinks: "none",
+				AWS:             &config.AwsConfig{AccessKeyID: "AKID", WebIdentityTokenFile: "/var/run/token"},
+				ChangeFeed:      &config.ChangeFeedConfig{PollInterval: "often", Sinks: []config.ChangeSinkConfig{{Type: "http", URL: "example.com"}, {Type: "kafka"}}},
+				Cache:           &config.CacheConfig{TTL: "a while"},
+				Consistency:     &config.ConsistencyConfig{Interval: "daily", Segments: -1},
+			},
+			errors: []string{
+				"table", "namespace", "occurrence_links", "aws.access_key_id", "aws.role_arn", "change_feed.poll_interval",
+				"change_feed.sinks[0].url", "change_feed.sinks[1].type", "cache.ttl", "consistency.interval", "consistency.segments",
+			},
+		},
+		{
+			config: &config.DynamoDbConfig{
+				TableName:  "grafeas",
+				Encryption: &config.EncryptionConfig{Provider: "kms"},
+				Webhooks:   []config.WebhookConfig{{URL: "example.com"}},
+				Logging:    &config.LoggingConfig{Level: "loud", Format: "xml"},
+				Targets:    []config.TargetConfig{{Name: "default", TableName: "grafeas_2", AWS: &config.AwsConfig{Profile: "dev", AccessKeyID: "AKID", SecretAccessKey: "secret"}}},
+				Routes:     []config.RouteConfig{{Target: "missing", ProjectRegex: "("}},
+			},
+			errors: []string{
+				"encryption.kms_key_id", "webhooks[0].name", "webhooks[0].url", "logging.level", "logging.format",
+				"targets[0].name", "targets[0].aws", "routes[0].target", "routes[0].project_regex",
+			},
+		},
+		{
+			config: &config.DynamoDbConfig{
+				TableName: "grafeas",
+				Webhooks:  webhooks(config.MaxWebhooks + 1),
+			},
+			errors: []string{"webhooks must have at most 21"},
+		},
+	} {
+		err := test.config.Validate()
+		errs, ok := err.(config.Errors)
+		if !ok || len(errs) != len(test.errors) {
+			t.Errorf("Expected %d errors, got %v", len(test.errors), err)
+			continue
+		}
+		for i, field := range test.errors {
+			if !strings.Contains(errs[i], field) {
+				t.Errorf("Expected error %d to be about %s, got %q", i, field, errs[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeyProvider generates and unwraps the per-item data keys used for envelope encryption.
+type KeyProvider interface {
+	// KeyID returns the ID of the master key currently used to wrap new data keys.
+	KeyID() string
+	// GenerateDataKey returns a new plaintext data key together with that key wrapped by the current master key.
+	GenerateDataKey() (plaintext []byte, wrapped []byte, err error)
+	// DecryptDataKey unwraps a data key that was wrapped by the master key with the given ID.
+	DecryptDataKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Envelope is a payload encrypted with a data key, alongside that data key wrapped by a master key.
+type Envelope struct {
+	KeyID        string
+	EncryptedKey []byte
+	Ciphertext   []byte
+}
+
+// Encrypt seals plaintext under a freshly generated data key.
+func Encrypt(provider KeyProvider, plaintext []byte) (*Envelope, error) {
+	dataKey, wrapped, err := provider.GenerateDataKey()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to generate data key, %s", err))
+	}
+
+	ciphertext, err := seal(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		KeyID:        provider.KeyID(),
+		EncryptedKey: wrapped,
+		Ciphertext:   ciphertext,
+	}, nil
+}
+
+// Decrypt opens an envelope produced by Encrypt.
+func Decrypt(provider KeyProvider, envelope *Envelope) ([]byte, error) {
+	dataKey, err := provider.DecryptDataKey(envelope.KeyID, envelope.EncryptedKey)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to decrypt data key with key %s, %s", envelope.KeyID, err))
+	}
+
+	return open(dataKey, envelope.Ciphertext)
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the result with the random nonce.
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to generate nonce, %s", err))
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts ciphertext produced by seal.
+func open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("Ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to decrypt ciphertext, %s", err))
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid encryption key, %s", err))
+	}
+
+	return cipher.NewGCM(block)
+}
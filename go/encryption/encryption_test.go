@@ -0,0 +1,158 @@
+package encryption_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/encryption"
+)
+
+func writeKeyFile(keyIDs ...string) string {
+	file, err := ioutil.TempFile("", "keys.*.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = file.WriteString("# test keys\n")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i, keyID := range keyIDs {
+		key := bytes.Repeat([]byte{byte(i + 1)}, 32)
+		_, err = file.WriteString(fmt.Sprintf("%s %s\n", keyID, base64.StdEncoding.EncodeToString(key)))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = file.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return file.Name()
+}
+
+func TestKeyFileProviderRoundTrip(t *testing.T) {
+	keyFile := writeKeyFile("key-1")
+	defer func() {
+		_ = os.Remove(keyFile)
+	}()
+
+	provider, err := encryption.NewKeyFileProvider(keyFile)
+	if err != nil {
+		t.Fatalf("Unable to create key file provider, %s", err)
+	}
+
+	plaintext := []byte(`{"name":"projects/p1/occurrences/o1"}`)
+	envelope, err := encryption.Encrypt(provider, plaintext)
+	if err != nil {
+		t.Fatalf("Unable to encrypt, %s", err)
+	}
+
+	if envelope.KeyID != "key-1" {
+		t.Errorf("KeyID is incorrect, got '%s', expected 'key-1'", envelope.KeyID)
+	}
+
+	if bytes.Contains(envelope.Ciphertext, plaintext) {
+		t.Error("Ciphertext contains the plaintext")
+	}
+
+	decrypted, err := encryption.Decrypt(provider, envelope)
+	if err != nil {
+		t.Fatalf("Unable to decrypt, %s", err)
+	}
+
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypted payload is incorrect, got '%s', expected '%s'", decrypted, plaintext)
+	}
+}
+
+func TestKeyFileProviderRotation(t *testing.T) {
+	oldKeyFile := writeKeyFile("key-1")
+	rotatedKeyFile := writeKeyFile("key-1", "key-2")
+	defer func() {
+		_ = os.Remove(oldKeyFile)
+		_ = os.Remove(rotatedKeyFile)
+	}()
+
+	oldProvider, err := encryption.NewKeyFileProvider(oldKeyFile)
+	if err != nil {
+		t.Fatalf("Unable to create key file provider, %s", err)
+	}
+
+	rotatedProvider, err := encryption.NewKeyFileProvider(rotatedKeyFile)
+	if err != nil {
+		t.Fatalf("Unable to create key file provider, %s", err)
+	}
+
+	if rotatedProvider.KeyID() != "key-2" {
+		t.Errorf("KeyID is incorrect, got '%s', expected 'key-2'", rotatedProvider.KeyID())
+	}
+
+	envelope, err := encryption.Encrypt(oldProvider, []byte("payload"))
+	if err != nil {
+		t.Fatalf("Unable to encrypt, %s", err)
+	}
+
+	decrypted, err := encryption.Decrypt(rotatedProvider, envelope)
+	if err != nil {
+		t.Fatalf("Unable to decrypt with rotated key file, %s", err)
+	}
+
+	if string(decrypted) != "payload" {
+		t.Errorf("Decrypted payload is incorrect, got '%s', expected 'payload'", decrypted)
+	}
+}
+
+func TestDecryptRejectsTamperedCiphertext(t *testing.T) {
+	keyFile := writeKeyFile("key-1")
+	defer func() {
+		_ = os.Remove(keyFile)
+	}()
+
+	provider, err := encryption.NewKeyFileProvider(keyFile)
+	if err != nil {
+		t.Fatalf("Unable to create key file provider, %s", err)
+	}
+
+	envelope, err := encryption.Encrypt(provider, []byte("payload"))
+	if err != nil {
+		t.Fatalf("Unable to encrypt, %s", err)
+	}
+
+	envelope.Ciphertext[len(envelope.Ciphertext)-1] ^= 0xff
+	if _, err = encryption.Decrypt(provider, envelope); err == nil {
+		t.Error("Expected tampered ciphertext to fail decryption")
+	}
+}
+
+func TestKeyFileProviderRejectsInvalidKeys(t *testing.T) {
+	file, err := ioutil.TempFile("", "keys.*.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	_, err = file.WriteString("key-1 dG9vIHNob3J0\n")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err = encryption.NewKeyFileProvider(file.Name()); err == nil {
+		t.Error("Expected key file with a short key to be rejected")
+	}
+}
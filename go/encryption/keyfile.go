@@ -0,0 +1,92 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// KeyFileProvider wraps data keys using master keys read from a local file.  It is intended for tests and
+// local development only.
+//
+// Each non-blank line of the file holds a key ID and a base64 encoded 256 bit key separated by whitespace;
+// lines starting with '#' are ignored.  The last key in the file is used to wrap new data keys, so keys are
+// rotated by appending a new line and keeping the old ones for decryption.
+type KeyFileProvider struct {
+	keys         map[string][]byte
+	currentKeyID string
+}
+
+// NewKeyFileProvider creates a KeyProvider from the key file at the given path.
+func NewKeyFileProvider(path string) (*KeyFileProvider, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read key file %s, %s", path, err))
+	}
+
+	provider := &KeyFileProvider{
+		keys: map[string][]byte{},
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid key file %s, line %d must contain a key ID and a key", path, lineNumber))
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, errors.New(fmt.Sprintf("Invalid key file %s, key on line %d must be 32 bytes encoded as base64", path, lineNumber))
+		}
+
+		provider.keys[fields[0]] = key
+		provider.currentKeyID = fields[0]
+	}
+
+	if provider.currentKeyID == "" {
+		return nil, errors.New(fmt.Sprintf("Key file %s does not contain any keys", path))
+	}
+
+	return provider, nil
+}
+
+// KeyID returns the ID of the last key in the key file.
+func (p *KeyFileProvider) KeyID() string {
+	return p.currentKeyID
+}
+
+// GenerateDataKey creates a random 256 bit data key and wraps it with the current master key.
+func (p *KeyFileProvider) GenerateDataKey() ([]byte, []byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := seal(p.keys[p.currentKeyID], dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dataKey, wrapped, nil
+}
+
+// DecryptDataKey unwraps a data key with the named master key.
+func (p *KeyFileProvider) DecryptDataKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Key %s is not present in the key file", keyID))
+	}
+
+	return open(key, wrapped)
+}
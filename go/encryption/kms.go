@@ -0,0 +1,59 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// KmsKeyProvider wraps data keys using an AWS KMS customer master key.
+type KmsKeyProvider struct {
+	kmsiface.KMSAPI
+	keyID string
+}
+
+// NewKmsKeyProvider creates a KeyProvider that generates data keys under the given KMS key ID, ARN or alias.
+func NewKmsKeyProvider(client kmsiface.KMSAPI, keyID string) (*KmsKeyProvider, error) {
+	if keyID == "" {
+		return nil, errors.New("A KMS key ID must be provided")
+	}
+
+	return &KmsKeyProvider{
+		KMSAPI: client,
+		keyID:  keyID,
+	}, nil
+}
+
+// KeyID returns the KMS key used to wrap new data keys.
+func (p *KmsKeyProvider) KeyID() string {
+	return p.keyID
+}
+
+// GenerateDataKey asks KMS for a new 256 bit data key.
+func (p *KmsKeyProvider) GenerateDataKey() ([]byte, []byte, error) {
+	output, err := p.KMSAPI.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.keyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+// DecryptDataKey asks KMS to unwrap a data key.  KMS embeds the master key within the wrapped blob, so the
+// key ID is only used for error reporting.
+func (p *KmsKeyProvider) DecryptDataKey(keyID string, wrapped []byte) ([]byte, error) {
+	output, err := p.KMSAPI.Decrypt(&kms.DecryptInput{
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("KMS was unable to decrypt data key wrapped by %s, %s", keyID, err))
+	}
+
+	return output.Plaintext, nil
+}
@@ -0,0 +1,109 @@
+package memdb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// BatchGetItemWithContext returns up to 100 items by their primary keys.  Every key is read, so there are
+// never unprocessed keys, and missing items are left out of the response.
+func (db *DB) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	count := 0
+	for _, keysAndAttributes := range input.RequestItems {
+		count += len(keysAndAttributes.Keys)
+	}
+	if count == 0 || count > 100 {
+		return nil, validationError("Too many items requested for the BatchGetItem call")
+	}
+
+	output := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]*dynamodb.AttributeValue{},
+		UnprocessedKeys: map[string]*dynamodb.KeysAndAttributes{},
+	}
+	for tableName, keysAndAttributes := range input.RequestItems {
+		t, err := db.table(aws.String(tableName))
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[string]bool{}
+		responses := []map[string]*dynamodb.AttributeValue{}
+		for _, k := range keysAndAttributes.Keys {
+			key, err := t.primaryKey(k)
+			if err != nil {
+				return nil, err
+			}
+			if seen[key] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[key] = true
+
+			if i, ok := t.items[key]; ok {
+				responses = append(responses, copyItem(i))
+			}
+		}
+		output.Responses[tableName] = responses
+	}
+	return output, nil
+}
+
+// BatchGetItem returns up to 100 items by their primary keys.
+func (db *DB) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	return db.BatchGetItemWithContext(aws.BackgroundContext(), input)
+}
+
+// BatchWriteItemWithContext puts and deletes up to 25 items unconditionally.  Every request is applied, so
+// there are never unprocessed items, but unlike a transaction the writes are not isolated from each other.
+func (db *DB) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	count := 0
+	for _, writeRequests := range input.RequestItems {
+		count += len(writeRequests)
+	}
+	if count == 0 || count > 25 {
+		return nil, validationError("Member must have length less than or equal to 25 and greater than or equal to 1")
+	}
+
+	var writes []*pendingWrite
+	keys := map[string]bool{}
+	for tableName, writeRequests := range input.RequestItems {
+		for _, writeRequest := range writeRequests {
+			var write *pendingWrite
+			var err error
+			switch {
+			case writeRequest.PutRequest != nil:
+				write, err = db.put(aws.String(tableName), writeRequest.PutRequest.Item, nil, nil, nil)
+			case writeRequest.DeleteRequest != nil:
+				write, err = db.delete(aws.String(tableName), writeRequest.DeleteRequest.Key, nil, nil, nil)
+			default:
+				err = validationError("A write request must have a PutRequest or a DeleteRequest")
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			qualifiedKey := tableName + "\x00" + write.key
+			if keys[qualifiedKey] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			keys[qualifiedKey] = true
+			writes = append(writes, write)
+		}
+	}
+
+	for _, write := range writes {
+		write.apply()
+	}
+	return &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}, nil
+}
+
+// BatchWriteItem puts and deletes up to 25 items unconditionally.
+func (db *DB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	return db.BatchWriteItemWithContext(aws.BackgroundContext(), input)
+}
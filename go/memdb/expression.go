@@ -0,0 +1,540 @@
+package memdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// expression is a parsed condition, filter or key condition expression.
+type expression interface {
+	evaluate(item map[string]*dynamodb.AttributeValue) (bool, error)
+}
+
+// operand is a value within an expression: an attribute path, an expression attribute value or size(path).
+type operand interface {
+	value(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue
+}
+
+// parseExpression parses a DynamoDB condition expression, resolving its expression attribute names and values.
+// An empty expression matches every item.
+func parseExpression(text *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (expression, error) {
+	if text == nil || strings.TrimSpace(*text) == "" {
+		return trueExpression{}, nil
+	}
+
+	tokens, err := tokenize(*text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, names: names, values: values}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.position < len(p.tokens) {
+		return nil, errors.New(fmt.Sprintf("Unexpected %q in expression %q", p.tokens[p.position], *text))
+	}
+
+	return e, nil
+}
+
+func tokenize(text string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',' || c == '=':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '<' || c == '>':
+			if i+1 < len(text) && (text[i+1] == '=' || (c == '<' && text[i+1] == '>')) {
+				tokens = append(tokens, text[i:i+2])
+				i += 2
+			} else {
+				tokens = append(tokens, string(c))
+				i++
+			}
+		case isWordCharacter(c):
+			start := i
+			for i < len(text) && isWordCharacter(text[i]) {
+				i++
+			}
+			tokens = append(tokens, text[start:i])
+		default:
+			return nil, errors.New(fmt.Sprintf("Invalid character %q in expression %q", c, text))
+		}
+	}
+	return tokens, nil
+}
+
+func isWordCharacter(c byte) bool {
+	return c == '_' || c == '#' || c == ':' || c == '.' || c == '[' || c == ']' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+type parser struct {
+	tokens   []string
+	position int
+	names    map[string]*string
+	values   map[string]*dynamodb.AttributeValue
+}
+
+func (p *parser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+func (p *parser) keyword(keyword string) bool {
+	if strings.EqualFold(p.peek(), keyword) {
+		p.position++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(token string) error {
+	if actual := p.next(); actual != token {
+		return errors.New(fmt.Sprintf("Expected %q in expression, got %q", token, actual))
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpression{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expression, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpression{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expression, error) {
+	if p.keyword("NOT") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpression{e}, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (expression, error) {
+	if p.peek() == "(" {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+
+	switch strings.ToLower(p.peek()) {
+	case "attribute_exists", "attribute_not_exists":
+		function := strings.ToLower(p.next())
+		arguments, err := p.parseArguments(1)
+		if err != nil {
+			return nil, err
+		}
+		return existsExpression{path: arguments[0], exists: function == "attribute_exists"}, nil
+	case "begins_with", "contains", "attribute_type":
+		function := strings.ToLower(p.next())
+		arguments, err := p.parseArguments(2)
+		if err != nil {
+			return nil, err
+		}
+		return functionExpression{function: function, arguments: arguments}, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("BETWEEN") {
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, errors.New("Expected AND in BETWEEN expression")
+		}
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return andExpression{comparison{">=", left, low}, comparison{"<=", left, high}}, nil
+	}
+
+	if p.keyword("IN") {
+		arguments, err := p.parseArguments(-1)
+		if err != nil {
+			return nil, err
+		}
+		return inExpression{left, arguments}, nil
+	}
+
+	comparator := p.next()
+	switch comparator {
+	case "=", "<>", "<", "<=", ">", ">=":
+	default:
+		return nil, errors.New(fmt.Sprintf("Expected a comparator in expression, got %q", comparator))
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return comparison{comparator, left, right}, nil
+}
+
+// parseArguments parses a parenthesised list of operands, of the given length or of any length if count < 0.
+func (p *parser) parseArguments(count int) ([]operand, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var arguments []operand
+	for {
+		argument, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+
+	if count >= 0 && len(arguments) != count {
+		return nil, errors.New(fmt.Sprintf("Expected %d arguments, got %d", count, len(arguments)))
+	}
+	return arguments, p.expect(")")
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, errors.New("Unexpected end of expression")
+	case strings.EqualFold(token, "size"):
+		arguments, err := p.parseArguments(1)
+		if err != nil {
+			return nil, err
+		}
+		return sizeOperand{arguments[0]}, nil
+	case strings.HasPrefix(token, ":"):
+		value, ok := p.values[token]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Expression attribute value %s is not defined", token))
+		}
+		return valueOperand{value}, nil
+	default:
+		var path []string
+		for _, element := range strings.Split(token, ".") {
+			if strings.HasPrefix(element, "#") {
+				name, ok := p.names[element]
+				if !ok {
+					return nil, errors.New(fmt.Sprintf("Expression attribute name %s is not defined", element))
+				}
+				element = *name
+			}
+			path = append(path, element)
+		}
+		return pathOperand(path), nil
+	}
+}
+
+type trueExpression struct{}
+
+func (trueExpression) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	return true, nil
+}
+
+type andExpression struct {
+	left, right expression
+}
+
+func (e andExpression) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	left, err := e.left.evaluate(item)
+	if err != nil || !left {
+		return false, err
+	}
+	return e.right.evaluate(item)
+}
+
+type orExpression struct {
+	left, right expression
+}
+
+func (e orExpression) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	left, err := e.left.evaluate(item)
+	if err != nil || left {
+		return left, err
+	}
+	return e.right.evaluate(item)
+}
+
+type notExpression struct {
+	e expression
+}
+
+func (e notExpression) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	result, err := e.e.evaluate(item)
+	return !result, err
+}
+
+type existsExpression struct {
+	path   operand
+	exists bool
+}
+
+func (e existsExpression) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	return (e.path.value(item) != nil) == e.exists, nil
+}
+
+type functionExpression struct {
+	function  string
+	arguments []operand
+}
+
+func (e functionExpression) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	subject := e.arguments[0].value(item)
+	argument := e.arguments[1].value(item)
+	if subject == nil || argument == nil {
+		return false, nil
+	}
+
+	switch e.function {
+	case "begins_with":
+		if subject.S != nil && argument.S != nil {
+			return strings.HasPrefix(*subject.S, *argument.S), nil
+		}
+		if subject.B != nil && argument.B != nil {
+			return bytes.HasPrefix(subject.B, argument.B), nil
+		}
+		return false, nil
+	case "contains":
+		if subject.S != nil && argument.S != nil {
+			return strings.Contains(*subject.S, *argument.S), nil
+		}
+		for _, element := range subject.L {
+			if equal(element, argument) {
+				return true, nil
+			}
+		}
+		if argument.S != nil {
+			for _, element := range subject.SS {
+				if *element == *argument.S {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	default: // attribute_type
+		if argument.S == nil {
+			return false, errors.New("attribute_type requires a string type")
+		}
+		return typeOf(subject) == *argument.S, nil
+	}
+}
+
+type inExpression struct {
+	left   operand
+	values []operand
+}
+
+func (e inExpression) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	left := e.left.value(item)
+	if left == nil {
+		return false, nil
+	}
+	for _, value := range e.values {
+		if right := value.value(item); right != nil && equal(left, right) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type comparison struct {
+	comparator  string
+	left, right operand
+}
+
+func (e comparison) evaluate(item map[string]*dynamodb.AttributeValue) (bool, error) {
+	left := e.left.value(item)
+	right := e.right.value(item)
+	if left == nil || right == nil {
+		// every comparison with a missing attribute is false, including <>
+		return false, nil
+	}
+
+	switch e.comparator {
+	case "=":
+		return equal(left, right), nil
+	case "<>":
+		return !equal(left, right), nil
+	}
+
+	order, ok := compare(left, right)
+	if !ok {
+		return false, nil
+	}
+
+	switch e.comparator {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	default:
+		return order >= 0, nil
+	}
+}
+
+type pathOperand []string
+
+func (p pathOperand) value(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	current := &dynamodb.AttributeValue{M: item}
+	for _, element := range p {
+		if current == nil || current.M == nil {
+			return nil
+		}
+		current = current.M[element]
+	}
+	return current
+}
+
+type valueOperand struct {
+	v *dynamodb.AttributeValue
+}
+
+func (o valueOperand) value(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	return o.v
+}
+
+type sizeOperand struct {
+	path operand
+}
+
+func (o sizeOperand) value(item map[string]*dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	v := o.path.value(item)
+	if v == nil {
+		return nil
+	}
+
+	var size int
+	switch {
+	case v.S != nil:
+		size = len(*v.S)
+	case v.B != nil:
+		size = len(v.B)
+	case v.L != nil:
+		size = len(v.L)
+	case v.M != nil:
+		size = len(v.M)
+	case v.SS != nil:
+		size = len(v.SS)
+	case v.NS != nil:
+		size = len(v.NS)
+	case v.BS != nil:
+		size = len(v.BS)
+	default:
+		return nil
+	}
+
+	n := fmt.Sprintf("%d", size)
+	return &dynamodb.AttributeValue{N: &n}
+}
+
+// compare orders two scalar values of the same type.  ok is false if they cannot be ordered.
+func compare(a, b *dynamodb.AttributeValue) (order int, ok bool) {
+	switch {
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S), true
+	case a.N != nil && b.N != nil:
+		x, xOk := new(big.Rat).SetString(*a.N)
+		y, yOk := new(big.Rat).SetString(*b.N)
+		if !xOk || !yOk {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	case a.B != nil && b.B != nil:
+		return bytes.Compare(a.B, b.B), true
+	default:
+		return 0, false
+	}
+}
+
+func equal(a, b *dynamodb.AttributeValue) bool {
+	if order, ok := compare(a, b); ok {
+		return order == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func typeOf(v *dynamodb.AttributeValue) string {
+	switch {
+	case v.S != nil:
+		return "S"
+	case v.N != nil:
+		return "N"
+	case v.B != nil:
+		return "B"
+	case v.BOOL != nil:
+		return "BOOL"
+	case v.NULL != nil:
+		return "NULL"
+	case v.L != nil:
+		return "L"
+	case v.M != nil:
+		return "M"
+	case v.SS != nil:
+		return "SS"
+	case v.NS != nil:
+		return "NS"
+	default:
+		return "BS"
+	}
+}
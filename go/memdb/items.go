@@ -0,0 +1,262 @@
+package memdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// GetItemWithContext returns an item by its primary key.  Every read is strongly consistent.
+func (db *DB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.primaryKey(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.GetItemOutput{Item: copyItem(t.items[key])}, nil
+}
+
+// GetItem returns an item by its primary key.
+func (db *DB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return db.GetItemWithContext(aws.BackgroundContext(), input)
+}
+
+// PutItemWithContext creates or replaces an item, provided its condition expression holds.
+func (db *DB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	write, err := db.put(input.TableName, input.Item, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := write.check(); err != nil || !ok {
+		if err == nil {
+			err = conditionalCheckFailed()
+		}
+		return nil, err
+	}
+
+	old := write.apply()
+	output := &dynamodb.PutItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = old
+	}
+	return output, nil
+}
+
+// PutItem creates or replaces an item, provided its condition expression holds.
+func (db *DB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return db.PutItemWithContext(aws.BackgroundContext(), input)
+}
+
+// DeleteItemWithContext deletes an item, provided its condition expression holds.
+func (db *DB) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	write, err := db.delete(input.TableName, input.Key, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := write.check(); err != nil || !ok {
+		if err == nil {
+			err = conditionalCheckFailed()
+		}
+		return nil, err
+	}
+
+	old := write.apply()
+	output := &dynamodb.DeleteItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = old
+	}
+	return output, nil
+}
+
+// DeleteItem deletes an item, provided its condition expression holds.
+func (db *DB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return db.DeleteItemWithContext(aws.BackgroundContext(), input)
+}
+
+// TransactWriteItemsWithContext applies a set of puts, deletes and condition checks atomically: either every
+// condition holds and every write is applied, or nothing is written.
+func (db *DB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(input.TransactItems) == 0 || len(input.TransactItems) > 25 {
+		return nil, validationError("A transaction must contain between 1 and 25 items")
+	}
+
+	var writes []*pendingWrite
+	keys := map[string]bool{}
+	for _, transactItem := range input.TransactItems {
+		var write *pendingWrite
+		var err error
+		switch {
+		case transactItem.Put != nil:
+			put := transactItem.Put
+			write, err = db.put(put.TableName, put.Item, put.ConditionExpression, put.ExpressionAttributeNames, put.ExpressionAttributeValues)
+		case transactItem.Delete != nil:
+			del := transactItem.Delete
+			write, err = db.delete(del.TableName, del.Key, del.ConditionExpression, del.ExpressionAttributeNames, del.ExpressionAttributeValues)
+		case transactItem.ConditionCheck != nil:
+			check := transactItem.ConditionCheck
+			write, err = db.delete(check.TableName, check.Key, check.ConditionExpression, check.ExpressionAttributeNames, check.ExpressionAttributeValues)
+			if write != nil {
+				write.checkOnly = true
+			}
+		default:
+			err = validationError("Only Put, Delete and ConditionCheck are supported in transactions")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		qualifiedKey := aws.StringValue(write.table.description.TableName) + "\x00" + write.key
+		if keys[qualifiedKey] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		keys[qualifiedKey] = true
+		writes = append(writes, write)
+	}
+
+	reasons := make([]string, len(writes))
+	failed := false
+	for i, write := range writes {
+		ok, err := write.check()
+		if err != nil {
+			return nil, err
+		}
+		reasons[i] = "None"
+		if !ok {
+			reasons[i] = "ConditionalCheckFailed"
+			failed = true
+		}
+	}
+
+	if failed {
+		message := fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(reasons, ", "))
+		return nil, awserr.New(dynamodb.ErrCodeTransactionCanceledException, message, nil)
+	}
+
+	for _, write := range writes {
+		if !write.checkOnly {
+			write.apply()
+		}
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// TransactWriteItems applies a set of puts, deletes and condition checks atomically.
+func (db *DB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return db.TransactWriteItemsWithContext(aws.BackgroundContext(), input)
+}
+
+// pendingWrite is a validated put or delete, whose condition is checked before it is applied.
+type pendingWrite struct {
+	table     *table
+	key       string
+	item      item // nil for a delete
+	condition expression
+	checkOnly bool
+}
+
+func (db *DB) put(tableName *string, i item, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (*pendingWrite, error) {
+	t, err := db.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.primaryKey(i)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range i {
+		if value == nil || (value.S != nil && *value.S == "" && t.isKeyAttribute(name)) {
+			return nil, validationError(fmt.Sprintf("Invalid value for attribute %s", name))
+		}
+	}
+
+	e, err := parseExpression(condition, names, values)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+
+	return &pendingWrite{table: t, key: key, item: copyItem(i), condition: e}, nil
+}
+
+func (db *DB) delete(tableName *string, key item, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (*pendingWrite, error) {
+	t, err := db.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedKey, err := t.primaryKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := parseExpression(condition, names, values)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+
+	return &pendingWrite{table: t, key: encodedKey, condition: e}, nil
+}
+
+// check evaluates the condition against the stored item.  A missing item is evaluated as an item without
+// attributes.
+func (w *pendingWrite) check() (bool, error) {
+	existing := w.table.items[w.key]
+	if existing == nil {
+		existing = item{}
+	}
+	ok, err := w.condition.evaluate(existing)
+	if err != nil {
+		return false, validationError(err.Error())
+	}
+	return ok, nil
+}
+
+// apply writes the item, returning a copy of the item it replaced.
+func (w *pendingWrite) apply() item {
+	old := copyItem(w.table.items[w.key])
+	if w.item == nil {
+		delete(w.table.items, w.key)
+	} else {
+		w.table.items[w.key] = w.item
+	}
+	return old
+}
+
+// isKeyAttribute reports whether the attribute is a key of the table or of one of its indexes, which may not
+// be empty strings.
+func (t *table) isKeyAttribute(name string) bool {
+	if name == t.key.hash || name == t.key.rangeKey {
+		return true
+	}
+	for _, index := range t.indexes {
+		if name == index.hash || name == index.rangeKey {
+			return true
+		}
+	}
+	return false
+}
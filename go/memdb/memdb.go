@@ -0,0 +1,337 @@
+// Package memdb is an in-memory emulation of the subset of DynamoDB used by the Grafeas store, so that the
+// store can be run and tested without DynamoDB, DynamoDB Local or Java.  Data is lost when the process exits.
+package memdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Endpoint is the endpoint, in the aws section of the configuration, that selects the in-memory emulation.
+const Endpoint = "memory://"
+
+// DB is an in-memory DynamoDB.  Only the operations used by the store are implemented: CreateTable,
+// DescribeTable, DeleteTable, UpdateTimeToLive, GetItem, PutItem, DeleteItem, Query, Scan,
+// TransactWriteItems, BatchGetItem and BatchWriteItem.  Calling any other operation panics.
+type DB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+// New creates an empty DB.
+func New() *DB {
+	return &DB{
+		tables: map[string]*table{},
+	}
+}
+
+type item map[string]*dynamodb.AttributeValue
+
+type table struct {
+	description *dynamodb.TableDescription
+	key         keySchema
+	indexes     map[string]keySchema
+	items       map[string]item
+}
+
+type keySchema struct {
+	hash     string
+	rangeKey string
+}
+
+func (k keySchema) attributes() []string {
+	if k.rangeKey == "" {
+		return []string{k.hash}
+	}
+	return []string{k.hash, k.rangeKey}
+}
+
+func newKeySchema(elements []*dynamodb.KeySchemaElement) (keySchema, error) {
+	var schema keySchema
+	for _, element := range elements {
+		switch aws.StringValue(element.KeyType) {
+		case dynamodb.KeyTypeHash:
+			schema.hash = aws.StringValue(element.AttributeName)
+		case dynamodb.KeyTypeRange:
+			schema.rangeKey = aws.StringValue(element.AttributeName)
+		}
+	}
+	if schema.hash == "" {
+		return schema, validationError("Key schema must have a HASH key")
+	}
+	return schema, nil
+}
+
+func validationError(message string) error {
+	return awserr.New("ValidationException", message, nil)
+}
+
+func resourceNotFound(tableName string) error {
+	return awserr.New(dynamodb.ErrCodeResourceNotFoundException, fmt.Sprintf("Requested resource not found: Table: %s not found", tableName), nil)
+}
+
+func conditionalCheckFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+// table returns the named table.  The caller must hold the lock.
+func (db *DB) table(tableName *string) (*table, error) {
+	t, ok := db.tables[aws.StringValue(tableName)]
+	if !ok {
+		return nil, resourceNotFound(aws.StringValue(tableName))
+	}
+	return t, nil
+}
+
+// CreateTableWithContext creates a table and its global secondary indexes.  Local secondary indexes are not
+// supported.
+func (db *DB) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tableName := aws.StringValue(input.TableName)
+	if _, ok := db.tables[tableName]; ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceInUseException, fmt.Sprintf("Table already exists: %s", tableName), nil)
+	}
+
+	key, err := newKeySchema(input.KeySchema)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &table{
+		key:     key,
+		indexes: map[string]keySchema{},
+		items:   map[string]item{},
+		description: &dynamodb.TableDescription{
+			TableName:            input.TableName,
+			TableArn:             aws.String("arn:aws:dynamodb:memory:000000000000:table/" + tableName),
+			TableStatus:          aws.String(dynamodb.TableStatusActive),
+			CreationDateTime:     aws.Time(time.Now()),
+			KeySchema:            input.KeySchema,
+			AttributeDefinitions: input.AttributeDefinitions,
+			StreamSpecification:  input.StreamSpecification,
+			BillingModeSummary:   &dynamodb.BillingModeSummary{BillingMode: input.BillingMode},
+		},
+	}
+
+	for _, index := range input.GlobalSecondaryIndexes {
+		indexKey, err := newKeySchema(index.KeySchema)
+		if err != nil {
+			return nil, err
+		}
+		t.indexes[aws.StringValue(index.IndexName)] = indexKey
+		t.description.GlobalSecondaryIndexes = append(t.description.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{
+			IndexName:   index.IndexName,
+			KeySchema:   index.KeySchema,
+			Projection:  index.Projection,
+			IndexStatus: aws.String(dynamodb.IndexStatusActive),
+		})
+	}
+
+	db.tables[tableName] = t
+	return &dynamodb.CreateTableOutput{TableDescription: t.description}, nil
+}
+
+// CreateTable creates a table and its global secondary indexes.
+func (db *DB) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	return db.CreateTableWithContext(aws.BackgroundContext(), input)
+}
+
+// DescribeTableWithContext describes a table.  Tables have no stream, even if one was requested.
+func (db *DB) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	description := *t.description
+	description.ItemCount = aws.Int64(int64(len(t.items)))
+	return &dynamodb.DescribeTableOutput{Table: &description}, nil
+}
+
+// DescribeTable describes a table.
+func (db *DB) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return db.DescribeTableWithContext(aws.BackgroundContext(), input)
+}
+
+// DeleteTableWithContext deletes a table and all of its items.
+func (db *DB) DeleteTableWithContext(ctx aws.Context, input *dynamodb.DeleteTableInput, opts ...request.Option) (*dynamodb.DeleteTableOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(db.tables, aws.StringValue(input.TableName))
+	return &dynamodb.DeleteTableOutput{TableDescription: t.description}, nil
+}
+
+// DeleteTable deletes a table and all of its items.
+func (db *DB) DeleteTable(input *dynamodb.DeleteTableInput) (*dynamodb.DeleteTableOutput, error) {
+	return db.DeleteTableWithContext(aws.BackgroundContext(), input)
+}
+
+// UpdateTimeToLiveWithContext records the time to live setting of a table.  Expired items are not deleted,
+// as DynamoDB itself gives no guarantee of when they will be.
+func (db *DB) UpdateTimeToLiveWithContext(ctx aws.Context, input *dynamodb.UpdateTimeToLiveInput, opts ...request.Option) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, err := db.table(input.TableName); err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.UpdateTimeToLiveOutput{TimeToLiveSpecification: input.TimeToLiveSpecification}, nil
+}
+
+// UpdateTimeToLive records the time to live setting of a table.
+func (db *DB) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	return db.UpdateTimeToLiveWithContext(aws.BackgroundContext(), input)
+}
+
+// primaryKey encodes the primary key of an item, for use as a map key.
+func (t *table) primaryKey(attributes item) (string, error) {
+	var parts []string
+	for _, name := range t.key.attributes() {
+		value := attributes[name]
+		if value == nil || (value.S == nil && value.N == nil && value.B == nil) {
+			return "", validationError(fmt.Sprintf("Missing or invalid key attribute %s", name))
+		}
+		parts = append(parts, typeOf(value)+":"+scalarString(value))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
+
+// key returns just the primary key attributes of an item.
+func (t *table) keyOf(i item) item {
+	key := item{}
+	for _, name := range t.key.attributes() {
+		key[name] = i[name]
+	}
+	return key
+}
+
+func scalarString(value *dynamodb.AttributeValue) string {
+	switch {
+	case value.S != nil:
+		return *value.S
+	case value.N != nil:
+		return *value.N
+	default:
+		return string(value.B)
+	}
+}
+
+// sortedItems returns the items of the table, or of one of its indexes, ordered by hash key, then range key,
+// then the primary key of the table.  Items without the key attributes of an index are not in the index.
+func (t *table) sortedItems(indexName *string) (keySchema, []item, error) {
+	schema := t.key
+	if indexName != nil {
+		var ok bool
+		if schema, ok = t.indexes[*indexName]; !ok {
+			return schema, nil, validationError(fmt.Sprintf("The table does not have the specified index: %s", *indexName))
+		}
+	}
+
+	var items []item
+	for _, i := range t.items {
+		if i[schema.hash] == nil || (schema.rangeKey != "" && i[schema.rangeKey] == nil) {
+			continue
+		}
+		items = append(items, i)
+	}
+
+	order := t.ordering(schema)
+	sort.Slice(items, func(a, b int) bool {
+		return compareKeys(order, items[a], items[b]) < 0
+	})
+	return schema, items, nil
+}
+
+// ordering lists the attributes by which items of an index are ordered.
+func (t *table) ordering(schema keySchema) []string {
+	order := schema.attributes()
+	for _, name := range t.key.attributes() {
+		if name != schema.hash && name != schema.rangeKey {
+			order = append(order, name)
+		}
+	}
+	return order
+}
+
+func compareKeys(order []string, a, b item) int {
+	for _, name := range order {
+		x, y := a[name], b[name]
+		if x == nil || y == nil {
+			continue
+		}
+		if result, ok := compare(x, y); ok && result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// copyItem deep copies an item, so that callers cannot modify the stored item.
+func copyItem(i item) item {
+	if i == nil {
+		return nil
+	}
+	copied := make(item, len(i))
+	for name, value := range i {
+		copied[name] = copyValue(value)
+	}
+	return copied
+}
+
+func copyValue(value *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	if value.S != nil {
+		copied.S = aws.String(*value.S)
+	}
+	if value.N != nil {
+		copied.N = aws.String(*value.N)
+	}
+	if value.B != nil {
+		copied.B = append([]byte(nil), value.B...)
+	}
+	if value.L != nil {
+		copied.L = make([]*dynamodb.AttributeValue, len(value.L))
+		for i, element := range value.L {
+			copied.L[i] = copyValue(element)
+		}
+	}
+	if value.M != nil {
+		copied.M = copyItem(value.M)
+	}
+	if value.SS != nil {
+		copied.SS = append([]*string(nil), value.SS...)
+	}
+	if value.NS != nil {
+		copied.NS = append([]*string(nil), value.NS...)
+	}
+	if value.BS != nil {
+		copied.BS = append([][]byte(nil), value.BS...)
+	}
+	return &copied
+}
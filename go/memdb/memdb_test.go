@@ -0,0 +1,252 @@
+package memdb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+)
+
+func newTable(t *testing.T) *memdb.DB {
+	db := memdb.New()
+	_, err := db.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String("test_table"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("PK"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String("SK"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("GSI"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{AttributeName: aws.String("SK"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+					{AttributeName: aws.String("Data"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable failed, %s", err)
+	}
+	return db
+}
+
+func row(pk, sk, data string) map[string]*dynamodb.AttributeValue {
+	i := map[string]*dynamodb.AttributeValue{
+		"PK": {S: aws.String(pk)},
+		"SK": {S: aws.String(sk)},
+	}
+	if data != "" {
+		i["Data"] = &dynamodb.AttributeValue{S: aws.String(data)}
+	}
+	return i
+}
+
+func errorCode(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+func TestConditionalWrites(t *testing.T) {
+	db := newTable(t)
+	put := &dynamodb.PutItemInput{
+		TableName:           aws.String("test_table"),
+		Item:                row("a", "NOTE", "p1"),
+		ConditionExpression: aws.String("attribute_not_exists(PK) AND attribute_not_exists(SK)"),
+	}
+
+	if _, err := db.PutItem(put); err != nil {
+		t.Fatalf("PutItem failed, %s", err)
+	}
+	if _, err := db.PutItem(put); errorCode(err) != dynamodb.ErrCodeConditionalCheckFailedException {
+		t.Fatalf("Expected a conditional check failure creating a duplicate, got %v", err)
+	}
+
+	del := &dynamodb.DeleteItemInput{
+		TableName:                 aws.String("test_table"),
+		Key:                       row("a", "NOTE", ""),
+		ConditionExpression:       aws.String("#DATA = :DATA"),
+		ExpressionAttributeNames:  map[string]*string{"#DATA": aws.String("Data")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":DATA": {S: aws.String("p2")}},
+	}
+	if _, err := db.DeleteItem(del); errorCode(err) != dynamodb.ErrCodeConditionalCheckFailedException {
+		t.Fatalf("Expected a conditional check failure deleting with the wrong data, got %v", err)
+	}
+
+	del.ExpressionAttributeValues[":DATA"].S = aws.String("p1")
+	if _, err := db.DeleteItem(del); err != nil {
+		t.Fatalf("DeleteItem failed, %s", err)
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test_table"), Key: row("a", "NOTE", "")})
+	if err != nil || result.Item != nil {
+		t.Fatalf("Expected the item to be deleted, got %v, %v", result, err)
+	}
+}
+
+func TestTransactionsAreAtomic(t *testing.T) {
+	db := newTable(t)
+	if _, err := db.PutItem(&dynamodb.PutItemInput{TableName: aws.String("test_table"), Item: row("a", "NOTE", "p1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Put: &dynamodb.Put{TableName: aws.String("test_table"), Item: row("b", "NOTE", "p1")}},
+			{Put: &dynamodb.Put{
+				TableName:           aws.String("test_table"),
+				Item:                row("a", "NOTE", "p2"),
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+		},
+	})
+	if errorCode(err) != dynamodb.ErrCodeTransactionCanceledException {
+		t.Fatalf("Expected the transaction to be cancelled, got %v", err)
+	}
+
+	result, err := db.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test_table"), Key: row("b", "NOTE", "")})
+	if err != nil || result.Item != nil {
+		t.Fatalf("Expected no part of a cancelled transaction to be written, got %v, %v", result.Item, err)
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	db := newTable(t)
+	for i := 0; i < 5; i++ {
+		project := "p1"
+		if i == 2 {
+			project = "p2"
+		}
+		if _, err := db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String("test_table"),
+			Item:      row(fmt.Sprintf("n%d", i), "NOTE", project),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// not in the index, as it has no Data attribute
+	if _, err := db.PutItem(&dynamodb.PutItemInput{TableName: aws.String("test_table"), Item: row("x", "NOTE", "")}); err != nil {
+		t.Fatal(err)
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String("test_table"),
+		IndexName:                aws.String("GSI"),
+		KeyConditionExpression:   aws.String("SK = :SK"),
+		FilterExpression:         aws.String("#DATA <> :EXCLUDED"),
+		ExpressionAttributeNames: map[string]*string{"#DATA": aws.String("Data")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":SK":       {S: aws.String("NOTE")},
+			":EXCLUDED": {S: aws.String("p2")},
+		},
+		Limit: aws.Int64(2),
+	}
+
+	var pages [][]string
+	err := db.QueryPages(input, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		var names []string
+		for _, i := range output.Items {
+			names = append(names, *i["PK"].S)
+		}
+		pages = append(pages, names)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("QueryPages failed, %s", err)
+	}
+
+	// items are in Data order, and the limit applies before the filter, so the page holding n2 is empty
+	expected := "[[n0 n1] [n3 n4] []]"
+	if actual := fmt.Sprint(pages); actual != expected {
+		t.Errorf("Got pages %s, expected %s", actual, expected)
+	}
+}
+
+func TestParallelScanSegments(t *testing.T) {
+	db := newTable(t)
+	for i := 0; i < 20; i++ {
+		if _, err := db.PutItem(&dynamodb.PutItemInput{TableName: aws.String("test_table"), Item: row(fmt.Sprintf("p%d", i), "NOTE", "")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := map[string]int{}
+	for segment := int64(0); segment < 3; segment++ {
+		err := db.ScanPages(&dynamodb.ScanInput{
+			TableName:     aws.String("test_table"),
+			Segment:       aws.Int64(segment),
+			TotalSegments: aws.Int64(3),
+			Limit:         aws.Int64(4),
+		}, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+			for _, i := range output.Items {
+				seen[*i["PK"].S]++
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatalf("ScanPages failed, %s", err)
+		}
+	}
+	if len(seen) != 20 {
+		t.Errorf("Expected every item to be scanned, got %v", seen)
+	}
+	for pk, count := range seen {
+		if count != 1 {
+			t.Errorf("Expected %s to be in exactly one segment, got %d", pk, count)
+		}
+	}
+
+	_, err := db.Scan(&dynamodb.ScanInput{TableName: aws.String("test_table"), Segment: aws.Int64(3), TotalSegments: aws.Int64(3)})
+	if errorCode(err) != "ValidationException" {
+		t.Errorf("Expected a segment out of range to be rejected, got %v", err)
+	}
+}
+
+func TestBatchOperations(t *testing.T) {
+	db := newTable(t)
+	if _, err := db.PutItem(&dynamodb.PutItemInput{TableName: aws.String("test_table"), Item: row("a", "NOTE", "p1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := db.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]*dynamodb.WriteRequest{
+			"test_table": {
+				{PutRequest: &dynamodb.PutRequest{Item: row("b", "NOTE", "p1")}},
+				{PutRequest: &dynamodb.PutRequest{Item: row("c", "NOTE", "p2")}},
+				{DeleteRequest: &dynamodb.DeleteRequest{Key: row("a", "NOTE", "")}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BatchWriteItem failed, %s", err)
+	}
+
+	output, err := db.BatchGetItem(&dynamodb.BatchGetItemInput{
+		RequestItems: map[string]*dynamodb.KeysAndAttributes{
+			"test_table": {Keys: []map[string]*dynamodb.AttributeValue{row("a", "NOTE", ""), row("b", "NOTE", ""), row("c", "NOTE", "")}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BatchGetItem failed, %s", err)
+	}
+	if items := output.Responses["test_table"]; len(items) != 2 {
+		t.Errorf("Expected the written items to be returned and the deleted one left out, got %v", items)
+	}
+
+	_, err = db.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]*dynamodb.WriteRequest{
+			"test_table": {
+				{PutRequest: &dynamodb.PutRequest{Item: row("d", "NOTE", "p1")}},
+				{DeleteRequest: &dynamodb.DeleteRequest{Key: row("d", "NOTE", "")}},
+			},
+		},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Errorf("Expected two requests for one item to be rejected, got %v", err)
+	}
+}
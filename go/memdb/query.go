@@ -0,0 +1,239 @@
+package memdb
+
+import (
+	"hash/fnv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// QueryWithContext returns the items of a table or index that match the key condition expression, in range
+// key order.  Limit and ExclusiveStartKey page through the results as DynamoDB does: Limit bounds the number
+// of items evaluated, before the filter expression is applied.
+func (db *DB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.KeyConditionExpression == nil {
+		return nil, validationError("KeyConditionExpression must be specified")
+	}
+
+	keyCondition, err := parseExpression(input.KeyConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+
+	page, err := t.page(input.IndexName, keyCondition, input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues,
+		input.ExclusiveStartKey, input.Limit, input.ScanIndexForward == nil || *input.ScanIndexForward)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.QueryOutput{
+		Count:            aws.Int64(int64(len(page.items))),
+		ScannedCount:     aws.Int64(page.scanned),
+		LastEvaluatedKey: page.lastEvaluatedKey,
+	}
+	if aws.StringValue(input.Select) != dynamodb.SelectCount {
+		output.Items = page.items
+	}
+	return output, nil
+}
+
+// Query returns the items of a table or index that match the key condition expression.
+func (db *DB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return db.QueryWithContext(aws.BackgroundContext(), input)
+}
+
+// QueryPagesWithContext calls fn with each page of the results of a query, until fn returns false.
+func (db *DB) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := db.QueryWithContext(ctx, &pageInput)
+		if err != nil {
+			return err
+		}
+		lastPage := output.LastEvaluatedKey == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// QueryPages calls fn with each page of the results of a query, until fn returns false.
+func (db *DB) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	return db.QueryPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+// ScanWithContext returns the items of a table or index that match the filter expression, in primary key
+// order.  A parallel scan's segments are the items whose partition keys hash to them.
+func (db *DB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var selection expression = trueExpression{}
+	if input.TotalSegments != nil || input.Segment != nil {
+		total, segment := aws.Int64Value(input.TotalSegments), aws.Int64Value(input.Segment)
+		if total < 1 || segment < 0 || segment >= total {
+			return nil, validationError("Segment must be at least 0 and less than TotalSegments")
+		}
+		selection = segmentExpression{attribute: t.key.hash, segment: segment, total: total}
+	}
+
+	page, err := t.page(input.IndexName, selection, input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues,
+		input.ExclusiveStartKey, input.Limit, true)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.ScanOutput{
+		Count:            aws.Int64(int64(len(page.items))),
+		ScannedCount:     aws.Int64(page.scanned),
+		LastEvaluatedKey: page.lastEvaluatedKey,
+	}
+	if aws.StringValue(input.Select) != dynamodb.SelectCount {
+		output.Items = page.items
+	}
+	return output, nil
+}
+
+// Scan returns the items of a table or index that match the filter expression.
+func (db *DB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	return db.ScanWithContext(aws.BackgroundContext(), input)
+}
+
+// ScanPagesWithContext calls fn with each page of the results of a scan, until fn returns false.
+func (db *DB) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+	pageInput := *input
+	for {
+		output, err := db.ScanWithContext(ctx, &pageInput)
+		if err != nil {
+			return err
+		}
+		lastPage := output.LastEvaluatedKey == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+// ScanPages calls fn with each page of the results of a scan, until fn returns false.
+func (db *DB) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	return db.ScanPagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+type page struct {
+	items            []map[string]*dynamodb.AttributeValue
+	scanned          int64
+	lastEvaluatedKey map[string]*dynamodb.AttributeValue
+}
+
+// page evaluates the items of a table or index in order, starting after exclusiveStartKey, until limit items
+// have been evaluated.
+func (t *table) page(indexName *string, keyCondition expression, filterExpression *string, names map[string]*string,
+	values map[string]*dynamodb.AttributeValue, exclusiveStartKey map[string]*dynamodb.AttributeValue, limit *int64, forward bool) (*page, error) {
+
+	filter, err := parseExpression(filterExpression, names, values)
+	if err != nil {
+		return nil, validationError(err.Error())
+	}
+
+	if limit != nil && *limit <= 0 {
+		return nil, validationError("Limit must be greater than 0")
+	}
+
+	schema, items, err := t.sortedItems(indexName)
+	if err != nil {
+		return nil, err
+	}
+	order := t.ordering(schema)
+
+	var matching []item
+	for _, i := range items {
+		ok, err := keyCondition.evaluate(i)
+		if err != nil {
+			return nil, validationError(err.Error())
+		}
+		if ok {
+			matching = append(matching, i)
+		}
+	}
+
+	if !forward {
+		for a, b := 0, len(matching)-1; a < b; a, b = a+1, b-1 {
+			matching[a], matching[b] = matching[b], matching[a]
+		}
+	}
+
+	start := 0
+	if exclusiveStartKey != nil {
+		for start < len(matching) {
+			result := compareKeys(order, matching[start], exclusiveStartKey)
+			if (forward && result > 0) || (!forward && result < 0) {
+				break
+			}
+			start++
+		}
+	}
+
+	result := &page{items: []map[string]*dynamodb.AttributeValue{}}
+	for _, i := range matching[start:] {
+		result.scanned++
+
+		ok, err := filter.evaluate(i)
+		if err != nil {
+			return nil, validationError(err.Error())
+		}
+		if ok {
+			result.items = append(result.items, copyItem(i))
+		}
+
+		// like DynamoDB, a page that reaches the limit has a LastEvaluatedKey even if no items remain
+		if limit != nil && result.scanned == *limit {
+			result.lastEvaluatedKey = t.evaluatedKey(schema, i)
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// evaluatedKey returns the key attributes of the table and index of an item, as a LastEvaluatedKey.
+func (t *table) evaluatedKey(schema keySchema, i item) map[string]*dynamodb.AttributeValue {
+	key := map[string]*dynamodb.AttributeValue{}
+	for _, name := range append(t.key.attributes(), schema.attributes()...) {
+		key[name] = copyValue(i[name])
+	}
+	return key
+}
+
+// segmentExpression selects the items of one segment of a parallel scan.
+type segmentExpression struct {
+	attribute string
+	segment   int64
+	total     int64
+}
+
+func (e segmentExpression) evaluate(i map[string]*dynamodb.AttributeValue) (bool, error) {
+	value := i[e.attribute]
+	if value == nil {
+		return false, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(aws.StringValue(value.S) + aws.StringValue(value.N)))
+	h.Write(value.B)
+	return int64(h.Sum32())%e.total == e.segment, nil
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Checkpoint is the progress of an export: how far each segment of the scan has been written, and how long
+// the output was at that point.
+type Checkpoint struct {
+	Segments     []SegmentCheckpoint `json:"segments"`
+	Offset       int64               `json:"offset"`
+	ProjectID    string              `json:"project_id,omitempty"`
+	UpdatedSince time.Time           `json:"updated_since,omitempty"`
+	Gzip         bool                `json:"gzip,omitempty"`
+}
+
+// SegmentCheckpoint is the progress of one segment of the scan.
+type SegmentCheckpoint struct {
+	// ExclusiveStartKey is the key the segment's scan continues from, or nil to start at the beginning.
+	ExclusiveStartKey map[string]*dynamodb.AttributeValue `json:"exclusive_start_key,omitempty"`
+	// Done is set once the segment has been written in full.
+	Done bool `json:"done,omitempty"`
+}
+
+// LoadCheckpoint reads the checkpoint written to path by an earlier export, or returns nil if there is none.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	if ok, err := loadJSON(path, &checkpoint); !ok || err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint to path, replacing it atomically so that an interrupted write leaves the previous
+// checkpoint in place.
+func (c *Checkpoint) Save(path string) error {
+	return saveJSON(path, c)
+}
+
+// Complete reports whether every segment has been written.
+func (c *Checkpoint) Complete() bool {
+	for _, segment := range c.Segments {
+		if !segment.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the checkpoint was written by an export with the same settings as other.
+func (c *Checkpoint) matches(other *Checkpoint) bool {
+	return len(c.Segments) == len(other.Segments) && c.ProjectID == other.ProjectID &&
+		c.UpdatedSince.Equal(other.UpdatedSince) && c.Gzip == other.Gzip
+}
+
+// MigrationCheckpoint is the progress of a migration.  Projects are migrated one at a time, in the order the
+// source lists them: the project itself, then its notes, then its occurrences.
+type MigrationCheckpoint struct {
+	// ProjectsPageToken is the token of the page of projects being migrated.
+	ProjectsPageToken string `json:"projects_page_token,omitempty"`
+	// ProjectIndex is the position, within that page, of the project being migrated.
+	ProjectIndex int `json:"project_index"`
+	// Stage is what has been written of the project: nothing yet, its notes or its occurrences.
+	Stage string `json:"stage,omitempty"`
+	// PageToken is the token of the next page of the stage.
+	PageToken string `json:"page_token,omitempty"`
+	// Done is set once every project has been migrated.
+	Done  bool        `json:"done,omitempty"`
+	Stats ImportStats `json:"stats"`
+}
+
+// LoadMigrationCheckpoint reads the checkpoint written to path by an earlier migration, or returns nil if there
+// is none.
+func LoadMigrationCheckpoint(path string) (*MigrationCheckpoint, error) {
+	var checkpoint MigrationCheckpoint
+	if ok, err := loadJSON(path, &checkpoint); !ok || err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint to path, replacing it atomically.
+func (c *MigrationCheckpoint) Save(path string) error {
+	return saveJSON(path, c)
+}
+
+// loadJSON reads a checkpoint into v, reporting false if there is none.
+func loadJSON(path string, v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Unable to read checkpoint, %s", err))
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, errors.New(fmt.Sprintf("Unable to parse checkpoint %s, %s", path, err))
+	}
+	return true, nil
+}
+
+// saveJSON writes a checkpoint to path through a temporary file, so that an interrupted write leaves the
+// previous checkpoint in place.
+func saveJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to write checkpoint, %s", err))
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.New(fmt.Sprintf("Unable to write checkpoint, %s", err))
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.New(fmt.Sprintf("Unable to write checkpoint, %s", err))
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.New(fmt.Sprintf("Unable to write checkpoint, %s", err))
+	}
+	return nil
+}
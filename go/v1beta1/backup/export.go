@@ -0,0 +1,263 @@
+// Package backup exports the projects, notes and occurrences of a store as NDJSON, one resource in its proto
+// JSON form per line, and imports them again.
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/grafeas/grafeas/go/name"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// DefaultSegments is the number of segments an export scans in parallel unless told otherwise.
+const DefaultSegments = 4
+
+// ExportStats counts the resources written by an export.
+type ExportStats struct {
+	Projects    int
+	Notes       int
+	Occurrences int
+}
+
+// Exporter writes the projects, notes and occurrences of a store as NDJSON.  Auxiliary rows, such as the
+// occurrence note rows, revisions and webhook deliveries, are not exported.
+type Exporter struct {
+	Store *storage.DynamoDb
+	// Segments is the number of segments of the table scanned in parallel.  Defaults to DefaultSegments.
+	Segments int
+	// ProjectID restricts the export to the project and its notes and occurrences, if set.
+	ProjectID string
+	// UpdatedSince restricts the export to resources written at or after this time, if set.  Resources written
+	// before the store recorded update times are always exported.
+	UpdatedSince time.Time
+	// Gzip compresses the output.  Each page of the scan is a separate gzip member, so that a resumed export
+	// appends to a valid file.
+	Gzip bool
+	// CheckpointFile is where progress is recorded after every page, if set.
+	CheckpointFile string
+	// Resume continues the export that wrote the checkpoint.  The output must have been truncated to the
+	// checkpoint's Offset, as anything after it was written by pages that are scanned again.
+	Resume *Checkpoint
+}
+
+// Export scans the table and writes every resource to w.  Resources are written in no particular order.
+func (e *Exporter) Export(ctx context.Context, w io.Writer) (*ExportStats, error) {
+	segments := e.Segments
+	if segments == 0 {
+		segments = DefaultSegments
+	}
+	if segments < 1 {
+		return nil, errors.New(fmt.Sprintf("Invalid number of segments %d, must be at least 1", segments))
+	}
+
+	checkpoint := &Checkpoint{
+		Segments:     make([]SegmentCheckpoint, segments),
+		ProjectID:    e.ProjectID,
+		UpdatedSince: e.UpdatedSince.UTC(),
+		Gzip:         e.Gzip,
+	}
+	if e.Resume != nil {
+		if !e.Resume.matches(checkpoint) {
+			return nil, errors.New("Unable to resume export, the checkpoint was written by an export with different settings")
+		}
+		checkpoint = e.Resume
+	}
+
+	run := &exportRun{
+		exporter:   e,
+		w:          w,
+		checkpoint: checkpoint,
+		stats:      &ExportStats{},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, segments)
+	var wg sync.WaitGroup
+	for segment := range checkpoint.Segments {
+		if checkpoint.Segments[segment].Done {
+			continue
+		}
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			if err := run.scanSegment(ctx, segment); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(segment)
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return run.stats, err
+	}
+	return run.stats, nil
+}
+
+// exportRun is the state shared by the segments of an export.
+type exportRun struct {
+	exporter *Exporter
+
+	mu         sync.Mutex
+	w          io.Writer
+	checkpoint *Checkpoint
+	stats      *ExportStats
+}
+
+func (r *exportRun) scanSegment(ctx context.Context, segment int) error {
+	db := r.exporter.Store
+	input := r.exporter.scanInput()
+	input.Segment = aws.Int64(int64(segment))
+	input.TotalSegments = aws.Int64(int64(len(r.checkpoint.Segments)))
+
+	r.mu.Lock()
+	input.ExclusiveStartKey = r.checkpoint.Segments[segment].ExclusiveStartKey
+	r.mu.Unlock()
+
+	var pageErr error
+	err := db.ScanPagesWithContext(ctx, input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var encoded []byte
+		var stats ExportStats
+		encoded, stats, pageErr = r.exporter.encodePage(page.Items)
+		if pageErr != nil {
+			return false
+		}
+		pageErr = r.write(segment, encoded, stats, page.LastEvaluatedKey, lastPage)
+		return pageErr == nil
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to scan segment %d, %s", segment, err))
+	}
+	return pageErr
+}
+
+// write appends a page to the output and records that the segment has been exported up to its last key.
+func (r *exportRun) write(segment int, encoded []byte, stats ExportStats, lastEvaluatedKey map[string]*dynamodb.AttributeValue, lastPage bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(encoded) > 0 {
+		n, err := r.w.Write(encoded)
+		r.checkpoint.Offset += int64(n)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Unable to write export, %s", err))
+		}
+	}
+	r.stats.Projects += stats.Projects
+	r.stats.Notes += stats.Notes
+	r.stats.Occurrences += stats.Occurrences
+
+	r.checkpoint.Segments[segment] = SegmentCheckpoint{ExclusiveStartKey: lastEvaluatedKey, Done: lastPage}
+	if r.exporter.CheckpointFile == "" {
+		return nil
+	}
+	if syncer, ok := r.w.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			return errors.New(fmt.Sprintf("Unable to write export, %s", err))
+		}
+	}
+	return r.checkpoint.Save(r.exporter.CheckpointFile)
+}
+
+// scanInput selects the rows of projects, notes and occurrences in the store's namespace, restricted to the
+// project and update time of the export.
+func (e *Exporter) scanInput() *dynamodb.ScanInput {
+	key := func(value string) *dynamodb.AttributeValue {
+		return &dynamodb.AttributeValue{S: aws.String(storage.NamespacedKey(e.Store.Namespace, value))}
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(e.Store.TableName),
+		ExpressionAttributeNames: map[string]*string{
+			"#SK": aws.String(storage.SortKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":PROJECT":    key(storage.ProjectSortKey),
+			":NOTE":       key(storage.NoteSortKey),
+			":OCCURRENCE": key(storage.OccurrenceSortKey),
+		},
+	}
+	filter := "#SK IN (:PROJECT, :NOTE, :OCCURRENCE)"
+
+	if e.ProjectID != "" {
+		input.ExpressionAttributeNames["#PK"] = aws.String(storage.PartitionKeyName)
+		input.ExpressionAttributeValues[":PROJECT_NAME"] = key(name.FormatProject(e.ProjectID))
+		input.ExpressionAttributeValues[":PROJECT_PREFIX"] = key(name.FormatProject(e.ProjectID) + "/")
+		filter += " AND (#PK = :PROJECT_NAME OR begins_with(#PK, :PROJECT_PREFIX))"
+	}
+
+	if !e.UpdatedSince.IsZero() {
+		input.ExpressionAttributeNames["#UPDATED_AT"] = aws.String(storage.UpdatedAtKeyName)
+		input.ExpressionAttributeValues[":SINCE"] = &dynamodb.AttributeValue{S: aws.String(e.UpdatedSince.UTC().Format(storage.AuditTimeFormat))}
+		filter += " AND (#UPDATED_AT >= :SINCE OR attribute_not_exists(#UPDATED_AT))"
+	}
+
+	input.FilterExpression = aws.String(filter)
+	return input
+}
+
+// encodePage converts the rows of a page into NDJSON, compressed as a single gzip member if required.
+func (e *Exporter) encodePage(items []map[string]*dynamodb.AttributeValue) ([]byte, ExportStats, error) {
+	var stats ExportStats
+	if len(items) == 0 {
+		return nil, stats, nil
+	}
+
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var zw *gzip.Writer
+	if e.Gzip {
+		zw = gzip.NewWriter(&buf)
+		out = zw
+	}
+
+	for _, item := range items {
+		dataItem := storage.DataItem{}
+		if err := dynamodbattribute.UnmarshalMap(item, &dataItem); err != nil {
+			return nil, stats, errors.New(fmt.Sprintf("Unable to unmarshal item, %s", err))
+		}
+		jsonObject, err := e.Store.OpenPayload(&dataItem)
+		if err != nil {
+			return nil, stats, err
+		}
+
+		var line bytes.Buffer
+		if err := json.Compact(&line, []byte(jsonObject)); err != nil {
+			return nil, stats, errors.New(fmt.Sprintf("Invalid json stored for %s, %s", dataItem.PartitionKey, err))
+		}
+		line.WriteByte('\n')
+		if _, err := out.Write(line.Bytes()); err != nil {
+			return nil, stats, err
+		}
+
+		switch sk, _ := storage.StripNamespace(e.Store.Namespace, dataItem.SortKey); sk {
+		case storage.ProjectSortKey:
+			stats.Projects++
+		case storage.NoteSortKey:
+			stats.Notes++
+		case storage.OccurrenceSortKey:
+			stats.Occurrences++
+		}
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, stats, err
+		}
+	}
+	return buf.Bytes(), stats, nil
+}
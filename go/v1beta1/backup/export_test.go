@@ -0,0 +1,232 @@
+package backup_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/backup"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+func newStore(client *memdb.DB, namespace string) *storage.DynamoDb {
+	return storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		Namespace: namespace,
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+}
+
+// populate creates two projects, each with two notes and an occurrence of each note.
+func populate(t *testing.T, store *storage.DynamoDb) {
+	ctx := context.Background()
+	for _, pID := range []string{"p1", "p2"} {
+		if _, err := store.CreateProject(ctx, pID, &prpb.Project{Name: "projects/" + pID}); err != nil {
+			t.Fatalf("CreateProject failed, %s", err)
+		}
+		for _, nID := range []string{"n1", "n2"} {
+			if _, err := store.CreateNote(ctx, pID, nID, "user", &pb.Note{}); err != nil {
+				t.Fatalf("CreateNote failed, %s", err)
+			}
+			if _, err := store.CreateOccurrence(ctx, pID, "user", &pb.Occurrence{NoteName: "projects/" + pID + "/notes/" + nID}); err != nil {
+				t.Fatalf("CreateOccurrence failed, %s", err)
+			}
+		}
+	}
+}
+
+// names reads the name of every resource in an NDJSON export, sorted.
+func names(t *testing.T, r io.Reader) []string {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var resource struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &resource); err != nil {
+			t.Fatalf("Invalid line %q, %s", scanner.Text(), err)
+		}
+		names = append(names, resource.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestExport(t *testing.T) {
+	store := newStore(memdb.New(), "")
+	populate(t, store)
+
+	var buf bytes.Buffer
+	stats, err := (&backup.Exporter{Store: store, Segments: 3}).Export(context.Background(), &buf)
+	if err != nil {
+		t.Fatalf("Export failed, %s", err)
+	}
+	if *stats != (backup.ExportStats{Projects: 2, Notes: 4, Occurrences: 4}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if exported := names(t, &buf); len(exported) != 10 {
+		t.Errorf("Expected each resource to be exported once, got %v", exported)
+	}
+}
+
+func TestExportProject(t *testing.T) {
+	store := newStore(memdb.New(), "")
+	populate(t, store)
+
+	var buf bytes.Buffer
+	stats, err := (&backup.Exporter{Store: store, ProjectID: "p1"}).Export(context.Background(), &buf)
+	if err != nil {
+		t.Fatalf("Export failed, %s", err)
+	}
+	if *stats != (backup.ExportStats{Projects: 1, Notes: 2, Occurrences: 2}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	for _, name := range names(t, &buf) {
+		if name != "projects/p1" && !strings.HasPrefix(name, "projects/p1/") {
+			t.Errorf("Expected only project p1 to be exported, got %s", name)
+		}
+	}
+}
+
+func TestExportUpdatedSince(t *testing.T) {
+	client := memdb.New()
+	store := newStore(client, "")
+	populate(t, store)
+
+	// A project written before update times were recorded.
+	if _, err := client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("test_table"),
+		Item: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String("projects/legacy")},
+			storage.SortKeyName:      {S: aws.String(storage.ProjectSortKey)},
+			storage.DataKeyName:      {S: aws.String("projects/legacy")},
+			"Json":                   {S: aws.String(`{"name":"projects/legacy"}`)},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := (&backup.Exporter{Store: store, UpdatedSince: time.Now().Add(time.Hour)}).Export(context.Background(), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Export failed, %s", err)
+	}
+	if *stats != (backup.ExportStats{Projects: 1}) {
+		t.Errorf("Expected only the project without an update time to be exported, got %+v", stats)
+	}
+
+	stats, err = (&backup.Exporter{Store: store, UpdatedSince: time.Now().Add(-time.Hour)}).Export(context.Background(), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("Export failed, %s", err)
+	}
+	if *stats != (backup.ExportStats{Projects: 3, Notes: 4, Occurrences: 4}) {
+		t.Errorf("Expected the recently updated resources to be exported, got %+v", stats)
+	}
+}
+
+func TestExportGzip(t *testing.T) {
+	store := newStore(memdb.New(), "")
+	populate(t, store)
+
+	var buf bytes.Buffer
+	if _, err := (&backup.Exporter{Store: store, Gzip: true}).Export(context.Background(), &buf); err != nil {
+		t.Fatalf("Export failed, %s", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported := names(t, zr); len(exported) != 10 {
+		t.Errorf("Expected every resource in the compressed export, got %v", exported)
+	}
+}
+
+func TestExportNamespace(t *testing.T) {
+	client := memdb.New()
+	populate(t, newStore(client, "dev"))
+	store := newStore(client, "prod")
+	if _, err := store.CreateProject(context.Background(), "p3", &prpb.Project{Name: "projects/p3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := (&backup.Exporter{Store: store}).Export(context.Background(), &buf); err != nil {
+		t.Fatalf("Export failed, %s", err)
+	}
+	if exported := names(t, &buf); len(exported) != 1 || exported[0] != "projects/p3" {
+		t.Errorf("Expected only the namespace's resources to be exported, got %v", exported)
+	}
+}
+
+// failingWriter fails after writing a number of pages.
+type failingWriter struct {
+	bytes.Buffer
+	pages int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.pages == 0 {
+		return 0, errors.New("disk full")
+	}
+	w.pages--
+	return w.Buffer.Write(p)
+}
+
+func TestExportResumesFromCheckpoint(t *testing.T) {
+	store := newStore(memdb.New(), "")
+	populate(t, store)
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+
+	w := &failingWriter{pages: 2}
+	exporter := &backup.Exporter{Store: store, Segments: 4, CheckpointFile: checkpointFile}
+	if _, err := exporter.Export(context.Background(), w); err == nil {
+		t.Fatalf("Expected the export to fail")
+	}
+
+	checkpoint, err := backup.LoadCheckpoint(checkpointFile)
+	if err != nil || checkpoint == nil || checkpoint.Complete() {
+		t.Fatalf("Expected an incomplete checkpoint, got %+v, %v", checkpoint, err)
+	}
+	if checkpoint.Offset != int64(w.Len()) {
+		t.Errorf("Expected the checkpoint to record the output written, got %d of %d", checkpoint.Offset, w.Len())
+	}
+
+	output := bytes.NewBuffer(w.Bytes()[:checkpoint.Offset])
+	exporter.Resume = checkpoint
+	if _, err := exporter.Export(context.Background(), output); err != nil {
+		t.Fatalf("Resumed export failed, %s", err)
+	}
+	exported := names(t, output)
+	if len(exported) != 10 {
+		t.Errorf("Expected each resource to be exported once, got %v", exported)
+	}
+	if checkpoint, _ := backup.LoadCheckpoint(checkpointFile); !checkpoint.Complete() {
+		t.Errorf("Expected the checkpoint to be complete")
+	}
+
+	exporter.Resume = &backup.Checkpoint{Segments: make([]backup.SegmentCheckpoint, 2)}
+	if _, err := exporter.Export(context.Background(), &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected a checkpoint of different settings to be rejected")
+	}
+}
@@ -0,0 +1,483 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/grafeas/grafeas/go/name"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// ConflictMode decides what an import does with a resource that already exists in the store.
+type ConflictMode string
+
+const (
+	// ConflictSkip leaves the stored resource as it is.
+	ConflictSkip ConflictMode = "skip"
+	// ConflictOverwrite replaces the stored resource.
+	ConflictOverwrite ConflictMode = "overwrite"
+	// ConflictFail stops the import before the batch holding the resource is written.
+	ConflictFail ConflictMode = "fail"
+)
+
+const (
+	// DefaultBatchSize is the number of resources an import reads and writes at a time unless told otherwise.
+	DefaultBatchSize = 25
+	// maxBatchSize is the number of keys BatchGetItem accepts.
+	maxBatchSize = 100
+	// maxBatchWrite is the number of requests BatchWriteItem accepts.
+	maxBatchWrite = 25
+	// maxUnprocessedBackoff bounds the wait before writing or reading unprocessed items again.
+	maxUnprocessedBackoff = 5 * time.Second
+)
+
+// ImportStats counts the resources read by an import.  In a dry run, they count what would have been written.
+type ImportStats struct {
+	// Projects, Notes and Occurrences count the resources written, including those overwritten.
+	Projects    int `json:"projects"`
+	Notes       int `json:"notes"`
+	Occurrences int `json:"occurrences"`
+	// Skipped counts the resources left as they are because they already exist.
+	Skipped int `json:"skipped"`
+	// Overwritten counts the resources that replaced a stored resource.
+	Overwritten int `json:"overwritten"`
+	// Conflicts names the resources that already exist, in a dry run with ConflictFail.
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// Importer reads projects, notes and occurrences written by an Exporter and writes them to a store, rebuilding
+// the rows that link occurrences to their notes.  Resources keep their names and their create and update times.
+// Whether a resource exists is decided by reading each batch before writing it, so an import should not run
+// alongside clients writing the same resources.
+type Importer struct {
+	Store *storage.DynamoDb
+	// Destination returns the store of the table that holds a project, if set, in place of Store.
+	Destination func(pID string) *storage.DynamoDb
+	// Conflict decides what happens to resources that already exist.  Defaults to ConflictSkip.
+	Conflict ConflictMode
+	// BatchSize is the number of resources read and written at a time, at most 100.  Defaults to
+	// DefaultBatchSize.
+	BatchSize int
+	// WritesPerSecond limits the rows written per second, if set, to leave capacity for the server.
+	WritesPerSecond float64
+	// DryRun reads the input and the store, and reports what would be written, without writing anything.
+	DryRun bool
+	// UserID is recorded as the creator of the rows written.
+	UserID string
+}
+
+// importedResource is a resource read from the input, and the rows that hold it.
+type importedResource struct {
+	// line is where the resource was read from the input, or zero if it was not read from one.
+	line  int
+	name  string
+	kind  string
+	items []storage.DataItem
+}
+
+// newImportedResource lays out the rows of a resource as the store would hold them.
+func newImportedResource(store *storage.DynamoDb, userID string, line int, resourceName string, resource proto.Message, now time.Time) (*importedResource, error) {
+	items, err := store.RestoreItems(resource, userID, now)
+	if err != nil {
+		if line > 0 {
+			return nil, errors.New(fmt.Sprintf("Unable to import line %d, %s", line, err))
+		}
+		return nil, errors.New(fmt.Sprintf("Unable to import %s, %s", resourceName, err))
+	}
+
+	kind, _ := storage.StripNamespace(store.Namespace, items[0].SortKey)
+	return &importedResource{line: line, name: resourceName, kind: kind, items: items}, nil
+}
+
+// String describes where the resource came from, for error messages.
+func (r *importedResource) String() string {
+	if r.line > 0 {
+		return fmt.Sprintf("%s on line %d", r.name, r.line)
+	}
+	return r.name
+}
+
+// Import reads NDJSON from r, compressed with gzip or not, and writes the resources to the store.  Batches
+// written before an error are not rolled back; as resources are written whole, the import can be run again
+// with ConflictSkip or ConflictOverwrite to finish it.
+func (i *Importer) Import(ctx context.Context, r io.Reader) (*ImportStats, error) {
+	limiter := &limiter{rate: i.WritesPerSecond}
+	stats := &ImportStats{}
+	if _, err := i.newRun(limiter, stats); err != nil {
+		return nil, err
+	}
+
+	input, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// each table is written in batches of its own, flushed in the order the tables were first read
+	batches := map[*storage.DynamoDb]*importBatch{}
+	var stores []*storage.DynamoDb
+	now := time.Now()
+	for line := 1; ; line++ {
+		data, err := input.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return stats, errors.New(fmt.Sprintf("Unable to read line %d, %s", line, err))
+		}
+		if len(strings.TrimSpace(string(data))) > 0 {
+			resource, store, parseErr := i.parse(line, data, now)
+			if parseErr != nil {
+				return stats, parseErr
+			}
+			batch, ok := batches[store]
+			if !ok {
+				importer := *i
+				importer.Store = store
+				run, err := importer.newRun(limiter, stats)
+				if err != nil {
+					return stats, err
+				}
+				batch = &importBatch{run: run, names: map[string]bool{}}
+				batches[store] = batch
+				stores = append(stores, store)
+			}
+			if err := batch.add(ctx, resource); err != nil {
+				return stats, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	for _, store := range stores {
+		if err := batches[store].flush(ctx); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// importBatch holds the resources read for a table that have yet to be written.
+type importBatch struct {
+	run       *importRun
+	resources []*importedResource
+	names     map[string]bool
+}
+
+// add appends a resource to the batch, writing the batch first if it is full or already holds the resource.
+func (b *importBatch) add(ctx context.Context, resource *importedResource) error {
+	// BatchWriteItem rejects two writes of one item, so a repeated resource starts a new batch
+	if len(b.resources) == b.run.batchSize || b.names[resource.name] {
+		if err := b.flush(ctx); err != nil {
+			return err
+		}
+	}
+	b.resources = append(b.resources, resource)
+	b.names[resource.name] = true
+	return nil
+}
+
+// flush writes the resources of the batch, if any, and empties it.
+func (b *importBatch) flush(ctx context.Context) error {
+	if len(b.resources) == 0 {
+		return nil
+	}
+	if err := b.run.writeBatch(ctx, b.resources); err != nil {
+		return err
+	}
+	b.resources, b.names = nil, map[string]bool{}
+	return nil
+}
+
+// decompress returns the input, decompressed if it starts with the gzip magic number.  Every member of a
+// multi-member gzip stream, as written by an Exporter, is read.
+func decompress(r io.Reader) (*bufio.Reader, error) {
+	input := bufio.NewReader(r)
+	magic, err := input.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return input, nil
+	}
+
+	zr, err := gzip.NewReader(input)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to read gzip input, %s", err))
+	}
+	return bufio.NewReader(zr), nil
+}
+
+// parse decodes a line into a project, note or occurrence, told apart by the form of its name, and returns the
+// store of the table its project is routed to.
+func (i *Importer) parse(line int, data []byte, now time.Time) (*importedResource, *storage.DynamoDb, error) {
+	var named struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &named); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Invalid json on line %d, %s", line, err))
+	}
+
+	var pID string
+	var resource proto.Message
+	var err error
+	if pID, _, err = name.ParseOccurrence(named.Name); err == nil {
+		resource = &pb.Occurrence{}
+	} else if pID, _, err = name.ParseNote(named.Name); err == nil {
+		resource = &pb.Note{}
+	} else if pID, err = name.ParseProject(named.Name); err == nil {
+		resource = &prpb.Project{}
+	} else {
+		return nil, nil, errors.New(fmt.Sprintf("Line %d is not a project, note or occurrence, its name is %q", line, named.Name))
+	}
+
+	if err := jsonpb.UnmarshalString(string(data), resource); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Invalid resource on line %d, %s", line, err))
+	}
+
+	store := i.Store
+	if i.Destination != nil {
+		store = i.Destination(pID)
+	}
+	imported, err := newImportedResource(store, i.UserID, line, named.Name, resource, now)
+	return imported, store, err
+}
+
+// importRun is the state of an import into a store.
+type importRun struct {
+	importer  *Importer
+	conflict  ConflictMode
+	batchSize int
+	limiter   *limiter
+	stats     *ImportStats
+}
+
+// newRun validates the settings of the importer and starts a run that counts into stats.
+func (i *Importer) newRun(limiter *limiter, stats *ImportStats) (*importRun, error) {
+	conflict := i.Conflict
+	if conflict == "" {
+		conflict = ConflictSkip
+	}
+	if conflict != ConflictSkip && conflict != ConflictOverwrite && conflict != ConflictFail {
+		return nil, errors.New(fmt.Sprintf("Invalid conflict mode %q, must be one of skip, overwrite or fail", conflict))
+	}
+	batchSize := i.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+	if batchSize < 1 || batchSize > maxBatchSize {
+		return nil, errors.New(fmt.Sprintf("Invalid batch size %d, must be between 1 and %d", batchSize, maxBatchSize))
+	}
+
+	return &importRun{importer: i, conflict: conflict, batchSize: batchSize, limiter: limiter, stats: stats}, nil
+}
+
+// writeBatch reads which resources of the batch already exist, decides what to do with them and writes the
+// rest.
+func (r *importRun) writeBatch(ctx context.Context, batch []*importedResource) error {
+	db := r.importer.Store
+
+	existing, err := r.existing(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []*importedResource
+	var writes []*dynamodb.WriteRequest
+	stats := *r.stats
+	for _, resource := range batch {
+		if current, ok := existing[resource.items[0].PartitionKey]; ok {
+			switch r.conflict {
+			case ConflictSkip:
+				stats.Skipped++
+				continue
+			case ConflictFail:
+				conflicts = append(conflicts, resource)
+				stats.Conflicts = append(stats.Conflicts, resource.name)
+				continue
+			}
+
+			stats.Overwritten++
+			for i := range resource.items {
+				resource.items[i].Version = current.Version + 1
+			}
+			// an occurrence that has moved to another note leaves the row linking it to the old note behind
+			if resource.kind == storage.OccurrenceSortKey {
+				var o pb.Occurrence
+				jsonObject, err := db.OpenPayload(current)
+				if err == nil {
+					err = jsonpb.UnmarshalString(jsonObject, &o)
+				}
+				if err != nil {
+					return errors.New(fmt.Sprintf("Unable to read stored occurrence %s, %s", resource.name, err))
+				}
+				if oldLink := storage.NamespacedKey(db.Namespace, o.NoteName); o.NoteName != "" && oldLink != resource.items[1].SortKey {
+					writes = append(writes, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+						Key: itemKey(resource.items[0].PartitionKey, oldLink),
+					}})
+				}
+			}
+		} else if resource.kind != storage.ProjectSortKey {
+			// a resource deleted since it was exported has left revisions behind, which it must not collide with
+			version, err := db.FirstVersion(ctx, resource.name)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Unable to read revisions of %s, %s", resource, err))
+			}
+			for i := range resource.items {
+				resource.items[i].Version = version
+			}
+		}
+
+		switch resource.kind {
+		case storage.ProjectSortKey:
+			stats.Projects++
+		case storage.NoteSortKey:
+			stats.Notes++
+		case storage.OccurrenceSortKey:
+			stats.Occurrences++
+		}
+		for _, item := range resource.items {
+			av, err := dynamodbattribute.MarshalMap(item)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Unable to marshal %s into AttributeValues, %s", resource, err))
+			}
+			writes = append(writes, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: av}})
+		}
+	}
+
+	if len(conflicts) > 0 && !r.importer.DryRun {
+		return errors.New(fmt.Sprintf("Unable to import %s, it already exists", conflicts[0]))
+	}
+	if !r.importer.DryRun {
+		if err := r.write(ctx, writes); err != nil {
+			return err
+		}
+	}
+	*r.stats = stats
+	return nil
+}
+
+// existing reads the stored rows of the resources of the batch that already exist, keyed by partition key.
+func (r *importRun) existing(ctx context.Context, batch []*importedResource) (map[string]*storage.DataItem, error) {
+	db := r.importer.Store
+
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, resource := range batch {
+		keys = append(keys, itemKey(resource.items[0].PartitionKey, resource.items[0].SortKey))
+	}
+
+	existing := map[string]*storage.DataItem{}
+	request := map[string]*dynamodb.KeysAndAttributes{
+		db.TableName: {Keys: keys, ConsistentRead: aws.Bool(true)},
+	}
+	for attempt := 0; len(request) > 0; attempt++ {
+		if err := backoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+		output, err := db.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to read existing resources, %s", err))
+		}
+		for _, item := range output.Responses[db.TableName] {
+			dataItem := &storage.DataItem{}
+			if err := dynamodbattribute.UnmarshalMap(item, dataItem); err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to unmarshal item, %s", err))
+			}
+			existing[dataItem.PartitionKey] = dataItem
+		}
+		request = output.UnprocessedKeys
+	}
+	return existing, nil
+}
+
+// write applies the requests in batches, writing unprocessed items again until every request is applied.
+func (r *importRun) write(ctx context.Context, writes []*dynamodb.WriteRequest) error {
+	db := r.importer.Store
+
+	for len(writes) > 0 {
+		n := len(writes)
+		if n > maxBatchWrite {
+			n = maxBatchWrite
+		}
+		if err := r.limiter.wait(ctx, n); err != nil {
+			return err
+		}
+
+		request := map[string][]*dynamodb.WriteRequest{db.TableName: writes[:n]}
+		for attempt := 0; len(request) > 0; attempt++ {
+			if err := backoff(ctx, attempt); err != nil {
+				return err
+			}
+			output, err := db.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{RequestItems: request})
+			if err != nil {
+				return errors.New(fmt.Sprintf("Unable to write resources, %s", err))
+			}
+			request = output.UnprocessedItems
+		}
+		writes = writes[n:]
+	}
+	return nil
+}
+
+func itemKey(partitionKey, sortKey string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		storage.PartitionKeyName: {S: aws.String(partitionKey)},
+		storage.SortKeyName:      {S: aws.String(sortKey)},
+	}
+}
+
+// backoff waits before the attempt'th retry of unprocessed items, doubling the wait on every attempt.
+func backoff(ctx context.Context, attempt int) error {
+	if attempt == 0 {
+		return nil
+	}
+	delay := maxUnprocessedBackoff
+	if attempt < 8 {
+		delay = time.Duration(1<<uint(attempt-1)) * 50 * time.Millisecond
+	}
+	return sleep(ctx, delay)
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limiter spreads writes out so that, on average, no more than rate are made per second.
+type limiter struct {
+	rate   float64
+	start  time.Time
+	writes int
+}
+
+// wait blocks until n more writes may be made.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return nil
+	}
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+
+	due := l.start.Add(time.Duration(float64(l.writes) / l.rate * float64(time.Second)))
+	l.writes += n
+	if delay := time.Until(due); delay > 0 {
+		return sleep(ctx, delay)
+	}
+	return nil
+}
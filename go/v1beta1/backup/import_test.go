@@ -0,0 +1,237 @@
+package backup_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/backup"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// export populates a store and returns it with its export.
+func export(t *testing.T, gzip bool) (*storage.DynamoDb, []byte) {
+	store := newStore(memdb.New(), "")
+	populate(t, store)
+
+	var buf bytes.Buffer
+	if _, err := (&backup.Exporter{Store: store, Gzip: gzip}).Export(context.Background(), &buf); err != nil {
+		t.Fatalf("Export failed, %s", err)
+	}
+	return store, buf.Bytes()
+}
+
+func TestImportRestoresExport(t *testing.T) {
+	source, exported := export(t, true)
+	ctx := context.Background()
+	store := newStore(memdb.New(), "restored")
+
+	stats, err := (&backup.Importer{Store: store, BatchSize: 3, UserID: "restorer"}).Import(ctx, bytes.NewReader(exported))
+	if err != nil {
+		t.Fatalf("Import failed, %s", err)
+	}
+	if stats.Projects != 2 || stats.Notes != 4 || stats.Occurrences != 4 || stats.Skipped != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	occurrences, _, err := source.ListOccurrences(ctx, "p1", "", "", 10)
+	if err != nil || len(occurrences) != 2 {
+		t.Fatalf("ListOccurrences failed, %v, %v", occurrences, err)
+	}
+	for _, o := range occurrences {
+		oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+		restored, err := store.GetOccurrence(ctx, "p1", oID)
+		if err != nil || !proto.Equal(o, restored) {
+			t.Errorf("Expected %v to be restored with its name and create time, got %v, %v", o, restored, err)
+		}
+	}
+
+	noteOccurrences, _, err := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10)
+	if err != nil || len(noteOccurrences) != 1 {
+		t.Errorf("Expected the note's occurrence to be linked to it, got %v, %v", noteOccurrences, err)
+	}
+
+	records, _, err := store.ListAuditRecords(ctx, storage.AuditFilter{User: "restorer", Kind: storage.OccurrenceSortKey}, 100, "")
+	if err != nil || len(records) != 4 {
+		t.Fatalf("Expected the importing user to be recorded, got %v, %v", records, err)
+	}
+	created := occurrences[0].CreateTime
+	for _, record := range records {
+		if record.Name == occurrences[0].Name && record.CreatedAt.Unix() != created.Seconds {
+			t.Errorf("Expected the create time to be recorded, got %s", record.CreatedAt)
+		}
+	}
+}
+
+func TestImportRoutesProjects(t *testing.T) {
+	_, exported := export(t, false)
+	ctx := context.Background()
+	p1, rest := newStore(memdb.New(), ""), newStore(memdb.New(), "")
+	importer := &backup.Importer{
+		Destination: func(pID string) *storage.DynamoDb {
+			if pID == "p1" {
+				return p1
+			}
+			return rest
+		},
+		BatchSize: 2,
+	}
+
+	stats, err := importer.Import(ctx, bytes.NewReader(exported))
+	if err != nil {
+		t.Fatalf("Import failed, %s", err)
+	}
+	if stats.Projects != 2 || stats.Notes != 4 || stats.Occurrences != 4 {
+		t.Errorf("Expected the stats of both tables, got %+v", stats)
+	}
+
+	for _, test := range []struct {
+		store         *storage.DynamoDb
+		pID, otherPID string
+	}{{p1, "p1", "p2"}, {rest, "p2", "p1"}} {
+		if occurrences, _, err := test.store.ListNoteOccurrences(ctx, test.pID, "n1", "", "", 10); err != nil || len(occurrences) != 1 {
+			t.Errorf("Expected %s to be imported into its table, got %v, %v", test.pID, occurrences, err)
+		}
+		if _, err := test.store.GetProject(ctx, test.otherPID); err == nil {
+			t.Errorf("Expected %s not to be imported into the table of %s", test.otherPID, test.pID)
+		}
+	}
+}
+
+func TestImportSlimLinks(t *testing.T) {
+	_, exported := export(t, false)
+	ctx := context.Background()
+	store := newStore(memdb.New(), "")
+	store.SlimLinks = true
+
+	if _, err := (&backup.Importer{Store: store}).Import(ctx, bytes.NewReader(exported)); err != nil {
+		t.Fatalf("Import failed, %s", err)
+	}
+
+	noteOccurrences, _, err := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10)
+	if err != nil || len(noteOccurrences) != 1 {
+		t.Fatalf("Expected the note's occurrence to be linked to it, got %v, %v", noteOccurrences, err)
+	}
+	o := noteOccurrences[0]
+	restored, err := store.GetOccurrence(ctx, "p1", o.Name[strings.LastIndex(o.Name, "/")+1:])
+	if err != nil || !proto.Equal(o, restored) {
+		t.Errorf("Expected the occurrence to be listed whole through its slim link, got %v, %v", o, restored)
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	store, exported := export(t, false)
+	ctx := context.Background()
+
+	stats, err := (&backup.Importer{Store: store}).Import(ctx, bytes.NewReader(exported))
+	if err != nil || stats.Skipped != 10 || stats.Projects+stats.Notes+stats.Occurrences != 0 {
+		t.Errorf("Expected existing resources to be skipped, got %+v, %v", stats, err)
+	}
+
+	if _, err := (&backup.Importer{Store: store, Conflict: backup.ConflictFail}).Import(ctx, bytes.NewReader(exported)); err == nil {
+		t.Errorf("Expected the import to fail on an existing resource")
+	}
+
+	stats, err = (&backup.Importer{Store: store, Conflict: backup.ConflictFail, DryRun: true}).Import(ctx, bytes.NewReader(exported))
+	if err != nil || len(stats.Conflicts) != 10 {
+		t.Errorf("Expected a dry run to report every existing resource, got %+v, %v", stats, err)
+	}
+
+	if _, err := (&backup.Importer{Store: store, Conflict: "merge"}).Import(ctx, bytes.NewReader(exported)); err == nil {
+		t.Errorf("Expected an unknown conflict mode to be rejected")
+	}
+}
+
+func TestImportOverwritesMovedOccurrence(t *testing.T) {
+	store, _ := export(t, false)
+	ctx := context.Background()
+
+	occurrences, _, err := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10)
+	if err != nil || len(occurrences) != 1 {
+		t.Fatalf("ListNoteOccurrences failed, %v, %v", occurrences, err)
+	}
+	moved := proto.Clone(occurrences[0]).(*pb.Occurrence)
+	moved.NoteName = "projects/p1/notes/n2"
+	line := fmt.Sprintf(`{"name":%q,"noteName":%q,"createTime":"2019-10-01T12:00:00Z"}`, moved.Name, moved.NoteName)
+
+	stats, err := (&backup.Importer{Store: store, Conflict: backup.ConflictOverwrite}).Import(ctx, strings.NewReader(line+"\n"))
+	if err != nil || stats.Overwritten != 1 || stats.Occurrences != 1 {
+		t.Fatalf("Expected the occurrence to be overwritten, got %+v, %v", stats, err)
+	}
+
+	if occurrences, _, _ := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); len(occurrences) != 0 {
+		t.Errorf("Expected the occurrence to be unlinked from its old note, got %v", occurrences)
+	}
+	if occurrences, _, _ := store.ListNoteOccurrences(ctx, "p1", "n2", "", "", 10); len(occurrences) != 2 {
+		t.Errorf("Expected the occurrence to be linked to its new note, got %v", occurrences)
+	}
+	oID := moved.Name[strings.LastIndex(moved.Name, "/")+1:]
+	if o, err := store.GetOccurrence(ctx, "p1", oID); err != nil || o.CreateTime.Seconds != time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("Expected the imported occurrence to replace the stored one, got %v, %v", o, err)
+	}
+}
+
+func TestImportRecreatesDeletedNote(t *testing.T) {
+	store, exported := export(t, false)
+	ctx := context.Background()
+	if err := store.DeleteNote(ctx, "p1", "n2"); err != nil {
+		t.Fatalf("DeleteNote failed, %s", err)
+	}
+
+	stats, err := (&backup.Importer{Store: store}).Import(ctx, bytes.NewReader(exported))
+	if err != nil || stats.Notes != 1 {
+		t.Fatalf("Expected the deleted note to be imported, got %+v, %v", stats, err)
+	}
+
+	// the imported note is newer than the revision its deletion left, so can be updated
+	if _, err := store.UpdateNote(ctx, "p1", "n2", &pb.Note{ShortDescription: "updated"}, nil); err != nil {
+		t.Errorf("Expected the imported note to be updated, got %s", err)
+	}
+}
+
+func TestImportDryRunWritesNothing(t *testing.T) {
+	_, exported := export(t, false)
+	store := newStore(memdb.New(), "")
+
+	stats, err := (&backup.Importer{Store: store, DryRun: true}).Import(context.Background(), bytes.NewReader(exported))
+	if err != nil || stats.Projects != 2 || stats.Notes != 4 || stats.Occurrences != 4 {
+		t.Errorf("Expected a dry run to report what would be written, got %+v, %v", stats, err)
+	}
+	if projects, _, _ := store.ListProjects(context.Background(), "", 10, ""); len(projects) != 0 {
+		t.Errorf("Expected a dry run not to write, got %v", projects)
+	}
+}
+
+func TestImportRejectsInvalidInput(t *testing.T) {
+	store := newStore(memdb.New(), "")
+	for _, input := range []string{
+		`{"name":"projects/p1"}` + "\nnot json\n",
+		`{"name":"projects/p1/widgets/w1"}`,
+		`{"name":"projects/p1/occurrences/o1"}`,
+		`{"name":"projects/p1/notes/n1","kind":"SPACESHIP"}`,
+	} {
+		if _, err := (&backup.Importer{Store: store, DryRun: true}).Import(context.Background(), strings.NewReader(input)); err == nil {
+			t.Errorf("Expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestImportLimitsWriteRate(t *testing.T) {
+	_, exported := export(t, false)
+	store := newStore(memdb.New(), "")
+
+	start := time.Now()
+	if _, err := (&backup.Importer{Store: store, BatchSize: 1, WritesPerSecond: 100}).Import(context.Background(), bytes.NewReader(exported)); err != nil {
+		t.Fatalf("Import failed, %s", err)
+	}
+	// 14 rows, the last batch of which is written without waiting
+	if elapsed := time.Since(start); elapsed < 120*time.Millisecond {
+		t.Errorf("Expected the writes to be spread out, took %s", elapsed)
+	}
+}
@@ -0,0 +1,339 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/grafeas/grafeas/go/name"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// DefaultPageSize is the number of resources a migration lists from the source at a time unless told
+// otherwise.
+const DefaultPageSize = 100
+
+const (
+	migrationStageNotes       = "notes"
+	migrationStageOccurrences = "occurrences"
+)
+
+// Source is a Grafeas store that resources are migrated from, such as one created by a storage type provider
+// registered with Grafeas.  DynamoDb is a Source too, which is how migrated resources are counted.
+type Source interface {
+	ListProjects(ctx context.Context, filter string, pageSize int, pageToken string) ([]*prpb.Project, string, error)
+	ListNotes(ctx context.Context, pID, filter, pageToken string, pageSize int32) ([]*pb.Note, string, error)
+	ListOccurrences(ctx context.Context, pID, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error)
+}
+
+// Migrator copies the projects, notes and occurrences of another Grafeas store into DynamoDB.  Resources keep
+// their names and their create and update times.
+type Migrator struct {
+	Source Source
+	// Destination returns the store of the table that holds a project.
+	Destination func(pID string) *storage.DynamoDb
+	// Importer holds the settings of the writes, which are made as an Importer makes them.  Its Store and
+	// Destination are not used.
+	Importer Importer
+	// PageSize is the number of resources listed from the source at a time.  Defaults to DefaultPageSize.
+	PageSize int
+	// CheckpointFile is where progress is recorded after every page, if set.
+	CheckpointFile string
+	// Resume continues the migration that wrote the checkpoint.  A page written again after an interruption is
+	// counted as skipped or overwritten, according to the conflict mode.
+	Resume *MigrationCheckpoint
+}
+
+// Migrate copies every project of the source, followed by its notes and occurrences.
+func (m *Migrator) Migrate(ctx context.Context) (*ImportStats, error) {
+	pageSize := m.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+
+	checkpoint := &MigrationCheckpoint{}
+	if m.Resume != nil {
+		checkpoint = m.Resume
+	}
+	if checkpoint.Done {
+		return &checkpoint.Stats, nil
+	}
+
+	run := &migrationRun{
+		migrator:   m,
+		pageSize:   pageSize,
+		checkpoint: checkpoint,
+		limiter:    &limiter{rate: m.Importer.WritesPerSecond},
+		runs:       map[*storage.DynamoDb]*importRun{},
+		now:        time.Now(),
+	}
+
+	// the first page of projects is resumed at the project that was being migrated, and that project at the
+	// stage it reached
+	token := checkpoint.ProjectsPageToken
+	for {
+		projects, next, err := m.Source.ListProjects(ctx, "", pageSize, token)
+		if err != nil {
+			return &checkpoint.Stats, errors.New(fmt.Sprintf("Unable to list projects, %s", err))
+		}
+
+		for index := checkpoint.ProjectIndex; index < len(projects); index++ {
+			if err := run.migrateProject(ctx, projects[index]); err != nil {
+				return &checkpoint.Stats, err
+			}
+			*checkpoint = MigrationCheckpoint{ProjectsPageToken: token, ProjectIndex: index + 1, Stats: checkpoint.Stats}
+			if err := run.save(); err != nil {
+				return &checkpoint.Stats, err
+			}
+		}
+
+		token = next
+		*checkpoint = MigrationCheckpoint{ProjectsPageToken: token, Done: token == "", Stats: checkpoint.Stats}
+		if err := run.save(); err != nil {
+			return &checkpoint.Stats, err
+		}
+		if token == "" {
+			return &checkpoint.Stats, nil
+		}
+	}
+}
+
+// migrationRun is the state of a migration.
+type migrationRun struct {
+	migrator   *Migrator
+	pageSize   int
+	checkpoint *MigrationCheckpoint
+	// limiter is shared by the writes to every table
+	limiter *limiter
+	runs    map[*storage.DynamoDb]*importRun
+	now     time.Time
+}
+
+// migrateProject copies a project, its notes and its occurrences, carrying on from the stage and page of the
+// checkpoint.
+func (r *migrationRun) migrateProject(ctx context.Context, p *prpb.Project) error {
+	pID, err := name.ParseProject(p.Name)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to migrate project, %s", err))
+	}
+	store := r.migrator.Destination(pID)
+
+	if r.checkpoint.Stage == "" {
+		if err := r.write(ctx, store, []proto.Message{p}); err != nil {
+			return err
+		}
+		r.checkpoint.Stage = migrationStageNotes
+		r.checkpoint.PageToken = ""
+		if err := r.save(); err != nil {
+			return err
+		}
+	}
+
+	if r.checkpoint.Stage == migrationStageNotes {
+		err := r.migratePages(ctx, store, func(pageToken string) ([]proto.Message, string, error) {
+			notes, next, err := r.migrator.Source.ListNotes(ctx, pID, "", pageToken, int32(r.pageSize))
+			if err != nil {
+				return nil, "", errors.New(fmt.Sprintf("Unable to list notes of project %s, %s", pID, err))
+			}
+			var resources []proto.Message
+			for _, n := range notes {
+				if inProject(pID, n.Name, name.ParseNote) {
+					resources = append(resources, n)
+				}
+			}
+			return resources, next, nil
+		})
+		if err != nil {
+			return err
+		}
+		r.checkpoint.Stage = migrationStageOccurrences
+		r.checkpoint.PageToken = ""
+		if err := r.save(); err != nil {
+			return err
+		}
+	}
+
+	return r.migratePages(ctx, store, func(pageToken string) ([]proto.Message, string, error) {
+		occurrences, next, err := r.migrator.Source.ListOccurrences(ctx, pID, "", pageToken, int32(r.pageSize))
+		if err != nil {
+			return nil, "", errors.New(fmt.Sprintf("Unable to list occurrences of project %s, %s", pID, err))
+		}
+		var resources []proto.Message
+		for _, o := range occurrences {
+			if inProject(pID, o.Name, name.ParseOccurrence) {
+				resources = append(resources, o)
+			}
+		}
+		return resources, next, nil
+	})
+}
+
+// inProject reports whether the named resource belongs to the project.  Grafeas' memstore and embedded store
+// list the resources of every project whose ID starts with the ID asked for, e.g. p10 as well as p1.
+func inProject(pID, resourceName string, parse func(string) (string, string, error)) bool {
+	resourcePID, _, err := parse(resourceName)
+	return err == nil && resourcePID == pID
+}
+
+// migratePages lists pages from the token of the checkpoint onwards, writing each and recording that it has
+// been written.
+func (r *migrationRun) migratePages(ctx context.Context, store *storage.DynamoDb, list func(pageToken string) ([]proto.Message, string, error)) error {
+	for {
+		resources, next, err := list(r.checkpoint.PageToken)
+		if err != nil {
+			return err
+		}
+		if err := r.write(ctx, store, resources); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		r.checkpoint.PageToken = next
+		if err := r.save(); err != nil {
+			return err
+		}
+	}
+}
+
+// write writes resources to the store in batches.
+func (r *migrationRun) write(ctx context.Context, store *storage.DynamoDb, resources []proto.Message) error {
+	run, ok := r.runs[store]
+	if !ok {
+		importer := r.migrator.Importer
+		importer.Store = store
+		var err error
+		run, err = importer.newRun(r.limiter, &r.checkpoint.Stats)
+		if err != nil {
+			return err
+		}
+		r.runs[store] = run
+	}
+
+	var batch []*importedResource
+	for _, resource := range resources {
+		resourceName := resource.(interface{ GetName() string }).GetName()
+		imported, err := newImportedResource(store, run.importer.UserID, 0, resourceName, resource, r.now)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, imported)
+		if len(batch) == run.batchSize {
+			if err := run.writeBatch(ctx, batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	if len(batch) > 0 {
+		return run.writeBatch(ctx, batch)
+	}
+	return nil
+}
+
+func (r *migrationRun) save() error {
+	if r.migrator.CheckpointFile == "" || r.migrator.Importer.DryRun {
+		return nil
+	}
+	return r.checkpoint.Save(r.migrator.CheckpointFile)
+}
+
+// Verify compares the number of notes and occurrences of every project of the source with the number in
+// DynamoDB, and returns a description of every difference found.  Resources written to DynamoDB by other
+// means, or skipped because they already existed, are counted as they are found.
+func (m *Migrator) Verify(ctx context.Context) ([]string, error) {
+	pageSize := m.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultPageSize
+	}
+
+	var differences []string
+	token := ""
+	for {
+		projects, next, err := m.Source.ListProjects(ctx, "", pageSize, token)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to list projects, %s", err))
+		}
+
+		for _, p := range projects {
+			pID, err := name.ParseProject(p.Name)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to verify project, %s", err))
+			}
+			store := m.Destination(pID)
+			if _, err := store.GetProject(ctx, pID); err != nil {
+				differences = append(differences, fmt.Sprintf("Project %s is missing, %s", pID, err))
+				continue
+			}
+
+			for _, counter := range []struct {
+				kind  string
+				count func(Source) (int, error)
+			}{
+				{"notes", func(s Source) (int, error) { return countNotes(ctx, s, pID, pageSize) }},
+				{"occurrences", func(s Source) (int, error) { return countOccurrences(ctx, s, pID, pageSize) }},
+			} {
+				want, err := counter.count(m.Source)
+				if err != nil {
+					return nil, err
+				}
+				got, err := counter.count(store)
+				if err != nil {
+					return nil, err
+				}
+				if want != got {
+					differences = append(differences, fmt.Sprintf("Project %s has %d %s in the source but %d in DynamoDB", pID, want, counter.kind, got))
+				}
+			}
+		}
+
+		if next == "" {
+			return differences, nil
+		}
+		token = next
+	}
+}
+
+func countNotes(ctx context.Context, s Source, pID string, pageSize int) (int, error) {
+	count := 0
+	token := ""
+	for {
+		notes, next, err := s.ListNotes(ctx, pID, "", token, int32(pageSize))
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Unable to list notes of project %s, %s", pID, err))
+		}
+		for _, n := range notes {
+			if inProject(pID, n.Name, name.ParseNote) {
+				count++
+			}
+		}
+		if next == "" {
+			return count, nil
+		}
+		token = next
+	}
+}
+
+func countOccurrences(ctx context.Context, s Source, pID string, pageSize int) (int, error) {
+	count := 0
+	token := ""
+	for {
+		occurrences, next, err := s.ListOccurrences(ctx, pID, "", token, int32(pageSize))
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Unable to list occurrences of project %s, %s", pID, err))
+		}
+		for _, o := range occurrences {
+			if inProject(pID, o.Name, name.ParseOccurrence) {
+				count++
+			}
+		}
+		if next == "" {
+			return count, nil
+		}
+		token = next
+	}
+}
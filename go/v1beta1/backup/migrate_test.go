@@ -0,0 +1,140 @@
+package backup_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	grafeasStorage "github.com/grafeas/grafeas/go/v1beta1/storage"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/backup"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// newMemStore creates a Grafeas memstore holding projects p1 and p10, so that listing the resources of p1
+// also lists those of p10, each with two notes and an occurrence of each note.
+func newMemStore(t *testing.T) *grafeasStorage.MemStore {
+	ctx := context.Background()
+	source := grafeasStorage.NewMemStore()
+	for _, pID := range []string{"p1", "p10"} {
+		if _, err := source.CreateProject(ctx, pID, &prpb.Project{Name: "projects/" + pID}); err != nil {
+			t.Fatal(err)
+		}
+		for _, nID := range []string{"n1", "n2"} {
+			if _, err := source.CreateNote(ctx, pID, nID, "user", &pb.Note{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := source.CreateOccurrence(ctx, pID, "user", &pb.Occurrence{NoteName: "projects/" + pID + "/notes/" + nID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return source
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	source := newMemStore(t)
+	store := newStore(memdb.New(), "")
+	migrator := &backup.Migrator{
+		Source:      source,
+		Destination: func(pID string) *storage.DynamoDb { return store },
+		Importer:    backup.Importer{BatchSize: 1},
+		PageSize:    1,
+	}
+
+	stats, err := migrator.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate failed, %s", err)
+	}
+	if stats.Projects != 2 || stats.Notes != 4 || stats.Occurrences != 4 {
+		t.Errorf("Expected every resource to be migrated once, got %+v", stats)
+	}
+
+	occurrences, _, err := source.ListOccurrences(ctx, "p10", "", "", 10)
+	if err != nil || len(occurrences) != 2 {
+		t.Fatalf("ListOccurrences failed, %v, %v", occurrences, err)
+	}
+	for _, o := range occurrences {
+		migrated, err := store.GetOccurrence(ctx, "p10", o.Name[strings.LastIndex(o.Name, "/")+1:])
+		if err != nil || !proto.Equal(o, migrated) {
+			t.Errorf("Expected %v to be migrated with its name and times, got %v, %v", o, migrated, err)
+		}
+	}
+
+	if differences, err := migrator.Verify(ctx); err != nil || len(differences) != 0 {
+		t.Errorf("Expected the counts to match, got %v, %v", differences, err)
+	}
+
+	if err := store.DeleteNote(ctx, "p1", "n2"); err != nil {
+		t.Fatal(err)
+	}
+	if differences, err := migrator.Verify(ctx); err != nil || len(differences) != 1 {
+		t.Errorf("Expected the missing note to be found, got %v, %v", differences, err)
+	}
+}
+
+// failingSource fails to list occurrences after a number of calls.
+type failingSource struct {
+	backup.Source
+	calls int
+}
+
+func (s *failingSource) ListOccurrences(ctx context.Context, pID, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	if s.calls == 0 {
+		return nil, "", errors.New("connection reset")
+	}
+	s.calls--
+	return s.Source.ListOccurrences(ctx, pID, filter, pageToken, pageSize)
+}
+
+func TestMigrateResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+
+	source := newMemStore(t)
+	store := newStore(memdb.New(), "")
+	migrator := &backup.Migrator{
+		Source:         &failingSource{Source: source, calls: 3},
+		Destination:    func(pID string) *storage.DynamoDb { return store },
+		PageSize:       1,
+		CheckpointFile: checkpointFile,
+	}
+	if _, err := migrator.Migrate(ctx); err == nil {
+		t.Fatalf("Expected the migration to fail")
+	}
+
+	checkpoint, err := backup.LoadMigrationCheckpoint(checkpointFile)
+	if err != nil || checkpoint == nil || checkpoint.Done {
+		t.Fatalf("Expected an incomplete checkpoint, got %+v, %v", checkpoint, err)
+	}
+
+	migrator.Source = source
+	migrator.Resume = checkpoint
+	stats, err := migrator.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Resumed migration failed, %s", err)
+	}
+	if stats.Projects != 2 || stats.Notes != 4 || stats.Occurrences != 4 || stats.Skipped != 0 {
+		t.Errorf("Expected every resource to be migrated once across both runs, got %+v", stats)
+	}
+	if differences, err := migrator.Verify(ctx); err != nil || len(differences) != 0 {
+		t.Errorf("Expected the counts to match, got %v, %v", differences, err)
+	}
+
+	if checkpoint, _ := backup.LoadMigrationCheckpoint(checkpointFile); checkpoint == nil || !checkpoint.Done {
+		t.Errorf("Expected the checkpoint to be done, got %+v", checkpoint)
+	}
+}
@@ -0,0 +1,631 @@
+package changefeed_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/changefeed"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/webhook"
+	"golang.org/x/net/context"
+)
+
+const streamArn = "arn:aws:dynamodb:eu-west-2:123456789012:table/test_table/stream/2019-10-01T00:00:00.000"
+
+func record(t *testing.T, eventName string, dataItem storage.DataItem) *dynamodbstreams.Record {
+	image, err := dynamodbattribute.MarshalMap(dataItem)
+	if err != nil {
+		t.Fatalf("Unable to marshal item, %s", err)
+	}
+
+	streamRecord := &dynamodbstreams.StreamRecord{}
+	if eventName == dynamodbstreams.OperationTypeRemove {
+		streamRecord.OldImage = image
+	} else {
+		streamRecord.NewImage = image
+	}
+
+	return &dynamodbstreams.Record{
+		EventName: aws.String(eventName),
+		Dynamodb:  streamRecord,
+	}
+}
+
+func occurrenceRows(oName, noteName string) (storage.DataItem, storage.DataItem) {
+	jsonObject := fmt.Sprintf(`{"name":%q,"noteName":%q}`, oName, noteName)
+	return storage.DataItem{
+		PartitionKey: oName,
+		SortKey:      storage.OccurrenceSortKey,
+		Data:         "p1",
+		Json:         jsonObject,
+	}, storage.DataItem{
+		PartitionKey: oName,
+		SortKey:      noteName,
+		Data:         oName,
+		Json:         jsonObject,
+	}
+}
+
+type recordingSink struct {
+	events []*changefeed.Event
+	fail   bool
+}
+
+func (s *recordingSink) Deliver(ctx context.Context, event *changefeed.Event) error {
+	if s.fail {
+		return errors.New("sink unavailable")
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestConsumerDeliversDecodedEvents(t *testing.T) {
+	stream := changefeed.NewMemoryStream(streamArn)
+	stream.AddShard("shard-00000000000000000000001", "")
+
+	main, link := occurrenceRows("projects/p1/occurrences/o1", "projects/p2/notes/n1")
+	for _, r := range []*dynamodbstreams.Record{
+		record(t, dynamodbstreams.OperationTypeInsert, storage.DataItem{PartitionKey: "projects/p1", SortKey: storage.ProjectSortKey, Data: "projects/p1", Json: `{"name":"projects/p1"}`}),
+		record(t, dynamodbstreams.OperationTypeInsert, main),
+		record(t, dynamodbstreams.OperationTypeInsert, link),
+		record(t, dynamodbstreams.OperationTypeModify, main),
+		record(t, dynamodbstreams.OperationTypeModify, link),
+		record(t, dynamodbstreams.OperationTypeRemove, main),
+		record(t, dynamodbstreams.OperationTypeRemove, link),
+	} {
+		if err := stream.Append("shard-00000000000000000000001", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := &recordingSink{}
+	consumer := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{}, changefeed.NewMemoryCheckpointer(), sink)
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+
+	expected := []struct {
+		eventType changefeed.EventType
+		kind      changefeed.Kind
+		name      string
+	}{
+		{changefeed.Created, changefeed.ProjectKind, "projects/p1"},
+		{changefeed.Created, changefeed.OccurrenceKind, "projects/p1/occurrences/o1"},
+		{changefeed.Updated, changefeed.OccurrenceKind, "projects/p1/occurrences/o1"},
+		{changefeed.Deleted, changefeed.OccurrenceKind, "projects/p1/occurrences/o1"},
+	}
+
+	if len(sink.events) != len(expected) {
+		t.Fatalf("Got %d events, expected %d", len(sink.events), len(expected))
+	}
+
+	for i, e := range expected {
+		event := sink.events[i]
+		if event.Type != e.eventType || event.Kind != e.kind || event.Name != e.name {
+			t.Errorf("Event %d is %s %s %s, expected %s %s %s", i, event.Type, event.Kind, event.Name, e.eventType, e.kind, e.name)
+		}
+	}
+
+	if sink.events[3].Occurrence.NoteName != "projects/p2/notes/n1" {
+		t.Errorf("Deleted occurrence was not decoded from the old image, got note name '%s'", sink.events[3].Occurrence.NoteName)
+	}
+}
+
+func TestConsumerResumesFromCheckpoint(t *testing.T) {
+	stream := changefeed.NewMemoryStream(streamArn)
+	stream.AddShard("shard-00000000000000000000001", "")
+
+	main, _ := occurrenceRows("projects/p1/occurrences/o1", "projects/p2/notes/n1")
+	if err := stream.Append("shard-00000000000000000000001", record(t, dynamodbstreams.OperationTypeInsert, main)); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpointer := changefeed.NewMemoryCheckpointer()
+	sink := &recordingSink{fail: true}
+	consumer := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{}, checkpointer, sink)
+	if err := consumer.Poll(context.Background()); err == nil {
+		t.Fatal("Expected Poll to fail when the sink fails")
+	}
+
+	sink.fail = false
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("Got %d events after recovery, expected 1", len(sink.events))
+	}
+
+	// a new consumer sharing the checkpoints only sees new records
+	if err := stream.Append("shard-00000000000000000000001", record(t, dynamodbstreams.OperationTypeModify, main)); err != nil {
+		t.Fatal(err)
+	}
+
+	restartedSink := &recordingSink{}
+	restarted := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{}, checkpointer, restartedSink)
+	if err := restarted.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+	if len(restartedSink.events) != 1 || restartedSink.events[0].Type != changefeed.Updated {
+		t.Fatalf("Restarted consumer got %d events, expected only the update", len(restartedSink.events))
+	}
+}
+
+// streamedTable appends a record to a shard of the stream for every row written through it, as DynamoDB Streams
+// does for a table, and counts the writes to the shard's checkpoint.
+type streamedTable struct {
+	*memdb.DB
+	t           *testing.T
+	stream      *changefeed.MemoryStream
+	shardID     string
+	checkpoints int
+}
+
+func (s *streamedTable) image(tableName *string, item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	result, err := s.DB.GetItem(&dynamodb.GetItemInput{
+		TableName: tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: item[storage.PartitionKeyName],
+			storage.SortKeyName:      item[storage.SortKeyName],
+		},
+	})
+	if err != nil {
+		s.t.Fatalf("GetItem failed, %s", err)
+	}
+	if len(result.Item) == 0 {
+		return nil
+	}
+	return result.Item
+}
+
+// write makes a write of the rows with the given keys, then appends a record of each row that it changed.
+func (s *streamedTable) write(tableName *string, keys []map[string]*dynamodb.AttributeValue, write func() error) error {
+	var oldImages []map[string]*dynamodb.AttributeValue
+	for _, key := range keys {
+		oldImages = append(oldImages, s.image(tableName, key))
+	}
+
+	if err := write(); err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		streamRecord := &dynamodbstreams.StreamRecord{OldImage: oldImages[i], NewImage: s.image(tableName, key)}
+		eventName := dynamodbstreams.OperationTypeModify
+		switch {
+		case streamRecord.OldImage == nil && streamRecord.NewImage == nil:
+			continue
+		case streamRecord.OldImage == nil:
+			eventName = dynamodbstreams.OperationTypeInsert
+		case streamRecord.NewImage == nil:
+			eventName = dynamodbstreams.OperationTypeRemove
+		}
+
+		if aws.StringValue(key[storage.SortKeyName].S) == s.shardID {
+			s.checkpoints++
+		}
+		if err := s.stream.Append(s.shardID, &dynamodbstreams.Record{EventName: aws.String(eventName), Dynamodb: streamRecord}); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	return nil
+}
+
+func (s *streamedTable) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	var output *dynamodb.PutItemOutput
+	err := s.write(input.TableName, []map[string]*dynamodb.AttributeValue{input.Item}, func() (err error) {
+		output, err = s.DB.PutItemWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (s *streamedTable) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return s.PutItemWithContext(aws.BackgroundContext(), input)
+}
+
+func (s *streamedTable) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	var output *dynamodb.DeleteItemOutput
+	err := s.write(input.TableName, []map[string]*dynamodb.AttributeValue{input.Key}, func() (err error) {
+		output, err = s.DB.DeleteItemWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (s *streamedTable) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return s.DeleteItemWithContext(aws.BackgroundContext(), input)
+}
+
+func (s *streamedTable) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	var tableName *string
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, transactItem := range input.TransactItems {
+		switch {
+		case transactItem.Put != nil:
+			tableName = transactItem.Put.TableName
+			keys = append(keys, transactItem.Put.Item)
+		case transactItem.Delete != nil:
+			tableName = transactItem.Delete.TableName
+			keys = append(keys, transactItem.Delete.Key)
+		}
+	}
+
+	var output *dynamodb.TransactWriteItemsOutput
+	err := s.write(tableName, keys, func() (err error) {
+		output, err = s.DB.TransactWriteItemsWithContext(ctx, input, opts...)
+		return err
+	})
+	return output, err
+}
+
+func (s *streamedTable) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return s.TransactWriteItemsWithContext(aws.BackgroundContext(), input)
+}
+
+func TestConsumerDoesNotCheckpointItsOwnCheckpoints(t *testing.T) {
+	stream := changefeed.NewMemoryStream(streamArn)
+	stream.AddShard("shard-00000000000000000000001", "")
+	table := &streamedTable{DB: memdb.New(), t: t, stream: stream, shardID: "shard-00000000000000000000001"}
+	store := storage.NewDynamoDbStoreWithClient(table, &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	if _, err := store.CreateNote(context.Background(), "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+
+	sink := &recordingSink{}
+	checkpointer := &changefeed.TableCheckpointer{Client: table, TableName: "test_table", ConsumerName: "feed"}
+	consumer := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{Opener: store}, checkpointer, sink)
+	for i := 0; i < 3; i++ {
+		if err := consumer.Poll(context.Background()); err != nil {
+			t.Fatalf("Poll failed, %s", err)
+		}
+	}
+
+	// the checkpoint's own record is read on the next pass, and must not be checkpointed in turn
+	if len(sink.events) != 1 || sink.events[0].Kind != changefeed.NoteKind {
+		t.Fatalf("Got %d events, expected only the note to be created", len(sink.events))
+	}
+	if table.checkpoints != 1 {
+		t.Errorf("Got %d checkpoint writes, expected 1", table.checkpoints)
+	}
+
+	// a restarted consumer skips the checkpoint's record again without writing
+	restarted := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{Opener: store}, checkpointer, sink)
+	if err := restarted.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+	if len(sink.events) != 1 || table.checkpoints != 1 {
+		t.Errorf("Got %d events and %d checkpoint writes after a restart, expected 1 and 1", len(sink.events), table.checkpoints)
+	}
+}
+
+func TestOnlyTheLeaseHolderConsumes(t *testing.T) {
+	client := memdb.New()
+	storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	stream := changefeed.NewMemoryStream(streamArn)
+	stream.AddShard("shard-00000000000000000000001", "")
+	main, _ := occurrenceRows("projects/p1/occurrences/o1", "projects/p2/notes/n1")
+	if err := stream.Append("shard-00000000000000000000001", record(t, dynamodbstreams.OperationTypeInsert, main)); err != nil {
+		t.Fatal(err)
+	}
+
+	// replicas share the consumer name, and so the checkpoints and the lease
+	checkpointer := &changefeed.TableCheckpointer{Client: client, TableName: "test_table", ConsumerName: "feed"}
+	replica := func(sink *recordingSink) *changefeed.Consumer {
+		consumer := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{}, checkpointer, sink)
+		consumer.Lease = &changefeed.TableLease{
+			Client:       client,
+			TableName:    "test_table",
+			ConsumerName: "feed",
+			Owner:        changefeed.NewLeaseOwner(),
+			Duration:     100 * time.Millisecond,
+		}
+		return consumer
+	}
+	firstSink, secondSink := &recordingSink{}, &recordingSink{}
+	first, second := replica(firstSink), replica(secondSink)
+
+	for _, consumer := range []*changefeed.Consumer{first, second, first, second} {
+		if err := consumer.Poll(context.Background()); err != nil {
+			t.Fatalf("Poll failed, %s", err)
+		}
+	}
+	if len(firstSink.events) != 1 || len(secondSink.events) != 0 {
+		t.Fatalf("Got %d and %d events, expected only the lease holder to deliver", len(firstSink.events), len(secondSink.events))
+	}
+
+	// once the holder stops renewing, the other replica takes over from its checkpoint
+	time.Sleep(150 * time.Millisecond)
+	if err := stream.Append("shard-00000000000000000000001", record(t, dynamodbstreams.OperationTypeModify, main)); err != nil {
+		t.Fatal(err)
+	}
+	for _, consumer := range []*changefeed.Consumer{second, first} {
+		if err := consumer.Poll(context.Background()); err != nil {
+			t.Fatalf("Poll failed, %s", err)
+		}
+	}
+	if len(firstSink.events) != 1 || len(secondSink.events) != 1 || secondSink.events[0].Type != changefeed.Updated {
+		t.Errorf("Got %d and %d events, expected the new holder to deliver only the update", len(firstSink.events), len(secondSink.events))
+	}
+}
+
+func TestConsumerDrainsParentShardsFirst(t *testing.T) {
+	stream := changefeed.NewMemoryStream(streamArn)
+	stream.AddShard("shard-00000000000000000000001", "")
+	stream.AddShard("shard-00000000000000000000002", "shard-00000000000000000000001")
+
+	main, _ := occurrenceRows("projects/p1/occurrences/o1", "projects/p2/notes/n1")
+	if err := stream.Append("shard-00000000000000000000001", record(t, dynamodbstreams.OperationTypeInsert, main)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Append("shard-00000000000000000000002", record(t, dynamodbstreams.OperationTypeRemove, main)); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &recordingSink{}
+	consumer := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{}, changefeed.NewMemoryCheckpointer(), sink)
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("Got %d events from an open parent shard, expected 1", len(sink.events))
+	}
+
+	stream.CloseShard("shard-00000000000000000000001")
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+	if len(sink.events) != 2 || sink.events[1].Type != changefeed.Deleted {
+		t.Fatalf("Child shard was not read once its parent was closed, got %d events", len(sink.events))
+	}
+}
+
+func TestDecoderSkipsOtherNamespaces(t *testing.T) {
+	r := record(t, dynamodbstreams.OperationTypeInsert, storage.DataItem{
+		PartitionKey: storage.NamespacedKey("dev", "projects/p1"),
+		SortKey:      storage.NamespacedKey("dev", storage.ProjectSortKey),
+		Data:         storage.NamespacedKey("dev", "projects/p1"),
+		Json:         `{"name":"projects/p1"}`,
+	})
+
+	event, err := (&changefeed.Decoder{Namespace: "dev"}).Decode("shard", r)
+	if err != nil || event == nil || event.Kind != changefeed.ProjectKind || event.Name != "projects/p1" {
+		t.Errorf("Expected a project event without the namespace, got %+v, %v", event, err)
+	}
+
+	if event, err := (&changefeed.Decoder{Namespace: "prod"}).Decode("shard", r); err != nil || event != nil {
+		t.Errorf("Expected the record of another namespace to be skipped, got %+v, %v", event, err)
+	}
+}
+
+// flakyOpener fails to open payloads while it is unavailable, as a key service might.
+type flakyOpener struct {
+	unavailable bool
+}
+
+func (o *flakyOpener) OpenPayload(dataItem *storage.DataItem) (string, error) {
+	if o.unavailable {
+		return "", errors.New("key service unavailable")
+	}
+	return dataItem.Json, nil
+}
+
+func TestConsumerRetriesTransientDecodeErrors(t *testing.T) {
+	stream := changefeed.NewMemoryStream(streamArn)
+	stream.AddShard("shard-00000000000000000000001", "")
+
+	malformed, _ := occurrenceRows("projects/p1/occurrences/o1", "projects/p2/notes/n1")
+	malformed.Json = "{"
+	main, _ := occurrenceRows("projects/p1/occurrences/o2", "projects/p2/notes/n1")
+	for _, r := range []*dynamodbstreams.Record{
+		record(t, dynamodbstreams.OperationTypeInsert, malformed),
+		record(t, dynamodbstreams.OperationTypeInsert, main),
+	} {
+		if err := stream.Append("shard-00000000000000000000001", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opener := &flakyOpener{unavailable: true}
+	sink := &recordingSink{}
+	consumer := changefeed.NewConsumer(stream, streamArn, &changefeed.Decoder{Opener: opener}, changefeed.NewMemoryCheckpointer(), sink)
+	if err := consumer.Poll(context.Background()); err == nil {
+		t.Fatal("Expected Poll to fail when payloads cannot be opened")
+	}
+	if len(sink.events) != 0 {
+		t.Fatalf("Got %d events while payloads cannot be opened, expected none", len(sink.events))
+	}
+
+	// once the opener recovers, the malformed record is skipped and the next is delivered
+	opener.unavailable = false
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+	if len(sink.events) != 1 || sink.events[0].Name != "projects/p1/occurrences/o2" {
+		t.Fatalf("Expected only the well formed record to be delivered, got %d events", len(sink.events))
+	}
+}
+
+func TestDecoderReportsMalformedRecords(t *testing.T) {
+	main, _ := occurrenceRows("projects/p1/occurrences/o1", "projects/p2/notes/n1")
+	main.Json = "not json"
+	_, err := (&changefeed.Decoder{}).Decode("shard", record(t, dynamodbstreams.OperationTypeInsert, main))
+	if _, ok := err.(*changefeed.MalformedRecordError); !ok {
+		t.Errorf("Expected invalid json to be reported as malformed, got %v", err)
+	}
+
+	main.Json = `{"name":"projects/p1/occurrences/o1"}`
+	_, err = (&changefeed.Decoder{Opener: &flakyOpener{unavailable: true}}).Decode("shard", record(t, dynamodbstreams.OperationTypeInsert, main))
+	if _, ok := err.(*changefeed.MalformedRecordError); err == nil || ok {
+		t.Errorf("Expected a failure to open the payload not to be reported as malformed, got %v", err)
+	}
+}
+
+func TestDecoderSkipsReEncryption(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("Unable to create directory, %s", err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "keys")
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32))
+	if err := ioutil.WriteFile(keyFile, []byte("k1 "+key+"\n"), 0600); err != nil {
+		t.Fatalf("Unable to write key file, %s", err)
+	}
+
+	client := memdb.New()
+	storeConfig := &config.DynamoDbConfig{TableName: "test_table", AWS: &config.AwsConfig{Region: aws.String("eu-west-2")}}
+	plain := storage.NewDynamoDbStoreWithClient(client, storeConfig)
+	if _, err := plain.CreateNote(ctx, "p1", "n1", "user", &pb.Note{ShortDescription: "first"}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	noteImage := func() map[string]*dynamodb.AttributeValue {
+		result, err := client.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test_table"), Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String("projects/p1/notes/n1")},
+			storage.SortKeyName:      {S: aws.String(storage.NoteSortKey)},
+		}})
+		if err != nil {
+			t.Fatalf("GetItem failed, %s", err)
+		}
+		return result.Item
+	}
+	modified := func(oldImage, newImage map[string]*dynamodb.AttributeValue) *dynamodbstreams.Record {
+		return &dynamodbstreams.Record{
+			EventName: aws.String(dynamodbstreams.OperationTypeModify),
+			Dynamodb:  &dynamodbstreams.StreamRecord{OldImage: oldImage, NewImage: newImage},
+		}
+	}
+
+	encryptedConfig := *storeConfig
+	encryptedConfig.Encryption = &config.EncryptionConfig{Provider: "local", KeyFile: keyFile}
+	encrypted := storage.NewDynamoDbStoreWithClient(client, &encryptedConfig)
+	decoder := &changefeed.Decoder{Opener: encrypted}
+
+	plaintextImage := noteImage()
+	if rewritten, err := encrypted.ReEncrypt(ctx); err != nil || rewritten != 1 {
+		t.Fatalf("Expected the note to be re-encrypted, got %d, %v", rewritten, err)
+	}
+	encryptedImage := noteImage()
+	if event, err := decoder.Decode("shard", modified(plaintextImage, encryptedImage)); err != nil || event != nil {
+		t.Errorf("Expected re-encryption not to be reported, got %v, %v", event, err)
+	}
+
+	if _, err := encrypted.UpdateNote(ctx, "p1", "n1", &pb.Note{ShortDescription: "second"}, nil); err != nil {
+		t.Fatalf("UpdateNote failed, %s", err)
+	}
+	event, err := decoder.Decode("shard", modified(encryptedImage, noteImage()))
+	if err != nil || event == nil || event.Type != changefeed.Updated || event.Note.GetShortDescription() != "second" {
+		t.Errorf("Expected the update to be reported, got %v, %v", event, err)
+	}
+}
+
+func TestStoreConsumerDeliversToConfiguredSinks(t *testing.T) {
+	var requests []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	store := storage.NewDynamoDbStoreWithClient(memdb.New(), &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	feedConfig := &config.ChangeFeedConfig{
+		Sinks: []config.ChangeSinkConfig{{Type: "log"}, {Type: "http", URL: server.URL, Secret: "secret"}},
+	}
+
+	// the in-memory table has no stream of its own
+	if _, err := changefeed.NewStoreConsumer(store, changefeed.NewMemoryStream(streamArn), feedConfig); err == nil {
+		t.Error("Expected a table without a stream to be rejected")
+	}
+
+	stream := changefeed.NewMemoryStream(streamArn)
+	stream.AddShard("shard-00000000000000000000001", "")
+	main, _ := occurrenceRows("projects/p1/occurrences/o1", "projects/p2/notes/n1")
+	if err := stream.Append("shard-00000000000000000000001", record(t, dynamodbstreams.OperationTypeInsert, main)); err != nil {
+		t.Fatal(err)
+	}
+
+	feedConfig.StreamArn = streamArn
+	consumer, err := changefeed.NewStoreConsumer(store, stream, feedConfig)
+	if err != nil {
+		t.Fatalf("NewStoreConsumer failed, %s", err)
+	}
+	if err := consumer.Poll(context.Background()); err != nil {
+		t.Fatalf("Poll failed, %s", err)
+	}
+
+	if len(requests) != 1 {
+		t.Fatalf("Got %d requests, expected 1", len(requests))
+	}
+	if signature := requests[0].Header.Get(webhook.SignatureHeader); signature != webhook.Sign([]byte("secret"), bodies[0]) {
+		t.Errorf("Expected the body to be signed, got signature %q", signature)
+	}
+	var payload struct {
+		Type     string
+		Kind     string
+		Name     string
+		Resource struct{ NoteName string }
+	}
+	if err := json.Unmarshal(bodies[0], &payload); err != nil || payload.Type != "CREATED" || payload.Kind != "OCCURRENCE" ||
+		payload.Name != "projects/p1/occurrences/o1" || payload.Resource.NoteName != "projects/p2/notes/n1" {
+		t.Errorf("Unexpected body %s, %v", bodies[0], err)
+	}
+
+	// the position is checkpointed in the store's table, under the default consumer name
+	checkpointer := &changefeed.TableCheckpointer{Client: store.DynamoDBAPI, TableName: "test_table", ConsumerName: changefeed.DefaultConsumerName}
+	if checkpoint, err := checkpointer.Checkpoint("shard-00000000000000000000001"); err != nil || checkpoint == "" {
+		t.Errorf("Expected the shard to be checkpointed, got %q, %v", checkpoint, err)
+	}
+}
+
+func TestTableCheckpointsAreNamespaced(t *testing.T) {
+	client := memdb.New()
+	storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	dev := &changefeed.TableCheckpointer{Client: client, TableName: "test_table", Namespace: "dev", ConsumerName: "feed"}
+	prod := &changefeed.TableCheckpointer{Client: client, TableName: "test_table", Namespace: "prod", ConsumerName: "feed"}
+
+	if err := dev.SetCheckpoint("shard-1", "100"); err != nil {
+		t.Fatalf("SetCheckpoint failed, %s", err)
+	}
+	if err := prod.SetCheckpoint("shard-1", "200"); err != nil {
+		t.Fatalf("SetCheckpoint failed, %s", err)
+	}
+
+	if checkpoint, err := dev.Checkpoint("shard-1"); err != nil || checkpoint != "100" {
+		t.Errorf("Expected the dev checkpoint to be kept, got %q, %v", checkpoint, err)
+	}
+	if checkpoint, err := prod.Checkpoint("shard-1"); err != nil || checkpoint != "200" {
+		t.Errorf("Expected the prod checkpoint to be kept, got %q, %v", checkpoint, err)
+	}
+}
@@ -0,0 +1,113 @@
+package changefeed
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+)
+
+// Checkpointer records, per shard, the sequence number of the last record that was delivered to every sink.
+type Checkpointer interface {
+	// Checkpoint returns the last sequence number recorded for the shard, or "" if there is none.
+	Checkpoint(shardID string) (string, error)
+	// SetCheckpoint records the sequence number of the last record delivered from the shard.
+	SetCheckpoint(shardID, sequenceNumber string) error
+}
+
+// MemoryCheckpointer keeps checkpoints in memory, so a restarted consumer starts from the oldest record still
+// held by the stream.
+type MemoryCheckpointer struct {
+	mu          sync.Mutex
+	checkpoints map[string]string
+}
+
+// NewMemoryCheckpointer creates an empty MemoryCheckpointer.
+func NewMemoryCheckpointer() *MemoryCheckpointer {
+	return &MemoryCheckpointer{
+		checkpoints: map[string]string{},
+	}
+}
+
+// Checkpoint returns the last sequence number recorded for the shard.
+func (c *MemoryCheckpointer) Checkpoint(shardID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checkpoints[shardID], nil
+}
+
+// SetCheckpoint records the last sequence number delivered from the shard.
+func (c *MemoryCheckpointer) SetCheckpoint(shardID, sequenceNumber string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkpoints[shardID] = sequenceNumber
+	return nil
+}
+
+// checkpointPKPrefix prefixes the partition key of checkpoint rows, followed by the consumer name.
+const checkpointPKPrefix = "CHECKPOINT#"
+
+// TableCheckpointer keeps checkpoints as rows in a DynamoDB table, typically the Grafeas table itself, so that
+// consumers resume where they left off after a restart.  Checkpoint rows are not projects, notes or
+// occurrences, so their own stream records are skipped by the Decoder and, having no event, are not themselves
+// checkpointed by the Consumer.  Their keys are prefixed with Namespace, as those of the store's entities are,
+// so that deployments sharing a table keep their own positions.
+type TableCheckpointer struct {
+	Client    dynamodbiface.DynamoDBAPI
+	TableName string
+	Namespace string
+	// ConsumerName distinguishes the checkpoints of independent consumers of the same stream.
+	ConsumerName string
+}
+
+func (c *TableCheckpointer) partitionKey() string {
+	return storage.NamespacedKey(c.Namespace, checkpointPKPrefix+c.ConsumerName)
+}
+
+// Checkpoint reads the last sequence number recorded for the shard.
+func (c *TableCheckpointer) Checkpoint(shardID string) (string, error) {
+	result, err := c.Client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(c.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {
+				S: aws.String(c.partitionKey()),
+			},
+			storage.SortKeyName: {
+				S: aws.String(shardID),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if sequenceNumber, ok := result.Item[sequenceNumberKeyName]; ok && sequenceNumber.S != nil {
+		return *sequenceNumber.S, nil
+	}
+
+	return "", nil
+}
+
+// SetCheckpoint writes the last sequence number delivered from the shard.
+func (c *TableCheckpointer) SetCheckpoint(shardID, sequenceNumber string) error {
+	_, err := c.Client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(c.TableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {
+				S: aws.String(c.partitionKey()),
+			},
+			storage.SortKeyName: {
+				S: aws.String(shardID),
+			},
+			sequenceNumberKeyName: {
+				S: aws.String(sequenceNumber),
+			},
+		},
+	})
+	return err
+}
+
+const sequenceNumberKeyName = "SequenceNumber"
@@ -0,0 +1,383 @@
+package changefeed
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// DefaultPollInterval is how long a consumer waits between passes once it has caught up with the stream.
+const DefaultPollInterval = time.Second
+
+// Consumer reads the shards of a table's stream, decodes the records into events and delivers them to sinks,
+// checkpointing its position in each shard as it goes.  Records are delivered at least once: after a failure,
+// delivery resumes from the last checkpoint.
+type Consumer struct {
+	Streams      dynamodbstreamsiface.DynamoDBStreamsAPI
+	StreamArn    string
+	Decoder      *Decoder
+	Checkpointer Checkpointer
+	Sinks        []Sink
+	PollInterval time.Duration
+	// Lease, if set, must be held for the consumer to read the stream, so that of the consumers sharing its
+	// checkpoints only one delivers events.  The others wait for its hold to expire.
+	Lease Lease
+	// Logger records skipped records and failed passes.  If nil, the standard logrus logger is used.
+	Logger *logrus.Logger
+
+	iterators map[string]*string
+	finished  map[string]bool
+
+	leaseHeld    bool
+	leaseRenewal time.Time
+}
+
+// NewConsumer creates a consumer of the stream with the given ARN.
+func NewConsumer(streams dynamodbstreamsiface.DynamoDBStreamsAPI, streamArn string, decoder *Decoder, checkpointer Checkpointer, sinks ...Sink) *Consumer {
+	return &Consumer{
+		Streams:      streams,
+		StreamArn:    streamArn,
+		Decoder:      decoder,
+		Checkpointer: checkpointer,
+		Sinks:        sinks,
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// DefaultConsumerName names the checkpoints of a consumer configured without a name.
+const DefaultConsumerName = "grafeas"
+
+// NewStoreConsumer creates a consumer of the stream of the store's table, as configured by the change_feed
+// section, that decrypts payloads with the store and checkpoints in its table.  Without a configured stream
+// ARN, the latest stream of the table is read.
+func NewStoreConsumer(store *storage.DynamoDb, streams dynamodbstreamsiface.DynamoDBStreamsAPI, feedConfig *config.ChangeFeedConfig) (*Consumer, error) {
+	streamArn := feedConfig.StreamArn
+	if streamArn == "" {
+		var err error
+		streamArn, err = LatestStreamArn(store.DynamoDBAPI, store.TableName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	consumerName := feedConfig.ConsumerName
+	if consumerName == "" {
+		consumerName = DefaultConsumerName
+	}
+
+	var sinks []Sink
+	for _, sinkConfig := range feedConfig.Sinks {
+		switch sinkConfig.Type {
+		case "log":
+			sinks = append(sinks, LogSink{Logger: store.BaseLogger()})
+		case "http":
+			if sinkConfig.URL == "" {
+				return nil, errors.New("The http change feed sink must have a url")
+			}
+			sinks = append(sinks, &HTTPSink{URL: sinkConfig.URL, Secret: sinkConfig.Secret})
+		default:
+			return nil, errors.New(fmt.Sprintf("Unknown change feed sink %q, must be 'log' or 'http'", sinkConfig.Type))
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, errors.New("The change feed must have at least one sink")
+	}
+
+	consumer := NewConsumer(streams, streamArn,
+		&Decoder{Opener: store, Namespace: store.Namespace},
+		&TableCheckpointer{Client: store.DynamoDBAPI, TableName: store.TableName, Namespace: store.Namespace, ConsumerName: consumerName},
+		sinks...)
+	consumer.Lease = &TableLease{
+		Client:       store.DynamoDBAPI,
+		TableName:    store.TableName,
+		Namespace:    store.Namespace,
+		ConsumerName: consumerName,
+		Owner:        NewLeaseOwner(),
+		Duration:     DefaultLeaseDuration,
+	}
+	consumer.Logger = store.BaseLogger()
+
+	if feedConfig.PollInterval != "" {
+		interval, err := time.ParseDuration(feedConfig.PollInterval)
+		if err != nil || interval <= 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid change feed poll interval %q", feedConfig.PollInterval))
+		}
+		consumer.PollInterval = interval
+	}
+
+	return consumer, nil
+}
+
+// LatestStreamArn returns the ARN of the current stream of a table.
+func LatestStreamArn(client dynamodbiface.DynamoDBAPI, tableName string) (string, error) {
+	output, err := client.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if output.Table == nil || output.Table.LatestStreamArn == nil {
+		return "", errors.New(fmt.Sprintf("Table %s does not have a stream enabled", tableName))
+	}
+
+	return *output.Table.LatestStreamArn, nil
+}
+
+// Run polls the stream until the context is cancelled.  A pass that fails is logged, and the records it did not
+// deliver are delivered again from the last checkpoint on the next pass.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		if err := c.Poll(ctx); err != nil {
+			c.logger().WithError(err).Errorf("Unable to process change feed of %s", c.StreamArn)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+// Poll makes a single pass over the shards of the stream, delivering every record that is available.  Parent
+// shards are drained before their children, so events for an item are delivered in order.  A consumer that does
+// not hold its lease does nothing.
+func (c *Consumer) Poll(ctx context.Context) error {
+	if c.iterators == nil {
+		c.iterators = map[string]*string{}
+		c.finished = map[string]bool{}
+	}
+
+	if held, err := c.holdLease(ctx); err != nil || !held {
+		return err
+	}
+
+	shards, err := c.listShards(ctx)
+	if err != nil {
+		return err
+	}
+
+	present := map[string]bool{}
+	for _, shard := range shards {
+		present[*shard.ShardId] = true
+	}
+
+	for _, shard := range shards {
+		shardID := *shard.ShardId
+		if c.finished[shardID] {
+			continue
+		}
+
+		if shard.ParentShardId != nil && present[*shard.ParentShardId] && !c.finished[*shard.ParentShardId] {
+			continue
+		}
+
+		if err := c.pollShard(ctx, shardID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Consumer) listShards(ctx context.Context) ([]*dynamodbstreams.Shard, error) {
+	var shards []*dynamodbstreams.Shard
+
+	input := &dynamodbstreams.DescribeStreamInput{
+		StreamArn: aws.String(c.StreamArn),
+	}
+	for {
+		output, err := c.Streams.DescribeStreamWithContext(ctx, input)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to describe stream %s, %s", c.StreamArn, err))
+		}
+
+		shards = append(shards, output.StreamDescription.Shards...)
+		if output.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = output.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// pollShard delivers the available records of a shard, marking the shard finished once it has been closed and
+// fully read.
+func (c *Consumer) pollShard(ctx context.Context, shardID string) error {
+	checkpoint, err := c.Checkpointer.Checkpoint(shardID)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to read checkpoint of shard %s, %s", shardID, err))
+	}
+
+	for {
+		// a long catch-up renews the lease as it goes, and stops if the lease has been lost
+		if held, err := c.holdLease(ctx); err != nil || !held {
+			return err
+		}
+
+		iterator, err := c.iterator(ctx, shardID, checkpoint)
+		if err != nil {
+			return err
+		}
+
+		output, err := c.Streams.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: iterator,
+		})
+		if err != nil {
+			delete(c.iterators, shardID)
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodbstreams.ErrCodeExpiredIteratorException {
+				continue
+			}
+			return errors.New(fmt.Sprintf("Unable to read records from shard %s, %s", shardID, err))
+		}
+
+		for _, record := range output.Records {
+			if record.Dynamodb == nil {
+				continue
+			}
+
+			sequenceNumber := aws.StringValue(record.Dynamodb.SequenceNumber)
+			if !sequenceNumberAfter(sequenceNumber, checkpoint) {
+				continue
+			}
+
+			delivered, err := c.deliver(ctx, shardID, record)
+			if err != nil {
+				// restart from the checkpoint on the next poll
+				delete(c.iterators, shardID)
+				return err
+			}
+			if !delivered {
+				continue
+			}
+
+			if err := c.Checkpointer.SetCheckpoint(shardID, sequenceNumber); err != nil {
+				delete(c.iterators, shardID)
+				return errors.New(fmt.Sprintf("Unable to checkpoint shard %s, %s", shardID, err))
+			}
+			checkpoint = sequenceNumber
+		}
+
+		if output.NextShardIterator == nil {
+			delete(c.iterators, shardID)
+			c.finished[shardID] = true
+			return nil
+		}
+
+		c.iterators[shardID] = output.NextShardIterator
+		if len(output.Records) == 0 {
+			return nil
+		}
+	}
+}
+
+// holdLease reports whether the consumer holds its lease, taking or renewing it once a third of its hold has
+// passed, or once another consumer's hold has expired.  When the lease is lost, the positions reached in each
+// shard are forgotten, as another consumer has moved the checkpoints on.
+func (c *Consumer) holdLease(ctx context.Context) (bool, error) {
+	if c.Lease == nil {
+		return true, nil
+	}
+
+	now := time.Now()
+	if now.Before(c.leaseRenewal) {
+		return c.leaseHeld, nil
+	}
+
+	held, expiry, err := c.Lease.Acquire(ctx)
+	if err != nil || !held {
+		if c.leaseHeld && err == nil {
+			c.logger().Infof("Stopped consuming %s, its lease is held by another consumer", c.StreamArn)
+		}
+		c.leaseHeld = false
+		c.leaseRenewal = expiry
+		c.iterators = map[string]*string{}
+		return false, err
+	}
+
+	if !c.leaseHeld {
+		c.logger().Infof("Consuming %s", c.StreamArn)
+	}
+	c.leaseHeld = true
+	c.leaseRenewal = now.Add(expiry.Sub(now) / 3)
+	return true, nil
+}
+
+// iterator returns the iterator to continue reading a shard from, starting after the checkpoint if there is no
+// iterator from a previous read.
+func (c *Consumer) iterator(ctx context.Context, shardID, checkpoint string) (*string, error) {
+	if iterator, ok := c.iterators[shardID]; ok {
+		return iterator, nil
+	}
+
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(c.StreamArn),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+	}
+	if checkpoint != "" {
+		input.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		input.SequenceNumber = aws.String(checkpoint)
+	}
+
+	output, err := c.Streams.GetShardIteratorWithContext(ctx, input)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to get iterator for shard %s, %s", shardID, err))
+	}
+
+	return output.ShardIterator, nil
+}
+
+// deliver decodes a record and delivers its event to every sink, returning whether the record's position should
+// be checkpointed.  Records without an event are not checkpointed: among them are the records of the checkpoint
+// rows themselves, so checkpointing them in the table whose stream is read would write a row for every row
+// written, forever.  They are read and skipped again after a restart.
+func (c *Consumer) deliver(ctx context.Context, shardID string, record *dynamodbstreams.Record) (bool, error) {
+	event, err := c.Decoder.Decode(shardID, record)
+	if _, ok := err.(*MalformedRecordError); ok {
+		// a malformed record will never succeed, so it is skipped rather than blocking the shard
+		c.logger().WithError(err).Warnf("Skipping undecodable record from shard %s", shardID)
+		return true, nil
+	} else if err != nil {
+		return false, errors.New(fmt.Sprintf("Unable to decode record from shard %s, %s", shardID, err))
+	}
+
+	if event == nil {
+		return false, nil
+	}
+
+	for _, sink := range c.Sinks {
+		if err := sink.Deliver(ctx, event); err != nil {
+			return false, errors.New(fmt.Sprintf("Unable to deliver %s %s to sink, %s", event.Type, event.Name, err))
+		}
+	}
+
+	return true, nil
+}
+
+func (c *Consumer) logger() *logrus.Logger {
+	if c.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return c.Logger
+}
+
+// sequenceNumberAfter reports whether sequence number a comes after b.  Sequence numbers are decimal strings
+// of varying length.
+func sequenceNumberAfter(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
@@ -0,0 +1,189 @@
+package changefeed
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+)
+
+// EventType is the kind of change made to an entity.
+type EventType string
+
+const (
+	Created EventType = "CREATED"
+	Updated EventType = "UPDATED"
+	Deleted EventType = "DELETED"
+)
+
+// Kind is the type of entity that was changed.
+type Kind string
+
+const (
+	ProjectKind    Kind = "PROJECT"
+	NoteKind       Kind = "NOTE"
+	OccurrenceKind Kind = "OCCURRENCE"
+)
+
+// Event is a change to a project, note or occurrence.  Exactly one of Project, Note and Occurrence is set: it
+// holds the entity after the change, or before it for deletions.
+type Event struct {
+	Type           EventType
+	Kind           Kind
+	Name           string
+	ShardID        string
+	SequenceNumber string
+	Time           time.Time
+
+	Project    *prpb.Project
+	Note       *pb.Note
+	Occurrence *pb.Occurrence
+}
+
+// PayloadOpener decrypts the payload of items, and is satisfied by storage.DynamoDb.
+type PayloadOpener interface {
+	OpenPayload(dataItem *storage.DataItem) (string, error)
+}
+
+// Decoder converts stream records into events.
+type Decoder struct {
+	// Opener decrypts payloads.  If nil, only unencrypted payloads can be decoded.
+	Opener PayloadOpener
+	// Namespace is that of the store whose changes are decoded.  Records of other namespaces are skipped.
+	Namespace string
+}
+
+// MalformedRecordError is returned when a stream record can never be decoded, however often it is retried.
+type MalformedRecordError struct {
+	message string
+}
+
+func (e *MalformedRecordError) Error() string {
+	return e.message
+}
+
+func malformed(message string) error {
+	return &MalformedRecordError{message: message}
+}
+
+// Decode converts a stream record into an event.  Records of auxiliary rows, such as occurrence notes and
+// revisions, duplicate the change to the main row and are skipped by returning a nil event, as are
+// modifications that leave the entity unchanged, such as re-encryption.  Records that are
+// malformed return a *MalformedRecordError; other errors, such as a failure to decrypt a payload, may succeed
+// when retried.
+func (d *Decoder) Decode(shardID string, record *dynamodbstreams.Record) (*Event, error) {
+	if record.Dynamodb == nil || record.EventName == nil {
+		return nil, malformed("Stream record has no data")
+	}
+
+	event := &Event{
+		ShardID: shardID,
+	}
+
+	if record.Dynamodb.SequenceNumber != nil {
+		event.SequenceNumber = *record.Dynamodb.SequenceNumber
+	}
+	if record.Dynamodb.ApproximateCreationDateTime != nil {
+		event.Time = *record.Dynamodb.ApproximateCreationDateTime
+	}
+
+	var image map[string]*dynamodb.AttributeValue
+	switch *record.EventName {
+	case dynamodbstreams.OperationTypeInsert:
+		event.Type = Created
+		image = record.Dynamodb.NewImage
+	case dynamodbstreams.OperationTypeModify:
+		event.Type = Updated
+		image = record.Dynamodb.NewImage
+	case dynamodbstreams.OperationTypeRemove:
+		event.Type = Deleted
+		image = record.Dynamodb.OldImage
+	default:
+		return nil, malformed(fmt.Sprintf("Unknown stream event %s", *record.EventName))
+	}
+
+	if image == nil {
+		return nil, malformed(fmt.Sprintf("Stream record %s has no image, the stream must use NEW_AND_OLD_IMAGES", event.SequenceNumber))
+	}
+
+	dataItem := storage.DataItem{}
+	err := dynamodbattribute.UnmarshalMap(image, &dataItem)
+	if err != nil {
+		return nil, malformed(fmt.Sprintf("Unable to unmarshal stream record %s, %s", event.SequenceNumber, err))
+	}
+
+	pk, ok := storage.StripNamespace(d.Namespace, dataItem.PartitionKey)
+	if !ok {
+		return nil, nil
+	}
+	sk, _ := storage.StripNamespace(d.Namespace, dataItem.SortKey)
+
+	var message proto.Message
+	switch sk {
+	case storage.ProjectSortKey:
+		event.Kind = ProjectKind
+		event.Project = &prpb.Project{}
+		message = event.Project
+	case storage.NoteSortKey:
+		event.Kind = NoteKind
+		event.Note = &pb.Note{}
+		message = event.Note
+	case storage.OccurrenceSortKey:
+		event.Kind = OccurrenceKind
+		event.Occurrence = &pb.Occurrence{}
+		message = event.Occurrence
+	default:
+		return nil, nil
+	}
+	event.Name = pk
+
+	jsonObject, err := d.open(event, &dataItem)
+	if err != nil {
+		return nil, err
+	}
+
+	// every change to an entity increments its version, so a modification that leaves it as it was, such as
+	// re-encryption under a new data key, is only reported if the payload itself has changed
+	if event.Type == Updated && record.Dynamodb.OldImage != nil {
+		oldItem := storage.DataItem{}
+		if err := dynamodbattribute.UnmarshalMap(record.Dynamodb.OldImage, &oldItem); err != nil {
+			return nil, malformed(fmt.Sprintf("Unable to unmarshal stream record %s, %s", event.SequenceNumber, err))
+		}
+		if oldItem.Version == dataItem.Version {
+			oldJsonObject, err := d.open(event, &oldItem)
+			if err != nil {
+				return nil, err
+			}
+			if oldJsonObject == jsonObject {
+				return nil, nil
+			}
+		}
+	}
+
+	err = jsonpb.Unmarshal(strings.NewReader(jsonObject), message)
+	if err != nil {
+		return nil, malformed(fmt.Sprintf("Unable to unmarshal json of %s, %s", event.Name, err))
+	}
+
+	return event, nil
+}
+
+// open returns the payload of an item of the record, decrypting it if required.
+func (d *Decoder) open(event *Event, dataItem *storage.DataItem) (string, error) {
+	if d.Opener != nil {
+		return d.Opener.OpenPayload(dataItem)
+	}
+	if dataItem.KeyId != "" {
+		return "", errors.New(fmt.Sprintf("Stream record %s is encrypted but the decoder has no PayloadOpener", event.SequenceNumber))
+	}
+	return dataItem.Json, nil
+}
@@ -0,0 +1,137 @@
+package changefeed
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/google/uuid"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// DefaultLeaseDuration is how long a consumer holds the lease on a stream without renewing it.  A replica that
+// stops is replaced by another within this time.
+const DefaultLeaseDuration = time.Minute
+
+// Lease elects one of the consumers that share a name, so that when every replica of a server runs a consumer,
+// each event is delivered by one of them and only it writes the checkpoints.
+type Lease interface {
+	// Acquire takes the lease, or renews it if it is already held, returning whether it is held and until when.
+	// If another consumer holds it, the time is when that consumer's hold expires.
+	Acquire(ctx context.Context) (bool, time.Time, error)
+}
+
+const (
+	// leasePKPrefix prefixes the partition key of lease rows, followed by the consumer name.
+	leasePKPrefix = "CHANGE_FEED_LEASE#"
+	// leaseSK is the sort key of lease rows.  Data holds the time the lease expires.
+	leaseSK = "CHANGE_FEED_LEASE"
+
+	// leaseTimeFormat is fixed width, so that times sort lexicographically.
+	leaseTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+	ownerKeyName = "Owner"
+)
+
+// TableLease keeps a lease as a row in a DynamoDB table, typically the Grafeas table itself, taken with a
+// conditional write that only succeeds if the lease is free, expired or already held by Owner.  Lease rows are
+// not projects, notes or occurrences, so their own stream records are skipped by the Decoder.  Their keys are
+// prefixed with Namespace, as those of the checkpoints are.
+type TableLease struct {
+	Client       dynamodbiface.DynamoDBAPI
+	TableName    string
+	Namespace    string
+	ConsumerName string
+	// Owner identifies the consumer holding the lease, and must differ between replicas.
+	Owner    string
+	Duration time.Duration
+}
+
+// NewLeaseOwner returns an owner for a TableLease that is unique to this process.
+func NewLeaseOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s/%s", hostname, uuid.New().String())
+}
+
+func (l *TableLease) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		storage.PartitionKeyName: {
+			S: aws.String(storage.NamespacedKey(l.Namespace, leasePKPrefix+l.ConsumerName)),
+		},
+		storage.SortKeyName: {
+			S: aws.String(storage.NamespacedKey(l.Namespace, leaseSK)),
+		},
+	}
+}
+
+// Acquire takes or renews the lease.  The lease row is read first, so that while another consumer holds the
+// lease, nothing is written until its hold expires.
+func (l *TableLease) Acquire(ctx context.Context) (bool, time.Time, error) {
+	now := time.Now()
+
+	result, err := l.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(l.TableName),
+		Key:            l.key(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, time.Time{}, errors.New(fmt.Sprintf("Unable to read change feed lease of %s, %s", l.ConsumerName, err))
+	}
+	if owner, expiry, ok := leaseHolder(result.Item); ok && owner != l.Owner && expiry.After(now) {
+		return false, expiry, nil
+	}
+
+	expiry := now.Add(l.Duration)
+	item := l.key()
+	item[storage.DataKeyName] = &dynamodb.AttributeValue{S: aws.String(expiry.UTC().Format(leaseTimeFormat))}
+	item[ownerKeyName] = &dynamodb.AttributeValue{S: aws.String(l.Owner)}
+
+	_, err = l.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(l.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#PK) OR #OWNER = :OWNER OR #DATA < :NOW"),
+		ExpressionAttributeNames: map[string]*string{
+			"#PK":    aws.String(storage.PartitionKeyName),
+			"#OWNER": aws.String(ownerKeyName),
+			"#DATA":  aws.String(storage.DataKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":OWNER": {
+				S: aws.String(l.Owner),
+			},
+			":NOW": {
+				S: aws.String(now.UTC().Format(leaseTimeFormat)),
+			},
+		},
+	})
+	if err != nil {
+		if storage.IsConditionalCheckFailure(err) {
+			// another consumer took the lease since it was read
+			return false, expiry, nil
+		}
+		return false, time.Time{}, errors.New(fmt.Sprintf("Unable to take change feed lease of %s, %s", l.ConsumerName, err))
+	}
+
+	return true, expiry, nil
+}
+
+// leaseHolder returns the owner of a lease row and when its hold expires.
+func leaseHolder(item map[string]*dynamodb.AttributeValue) (string, time.Time, bool) {
+	owner, data := item[ownerKeyName], item[storage.DataKeyName]
+	if owner == nil || owner.S == nil || data == nil || data.S == nil {
+		return "", time.Time{}, false
+	}
+	expiry, err := time.Parse(leaseTimeFormat, *data.S)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return *owner.S, expiry, true
+}
@@ -0,0 +1,182 @@
+package changefeed
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+)
+
+// MemoryStream is an in-memory stand-in for DynamoDB Streams, so that consumers can be tested without AWS.
+// Only the operations used by Consumer are implemented.
+type MemoryStream struct {
+	dynamodbstreamsiface.DynamoDBStreamsAPI
+
+	Arn string
+
+	mu             sync.Mutex
+	shards         []*memoryShard
+	sequenceNumber int64
+}
+
+type memoryShard struct {
+	id       string
+	parentID string
+	records  []*dynamodbstreams.Record
+	closed   bool
+}
+
+// NewMemoryStream creates an empty stream with the given ARN.
+func NewMemoryStream(arn string) *MemoryStream {
+	return &MemoryStream{
+		Arn: arn,
+	}
+}
+
+// AddShard adds an open shard to the stream.  parentShardID may be empty.
+func (s *MemoryStream) AddShard(shardID, parentShardID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shards = append(s.shards, &memoryShard{id: shardID, parentID: parentShardID})
+}
+
+// CloseShard closes a shard, after which no more records can be appended to it.
+func (s *MemoryStream) CloseShard(shardID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if shard := s.shard(shardID); shard != nil {
+		shard.closed = true
+	}
+}
+
+// Append adds a record to an open shard, assigning it the next sequence number.
+func (s *MemoryStream) Append(shardID string, record *dynamodbstreams.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard := s.shard(shardID)
+	if shard == nil || shard.closed {
+		return errors.New(fmt.Sprintf("Shard %s is not open", shardID))
+	}
+
+	s.sequenceNumber++
+	if record.Dynamodb == nil {
+		record.Dynamodb = &dynamodbstreams.StreamRecord{}
+	}
+	record.Dynamodb.SequenceNumber = aws.String(fmt.Sprintf("%021d", s.sequenceNumber))
+	shard.records = append(shard.records, record)
+	return nil
+}
+
+func (s *MemoryStream) shard(shardID string) *memoryShard {
+	for _, shard := range s.shards {
+		if shard.id == shardID {
+			return shard
+		}
+	}
+	return nil
+}
+
+// DescribeStreamWithContext returns every shard of the stream.
+func (s *MemoryStream) DescribeStreamWithContext(ctx aws.Context, input *dynamodbstreams.DescribeStreamInput, opts ...request.Option) (*dynamodbstreams.DescribeStreamOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if aws.StringValue(input.StreamArn) != s.Arn {
+		return nil, awserr.New(dynamodbstreams.ErrCodeResourceNotFoundException, "Stream not found", nil)
+	}
+
+	var shards []*dynamodbstreams.Shard
+	for _, shard := range s.shards {
+		description := &dynamodbstreams.Shard{
+			ShardId: aws.String(shard.id),
+		}
+		if shard.parentID != "" {
+			description.ParentShardId = aws.String(shard.parentID)
+		}
+		shards = append(shards, description)
+	}
+
+	return &dynamodbstreams.DescribeStreamOutput{
+		StreamDescription: &dynamodbstreams.StreamDescription{
+			StreamArn:      aws.String(s.Arn),
+			StreamViewType: aws.String(dynamodbstreams.StreamViewTypeNewAndOldImages),
+			Shards:         shards,
+		},
+	}, nil
+}
+
+// GetShardIteratorWithContext returns an iterator positioned within a shard.
+func (s *MemoryStream) GetShardIteratorWithContext(ctx aws.Context, input *dynamodbstreams.GetShardIteratorInput, opts ...request.Option) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard := s.shard(aws.StringValue(input.ShardId))
+	if shard == nil {
+		return nil, awserr.New(dynamodbstreams.ErrCodeResourceNotFoundException, "Shard not found", nil)
+	}
+
+	position := 0
+	switch aws.StringValue(input.ShardIteratorType) {
+	case dynamodbstreams.ShardIteratorTypeTrimHorizon:
+	case dynamodbstreams.ShardIteratorTypeLatest:
+		position = len(shard.records)
+	case dynamodbstreams.ShardIteratorTypeAtSequenceNumber, dynamodbstreams.ShardIteratorTypeAfterSequenceNumber:
+		position = len(shard.records)
+		for i, record := range shard.records {
+			if *record.Dynamodb.SequenceNumber == aws.StringValue(input.SequenceNumber) {
+				position = i
+				if aws.StringValue(input.ShardIteratorType) == dynamodbstreams.ShardIteratorTypeAfterSequenceNumber {
+					position++
+				}
+				break
+			}
+		}
+	default:
+		return nil, awserr.New("ValidationException", "Unknown shard iterator type", nil)
+	}
+
+	return &dynamodbstreams.GetShardIteratorOutput{
+		ShardIterator: aws.String(fmt.Sprintf("%s|%d", shard.id, position)),
+	}, nil
+}
+
+// GetRecordsWithContext returns the records after an iterator.  The next iterator is nil once a closed shard
+// has been read to the end.
+func (s *MemoryStream) GetRecordsWithContext(ctx aws.Context, input *dynamodbstreams.GetRecordsInput, opts ...request.Option) (*dynamodbstreams.GetRecordsOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	iterator := aws.StringValue(input.ShardIterator)
+	separator := strings.LastIndex(iterator, "|")
+	if separator < 0 {
+		return nil, awserr.New(dynamodbstreams.ErrCodeExpiredIteratorException, "Invalid shard iterator", nil)
+	}
+
+	shard := s.shard(iterator[:separator])
+	position, err := strconv.Atoi(iterator[separator+1:])
+	if shard == nil || err != nil || position > len(shard.records) {
+		return nil, awserr.New(dynamodbstreams.ErrCodeExpiredIteratorException, "Invalid shard iterator", nil)
+	}
+
+	end := len(shard.records)
+	if input.Limit != nil && position+int(*input.Limit) < end {
+		end = position + int(*input.Limit)
+	}
+
+	output := &dynamodbstreams.GetRecordsOutput{
+		Records: shard.records[position:end],
+	}
+	if !shard.closed || end < len(shard.records) {
+		output.NextShardIterator = aws.String(fmt.Sprintf("%s|%d", shard.id, end))
+	}
+
+	return output, nil
+}
@@ -0,0 +1,124 @@
+package changefeed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/webhook"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// Sink receives decoded events.  A sink returning an error causes the event to be delivered again, so sinks
+// should be idempotent.
+type Sink interface {
+	Deliver(ctx context.Context, event *Event) error
+}
+
+// SinkFunc adapts a function into a Sink.
+type SinkFunc func(ctx context.Context, event *Event) error
+
+// Deliver calls f.
+func (f SinkFunc) Deliver(ctx context.Context, event *Event) error {
+	return f(ctx, event)
+}
+
+// LogSink logs every event, which is useful for local development.
+type LogSink struct {
+	// Logger records the events.  If nil, the standard logrus logger is used.
+	Logger *logrus.Logger
+}
+
+// Deliver logs the event.
+func (s LogSink) Deliver(ctx context.Context, event *Event) error {
+	logger := s.Logger
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	logger.Infof("%s %s %s (shard %s, sequence %s)", event.Type, event.Kind, event.Name, event.ShardID, event.SequenceNumber)
+	return nil
+}
+
+// HTTPSink POSTs every event as JSON to a URL.  The body is signed with Secret, if set, as webhook deliveries
+// are, and the event is identified by its sequence number so that receivers can drop repeats.  Any response
+// other than a 2xx fails the delivery.
+type HTTPSink struct {
+	URL    string
+	Secret string
+	// Client sends the requests.  If nil, a client with a 30 second timeout is used.
+	Client *http.Client
+}
+
+// httpPayload is the body of each request.
+type httpPayload struct {
+	Type     EventType       `json:"type"`
+	Kind     Kind            `json:"kind"`
+	Name     string          `json:"name"`
+	Time     string          `json:"time,omitempty"`
+	Resource json.RawMessage `json:"resource"`
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Deliver posts the event.
+func (s *HTTPSink) Deliver(ctx context.Context, event *Event) error {
+	var resource proto.Message
+	switch {
+	case event.Project != nil:
+		resource = event.Project
+	case event.Note != nil:
+		resource = event.Note
+	default:
+		resource = event.Occurrence
+	}
+	m := jsonpb.Marshaler{}
+	jsonObject, err := m.MarshalToString(resource)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to marshal %s into json, %s", event.Name, err))
+	}
+
+	p := httpPayload{Type: event.Type, Kind: event.Kind, Name: event.Name, Resource: json.RawMessage(jsonObject)}
+	if !event.Time.IsZero() {
+		p.Time = event.Time.UTC().Format(time.RFC3339)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.EventHeader, fmt.Sprintf("%s_%s", event.Kind, event.Type))
+	req.Header.Set(webhook.DeliveryHeader, event.SequenceNumber)
+	if s.Secret != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign([]byte(s.Secret), body))
+	}
+
+	client := s.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("%s responded with %s", s.URL, resp.Status))
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+// Package consistency checks that every occurrence in a table has exactly one row linking it to its note, that
+// the row carries the occurrence as it is stored, and that the note exists.  Rows left behind by interrupted
+// writes, or by occurrences moved to another note, are found and can be repaired.
+package consistency
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/grafeas/grafeas/go/name"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The kinds of problem found.
+const (
+	// MissingLink is an occurrence without a row linking it to its note, so ListNoteOccurrences leaves it out.
+	MissingLink = "missing_link"
+	// OrphanLink is a row linking a note to an occurrence that does not exist, or that refers to another note,
+	// so ListNoteOccurrences returns an occurrence it should not.
+	OrphanLink = "orphan_link"
+	// StaleLink is a row linking an occurrence to its note that carries another version of the occurrence, so
+	// ListNoteOccurrences returns the occurrence as it was.
+	StaleLink = "stale_link"
+	// MissingNote is an occurrence that refers to a note that does not exist.  It is reported but not repaired.
+	MissingNote = "missing_note"
+	// LinkLayout is a row linking an occurrence to its note that is slim when the store is configured for full
+	// links, or the other way round.  Repairing it rewrites the link in the configured layout, which is how the
+	// links of an existing table are converted.
+	LinkLayout = "link_layout"
+)
+
+const (
+	defaultInterval = 24 * time.Hour
+	defaultSegments = 4
+)
+
+// Problem is an inconsistency found in the table.
+type Problem struct {
+	Kind       string `json:"kind"`
+	Occurrence string `json:"occurrence"`
+	Note       string `json:"note"`
+	// Repaired is set once the problem has been repaired.  A problem is left alone if the occurrence changes
+	// while it is being repaired, as the write changing it may have repaired it too.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// Report is the outcome of a check of the table.
+type Report struct {
+	Occurrences int       `json:"occurrences"`
+	Links       int       `json:"links"`
+	Problems    []Problem `json:"problems"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// NoteGetter gets notes, such as a store or a router of stores.
+type NoteGetter interface {
+	GetNote(ctx context.Context, pID, nID string) (*pb.Note, error)
+}
+
+// Checker scans the table of a store for inconsistencies.
+type Checker struct {
+	Store *storage.DynamoDb
+	// Notes is where the notes occurrences refer to are looked up, which may be in other tables if projects are
+	// routed to several.  Defaults to Store.
+	Notes NoteGetter
+	// Segments is the number of segments of the table scanned in parallel.
+	Segments int
+	// Repair repairs the problems found, rather than only reporting them.
+	Repair bool
+	// Interval is how often Run checks the table.
+	Interval time.Duration
+}
+
+// NewChecker creates a checker of the store, configured as the consistency section of the configuration
+// describes.
+func NewChecker(store *storage.DynamoDb, consistencyConfig *config.ConsistencyConfig) (*Checker, error) {
+	c := &Checker{
+		Store:    store,
+		Segments: consistencyConfig.Segments,
+		Repair:   consistencyConfig.Repair,
+		Interval: defaultInterval,
+	}
+	if consistencyConfig.Interval != "" {
+		interval, err := time.ParseDuration(consistencyConfig.Interval)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse consistency interval, %s", err))
+		}
+		c.Interval = interval
+	}
+	return c, nil
+}
+
+// Run checks the table every interval until the context is cancelled, logging the problems found with the
+// store's logger.
+func (c *Checker) Run(ctx context.Context) {
+	logger := c.Store.BaseLogger()
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := c.Check(ctx)
+		if err != nil {
+			logger.WithError(err).Errorf("Unable to check the consistency of table %s", c.Store.TableName)
+		} else {
+			for _, problem := range report.Problems {
+				logger.Warn(problem)
+			}
+			logger.Infof("Checked %d occurrences of table %s, found %d problems", report.Occurrences, c.Store.TableName, len(report.Problems))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// String describes the problem for logs.
+func (p Problem) String() string {
+	var description string
+	switch p.Kind {
+	case MissingLink:
+		description = fmt.Sprintf("Occurrence %s is not linked to its note %s", p.Occurrence, p.Note)
+	case OrphanLink:
+		description = fmt.Sprintf("Note %s is linked to occurrence %s, which does not refer to it", p.Note, p.Occurrence)
+	case StaleLink:
+		description = fmt.Sprintf("Occurrence %s is linked to its note %s with an outdated copy", p.Occurrence, p.Note)
+	case MissingNote:
+		description = fmt.Sprintf("Occurrence %s refers to note %s, which does not exist", p.Occurrence, p.Note)
+	case LinkLayout:
+		description = fmt.Sprintf("Occurrence %s is linked to its note %s in the other layout of links", p.Occurrence, p.Note)
+	default:
+		description = fmt.Sprintf("%s: occurrence %s, note %s", p.Kind, p.Occurrence, p.Note)
+	}
+	if p.Repaired {
+		description += ", repaired"
+	}
+	return description
+}
+
+// Check scans the table once, repairing the problems found if configured to.  Problems are sorted by
+// occurrence.  Each occurrence is checked against its links as they were when its partition was scanned.
+func (c *Checker) Check(ctx context.Context) (*Report, error) {
+	segments := c.Segments
+	if segments == 0 {
+		segments = defaultSegments
+	}
+
+	run := &checkRun{
+		checker: c,
+		report:  &Report{Problems: []Problem{}},
+		notes:   map[string]noteLookup{},
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, segments)
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			if err := run.scanSegment(ctx, segment, segments); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(segment)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	report := run.report
+	sort.Slice(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		if a.Occurrence != b.Occurrence {
+			return a.Occurrence < b.Occurrence
+		}
+		return a.Note < b.Note
+	})
+	report.CheckedAt = time.Now().UTC()
+	return report, nil
+}
+
+// checkRun is the state shared by the segments of a check.
+type checkRun struct {
+	checker *Checker
+
+	mu     sync.Mutex
+	report *Report
+	// notes caches whether each note referred to exists
+	notes map[string]noteLookup
+}
+
+type noteLookup struct {
+	once   *sync.Once
+	exists bool
+	err    error
+}
+
+// partition is the occurrence row and link rows that share the partition key of an occurrence.
+type partition struct {
+	name       string
+	occurrence *storage.DataItem
+	links      []*storage.DataItem
+}
+
+// scanSegment reads the occurrence and link rows of a segment of the table.  The rows of a partition are
+// scanned together, in sort key order, so each partition is checked once its last row has been read.
+func (r *checkRun) scanSegment(ctx context.Context, segment, segments int) error {
+	db := r.checker.Store
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(db.TableName),
+		ExpressionAttributeNames: map[string]*string{
+			"#SK": aws.String(storage.SortKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":OCCURRENCE":  {S: aws.String(storage.NamespacedKey(db.Namespace, storage.OccurrenceSortKey))},
+			":NOTE_PREFIX": {S: aws.String(storage.NamespacedKey(db.Namespace, name.FormatProject("")))},
+		},
+		FilterExpression: aws.String("#SK = :OCCURRENCE OR begins_with(#SK, :NOTE_PREFIX)"),
+		ConsistentRead:   aws.Bool(true),
+		Segment:          aws.Int64(int64(segment)),
+		TotalSegments:    aws.Int64(int64(segments)),
+	}
+
+	current := &partition{}
+	var checkErr error
+	err := db.ScanPagesWithContext(ctx, input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			dataItem := &storage.DataItem{}
+			if checkErr = dynamodbattribute.UnmarshalMap(item, dataItem); checkErr != nil {
+				return false
+			}
+			oName, _ := storage.StripNamespace(db.Namespace, dataItem.PartitionKey)
+			if _, _, err := name.ParseOccurrence(oName); err != nil {
+				continue
+			}
+
+			if oName != current.name {
+				if checkErr = r.checkPartition(ctx, current); checkErr != nil {
+					return false
+				}
+				current = &partition{name: oName}
+			}
+			if sk, _ := storage.StripNamespace(db.Namespace, dataItem.SortKey); sk == storage.OccurrenceSortKey {
+				current.occurrence = dataItem
+			} else {
+				current.links = append(current.links, dataItem)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to scan segment %d, %s", segment, err))
+	}
+	if checkErr != nil {
+		return checkErr
+	}
+	return r.checkPartition(ctx, current)
+}
+
+// checkPartition finds the problems of an occurrence and its links, and repairs them if configured to.
+func (r *checkRun) checkPartition(ctx context.Context, p *partition) error {
+	if p.name == "" {
+		return nil
+	}
+	db := r.checker.Store
+
+	var problems []Problem
+	var occurrenceJSON, noteName string
+	if p.occurrence != nil {
+		var err error
+		occurrenceJSON, err = db.OpenPayload(p.occurrence)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Unable to read occurrence %s, %s", p.name, err))
+		}
+		var o pb.Occurrence
+		if err := jsonpb.UnmarshalString(occurrenceJSON, &o); err != nil {
+			return errors.New(fmt.Sprintf("Unable to decode occurrence %s, %s", p.name, err))
+		}
+		noteName = o.NoteName
+	}
+
+	linked := false
+	for _, link := range p.links {
+		linkNote, _ := storage.StripNamespace(db.Namespace, link.SortKey)
+		if p.occurrence == nil || linkNote != noteName {
+			problems = append(problems, Problem{Kind: OrphanLink, Occurrence: p.name, Note: linkNote})
+			continue
+		}
+
+		linked = true
+		stale, err := r.stale(link, p.occurrence, occurrenceJSON)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Unable to read link of occurrence %s, %s", p.name, err))
+		}
+		if stale {
+			problems = append(problems, Problem{Kind: StaleLink, Occurrence: p.name, Note: noteName})
+		} else if storage.IsSlimLink(link) != db.SlimLinks {
+			problems = append(problems, Problem{Kind: LinkLayout, Occurrence: p.name, Note: noteName})
+		}
+	}
+
+	if p.occurrence != nil {
+		if !linked {
+			problems = append(problems, Problem{Kind: MissingLink, Occurrence: p.name, Note: noteName})
+		}
+		exists, err := r.noteExists(ctx, noteName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			problems = append(problems, Problem{Kind: MissingNote, Occurrence: p.name, Note: noteName})
+		}
+	}
+
+	if r.checker.Repair {
+		for i := range problems {
+			repaired, err := r.repair(ctx, p, problems[i])
+			if err != nil {
+				return err
+			}
+			problems[i].Repaired = repaired
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p.occurrence != nil {
+		r.report.Occurrences++
+	}
+	r.report.Links += len(p.links)
+	r.report.Problems = append(r.report.Problems, problems...)
+	return nil
+}
+
+// stale reports whether a link is out of date: a slim link is if its version is not the occurrence's, and a full
+// link is if its copy of the occurrence differs.
+func (r *checkRun) stale(link, occurrence *storage.DataItem, occurrenceJSON string) (bool, error) {
+	if storage.IsSlimLink(link) {
+		return link.Version != occurrence.Version, nil
+	}
+	linkJSON, err := r.checker.Store.OpenPayload(link)
+	if err != nil {
+		return false, err
+	}
+	return linkJSON != occurrenceJSON, nil
+}
+
+// noteExists looks up a note once per check, however many occurrences refer to it.
+func (r *checkRun) noteExists(ctx context.Context, noteName string) (bool, error) {
+	r.mu.Lock()
+	lookup, ok := r.notes[noteName]
+	if !ok {
+		lookup = noteLookup{once: &sync.Once{}}
+		r.notes[noteName] = lookup
+	}
+	r.mu.Unlock()
+
+	lookup.once.Do(func() {
+		pID, nID, err := name.ParseNote(noteName)
+		if err != nil {
+			return
+		}
+		notes := r.checker.Notes
+		if notes == nil {
+			notes = r.checker.Store
+		}
+		_, err = notes.GetNote(ctx, pID, nID)
+		exists := err == nil
+		if status.Code(err) == codes.NotFound {
+			err = nil
+		}
+
+		r.mu.Lock()
+		r.notes[noteName] = noteLookup{once: lookup.once, exists: exists, err: err}
+		r.mu.Unlock()
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lookup = r.notes[noteName]
+	if lookup.err != nil {
+		return false, errors.New(fmt.Sprintf("Unable to get note %s, %s", noteName, lookup.err))
+	}
+	return lookup.exists, nil
+}
+
+// repair rewrites or deletes a link row, provided the occurrence has not changed since it was scanned.  It
+// reports false if the occurrence has changed, or if the problem cannot be repaired.
+func (r *checkRun) repair(ctx context.Context, p *partition, problem Problem) (bool, error) {
+	db := r.checker.Store
+	occurrenceKey := key(db.Namespace, p.name, storage.OccurrenceSortKey)
+
+	// the occurrence must still be as it was scanned, or still not exist
+	check := &dynamodb.ConditionCheck{TableName: aws.String(db.TableName), Key: occurrenceKey}
+	if p.occurrence != nil {
+		check.ConditionExpression, check.ExpressionAttributeNames, check.ExpressionAttributeValues = storage.VersionCondition(p.occurrence.Version)
+	} else {
+		check.ConditionExpression = aws.String("attribute_not_exists(#PARTITION_KEY)")
+		check.ExpressionAttributeNames = map[string]*string{"#PARTITION_KEY": aws.String(storage.PartitionKeyName)}
+	}
+
+	write := &dynamodb.TransactWriteItem{}
+	switch problem.Kind {
+	case MissingLink, StaleLink, LinkLayout:
+		item, err := dynamodbattribute.MarshalMap(db.NoteLinkItem(p.occurrence, problem.Note))
+		if err != nil {
+			return false, errors.New(fmt.Sprintf("Unable to marshal link of occurrence %s, %s", p.name, err))
+		}
+		write.Put = &dynamodb.Put{TableName: aws.String(db.TableName), Item: item}
+	case OrphanLink:
+		write.Delete = &dynamodb.Delete{TableName: aws.String(db.TableName), Key: key(db.Namespace, p.name, problem.Note)}
+	default:
+		return false, nil
+	}
+
+	_, err := db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{{ConditionCheck: check}, write},
+	})
+	if storage.IsConditionalCheckFailure(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Unable to repair occurrence %s, %s", p.name, err))
+	}
+	return true, nil
+}
+
+func key(namespace, partitionKey, sortKey string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		storage.PartitionKeyName: {S: aws.String(storage.NamespacedKey(namespace, partitionKey))},
+		storage.SortKeyName:      {S: aws.String(storage.NamespacedKey(namespace, sortKey))},
+	}
+}
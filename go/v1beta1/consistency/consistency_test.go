@@ -0,0 +1,286 @@
+package consistency_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/consistency"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+func newStore(client *memdb.DB, namespace string) *storage.DynamoDb {
+	return storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		Namespace: namespace,
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+}
+
+func key(partitionKey, sortKey string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		storage.PartitionKeyName: {S: aws.String(partitionKey)},
+		storage.SortKeyName:      {S: aws.String(sortKey)},
+	}
+}
+
+// inconsistent creates a project with notes n1, n2 and n3 and four occurrences, then breaks the rows of all but
+// the first: the second has no link, the third is linked to the wrong note as well as its own, and the fourth
+// has an outdated link and refers to a note that is then deleted.  A link to an occurrence that does not exist
+// is added too.  It returns the names of the occurrences.
+func inconsistent(t *testing.T, client *memdb.DB, store *storage.DynamoDb) []string {
+	ctx := context.Background()
+	if _, err := store.CreateProject(ctx, "p1", &prpb.Project{Name: "projects/p1"}); err != nil {
+		t.Fatalf("CreateProject failed, %s", err)
+	}
+	for _, nID := range []string{"n1", "n2", "n3"} {
+		if _, err := store.CreateNote(ctx, "p1", nID, "user", &pb.Note{}); err != nil {
+			t.Fatalf("CreateNote failed, %s", err)
+		}
+	}
+	var names []string
+	for _, nID := range []string{"n1", "n1", "n2", "n3"} {
+		o, err := store.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/" + nID})
+		if err != nil {
+			t.Fatalf("CreateOccurrence failed, %s", err)
+		}
+		names = append(names, o.Name)
+	}
+
+	if _, err := client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("test_table"),
+		Key:       key(names[1], "projects/p1/notes/n1"),
+	}); err != nil {
+		t.Fatalf("DeleteItem failed, %s", err)
+	}
+
+	for _, link := range []struct{ occurrence, note string }{
+		{names[2], "projects/p1/notes/n1"},
+		{"projects/p1/occurrences/gone", "projects/p1/notes/n1"},
+		{names[3], "projects/p1/notes/n3"},
+	} {
+		if _, err := client.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String("test_table"),
+			Item: map[string]*dynamodb.AttributeValue{
+				storage.PartitionKeyName: {S: aws.String(link.occurrence)},
+				storage.SortKeyName:      {S: aws.String(link.note)},
+				storage.DataKeyName:      {S: aws.String(link.occurrence)},
+				storage.JsonKeyName:      {S: aws.String(`{"name":"` + link.occurrence + `"}`)},
+			},
+		}); err != nil {
+			t.Fatalf("PutItem failed, %s", err)
+		}
+	}
+
+	if _, err := client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("test_table"),
+		Key:       key("projects/p1/notes/n3", storage.NoteSortKey),
+	}); err != nil {
+		t.Fatalf("DeleteItem failed, %s", err)
+	}
+
+	return names
+}
+
+func problems(report *consistency.Report) map[string][]string {
+	found := map[string][]string{}
+	for _, problem := range report.Problems {
+		found[problem.Kind] = append(found[problem.Kind], problem.Occurrence+" "+problem.Note)
+	}
+	return found
+}
+
+func TestCheck(t *testing.T) {
+	client := memdb.New()
+	store := newStore(client, "")
+	names := inconsistent(t, client, store)
+
+	report, err := (&consistency.Checker{Store: store, Segments: 3}).Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check failed, %s", err)
+	}
+	if report.Occurrences != 4 {
+		t.Errorf("Expected 4 occurrences to be checked, got %d", report.Occurrences)
+	}
+
+	expected := map[string][]string{
+		consistency.MissingLink: {names[1] + " projects/p1/notes/n1"},
+		consistency.OrphanLink:  {names[2] + " projects/p1/notes/n1", "projects/p1/occurrences/gone projects/p1/notes/n1"},
+		consistency.StaleLink:   {names[3] + " projects/p1/notes/n3"},
+		consistency.MissingNote: {names[3] + " projects/p1/notes/n3"},
+	}
+	found := problems(report)
+	for kind, want := range expected {
+		got := found[kind]
+		if len(got) != len(want) {
+			t.Errorf("Expected %s problems %v, got %v", kind, want, got)
+			continue
+		}
+		for _, w := range want {
+			present := false
+			for _, g := range got {
+				present = present || g == w
+			}
+			if !present {
+				t.Errorf("Expected %s problem %s, got %v", kind, w, got)
+			}
+		}
+	}
+	for _, problem := range report.Problems {
+		if problem.Repaired {
+			t.Errorf("Expected %s not to be repaired without Repair set", problem)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := newStore(client, "")
+	names := inconsistent(t, client, store)
+
+	checker := &consistency.Checker{Store: store, Repair: true}
+	report, err := checker.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed, %s", err)
+	}
+	for _, problem := range report.Problems {
+		if problem.Repaired != (problem.Kind != consistency.MissingNote) {
+			t.Errorf("Unexpected repair of %s", problem)
+		}
+	}
+
+	report, err = checker.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed, %s", err)
+	}
+	if found := problems(report); !reflect.DeepEqual(found, map[string][]string{consistency.MissingNote: {names[3] + " projects/p1/notes/n3"}}) {
+		t.Errorf("Expected only the missing note to remain, got %v", found)
+	}
+
+	occurrences, _, err := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10)
+	if err != nil {
+		t.Fatalf("ListNoteOccurrences failed, %s", err)
+	}
+	if len(occurrences) != 2 {
+		t.Errorf("Expected 2 occurrences of note n1 after repair, got %v", occurrences)
+	}
+}
+
+func TestCheckNamespace(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	inconsistent(t, client, newStore(client, ""))
+
+	store := newStore(client, "ns")
+	if _, err := store.CreateNote(ctx, "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	if _, err := store.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1"}); err != nil {
+		t.Fatalf("CreateOccurrence failed, %s", err)
+	}
+
+	report, err := (&consistency.Checker{Store: store, Repair: true}).Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed, %s", err)
+	}
+	if report.Occurrences != 1 || len(report.Problems) != 0 {
+		t.Errorf("Expected only the consistent occurrence of the namespace to be checked, got %+v", report)
+	}
+}
+
+func TestRepairSkipsChangedOccurrence(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := newStore(client, "")
+	names := inconsistent(t, client, store)
+
+	// the occurrence changes between the scan and the repair, as if written by the server
+	notes := &updatingNotes{NoteGetter: store, update: func() {
+		if _, err := store.UpdateOccurrence(ctx, "p1", names[1][len("projects/p1/occurrences/"):], &pb.Occurrence{NoteName: "projects/p1/notes/n1", Remediation: "changed"}, nil); err != nil {
+			t.Fatalf("UpdateOccurrence failed, %s", err)
+		}
+	}}
+	report, err := (&consistency.Checker{Store: store, Notes: notes, Segments: 1, Repair: true}).Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed, %s", err)
+	}
+	for _, problem := range report.Problems {
+		if problem.Occurrence == names[1] && problem.Repaired {
+			t.Errorf("Expected %s not to be repaired once the occurrence changed", problem)
+		}
+	}
+}
+
+// updatingNotes calls update the first time a note is looked up, which is after the occurrence referring to it
+// has been scanned and before its problems are repaired.
+type updatingNotes struct {
+	consistency.NoteGetter
+	update func()
+}
+
+func (n *updatingNotes) GetNote(ctx context.Context, pID, nID string) (*pb.Note, error) {
+	if n.update != nil {
+		n.update()
+		n.update = nil
+	}
+	return n.NoteGetter.GetNote(ctx, pID, nID)
+}
+
+func TestRepairConvertsLinkLayout(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	full := newStore(client, "")
+	if _, err := full.CreateProject(ctx, "p1", &prpb.Project{Name: "projects/p1"}); err != nil {
+		t.Fatalf("CreateProject failed, %s", err)
+	}
+	if _, err := full.CreateNote(ctx, "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := full.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1"}); err != nil {
+			t.Fatalf("CreateOccurrence failed, %s", err)
+		}
+	}
+
+	slim := storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName:       "test_table",
+		OccurrenceLinks: storage.OccurrenceLinksSlim,
+		AWS:             &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	checker := &consistency.Checker{Store: slim, Repair: true}
+	report, err := checker.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed, %s", err)
+	}
+	if found := problems(report); len(found) != 1 || len(found[consistency.LinkLayout]) != 3 {
+		t.Errorf("Expected every link to be in the other layout, got %v", found)
+	}
+
+	report, err = checker.Check(ctx)
+	if err != nil {
+		t.Fatalf("Check failed, %s", err)
+	}
+	if len(report.Problems) != 0 || report.Links != 3 {
+		t.Errorf("Expected the links to have been rewritten, got %+v", report)
+	}
+
+	result, err := client.Scan(&dynamodb.ScanInput{TableName: aws.String("test_table")})
+	if err != nil {
+		t.Fatalf("Scan failed, %s", err)
+	}
+	for _, item := range result.Items {
+		if sk := aws.StringValue(item[storage.SortKeyName].S); sk == "projects/p1/notes/n1" && item[storage.JsonKeyName] != nil {
+			t.Errorf("Expected links to be slim, got %v", item)
+		}
+	}
+	if occurrences, _, _ := slim.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); len(occurrences) != 3 {
+		t.Errorf("Expected the occurrences to be listed through slim links, got %v", occurrences)
+	}
+}
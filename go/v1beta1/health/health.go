@@ -0,0 +1,329 @@
+// Package health reports whether the DynamoDB store can serve requests: whether its table and indexes are
+// active, whether it can read from the table, and whether DynamoDB is throttling it.  Reports are served on
+// /healthz and /readyz, and through the gRPC health checking protocol.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// The states of a check and of the store as a whole, from best to worst.  A degraded store still serves
+// requests, but some may fail or be slow.
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+var severity = map[string]int{
+	StatusOK:          0,
+	StatusDegraded:    1,
+	StatusUnavailable: 2,
+}
+
+const (
+	defaultInterval       = 10 * time.Second
+	defaultThrottleWindow = time.Minute
+
+	// probeKey is the partition and sort key of the item read by the probe, which is never written
+	probeKey = "grafeas-health-check"
+)
+
+// Check is the outcome of one of the checks made of the store.
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Report is the outcome of the checks made of the store, whose status is that of its worst check.
+type Report struct {
+	Status    string    `json:"status"`
+	Checks    []Check   `json:"checks"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Checker periodically checks the tables of a store and keeps the latest report.
+type Checker struct {
+	tables         []table
+	indexes        []string
+	interval       time.Duration
+	throttleWindow time.Duration
+	grpcHealth     *grpchealth.Server
+
+	// lastThrottle is the time, in Unix nanoseconds, that DynamoDB last throttled a call
+	lastThrottle int64
+
+	mu     sync.RWMutex
+	report Report
+}
+
+// table is a table the checker checks, and the client it is reached through.
+type table struct {
+	client dynamodbiface.DynamoDBAPI
+	name   string
+}
+
+// NewChecker creates a checker of the table named by the configuration, which is reached through client.  It
+// reports the store as unavailable until the first check is made.
+func NewChecker(client dynamodbiface.DynamoDBAPI, config *config.DynamoDbConfig) (*Checker, error) {
+	c := &Checker{
+		tables:         []table{{client: client, name: config.TableName}},
+		indexes:        []string{storage.GlobalSecondaryIndex1},
+		interval:       defaultInterval,
+		throttleWindow: defaultThrottleWindow,
+		grpcHealth:     grpchealth.NewServer(),
+		report: Report{
+			Status: StatusUnavailable,
+			Checks: []Check{{Name: "table", Status: StatusUnavailable, Message: "Not yet checked"}},
+		},
+	}
+	c.grpcHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	if config.Health != nil && config.Health.Interval != "" {
+		interval, err := time.ParseDuration(config.Health.Interval)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse health check interval, %s", err))
+		}
+		c.interval = interval
+	}
+	if config.Health != nil && config.Health.ThrottleWindow != "" {
+		window, err := time.ParseDuration(config.Health.ThrottleWindow)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse throttle window, %s", err))
+		}
+		c.throttleWindow = window
+	}
+
+	return c, nil
+}
+
+// AddTable checks another table as well, such as one that projects are routed to, reached through client.  The
+// store is only as healthy as its least healthy table, and the checks of each table are named after it.  It must
+// be called before the checker is run.
+func (c *Checker) AddTable(client dynamodbiface.DynamoDBAPI, tableName string) {
+	c.tables = append(c.tables, table{client: client, name: tableName})
+}
+
+// InstrumentClient makes the checker report the store as degraded when DynamoDB throttles any call made by
+// client.
+func (c *Checker) InstrumentClient(client *dynamodb.DynamoDB) {
+	client.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{
+		Name: "grafeas.health.CompleteAttempt",
+		Fn: func(r *request.Request) {
+			if r.Error != nil && request.IsErrorThrottle(r.Error) {
+				c.Throttled()
+			}
+		},
+	})
+}
+
+// Throttled records that DynamoDB has throttled a call.
+func (c *Checker) Throttled() {
+	atomic.StoreInt64(&c.lastThrottle, time.Now().UnixNano())
+}
+
+// Run checks the store every interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check checks the store now, making the outcome the latest report.
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+
+	var checks []Check
+	for _, t := range c.tables {
+		tableChecks := append(c.checkTable(ctx, t), c.probe(ctx, t))
+		if len(c.tables) > 1 {
+			for i := range tableChecks {
+				tableChecks[i].Name = fmt.Sprintf("%s of %s", tableChecks[i].Name, t.name)
+			}
+		}
+		checks = append(checks, tableChecks...)
+	}
+	checks = append(checks, c.checkThrottling())
+	report := Report{
+		Status:    StatusOK,
+		Checks:    checks,
+		CheckedAt: time.Now().UTC(),
+	}
+	for _, check := range checks {
+		if severity[check.Status] > severity[report.Status] {
+			report.Status = check.Status
+		}
+	}
+
+	c.mu.Lock()
+	c.report = report
+	c.mu.Unlock()
+
+	if report.Status == StatusUnavailable {
+		c.grpcHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	} else {
+		c.grpcHealth.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	}
+
+	return report
+}
+
+// Report returns the latest report.
+func (c *Checker) Report() Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.report
+}
+
+// checkTable describes the table, checking that it and each of the store's indexes can serve requests.  Tables
+// and indexes that are being updated still serve requests, so only degrade the store.
+func (c *Checker) checkTable(ctx context.Context, t table) []Check {
+	output, err := t.client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(t.name),
+	})
+	if err != nil {
+		return []Check{{Name: "table", Status: StatusUnavailable, Message: fmt.Sprintf("Unable to describe table, %s", err)}}
+	}
+
+	checks := []Check{resourceCheck("table", aws.StringValue(output.Table.TableStatus))}
+
+	descriptions := map[string]*dynamodb.GlobalSecondaryIndexDescription{}
+	for _, index := range output.Table.GlobalSecondaryIndexes {
+		descriptions[aws.StringValue(index.IndexName)] = index
+	}
+	for _, index := range c.indexes {
+		name := "index " + index
+		description, ok := descriptions[index]
+		if !ok {
+			checks = append(checks, Check{Name: name, Status: StatusUnavailable, Message: "Index does not exist"})
+			continue
+		}
+		check := resourceCheck(name, aws.StringValue(description.IndexStatus))
+		if aws.BoolValue(description.Backfilling) {
+			check = Check{Name: name, Status: StatusUnavailable, Message: "Index is backfilling"}
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+func resourceCheck(name, resourceStatus string) Check {
+	switch resourceStatus {
+	case dynamodb.TableStatusActive:
+		return Check{Name: name, Status: StatusOK}
+	case dynamodb.TableStatusUpdating:
+		return Check{Name: name, Status: StatusDegraded, Message: "Status is " + resourceStatus}
+	default:
+		return Check{Name: name, Status: StatusUnavailable, Message: "Status is " + resourceStatus}
+	}
+}
+
+// probe makes an eventually consistent read of an item that does not exist, which costs half a read capacity
+// unit.
+func (c *Checker) probe(ctx context.Context, t table) Check {
+	_, err := t.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(t.name),
+		Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String(probeKey)},
+			storage.SortKeyName:      {S: aws.String(probeKey)},
+		},
+		ProjectionExpression: aws.String(storage.PartitionKeyName),
+	})
+	switch {
+	case err == nil:
+		return Check{Name: "read", Status: StatusOK}
+	case request.IsErrorThrottle(err):
+		c.Throttled()
+		return Check{Name: "read", Status: StatusDegraded, Message: fmt.Sprintf("Read was throttled, %s", err)}
+	default:
+		return Check{Name: "read", Status: StatusUnavailable, Message: fmt.Sprintf("Unable to read table, %s", err)}
+	}
+}
+
+func (c *Checker) checkThrottling() Check {
+	lastThrottle := atomic.LoadInt64(&c.lastThrottle)
+	if lastThrottle == 0 {
+		return Check{Name: "throttling", Status: StatusOK}
+	}
+
+	since := time.Since(time.Unix(0, lastThrottle))
+	if since < c.throttleWindow {
+		return Check{Name: "throttling", Status: StatusDegraded, Message: fmt.Sprintf("Throttled %s ago", since.Round(time.Second))}
+	}
+	return Check{Name: "throttling", Status: StatusOK}
+}
+
+// Handler serves /healthz, which succeeds while the process is running, and /readyz, which serves the latest
+// report and fails while the store is unavailable.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := c.Report()
+		code := http.StatusOK
+		if report.Status == StatusUnavailable {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// HealthServer returns the gRPC health service, which reports the overall ("") service as serving unless the
+// store is unavailable.
+func (c *Checker) HealthServer() healthpb.HealthServer {
+	return c.grpcHealth
+}
+
+// ListenAndServe serves /healthz and /readyz at the given address.  It only returns on error.
+func (c *Checker) ListenAndServe(address string) error {
+	return http.ListenAndServe(address, c.Handler())
+}
+
+// ServeGrpc serves the gRPC health service at the given address.  It only returns on error.
+func (c *Checker) ServeGrpc(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, c.grpcHealth)
+	return server.Serve(listener)
+}
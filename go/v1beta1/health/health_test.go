@@ -0,0 +1,162 @@
+package health_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/health"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func newConfig() *config.DynamoDbConfig {
+	return &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	}
+}
+
+// throttlingClient throttles every read.
+type throttlingClient struct {
+	*memdb.DB
+}
+
+func (throttlingClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil)
+}
+
+func servingStatus(t *testing.T, checker *health.Checker) healthpb.HealthCheckResponse_ServingStatus {
+	response, err := checker.HealthServer().Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Health check failed, %s", err)
+	}
+	return response.Status
+}
+
+func readyz(t *testing.T, checker *health.Checker) (int, health.Report) {
+	recorder := httptest.NewRecorder()
+	checker.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var report health.Report
+	if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+		t.Fatalf("Unable to decode report, %s", err)
+	}
+	return recorder.Code, report
+}
+
+func TestReadyWhenTableIsActive(t *testing.T) {
+	client := memdb.New()
+	checker, err := health.NewChecker(client, newConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _ := readyz(t, checker); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the store to be unavailable before it is checked, got %d", code)
+	}
+
+	storage.NewDynamoDbStoreWithClient(client, newConfig())
+	if report := checker.Check(context.Background()); report.Status != health.StatusOK {
+		t.Fatalf("Expected the store to be ok, got %+v", report)
+	}
+
+	if code, report := readyz(t, checker); code != http.StatusOK || report.Status != health.StatusOK {
+		t.Errorf("Expected /readyz to succeed, got %d %+v", code, report)
+	}
+	if status := servingStatus(t, checker); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected the gRPC health service to be serving, got %s", status)
+	}
+}
+
+func TestUnavailableWithoutTable(t *testing.T) {
+	checker, err := health.NewChecker(memdb.New(), newConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checker.Check(context.Background())
+	if code, report := readyz(t, checker); code != http.StatusServiceUnavailable || report.Status != health.StatusUnavailable {
+		t.Errorf("Expected /readyz to fail, got %d %+v", code, report)
+	}
+	if status := servingStatus(t, checker); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Expected the gRPC health service not to be serving, got %s", status)
+	}
+
+	recorder := httptest.NewRecorder()
+	checker.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to succeed, got %d", recorder.Code)
+	}
+}
+
+func TestDegradedWhileThrottled(t *testing.T) {
+	client := memdb.New()
+	storage.NewDynamoDbStoreWithClient(client, newConfig())
+
+	checker, err := health.NewChecker(throttlingClient{client}, newConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := checker.Check(context.Background())
+	if report.Status != health.StatusDegraded {
+		t.Fatalf("Expected the store to be degraded, got %+v", report)
+	}
+	for _, check := range report.Checks {
+		if check.Name == "throttling" && check.Status != health.StatusDegraded {
+			t.Errorf("Expected the throttled read to be remembered, got %+v", check)
+		}
+	}
+
+	if code, _ := readyz(t, checker); code != http.StatusOK {
+		t.Errorf("Expected a degraded store to be ready, got %d", code)
+	}
+	if status := servingStatus(t, checker); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected the gRPC health service to be serving, got %s", status)
+	}
+}
+
+func TestUnavailableWithoutRoutedTable(t *testing.T) {
+	client := memdb.New()
+	storage.NewDynamoDbStoreWithClient(client, newConfig())
+	checker, err := health.NewChecker(client, newConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker.AddTable(memdb.New(), "regulated_table")
+
+	report := checker.Check(context.Background())
+	if report.Status != health.StatusUnavailable {
+		t.Fatalf("Expected the store to be unavailable without the routed table, got %+v", report)
+	}
+	statuses := map[string]string{}
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	if statuses["table of test_table"] != health.StatusOK || statuses["read of test_table"] != health.StatusOK ||
+		statuses["table of regulated_table"] != health.StatusUnavailable {
+		t.Errorf("Expected the checks to be named after their table, got %+v", report.Checks)
+	}
+}
+
+func TestNewCheckerRejectsInvalidConfig(t *testing.T) {
+	for _, c := range []*config.HealthConfig{
+		{Interval: "often"},
+		{ThrottleWindow: "a while"},
+	} {
+		storeConfig := newConfig()
+		storeConfig.Health = c
+		if _, err := health.NewChecker(memdb.New(), storeConfig); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
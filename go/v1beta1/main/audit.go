@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// runAudit writes who created and last wrote the projects, notes and occurrences of the table as NDJSON, one
+// record per line.
+func runAudit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	var storeOptions storeFlags
+	storeOptions.register(flags)
+	user := flags.String("user", "", "Only list resources created or last written by this user")
+	kind := flags.String("kind", "", "Only list resources of this kind: PROJECT, NOTE or OCCURRENCE")
+	since := flags.String("since", "", "Only list resources created or last written, by -user if given, at or after this RFC 3339 time")
+	until := flags.String("until", "", "Only list resources created or last written, by -user if given, at or before this RFC 3339 time")
+	pageSize := flags.Int("page-size", 100, "Number of rows scanned at a time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := storage.AuditFilter{User: *user, Kind: *kind}
+	switch *kind {
+	case "", storage.ProjectSortKey, storage.NoteSortKey, storage.OccurrenceSortKey:
+	default:
+		return errors.New(fmt.Sprintf("Invalid -kind %q, must be PROJECT, NOTE or OCCURRENCE", *kind))
+	}
+	for _, bound := range []struct {
+		flag  string
+		value string
+		time  *time.Time
+	}{
+		{"since", *since, &filter.Since},
+		{"until", *until, &filter.Until},
+	} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, bound.value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid -%s, %s", bound.flag, err))
+		}
+		*bound.time = t
+	}
+
+	store, err := storeOptions.openStore()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	encoder := json.NewEncoder(os.Stdout)
+	listed := 0
+	token := ""
+	for {
+		records, next, err := store.ListAuditRecords(ctx, filter, int32(*pageSize), token)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		listed += len(records)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	store.BaseLogger().Infof("Listed %d resources", listed)
+	return nil
+}
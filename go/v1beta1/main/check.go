@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/consistency"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// runCheck checks the table once for occurrences that are not linked to their notes as they should be,
+// failing if any problem is left unrepaired.
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	var storeOptions storeFlags
+	storeOptions.register(flags)
+	segments := flags.Int("segments", 4, "Number of segments of the table scanned in parallel")
+	repair := flags.Bool("repair", false, "Repair the problems found, rather than only reporting them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := storeOptions.openStore()
+	if err != nil {
+		return err
+	}
+	destination, _, err := openStores(storeOptions.config)
+	if err != nil {
+		return err
+	}
+
+	checker := &consistency.Checker{
+		Store:    store,
+		Notes:    routedNotes(destination),
+		Segments: *segments,
+		Repair:   *repair,
+	}
+	report, err := checker.Check(context.Background())
+	if err != nil {
+		return err
+	}
+
+	unrepaired := 0
+	for _, problem := range report.Problems {
+		store.BaseLogger().Warn(problem)
+		if !problem.Repaired {
+			unrepaired++
+		}
+	}
+	store.BaseLogger().Infof("Checked %d occurrences and %d links, found %d problems", report.Occurrences, report.Links, len(report.Problems))
+	if unrepaired > 0 {
+		return errors.New(fmt.Sprintf("%d problems were not repaired", unrepaired))
+	}
+	return nil
+}
+
+// routedNotes gets notes from the table their project is routed to.
+type routedNotes func(pID string) *storage.DynamoDb
+
+func (r routedNotes) GetNote(ctx context.Context, pID, nID string) (*pb.Note, error) {
+	return r(pID).GetNote(ctx, pID, nID)
+}
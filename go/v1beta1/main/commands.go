@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	grafeasConfig "github.com/grafeas/grafeas/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+)
+
+// commands are the administrative subcommands, run as "grafeas-dynamodb <command> [flags]" instead of
+// starting the server.
+var commands = map[string]func(args []string) error{
+	"audit":     runAudit,
+	"check":     runCheck,
+	"export":    runExport,
+	"import":    runImport,
+	"migrate":   runMigrate,
+	"reencrypt": runReEncrypt,
+	"revisions": runRevisions,
+}
+
+// storeFlags are the flags shared by the commands that open the store.
+type storeFlags struct {
+	config string
+	target string
+}
+
+func (f *storeFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.config, "config", "", "Path to the Grafeas configuration file")
+	flags.StringVar(&f.target, "target", storage.DefaultTarget, "Table routing target whose table is used")
+}
+
+// openStore opens the store of the target's table, as configured in the dynamodb section of the file.
+func (f *storeFlags) openStore() (*storage.DynamoDb, error) {
+	storeConfig, err := loadStoreConfig(f.config)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := storage.Targets(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		if target.Name == f.target {
+			return storage.NewDynamoDbStore(target.Config), nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown target %q", f.target))
+}
+
+// openStores opens the store of every table, returning the store of the table each project is routed to and the
+// store of the top level table.
+func openStores(configFile string) (func(pID string) *storage.DynamoDb, *storage.DynamoDb, error) {
+	storeConfig, err := loadStoreConfig(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(storeConfig.Targets) == 0 && len(storeConfig.Routes) == 0 {
+		s := storage.NewDynamoDbStore(storeConfig)
+		return func(pID string) *storage.DynamoDb { return s }, s, nil
+	}
+
+	router, err := storage.NewRouter(storeConfig, func(target string, targetConfig *config.DynamoDbConfig) *storage.DynamoDb {
+		return storage.NewDynamoDbStore(targetConfig)
+	})
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Unable to configure table routing, %s", err))
+	}
+	return func(pID string) *storage.DynamoDb { return router.Store(router.TargetOf(pID)) }, router.Store(storage.DefaultTarget), nil
+}
+
+// loadStoreConfig reads the dynamodb section of a Grafeas configuration file.
+func loadStoreConfig(configFile string) (*config.DynamoDbConfig, error) {
+	if configFile == "" {
+		return nil, errors.New("A configuration file must be given with -config")
+	}
+
+	cfg, err := grafeasConfig.LoadConfig(configFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to load configuration, %s", err))
+	}
+	if cfg.StorageType != "dynamodb" || cfg.StorageConfig == nil {
+		return nil, errors.New(fmt.Sprintf("Storage type is %q, the configuration must have a dynamodb section", cfg.StorageType))
+	}
+
+	return storage.ParseConfig(cfg.StorageConfig)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/backup"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// runExport writes the projects, notes and occurrences of the table as NDJSON.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	var storeOptions storeFlags
+	storeOptions.register(flags)
+	output := flags.String("output", "-", "File to write, or - for standard output")
+	gzip := flags.Bool("gzip", false, "Compress the output with gzip")
+	project := flags.String("project", "", "Only export this project, and its notes and occurrences")
+	updatedSince := flags.String("updated-since", "", "Only export resources written at or after this RFC 3339 time")
+	segments := flags.Int("segments", backup.DefaultSegments, "Number of segments of the table scanned in parallel")
+	checkpoint := flags.String("checkpoint", "", "File recording progress, so that an interrupted export to -output can be resumed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	exporter := &backup.Exporter{
+		Segments:       *segments,
+		ProjectID:      *project,
+		Gzip:           *gzip,
+		CheckpointFile: *checkpoint,
+	}
+	if *updatedSince != "" {
+		since, err := time.Parse(time.RFC3339, *updatedSince)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid -updated-since, %s", err))
+		}
+		exporter.UpdatedSince = since
+	}
+
+	store, err := storeOptions.openStore()
+	if err != nil {
+		return err
+	}
+	exporter.Store = store
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, resume, err := openExportOutput(store.BaseLogger(), *output, *checkpoint)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+		exporter.Resume = resume
+	} else if *checkpoint != "" {
+		return errors.New("An export to standard output cannot be resumed, so -checkpoint requires -output")
+	}
+
+	stats, err := exporter.Export(context.Background(), w)
+	if err != nil {
+		return err
+	}
+	store.BaseLogger().Infof("Exported %d projects, %d notes and %d occurrences", stats.Projects, stats.Notes, stats.Occurrences)
+	return nil
+}
+
+// openExportOutput opens the output file, truncated to where the export recorded in the checkpoint got to, if
+// there is one.  A completed checkpoint is not resumed, so that the same command can be run again.
+func openExportOutput(logger *logrus.Logger, output, checkpointFile string) (*os.File, *backup.Checkpoint, error) {
+	var resume *backup.Checkpoint
+	if checkpointFile != "" {
+		var err error
+		resume, err = backup.LoadCheckpoint(checkpointFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resume != nil && resume.Complete() {
+			resume = nil
+		}
+	}
+
+	if resume == nil {
+		file, err := os.Create(output)
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Unable to create output, %s", err))
+		}
+		return file, nil, nil
+	}
+
+	file, err := os.OpenFile(output, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("Unable to open output to resume export, %s", err))
+	}
+	if err := file.Truncate(resume.Offset); err != nil {
+		file.Close()
+		return nil, nil, errors.New(fmt.Sprintf("Unable to truncate output to resume export, %s", err))
+	}
+	if _, err := file.Seek(resume.Offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, errors.New(fmt.Sprintf("Unable to resume export, %s", err))
+	}
+	logger.Infof("Resuming export from %s", checkpointFile)
+	return file, resume, nil
+}
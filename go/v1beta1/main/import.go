@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/backup"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// runImport writes the projects, notes and occurrences of an export to the table each project is routed to.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to the Grafeas configuration file")
+	input := flags.String("input", "-", "File to read, or - for standard input.  Gzip compressed input is detected")
+	conflict := flags.String("conflict", string(backup.ConflictSkip), "What to do with resources that already exist: skip, overwrite or fail")
+	batchSize := flags.Int("batch-size", backup.DefaultBatchSize, "Number of resources read and written at a time, at most 100")
+	rate := flags.Float64("rate", 0, "Maximum number of rows written per second, or 0 for no limit")
+	dryRun := flags.Bool("dry-run", false, "Report what would be written without writing anything")
+	user := flags.String("user", "", "User recorded as the creator of the rows written")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Unable to open input, %s", err))
+		}
+		defer file.Close()
+		r = file
+	}
+
+	destination, store, err := openStores(*configFile)
+	if err != nil {
+		return err
+	}
+
+	importer := &backup.Importer{
+		Destination:     destination,
+		Conflict:        backup.ConflictMode(*conflict),
+		BatchSize:       *batchSize,
+		WritesPerSecond: *rate,
+		DryRun:          *dryRun,
+		UserID:          *user,
+	}
+	stats, err := importer.Import(context.Background(), r)
+	if stats != nil {
+		logImportStats(store.BaseLogger(), stats, *dryRun)
+	}
+	return err
+}
+
+func logImportStats(logger *logrus.Logger, stats *backup.ImportStats, dryRun bool) {
+	verb := "Imported"
+	if dryRun {
+		verb = "Would import"
+		for _, name := range stats.Conflicts {
+			logger.Infof("%s already exists", name)
+		}
+	}
+	logger.Infof("%s %d projects, %d notes and %d occurrences, of which %d overwrote existing resources; skipped %d existing resources",
+		verb, stats.Projects, stats.Notes, stats.Occurrences, stats.Overwritten, stats.Skipped)
+	if dryRun && len(stats.Conflicts) > 0 {
+		logger.Warnf("The import would fail, as %d resources already exist", len(stats.Conflicts))
+	}
+}
@@ -1,25 +1,274 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	grafeasConfig "github.com/grafeas/grafeas/go/config"
+	grafeas "github.com/grafeas/grafeas/go/v1beta1/api"
+	"github.com/grafeas/grafeas/go/v1beta1/project"
 	"github.com/grafeas/grafeas/go/v1beta1/server"
 	grafeasStorage "github.com/grafeas/grafeas/go/v1beta1/storage"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/changefeed"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/consistency"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/health"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/metrics"
 	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/tracing"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/webhook"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/global"
+	"golang.org/x/net/context"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				logrus.Fatalf("%s failed, %s", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	err := grafeasStorage.RegisterDefaultStorageTypeProviders()
 	if err != nil {
-		log.Panicf("Error when registering storage type providers, %s", err)
+		logrus.Panicf("Error when registering storage type providers, %s", err)
 	}
 
 	// register a new storage type using the key 'dynamodb'
-	err = grafeasStorage.RegisterStorageTypeProvider("dynamodb", storage.DynamodbStorageTypeProvider)
+	err = grafeasStorage.RegisterStorageTypeProvider("dynamodb", dynamodbStorageTypeProvider)
+
+	if err != nil {
+		logrus.Panicf("Error when registering my new storage, %s", err)
+	}
+
+	// the server only returns on a fatal error, so spans are also flushed when it is asked to stop
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		running.logger().Infof("Received %s, stopping", sig)
+		shutdownTracing()
+		os.Exit(0)
+	}()
 
+	err = server.StartGrafeas()
+	shutdownTracing()
 	if err != nil {
-		log.Panicf("Error when registering my new storage, %s", err)
+		running.logger().Fatalf("Grafeas server stopped, %s", err)
+	}
+}
+
+// serverState holds what the storage type provider creates that is needed once the server stops.
+type serverState struct {
+	mu sync.Mutex
+	// store is the store of the top level table, whose logger the server logs with.
+	store *storage.DynamoDb
+	// tracerProvider is set if tracing is configured.
+	tracerProvider *tracing.Provider
+}
+
+var running serverState
+
+func (s *serverState) logger() *logrus.Logger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.store == nil {
+		return logrus.StandardLogger()
+	}
+	return s.store.BaseLogger()
+}
+
+// shutdownTracing flushes the spans that have not yet been exported, if tracing is configured.
+func shutdownTracing() {
+	running.mu.Lock()
+	provider := running.tracerProvider
+	running.tracerProvider = nil
+	running.mu.Unlock()
+
+	if provider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		running.logger().WithError(err).Error("Unable to flush traces")
+	}
+}
+
+// dynamodbStorageTypeProvider creates the DynamoDB store, together with the optional features configured
+// alongside it.
+func dynamodbStorageTypeProvider(storageType string, storageConfig *grafeasConfig.StorageConfiguration) (*grafeasStorage.Storage, error) {
+	if storageType != "dynamodb" {
+		return nil, errors.New(fmt.Sprintf("Unknown storage type %s, must be 'dynamodb'", storageType))
+	}
+
+	storeConfig, err := storage.ParseConfig(storageConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *metrics.Metrics
+	if storeConfig.Metrics != nil {
+		m, err = metrics.New()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to configure metrics, %s", err))
+		}
+	}
+
+	if storeConfig.Tracing != nil {
+		provider, err := tracing.NewProvider(storeConfig.Tracing)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to configure tracing, %s", err))
+		}
+		global.SetTracerProvider(provider)
+
+		running.mu.Lock()
+		running.tracerProvider = provider
+		running.mu.Unlock()
+	}
+
+	// newStore creates the store of a table, instrumenting its client if it is the AWS SDK client
+	storeConfigs := map[*storage.DynamoDb]*config.DynamoDbConfig{}
+	newStore := func(target string, targetConfig *config.DynamoDbConfig) *storage.DynamoDb {
+		client := storage.NewDynamoDbClient(targetConfig)
+		if dynamoDb, ok := client.(*dynamodb.DynamoDB); ok {
+			if m != nil {
+				m.InstrumentClient(dynamoDb)
+			}
+			if storeConfig.Tracing != nil {
+				tracing.InstrumentClient(dynamoDb)
+			}
+		}
+		store := storage.NewDynamoDbStoreWithClient(client, targetConfig)
+		storeConfigs[store] = targetConfig
+		return store
+	}
+
+	var s *storage.DynamoDb
+	var stores []*storage.DynamoDb
+	var gs grafeas.Storage
+	var ps project.Storage
+	if len(storeConfig.Targets) > 0 || len(storeConfig.Routes) > 0 {
+		router, err := storage.NewRouter(storeConfig, newStore)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to configure table routing, %s", err))
+		}
+		s = router.Store(storage.DefaultTarget)
+		stores = router.Stores()
+		gs, ps = router, router
+	} else {
+		s = newStore(storage.DefaultTarget, storeConfig)
+		stores = []*storage.DynamoDb{s}
+		gs, ps = s, s
+	}
+
+	running.mu.Lock()
+	running.store = s
+	running.mu.Unlock()
+
+	if storeConfig.Health != nil {
+		checker, err := health.NewChecker(s.DynamoDBAPI, storeConfig)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to configure health checks, %s", err))
+		}
+		for _, store := range stores {
+			if store != s {
+				checker.AddTable(store.DynamoDBAPI, store.TableName)
+			}
+			if dynamoDb, ok := store.DynamoDBAPI.(*dynamodb.DynamoDB); ok {
+				checker.InstrumentClient(dynamoDb)
+			}
+		}
+
+		go checker.Run(context.Background())
+		if storeConfig.Health.Address != "" {
+			go func() {
+				s.BaseLogger().Infof("Serving health checks on %s", storeConfig.Health.Address)
+				s.BaseLogger().Errorf("Health check listener stopped, %s", checker.ListenAndServe(storeConfig.Health.Address))
+			}()
+		}
+		if storeConfig.Health.GrpcAddress != "" {
+			go func() {
+				s.BaseLogger().Infof("Serving gRPC health checks on %s", storeConfig.Health.GrpcAddress)
+				s.BaseLogger().Errorf("gRPC health check listener stopped, %s", checker.ServeGrpc(storeConfig.Health.GrpcAddress))
+			}()
+		}
+	}
+
+	if len(storeConfig.Webhooks) > 0 {
+		// deliveries are queued in the table of the occurrence they report, so they are stored alongside it
+		for _, store := range stores {
+			dispatcher, err := webhook.NewDispatcher(store, storeConfig.Webhooks)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to configure webhooks, %s", err))
+			}
+			store.OccurrenceHooks = append(store.OccurrenceHooks, dispatcher)
+			go dispatcher.Run(context.Background())
+		}
+	}
+
+	if storeConfig.ChangeFeed != nil {
+		// each table has a stream of its own, read with the credentials the table is reached with; every replica
+		// runs a consumer, but only the one holding the lease on the table's checkpoints reads the stream
+		for _, store := range stores {
+			feedConfig := *storeConfig.ChangeFeed
+			if store != s {
+				feedConfig.StreamArn = ""
+			}
+			sess, err := storage.NewSession(storeConfigs[store])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to configure the change feed, %s", err))
+			}
+			consumer, err := changefeed.NewStoreConsumer(store, dynamodbstreams.New(sess), &feedConfig)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to configure the change feed, %s", err))
+			}
+			go consumer.Run(context.Background())
+		}
+	}
+
+	if storeConfig.Consistency != nil {
+		for _, store := range stores {
+			checker, err := consistency.NewChecker(store, storeConfig.Consistency)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to configure consistency checks, %s", err))
+			}
+			// occurrences may refer to notes routed to another table
+			checker.Notes = gs
+			go checker.Run(context.Background())
+		}
+	}
+
+	if m != nil {
+		if err := m.RegisterCache(stores...); err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to configure metrics, %s", err))
+		}
+		go func() {
+			s.BaseLogger().Infof("Serving metrics on %s", storeConfig.Metrics.Address)
+			s.BaseLogger().Errorf("Metrics listener stopped, %s", m.ListenAndServe(storeConfig.Metrics.Address))
+		}()
+
+		instrumented := m.InstrumentStorage(gs, ps)
+		return &grafeasStorage.Storage{
+			Ps: instrumented,
+			Gs: instrumented,
+		}, nil
 	}
 
-	server.StartGrafeas()
+	return &grafeasStorage.Storage{
+		Ps: ps,
+		Gs: gs,
+	}, nil
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	grafeasConfig "github.com/grafeas/grafeas/go/config"
+	grafeasStorage "github.com/grafeas/grafeas/go/v1beta1/storage"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/backup"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// runMigrate copies the projects, notes and occurrences of another Grafeas store into the tables.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path to the Grafeas configuration file of the DynamoDB store")
+	sourceConfig := flags.String("source-config", "", "Path to the Grafeas configuration file of the store to migrate from")
+	conflict := flags.String("conflict", string(backup.ConflictSkip), "What to do with resources that already exist: skip, overwrite or fail")
+	batchSize := flags.Int("batch-size", backup.DefaultBatchSize, "Number of resources written at a time, at most 100")
+	pageSize := flags.Int("page-size", backup.DefaultPageSize, "Number of resources listed from the source at a time")
+	rate := flags.Float64("rate", 0, "Maximum number of rows written per second, or 0 for no limit")
+	dryRun := flags.Bool("dry-run", false, "Report what would be written without writing anything")
+	user := flags.String("user", "", "User recorded as the creator of the rows written")
+	checkpoint := flags.String("checkpoint", "", "File recording progress, so that an interrupted migration can be resumed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	source, err := openSource(*sourceConfig)
+	if err != nil {
+		return err
+	}
+	destination, store, err := openStores(*configFile)
+	if err != nil {
+		return err
+	}
+
+	migrator := &backup.Migrator{
+		Source:      source,
+		Destination: destination,
+		Importer: backup.Importer{
+			Conflict:        backup.ConflictMode(*conflict),
+			BatchSize:       *batchSize,
+			WritesPerSecond: *rate,
+			DryRun:          *dryRun,
+			UserID:          *user,
+		},
+		PageSize:       *pageSize,
+		CheckpointFile: *checkpoint,
+	}
+	if *checkpoint != "" {
+		resume, err := backup.LoadMigrationCheckpoint(*checkpoint)
+		if err != nil {
+			return err
+		}
+		if resume != nil && !resume.Done {
+			store.BaseLogger().Infof("Resuming migration from %s", *checkpoint)
+			migrator.Resume = resume
+		}
+	}
+
+	ctx := context.Background()
+	stats, err := migrator.Migrate(ctx)
+	if stats != nil {
+		logImportStats(store.BaseLogger(), stats, *dryRun)
+	}
+	if err != nil || *dryRun {
+		return err
+	}
+
+	differences, err := migrator.Verify(ctx)
+	if err != nil {
+		return err
+	}
+	for _, difference := range differences {
+		store.BaseLogger().Warn(difference)
+	}
+	if len(differences) > 0 {
+		return errors.New(fmt.Sprintf("The counts of %d projects differ between the source and DynamoDB", len(differences)))
+	}
+	store.BaseLogger().Infof("Verified the counts of every project")
+	return nil
+}
+
+// openSource creates the store configured in a Grafeas configuration file by the storage type providers
+// registered with Grafeas, which are memstore, embedded and postgres, as well as dynamodb.
+func openSource(configFile string) (*grafeasStorage.Storage, error) {
+	if configFile == "" {
+		return nil, errors.New("The configuration file of the store to migrate from must be given with -source-config")
+	}
+
+	cfg, err := grafeasConfig.LoadConfig(configFile)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to load source configuration, %s", err))
+	}
+
+	if err := grafeasStorage.RegisterDefaultStorageTypeProviders(); err != nil {
+		return nil, err
+	}
+	if err := grafeasStorage.RegisterStorageTypeProvider("dynamodb", storage.DynamodbStorageTypeProvider); err != nil {
+		return nil, err
+	}
+
+	source, err := grafeasStorage.CreateStorageOfType(cfg.StorageType, cfg.StorageConfig)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to open source, %s", err))
+	}
+	return source, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+
+	"golang.org/x/net/context"
+)
+
+// runReEncrypt rewrites the payloads of the target's table that are in plaintext or wrapped by a previous master
+// key, once encryption has been enabled or the master key rotated.
+func runReEncrypt(args []string) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	var storeOptions storeFlags
+	storeOptions.register(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := storeOptions.openStore()
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := store.ReEncrypt(context.Background())
+	store.BaseLogger().Infof("Re-encrypted %d items of table %s", rewritten, store.TableName)
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// revisionLine is a revision as written by the revisions command, with the note or occurrence in the proto JSON
+// form the API returns.
+type revisionLine struct {
+	Name       string          `json:"name"`
+	Revision   int64           `json:"revision"`
+	Operation  string          `json:"operation"`
+	RevisedBy  string          `json:"revised_by"`
+	RevisedAt  time.Time       `json:"revised_at"`
+	Note       json.RawMessage `json:"note,omitempty"`
+	Occurrence json.RawMessage `json:"occurrence,omitempty"`
+}
+
+// runRevisions writes the revisions of a note or occurrence as NDJSON, oldest first, or a single revision.
+func runRevisions(args []string) error {
+	flags := flag.NewFlagSet("revisions", flag.ExitOnError)
+	var storeOptions storeFlags
+	storeOptions.register(flags)
+	entityName := flags.String("name", "", "Name of the note or occurrence, e.g. projects/p1/notes/n1")
+	revision := flags.Int64("revision", 0, "Only write this revision, rather than every revision")
+	pageSize := flags.Int("page-size", 100, "Number of revisions read at a time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *entityName == "" {
+		return errors.New("The name of a note or occurrence must be given with -name")
+	}
+
+	store, err := storeOptions.openStore()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	encoder := json.NewEncoder(os.Stdout)
+	if *revision != 0 {
+		r, err := store.GetRevision(ctx, *entityName, *revision)
+		if err != nil {
+			return err
+		}
+		return writeRevision(encoder, r)
+	}
+
+	listed := 0
+	token := ""
+	for {
+		revisions, next, err := store.ListRevisions(ctx, *entityName, int32(*pageSize), token)
+		if err != nil {
+			return err
+		}
+		for _, r := range revisions {
+			if err := writeRevision(encoder, r); err != nil {
+				return err
+			}
+		}
+		listed += len(revisions)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	store.BaseLogger().Infof("Listed %d revisions of %s", listed, *entityName)
+	return nil
+}
+
+func writeRevision(encoder *json.Encoder, r *storage.Revision) error {
+	line := revisionLine{
+		Name:      r.Name,
+		Revision:  r.Revision,
+		Operation: r.Operation,
+		RevisedBy: r.RevisedBy,
+		RevisedAt: r.RevisedAt,
+	}
+
+	marshaler := jsonpb.Marshaler{}
+	if r.Note != nil {
+		note, err := marshaler.MarshalToString(r.Note)
+		if err != nil {
+			return err
+		}
+		line.Note = json.RawMessage(note)
+	}
+	if r.Occurrence != nil {
+		occurrence, err := marshaler.MarshalToString(r.Occurrence)
+		if err != nil {
+			return err
+		}
+		line.Occurrence = json.RawMessage(occurrence)
+	}
+
+	return encoder.Encode(line)
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/awsrequest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// readOperations are the DynamoDB operations whose capacity is read capacity.
+var readOperations = map[string]bool{
+	"GetItem":          true,
+	"BatchGetItem":     true,
+	"Query":            true,
+	"Scan":             true,
+	"TransactGetItems": true,
+}
+
+// InstrumentClient records the latency, throttling, retries and consumed capacity of every call made by a
+// DynamoDB client.  Calls are made to return their consumed capacity, broken down by index, unless they
+// already ask for it.
+func (m *Metrics) InstrumentClient(client *dynamodb.DynamoDB) {
+	client.Handlers.Build.PushFrontNamed(request.NamedHandler{
+		Name: "grafeas.metrics.ReturnConsumedCapacity",
+		Fn:   awsrequest.ReturnConsumedCapacity,
+	})
+	client.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{
+		Name: "grafeas.metrics.CompleteAttempt",
+		Fn: func(r *request.Request) {
+			if r.Error != nil && request.IsErrorThrottle(r.Error) {
+				m.throttles.With(requestLabels(r)).Inc()
+			}
+		},
+	})
+	client.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "grafeas.metrics.Complete",
+		Fn:   m.complete,
+	})
+}
+
+func (m *Metrics) complete(r *request.Request) {
+	labels := requestLabels(r)
+	m.requestDuration.With(labels).Observe(time.Since(r.Time).Seconds())
+	if r.RetryCount > 0 {
+		m.retries.With(labels).Add(float64(r.RetryCount))
+	}
+
+	if r.Error == nil {
+		for _, capacity := range awsrequest.ConsumedCapacity(r.Data) {
+			m.observeCapacity(r.Operation.Name, capacity)
+		}
+	}
+}
+
+func (m *Metrics) observeCapacity(operation string, capacity *dynamodb.ConsumedCapacity) {
+	table := aws.StringValue(capacity.TableName)
+	if capacity.Table == nil && capacity.GlobalSecondaryIndexes == nil {
+		m.addCapacity(prometheus.Labels{"table": table, "index": "", "operation": operation}, operation, &dynamodb.Capacity{
+			CapacityUnits:      capacity.CapacityUnits,
+			ReadCapacityUnits:  capacity.ReadCapacityUnits,
+			WriteCapacityUnits: capacity.WriteCapacityUnits,
+		})
+		return
+	}
+
+	if capacity.Table != nil {
+		m.addCapacity(prometheus.Labels{"table": table, "index": "", "operation": operation}, operation, capacity.Table)
+	}
+	for index, indexCapacity := range capacity.GlobalSecondaryIndexes {
+		m.addCapacity(prometheus.Labels{"table": table, "index": index, "operation": operation}, operation, indexCapacity)
+	}
+}
+
+// addCapacity records capacity units, which are attributed to reads or writes by the operation if DynamoDB
+// does not break them down.
+func (m *Metrics) addCapacity(labels prometheus.Labels, operation string, capacity *dynamodb.Capacity) {
+	if capacity.ReadCapacityUnits != nil || capacity.WriteCapacityUnits != nil {
+		m.readCapacity.With(labels).Add(aws.Float64Value(capacity.ReadCapacityUnits))
+		m.writeCapacity.With(labels).Add(aws.Float64Value(capacity.WriteCapacityUnits))
+		return
+	}
+
+	if readOperations[operation] {
+		m.readCapacity.With(labels).Add(aws.Float64Value(capacity.CapacityUnits))
+	} else {
+		m.writeCapacity.With(labels).Add(aws.Float64Value(capacity.CapacityUnits))
+	}
+}
+
+// requestLabels labels a call with its table, index and operation.
+func requestLabels(r *request.Request) prometheus.Labels {
+	return prometheus.Labels{"table": awsrequest.TableName(r), "index": awsrequest.IndexName(r), "operation": r.Operation.Name}
+}
@@ -0,0 +1,155 @@
+// Package metrics exports Prometheus metrics for the DynamoDB store: the latency and errors of each storage
+// method, and the latency, throttling, retries and consumed capacity of each DynamoDB call.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "grafeas"
+
+// Metrics holds the collectors of the store and the registry they are exported from.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	methodDuration  *prometheus.HistogramVec
+	methodErrors    *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	throttles       *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+	readCapacity    *prometheus.CounterVec
+	writeCapacity   *prometheus.CounterVec
+}
+
+// New creates the collectors and registers them, along with the Go runtime and process collectors, in a new
+// registry.
+func New() (*Metrics, error) {
+	dynamoDbLabels := []string{"table", "index", "operation"}
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		methodDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "method_duration_seconds",
+			Help:      "Latency of storage methods.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		methodErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "method_errors_total",
+			Help:      "Errors returned by storage methods, by gRPC code.",
+		}, []string{"method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of DynamoDB calls, including retries.",
+			Buckets:   prometheus.DefBuckets,
+		}, dynamoDbLabels),
+		throttles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "throttles_total",
+			Help:      "Attempts of DynamoDB calls that were throttled.",
+		}, dynamoDbLabels),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "retries_total",
+			Help:      "Retries of DynamoDB calls.",
+		}, dynamoDbLabels),
+		readCapacity: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "consumed_read_capacity_units_total",
+			Help:      "Read capacity units consumed by DynamoDB calls.",
+		}, dynamoDbLabels),
+		writeCapacity: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dynamodb",
+			Name:      "consumed_write_capacity_units_total",
+			Help:      "Write capacity units consumed by DynamoDB calls.",
+		}, dynamoDbLabels),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.methodDuration,
+		m.methodErrors,
+		m.requestDuration,
+		m.throttles,
+		m.retries,
+		m.readCapacity,
+		m.writeCapacity,
+	} {
+		if err := m.Registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// RegisterCache exports the hit, miss and eviction counts of the note and project caches of the stores, labelled
+// by table.  The counts of stores that share a table name are added together.
+func (m *Metrics) RegisterCache(stores ...*storage.DynamoDb) error {
+	var tables []string
+	byTable := map[string][]*storage.DynamoDb{}
+	for _, db := range stores {
+		if _, ok := byTable[db.TableName]; !ok {
+			tables = append(tables, db.TableName)
+		}
+		byTable[db.TableName] = append(byTable[db.TableName], db)
+	}
+
+	for _, tableName := range tables {
+		tableStores := byTable[tableName]
+		for _, counter := range []struct {
+			name  string
+			help  string
+			value func(storage.CacheStats) uint64
+		}{
+			{"hits_total", "Lookups served from the note and project cache.", func(s storage.CacheStats) uint64 { return s.Hits }},
+			{"misses_total", "Lookups not served from the note and project cache.", func(s storage.CacheStats) uint64 { return s.Misses }},
+			{"evictions_total", "Entries evicted from the note and project cache.", func(s storage.CacheStats) uint64 { return s.Evictions }},
+		} {
+			value := counter.value
+			err := m.Registry.Register(prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace:   namespace,
+				Subsystem:   "cache",
+				Name:        counter.name,
+				Help:        counter.help,
+				ConstLabels: prometheus.Labels{"table": tableName},
+			}, func() float64 {
+				var total uint64
+				for _, db := range tableStores {
+					total += value(db.CacheStats())
+				}
+				return float64(total)
+			}))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves the metrics on /metrics at the given address.  It only returns on error.
+func (m *Metrics) ListenAndServe(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	return http.ListenAndServe(address, mux)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newMetrics(t *testing.T) *Metrics {
+	m, err := New()
+	if err != nil {
+		t.Fatalf("New failed, %s", err)
+	}
+	return m
+}
+
+func TestInstrumentClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(body))
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if len(requests) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException","message":"slow down"}`))
+			return
+		}
+		w.Write([]byte(`{"Items":[],"Count":0,"ConsumedCapacity":{"TableName":"grafeas","CapacityUnits":2.5,` +
+			`"Table":{"CapacityUnits":0.5},"GlobalSecondaryIndexes":{"GSI_1":{"CapacityUnits":2}}}}`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(request.WithRetryer(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}, client.DefaultRetryer{NumMaxRetries: 1, MinThrottleDelay: time.Millisecond, MaxThrottleDelay: time.Millisecond})))
+	ddb := dynamodb.New(sess)
+
+	m := newMetrics(t)
+	m.InstrumentClient(ddb)
+
+	_, err := ddb.Query(&dynamodb.QueryInput{
+		TableName:              aws.String("grafeas"),
+		IndexName:              aws.String("GSI_1"),
+		KeyConditionExpression: aws.String("SortKey = :SK"),
+	})
+	if err != nil {
+		t.Fatalf("Query failed, %s", err)
+	}
+
+	if !strings.Contains(requests[0], `"ReturnConsumedCapacity":"INDEXES"`) {
+		t.Errorf("Expected the request to ask for consumed capacity, got %s", requests[0])
+	}
+
+	labels := []string{"grafeas", "GSI_1", "Query"}
+	if throttles := testutil.ToFloat64(m.throttles.WithLabelValues(labels...)); throttles != 1 {
+		t.Errorf("Got %v throttles, expected 1", throttles)
+	}
+	if retries := testutil.ToFloat64(m.retries.WithLabelValues(labels...)); retries != 1 {
+		t.Errorf("Got %v retries, expected 1", retries)
+	}
+	if units := testutil.ToFloat64(m.readCapacity.WithLabelValues(labels...)); units != 2 {
+		t.Errorf("Got %v read capacity units on the index, expected 2", units)
+	}
+	if units := testutil.ToFloat64(m.readCapacity.WithLabelValues("grafeas", "", "Query")); units != 0.5 {
+		t.Errorf("Got %v read capacity units on the table, expected 0.5", units)
+	}
+	if units := testutil.ToFloat64(m.writeCapacity.WithLabelValues("grafeas", "", "Query")); units != 0 {
+		t.Errorf("Got %v write capacity units on the table, expected 0", units)
+	}
+}
+
+type failingProjects struct {
+	*storage.DynamoDb
+}
+
+func (failingProjects) DeleteProject(ctx context.Context, pID string) error {
+	return status.Errorf(codes.NotFound, "Project with name %q does not Exist", pID)
+}
+
+func TestInstrumentStorageCountsErrorsByCode(t *testing.T) {
+	m := newMetrics(t)
+	s := m.InstrumentStorage(nil, failingProjects{})
+
+	for i := 0; i < 2; i++ {
+		if err := s.DeleteProject(context.Background(), "p1"); status.Code(err) != codes.NotFound {
+			t.Fatalf("Expected the error to be passed through, got %v", err)
+		}
+	}
+
+	if errors := testutil.ToFloat64(m.methodErrors.WithLabelValues("DeleteProject", "NotFound")); errors != 2 {
+		t.Errorf("Got %v errors, expected 2", errors)
+	}
+
+	families, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed, %s", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "grafeas_storage_method_duration_seconds" {
+			if count := family.GetMetric()[0].GetHistogram().GetSampleCount(); count != 2 {
+				t.Errorf("Got %d latency samples, expected 2", count)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected the latency of DeleteProject to be recorded")
+}
+
+func TestRegisterCacheLabelsEachTable(t *testing.T) {
+	newStore := func(tableName string) *storage.DynamoDb {
+		return storage.NewDynamoDbStoreWithClient(memdb.New(), &config.DynamoDbConfig{
+			TableName: tableName,
+			Cache:     &config.CacheConfig{Size: 10},
+			AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+		})
+	}
+	stores := []*storage.DynamoDb{newStore("test_table"), newStore("regulated_table"), newStore("regulated_table")}
+
+	m := newMetrics(t)
+	if err := m.RegisterCache(stores...); err != nil {
+		t.Fatalf("RegisterCache failed, %s", err)
+	}
+	for _, store := range stores {
+		store.GetProject(context.Background(), "p1")
+	}
+
+	families, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed, %s", err)
+	}
+	misses := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "grafeas_cache_misses_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "table" {
+					misses[label.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	if len(misses) != 2 || misses["test_table"] != 1 || misses["regulated_table"] != 2 {
+		t.Errorf("Expected the misses of each table, got %v", misses)
+	}
+}
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"time"
+
+	grafeas "github.com/grafeas/grafeas/go/v1beta1/api"
+	"github.com/grafeas/grafeas/go/v1beta1/project"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"golang.org/x/net/context"
+	fieldmaskpb "google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/grpc/status"
+)
+
+// Storage records the latency and errors of the methods of a Grafeas and project store.
+type Storage struct {
+	Gs      grafeas.Storage
+	Ps      project.Storage
+	Metrics *Metrics
+}
+
+// InstrumentStorage wraps a Grafeas and project store, recording the latency and errors of its methods.
+func (m *Metrics) InstrumentStorage(gs grafeas.Storage, ps project.Storage) *Storage {
+	return &Storage{Gs: gs, Ps: ps, Metrics: m}
+}
+
+// observe records the latency of a method call started at start, and the gRPC code of each error it returned.
+func (m *Metrics) observe(method string, start time.Time, errs ...error) {
+	m.methodDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	for _, err := range errs {
+		if err != nil {
+			m.methodErrors.WithLabelValues(method, status.Code(err).String()).Inc()
+		}
+	}
+}
+
+// CreateProject creates a project.
+func (s *Storage) CreateProject(ctx context.Context, pID string, p *prpb.Project) (*prpb.Project, error) {
+	start := time.Now()
+	created, err := s.Ps.CreateProject(ctx, pID, p)
+	s.Metrics.observe("CreateProject", start, err)
+	return created, err
+}
+
+// GetProject returns a project.
+func (s *Storage) GetProject(ctx context.Context, pID string) (*prpb.Project, error) {
+	start := time.Now()
+	p, err := s.Ps.GetProject(ctx, pID)
+	s.Metrics.observe("GetProject", start, err)
+	return p, err
+}
+
+// ListProjects returns a page of projects.
+func (s *Storage) ListProjects(ctx context.Context, filter string, pageSize int, pageToken string) ([]*prpb.Project, string, error) {
+	start := time.Now()
+	projects, nextPageToken, err := s.Ps.ListProjects(ctx, filter, pageSize, pageToken)
+	s.Metrics.observe("ListProjects", start, err)
+	return projects, nextPageToken, err
+}
+
+// DeleteProject deletes a project.
+func (s *Storage) DeleteProject(ctx context.Context, pID string) error {
+	start := time.Now()
+	err := s.Ps.DeleteProject(ctx, pID)
+	s.Metrics.observe("DeleteProject", start, err)
+	return err
+}
+
+// GetOccurrence returns an occurrence.
+func (s *Storage) GetOccurrence(ctx context.Context, projectID, occID string) (*pb.Occurrence, error) {
+	start := time.Now()
+	o, err := s.Gs.GetOccurrence(ctx, projectID, occID)
+	s.Metrics.observe("GetOccurrence", start, err)
+	return o, err
+}
+
+// ListOccurrences returns a page of the occurrences of a project.
+func (s *Storage) ListOccurrences(ctx context.Context, projectID, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	start := time.Now()
+	occurrences, nextPageToken, err := s.Gs.ListOccurrences(ctx, projectID, filter, pageToken, pageSize)
+	s.Metrics.observe("ListOccurrences", start, err)
+	return occurrences, nextPageToken, err
+}
+
+// CreateOccurrence creates an occurrence.
+func (s *Storage) CreateOccurrence(ctx context.Context, projectID, userID string, o *pb.Occurrence) (*pb.Occurrence, error) {
+	start := time.Now()
+	created, err := s.Gs.CreateOccurrence(ctx, projectID, userID, o)
+	s.Metrics.observe("CreateOccurrence", start, err)
+	return created, err
+}
+
+// BatchCreateOccurrences creates occurrences, recording an error for each that could not be created.
+func (s *Storage) BatchCreateOccurrences(ctx context.Context, projectID string, userID string, occs []*pb.Occurrence) ([]*pb.Occurrence, []error) {
+	start := time.Now()
+	created, errs := s.Gs.BatchCreateOccurrences(ctx, projectID, userID, occs)
+	s.Metrics.observe("BatchCreateOccurrences", start, errs...)
+	return created, errs
+}
+
+// UpdateOccurrence updates an occurrence.
+func (s *Storage) UpdateOccurrence(ctx context.Context, projectID, occID string, o *pb.Occurrence, mask *fieldmaskpb.FieldMask) (*pb.Occurrence, error) {
+	start := time.Now()
+	updated, err := s.Gs.UpdateOccurrence(ctx, projectID, occID, o, mask)
+	s.Metrics.observe("UpdateOccurrence", start, err)
+	return updated, err
+}
+
+// DeleteOccurrence deletes an occurrence.
+func (s *Storage) DeleteOccurrence(ctx context.Context, projectID, occID string) error {
+	start := time.Now()
+	err := s.Gs.DeleteOccurrence(ctx, projectID, occID)
+	s.Metrics.observe("DeleteOccurrence", start, err)
+	return err
+}
+
+// GetNote returns a note.
+func (s *Storage) GetNote(ctx context.Context, projectID, nID string) (*pb.Note, error) {
+	start := time.Now()
+	n, err := s.Gs.GetNote(ctx, projectID, nID)
+	s.Metrics.observe("GetNote", start, err)
+	return n, err
+}
+
+// ListNotes returns a page of the notes of a project.
+func (s *Storage) ListNotes(ctx context.Context, projectID, filter, pageToken string, pageSize int32) ([]*pb.Note, string, error) {
+	start := time.Now()
+	notes, nextPageToken, err := s.Gs.ListNotes(ctx, projectID, filter, pageToken, pageSize)
+	s.Metrics.observe("ListNotes", start, err)
+	return notes, nextPageToken, err
+}
+
+// CreateNote creates a note.
+func (s *Storage) CreateNote(ctx context.Context, projectID, nID string, userID string, n *pb.Note) (*pb.Note, error) {
+	start := time.Now()
+	created, err := s.Gs.CreateNote(ctx, projectID, nID, userID, n)
+	s.Metrics.observe("CreateNote", start, err)
+	return created, err
+}
+
+// BatchCreateNotes creates notes, recording an error for each that could not be created.
+func (s *Storage) BatchCreateNotes(ctx context.Context, projectID string, userID string, notes map[string]*pb.Note) ([]*pb.Note, []error) {
+	start := time.Now()
+	created, errs := s.Gs.BatchCreateNotes(ctx, projectID, userID, notes)
+	s.Metrics.observe("BatchCreateNotes", start, errs...)
+	return created, errs
+}
+
+// UpdateNote updates a note.
+func (s *Storage) UpdateNote(ctx context.Context, projectID, nID string, n *pb.Note, mask *fieldmaskpb.FieldMask) (*pb.Note, error) {
+	start := time.Now()
+	updated, err := s.Gs.UpdateNote(ctx, projectID, nID, n, mask)
+	s.Metrics.observe("UpdateNote", start, err)
+	return updated, err
+}
+
+// DeleteNote deletes a note.
+func (s *Storage) DeleteNote(ctx context.Context, projectID, nID string) error {
+	start := time.Now()
+	err := s.Gs.DeleteNote(ctx, projectID, nID)
+	s.Metrics.observe("DeleteNote", start, err)
+	return err
+}
+
+// GetOccurrenceNote returns the note of an occurrence.
+func (s *Storage) GetOccurrenceNote(ctx context.Context, projectID, oID string) (*pb.Note, error) {
+	start := time.Now()
+	n, err := s.Gs.GetOccurrenceNote(ctx, projectID, oID)
+	s.Metrics.observe("GetOccurrenceNote", start, err)
+	return n, err
+}
+
+// ListNoteOccurrences returns a page of the occurrences of a note.
+func (s *Storage) ListNoteOccurrences(ctx context.Context, projectID, nID, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	start := time.Now()
+	occurrences, nextPageToken, err := s.Gs.ListNoteOccurrences(ctx, projectID, nID, filter, pageToken, pageSize)
+	s.Metrics.observe("ListNoteOccurrences", start, err)
+	return occurrences, nextPageToken, err
+}
+
+// GetVulnerabilityOccurrencesSummary summarises the vulnerability occurrences of a project.
+func (s *Storage) GetVulnerabilityOccurrencesSummary(ctx context.Context, projectID, filter string) (*pb.VulnerabilityOccurrencesSummary, error) {
+	start := time.Now()
+	summary, err := s.Gs.GetVulnerabilityOccurrencesSummary(ctx, projectID, filter)
+	s.Metrics.observe("GetVulnerabilityOccurrencesSummary", start, err)
+	return summary, err
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// auditTimeFormat is a fixed width UTC timestamp, so that stored times sort lexicographically.
+const auditTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// AuditTimeFormat is the layout of the CreatedAt and UpdatedAt attributes.
+const AuditTimeFormat = auditTimeFormat
+
+// AuditRecord describes who wrote a project, note or occurrence, and when.
+type AuditRecord struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Version   int64     `json:"version"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AuditFilter restricts the records returned by ListAuditRecords.  Zero values match everything.
+type AuditFilter struct {
+	// User matches records created or last updated by this user.
+	User string
+	// Kind is one of "PROJECT", "NOTE" or "OCCURRENCE".
+	Kind string
+	// Since and Until bound, inclusively, the time of the creation or last update that matched User.  Without
+	// a User, either write may fall within them.
+	Since time.Time
+	Until time.Time
+}
+
+// endUserIDFromContext identifies the caller by the common name of its TLS client certificate, which is
+// how Grafeas authenticates clients when mutual TLS is configured.
+func endUserIDFromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return ""
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return tlsInfo.State.PeerCertificates[0].Subject.CommonName
+}
+
+// userOrCaller returns userID if Grafeas supplied one, otherwise the user identified from the context.
+func (db *DynamoDb) userOrCaller(ctx context.Context, userID string) string {
+	if userID != "" {
+		return userID
+	}
+	if db.EndUserID != nil {
+		return db.EndUserID(ctx)
+	}
+	return endUserIDFromContext(ctx)
+}
+
+// stampCreated records the user creating the item.
+func stampCreated(dataItem *DataItem, userID string, now time.Time) {
+	dataItem.CreatedBy = userID
+	dataItem.CreatedAt = now.UTC().Format(auditTimeFormat)
+	dataItem.UpdatedBy = userID
+	dataItem.UpdatedAt = dataItem.CreatedAt
+}
+
+// stampUpdated records the user updating the item, carrying over the creator from the stored item.
+func stampUpdated(dataItem *DataItem, current *DataItem, userID string, now time.Time) {
+	dataItem.CreatedBy = current.CreatedBy
+	dataItem.CreatedAt = current.CreatedAt
+	dataItem.UpdatedBy = userID
+	dataItem.UpdatedAt = now.UTC().Format(auditTimeFormat)
+}
+
+// ListAuditRecords returns who wrote which projects, notes and occurrences, e.g. to find everything written
+// by a service account on a given day.  This performs a filtered scan of the whole table and is intended for
+// administrative use only.
+func (db *DynamoDb) ListAuditRecords(ctx context.Context, filter AuditFilter, pageSize int32, pageToken string) ([]*AuditRecord, string, error) {
+	ctx, span := db.startOperation(ctx, "ListAuditRecords")
+	defer span.End()
+
+	var records []*AuditRecord
+
+	names := map[string]*string{
+		"#SORT_KEY": aws.String(SortKeyName),
+	}
+	values := map[string]*dynamodb.AttributeValue{}
+	var conditions []string
+
+	if filter.Kind != "" {
+		values[":KIND"] = &dynamodb.AttributeValue{S: aws.String(db.key(filter.Kind))}
+		conditions = append(conditions, "#SORT_KEY = :KIND")
+	} else {
+		values[":PROJECT"] = &dynamodb.AttributeValue{S: aws.String(db.key(projectSK))}
+		values[":NOTE"] = &dynamodb.AttributeValue{S: aws.String(db.key(noteSK))}
+		values[":OCCURRENCE"] = &dynamodb.AttributeValue{S: aws.String(db.key(occurrenceSK))}
+		conditions = append(conditions, "#SORT_KEY IN (:PROJECT, :NOTE, :OCCURRENCE)")
+	}
+
+	// the user and the times must match the same write, so that a resource created by the user long ago but
+	// updated by someone else in the period is not mistaken for one the user wrote in the period
+	var createdConditions, updatedConditions []string
+	if filter.User != "" {
+		names["#CREATED_BY"] = aws.String(CreatedByKeyName)
+		names["#UPDATED_BY"] = aws.String(UpdatedByKeyName)
+		values[":USER"] = &dynamodb.AttributeValue{S: aws.String(filter.User)}
+		createdConditions = append(createdConditions, "#CREATED_BY = :USER")
+		updatedConditions = append(updatedConditions, "#UPDATED_BY = :USER")
+	}
+
+	if !filter.Since.IsZero() {
+		names["#CREATED_AT"] = aws.String(CreatedAtKeyName)
+		names["#UPDATED_AT"] = aws.String(UpdatedAtKeyName)
+		values[":SINCE"] = &dynamodb.AttributeValue{S: aws.String(filter.Since.UTC().Format(auditTimeFormat))}
+		createdConditions = append(createdConditions, "#CREATED_AT >= :SINCE")
+		updatedConditions = append(updatedConditions, "#UPDATED_AT >= :SINCE")
+	}
+
+	if !filter.Until.IsZero() {
+		names["#CREATED_AT"] = aws.String(CreatedAtKeyName)
+		names["#UPDATED_AT"] = aws.String(UpdatedAtKeyName)
+		values[":UNTIL"] = &dynamodb.AttributeValue{S: aws.String(filter.Until.UTC().Format(auditTimeFormat))}
+		createdConditions = append(createdConditions, "#CREATED_AT <= :UNTIL")
+		updatedConditions = append(updatedConditions, "#UPDATED_AT <= :UNTIL")
+	}
+
+	if len(createdConditions) > 0 {
+		conditions = append(conditions, fmt.Sprintf("((%s) OR (%s))",
+			strings.Join(createdConditions, " AND "), strings.Join(updatedConditions, " AND ")))
+	}
+
+	scanInput := &dynamodb.ScanInput{
+		TableName:                 aws.String(db.TableName),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		FilterExpression:          aws.String(strings.Join(conditions, " AND ")),
+		Limit:                     aws.Int64(int64(pageSize)),
+	}
+
+	if pageToken != "" {
+		tokenArray := strings.Split(pageToken, PaginationString)
+		if len(tokenArray) != 2 {
+			return nil, "", status.Error(codes.InvalidArgument, "Invalid page token")
+		}
+
+		scanInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			PartitionKeyName: {
+				S: aws.String(db.key(tokenArray[0])),
+			},
+			SortKeyName: {
+				S: aws.String(db.key(tokenArray[1])),
+			},
+		}
+	}
+
+	result, err := db.ScanWithContext(ctx, scanInput)
+	if err != nil {
+		return nil, "", status.Errorf(codes.Internal, "Failed to scan audit records, %s", err)
+	}
+
+	for _, item := range result.Items {
+		dataItem := DataItem{}
+		err = dynamodbattribute.UnmarshalMap(item, &dataItem)
+		if err != nil {
+			return nil, "", status.Errorf(codes.Internal, "Failed to unmarshal item, %s", err)
+		}
+
+		records = append(records, db.newAuditRecord(&dataItem))
+	}
+
+	var token = ""
+	if result.LastEvaluatedKey != nil {
+		token = fmt.Sprintf("%s%s%s", db.unkey(*result.LastEvaluatedKey[PartitionKeyName].S), PaginationString, db.unkey(*result.LastEvaluatedKey[SortKeyName].S))
+	}
+
+	return records, token, nil
+}
+
+func (db *DynamoDb) newAuditRecord(dataItem *DataItem) *AuditRecord {
+	createdAt, _ := time.Parse(auditTimeFormat, dataItem.CreatedAt)
+	updatedAt, _ := time.Parse(auditTimeFormat, dataItem.UpdatedAt)
+
+	return &AuditRecord{
+		Name:      db.unkey(dataItem.PartitionKey),
+		Kind:      db.unkey(dataItem.SortKey),
+		Version:   dataItem.Version,
+		CreatedBy: dataItem.CreatedBy,
+		CreatedAt: createdAt,
+		UpdatedBy: dataItem.UpdatedBy,
+		UpdatedAt: updatedAt,
+	}
+}
@@ -0,0 +1,118 @@
+package storage_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// listAuditRecords reads every page of the records matching the filter, by name.
+func listAuditRecords(t *testing.T, store *storage.DynamoDb, filter storage.AuditFilter, pageSize int32) map[string]*storage.AuditRecord {
+	records := map[string]*storage.AuditRecord{}
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("Expected the records to be listed in fewer pages")
+		}
+		page, next, err := store.ListAuditRecords(context.Background(), filter, pageSize, token)
+		if err != nil {
+			t.Fatalf("ListAuditRecords failed, %s", err)
+		}
+		for _, record := range page {
+			if records[record.Name+" "+record.Kind] != nil {
+				t.Errorf("Expected %s to be listed once", record.Name)
+			}
+			records[record.Name+" "+record.Kind] = record
+		}
+		if next == "" {
+			return records
+		}
+		token = next
+	}
+}
+
+func TestListAuditRecords(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := newNamespacedStore(client, "ns")
+	caller := "ci"
+	store.EndUserID = func(ctx context.Context) string { return caller }
+
+	if _, err := store.CreateProject(ctx, "p1", &prpb.Project{Name: "projects/p1"}); err != nil {
+		t.Fatalf("CreateProject failed, %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := store.CreateNote(ctx, "p1", fmt.Sprintf("n%d", i), "alice", &pb.Note{}); err != nil {
+			t.Fatalf("CreateNote failed, %s", err)
+		}
+	}
+
+	time.Sleep(time.Millisecond)
+	since := time.Now()
+	caller = "bob"
+	if _, err := store.UpdateNote(ctx, "p1", "n0", &pb.Note{ShortDescription: "updated"}, nil); err != nil {
+		t.Fatalf("UpdateNote failed, %s", err)
+	}
+	if _, err := store.CreateOccurrence(ctx, "p1", "bob", &pb.Occurrence{NoteName: "projects/p1/notes/n1"}); err != nil {
+		t.Fatalf("CreateOccurrence failed, %s", err)
+	}
+
+	// every page of a small page size
+	if records := listAuditRecords(t, store, storage.AuditFilter{}, 2); len(records) != 7 {
+		t.Errorf("Expected a project, 5 notes and an occurrence, got %d records", len(records))
+	}
+
+	notes := listAuditRecords(t, store, storage.AuditFilter{Kind: storage.NoteSortKey}, 3)
+	if len(notes) != 5 {
+		t.Errorf("Expected 5 notes, got %d records", len(notes))
+	}
+	updated := notes["projects/p1/notes/n0 "+storage.NoteSortKey]
+	if updated == nil || updated.CreatedBy != "alice" || updated.UpdatedBy != "bob" || updated.Version != 2 || updated.UpdatedAt.Before(since) {
+		t.Errorf("Expected the note to be created by alice and updated by bob, got %+v", updated)
+	}
+
+	// a user matches the records they created or last updated
+	if records := listAuditRecords(t, store, storage.AuditFilter{User: "alice"}, 2); len(records) != 5 {
+		t.Errorf("Expected the 5 notes alice created, got %d records", len(records))
+	}
+	if records := listAuditRecords(t, store, storage.AuditFilter{User: "bob"}, 2); len(records) != 2 {
+		t.Errorf("Expected the note and occurrence bob wrote, got %d records", len(records))
+	}
+	if records := listAuditRecords(t, store, storage.AuditFilter{User: "ci", Kind: storage.ProjectSortKey}, 2); len(records) != 1 {
+		t.Errorf("Expected the project ci created, got %d records", len(records))
+	}
+
+	// the time window bounds the time of the creation or last update
+	if records := listAuditRecords(t, store, storage.AuditFilter{Since: since}, 2); len(records) != 2 {
+		t.Errorf("Expected the 2 records written since %s, got %d records", since, len(records))
+	}
+	if records := listAuditRecords(t, store, storage.AuditFilter{Until: since}, 2); len(records) != 6 {
+		t.Errorf("Expected the 6 records written until %s, got %d records", since, len(records))
+	}
+
+	// with a user, it bounds the time of that user's write: alice created n0 before the window and bob updated it
+	// within it
+	if records := listAuditRecords(t, store, storage.AuditFilter{User: "alice", Until: since}, 2); len(records) != 5 || records["projects/p1/notes/n0 "+storage.NoteSortKey] == nil {
+		t.Errorf("Expected the 5 notes alice created until %s, got %v", since, records)
+	}
+	if records := listAuditRecords(t, store, storage.AuditFilter{User: "alice", Since: since}, 2); len(records) != 0 {
+		t.Errorf("Expected alice to have written nothing since %s, got %v", since, records)
+	}
+	if records := listAuditRecords(t, store, storage.AuditFilter{User: "bob", Since: since}, 2); len(records) != 2 || records["projects/p1/notes/n0 "+storage.NoteSortKey] == nil {
+		t.Errorf("Expected the note and occurrence bob wrote since %s, got %v", since, records)
+	}
+	if records := listAuditRecords(t, store, storage.AuditFilter{User: "bob", Until: since}, 2); len(records) != 0 {
+		t.Errorf("Expected bob to have written nothing until %s, got %v", since, records)
+	}
+
+	// another namespace has none of the records
+	if records := listAuditRecords(t, newNamespacedStore(client, "other"), storage.AuditFilter{}, 2); len(records) != 0 {
+		t.Errorf("Expected no records in another namespace, got %d records", len(records))
+	}
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// CacheStats counts the lookups made against the note and project cache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// DefaultCacheTTL is how long an entry is cached if no time to live is configured.
+const DefaultCacheTTL = 30 * time.Second
+
+// cache is a size-bounded, least recently used cache of projects and notes, keyed by name.  Entries expire
+// after a fixed time to live, which bounds how stale a read can be when another server instance changes an
+// entity.  A nil *cache caches nothing.
+//
+// A read that misses takes the cache's generation before reading the table, and puts what it read only if no
+// entry has been removed since.  Otherwise a read that raced a write could cache the version the write replaced
+// after the write had invalidated it.
+type cache struct {
+	size int
+	ttl  time.Duration
+
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	generation uint64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheEntry struct {
+	name    string
+	message proto.Message
+	version int64
+	expires time.Time
+}
+
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// get returns a copy of the cached message and its version, if present and unexpired.
+func (c *cache) get(name string) (proto.Message, int64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[name]
+	if !ok {
+		c.misses++
+		return nil, 0, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if c.ttl > 0 && time.Now().After(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, name)
+		c.misses++
+		return nil, 0, false
+	}
+
+	c.lru.MoveToFront(element)
+	c.hits++
+	return proto.Clone(entry.message), entry.version, true
+}
+
+// begin returns the generation to put the result of a read with, to be taken before the table is read.
+func (c *cache) begin() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// put caches a copy of the message read in the given generation, evicting the least recently used entry if the
+// cache is full.  The message is not cached if an entry has been removed since the read began, as it may be the
+// version that a concurrent write replaced.
+func (c *cache) put(name string, message proto.Message, version int64, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	entry := &cacheEntry{
+		name:    name,
+		message: proto.Clone(message),
+		version: version,
+		expires: time.Now().Add(c.ttl),
+	}
+
+	if element, ok := c.entries[name]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[name] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).name)
+		c.evictions++
+	}
+}
+
+// remove invalidates the cached entry, if any.
+func (c *cache) remove(name string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if element, ok := c.entries[name]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, name)
+	}
+}
+
+func (c *cache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// EnableCache puts a cache of at most size projects and notes, each kept for at most ttl, in front of GetProject
+// and GetNote.  A ttl of zero uses DefaultCacheTTL.
+func (db *DynamoDb) EnableCache(size int, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	db.cache = newCache(size, ttl)
+}
+
+// CacheStats returns the hit, miss and eviction counts of the cache, which are all zero if it is disabled.
+func (db *DynamoDb) CacheStats() CacheStats {
+	return db.cache.stats()
+}
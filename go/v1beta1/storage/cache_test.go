@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(2, 0)
+	c.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 1, 0)
+	c.put("projects/p2", &prpb.Project{Name: "projects/p2"}, 1, 0)
+
+	// p1 becomes the most recently used, so p2 is evicted
+	if _, _, ok := c.get("projects/p1"); !ok {
+		t.Fatal("Expected projects/p1 to be cached")
+	}
+	c.put("projects/p3", &prpb.Project{Name: "projects/p3"}, 1, 0)
+
+	if _, _, ok := c.get("projects/p2"); ok {
+		t.Error("Expected projects/p2 to be evicted")
+	}
+	if _, _, ok := c.get("projects/p1"); !ok {
+		t.Error("Expected projects/p1 to still be cached")
+	}
+
+	stats := c.stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("Got stats %+v, expected 2 hits, 1 miss and 1 eviction", stats)
+	}
+}
+
+func TestCacheReturnsCopies(t *testing.T) {
+	c := newCache(10, 0)
+	c.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 3, 0)
+
+	cached, version, _ := c.get("projects/p1")
+	cached.(*prpb.Project).Name = "modified"
+
+	cached, _, _ = c.get("projects/p1")
+	if cached.(*prpb.Project).Name != "projects/p1" || version != 3 {
+		t.Errorf("Cached entry was modified through a returned copy, got %v", cached)
+	}
+}
+
+func TestCacheExpiresAndInvalidates(t *testing.T) {
+	c := newCache(10, time.Millisecond)
+	c.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 1, 0)
+	time.Sleep(5 * time.Millisecond)
+	if _, _, ok := c.get("projects/p1"); ok {
+		t.Error("Expected entry to expire")
+	}
+
+	c = newCache(10, time.Hour)
+	c.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 1, 0)
+	c.remove("projects/p1")
+	if _, _, ok := c.get("projects/p1"); ok {
+		t.Error("Expected entry to be invalidated")
+	}
+
+	var disabled *cache
+	disabled.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 1, 0)
+	if _, _, ok := disabled.get("projects/p1"); ok {
+		t.Error("Expected a nil cache to cache nothing")
+	}
+}
+
+func TestCacheRefusesReadsThatRacedARemoval(t *testing.T) {
+	c := newCache(10, time.Hour)
+	c.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 1, c.begin())
+
+	// a read of version 1 begins, then a write of version 2 invalidates the entry before the read is put
+	generation := c.begin()
+	c.remove("projects/p1")
+	c.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 1, generation)
+	if _, _, ok := c.get("projects/p1"); ok {
+		t.Error("Expected a read that began before the entry was removed not to be cached")
+	}
+
+	c.put("projects/p1", &prpb.Project{Name: "projects/p1"}, 2, c.begin())
+	if _, version, ok := c.get("projects/p1"); !ok || version != 2 {
+		t.Errorf("Expected a read that began after the removal to be cached, got version %d, %v", version, ok)
+	}
+}
@@ -0,0 +1,58 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// stubClient serves a single project, counting the calls made to it.
+type stubClient struct {
+	dynamodbiface.DynamoDBAPI
+
+	createdTables []string
+	gets          int
+}
+
+func (c *stubClient) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	c.createdTables = append(c.createdTables, aws.StringValue(input.TableName))
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (c *stubClient) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	c.gets++
+	return &dynamodb.GetItemOutput{
+		Item: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String("projects/p1")},
+			storage.SortKeyName:      {S: aws.String(storage.ProjectSortKey)},
+			storage.DataKeyName:      {S: aws.String("projects/p1")},
+			storage.JsonKeyName:      {S: aws.String(`{"name":"projects/p1"}`)},
+		},
+	}, nil
+}
+
+func TestNewDynamoDbStoreWithClient(t *testing.T) {
+	client := &stubClient{}
+	db := storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+
+	if len(client.createdTables) != 1 || client.createdTables[0] != "test_table" {
+		t.Errorf("Expected the table to be created through the client, got %v", client.createdTables)
+	}
+
+	p, err := db.GetProject(context.Background(), "p1")
+	if err != nil {
+		t.Fatalf("GetProject failed, %s", err)
+	}
+	if p.Name != "projects/p1" || client.gets != 1 {
+		t.Errorf("Expected project to be read through the client, got %v after %d gets", p, client.gets)
+	}
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// EtagMetadataKey is the gRPC metadata key on which the current version of an entity is returned.
+	EtagMetadataKey = "etag"
+	// IfMatchMetadataKey is the gRPC metadata key on which clients send the version they expect to update.
+	IfMatchMetadataKey = "if-match"
+	// gatewayIfMatchMetadataKey is the key under which the REST gateway forwards the HTTP If-Match header.
+	gatewayIfMatchMetadataKey = "grpcgateway-if-match"
+)
+
+// formatEtag converts an item version into the opaque token handed to clients.
+func formatEtag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseEtag converts a token produced by formatEtag back into an item version.
+func parseEtag(etag string) (int64, error) {
+	etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+	if unquoted, err := strconv.Unquote(etag); err == nil {
+		etag = unquoted
+	}
+	return strconv.ParseInt(etag, 10, 64)
+}
+
+// expectedVersionFromContext returns the version the client expects to update, if it sent one.
+func expectedVersionFromContext(ctx context.Context) (int64, bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, false, nil
+	}
+
+	for _, key := range []string{IfMatchMetadataKey, gatewayIfMatchMetadataKey} {
+		if values := md.Get(key); len(values) > 0 && values[0] != "" {
+			version, err := parseEtag(values[0])
+			if err != nil {
+				return 0, false, status.Errorf(codes.InvalidArgument, "Invalid etag %q", values[0])
+			}
+			return version, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// etagSuppressedKey marks a context whose operations must not return an etag to the client.
+type etagSuppressedKey struct{}
+
+// withoutEtag returns a context for an operation nested in a larger one, such as reading an occurrence to find its
+// note, so that only the version of the entity the larger operation returns is sent to the client.  Headers are
+// appended to, so an etag set by the nested operation could not be replaced afterwards.
+func withoutEtag(ctx context.Context) context.Context {
+	return context.WithValue(ctx, etagSuppressedKey{}, true)
+}
+
+// setEtagHeader returns the version of the entity to the client.  It is a no-op outside of a gRPC call and in
+// operations nested with withoutEtag.
+func setEtagHeader(ctx context.Context, version int64) {
+	if suppressed, _ := ctx.Value(etagSuppressedKey{}).(bool); suppressed {
+		return
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(EtagMetadataKey, formatEtag(version)))
+}
+
+// currentVersion reads the stored item with the given keys and checks its version against any version the
+// client expects, returning the stored item.  The keys are given without the store's namespace.
+func (db *DynamoDb) currentVersion(ctx context.Context, pk, sk string) (*DataItem, error) {
+	result, err := db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(db.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PartitionKeyName: {
+				S: aws.String(db.key(pk)),
+			},
+			SortKeyName: {
+				S: aws.String(db.key(sk)),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		db.logError(ctx, err).Error("Error when reading current version")
+		return nil, status.Errorf(codes.Internal, "Failed to read %s from database", pk)
+	}
+
+	dataItem := DataItem{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &dataItem)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to unmarshal item")
+		return nil, status.Errorf(codes.Internal, "Failed to unmarshal %s", pk)
+	}
+
+	if dataItem.PartitionKey == "" {
+		return nil, status.Errorf(codes.NotFound, "%q does not exist", pk)
+	}
+
+	expected, ok, err := expectedVersionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok && expected != dataItem.Version {
+		return nil, status.Errorf(codes.Aborted, "%q has been modified, expected etag %s but found %s", pk, formatEtag(expected), formatEtag(dataItem.Version))
+	}
+
+	return &dataItem, nil
+}
+
+// versionCondition returns a condition expression, with its names and values, that only succeeds if the
+// stored item is still at the given version.  Items written before versioning was introduced have no version.
+func versionCondition(version int64) (*string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	names := map[string]*string{
+		"#PARTITION_KEY": aws.String(PartitionKeyName),
+		"#VERSION":       aws.String(VersionKeyName),
+	}
+
+	if version == 0 {
+		return aws.String("attribute_exists(#PARTITION_KEY) AND attribute_not_exists(#VERSION)"), names, nil
+	}
+
+	values := map[string]*dynamodb.AttributeValue{
+		":EXPECTED_VERSION": {
+			N: aws.String(strconv.FormatInt(version, 10)),
+		},
+	}
+
+	return aws.String("attribute_exists(#PARTITION_KEY) AND #VERSION = :EXPECTED_VERSION"), names, values
+}
+
+// VersionCondition returns the condition expression, with its names and values, that only succeeds if the
+// stored item is still at the given version, for writes made outside of the store.
+func VersionCondition(version int64) (*string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	return versionCondition(version)
+}
+
+// IsConditionalCheckFailure reports whether a write was rejected by its condition expression, either on its
+// own or as part of a transaction.
+func IsConditionalCheckFailure(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	switch awsErr.Code() {
+	case dynamodb.ErrCodeConditionalCheckFailedException:
+		return true
+	case dynamodb.ErrCodeTransactionCanceledException:
+		return strings.Contains(awsErr.Message(), "ConditionalCheckFailed")
+	default:
+		return false
+	}
+}
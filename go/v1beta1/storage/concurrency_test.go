@@ -0,0 +1,284 @@
+package storage_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// headerStream records the headers a method sets, standing in for the stream of a gRPC call.
+type headerStream struct {
+	header metadata.MD
+}
+
+func (s *headerStream) Method() string { return "/grafeas.v1beta1.GrafeasV1Beta1/Test" }
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *headerStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+
+func (s *headerStream) SetTrailer(md metadata.MD) error { return nil }
+
+// call returns the context of a gRPC call that sends the if-match header, if given, and records the headers set.
+func call(ifMatch string) (context.Context, *headerStream) {
+	ctx := context.Background()
+	if ifMatch != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(storage.IfMatchMetadataKey, ifMatch))
+	}
+	stream := &headerStream{}
+	return grpc.NewContextWithServerTransportStream(ctx, stream), stream
+}
+
+func TestWritesReturnEtags(t *testing.T) {
+	store := newNamespacedStore(memdb.New(), "")
+
+	ctx, stream := call("")
+	if _, err := store.CreateNote(ctx, "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	if etag := stream.header.Get(storage.EtagMetadataKey); len(etag) != 1 || etag[0] != `"1"` {
+		t.Errorf("Expected etag \"1\" once created, got %v", etag)
+	}
+
+	ctx, stream = call(`"1"`)
+	if _, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{ShortDescription: "updated"}, nil); err != nil {
+		t.Fatalf("UpdateNote failed, %s", err)
+	}
+	if etag := stream.header.Get(storage.EtagMetadataKey); len(etag) != 1 || etag[0] != `"2"` {
+		t.Errorf("Expected etag \"2\" once updated, got %v", etag)
+	}
+
+	ctx, stream = call("")
+	if _, err := store.GetNote(ctx, "p1", "n1"); err != nil {
+		t.Fatalf("GetNote failed, %s", err)
+	}
+	if etag := stream.header.Get(storage.EtagMetadataKey); len(etag) != 1 || etag[0] != `"2"` {
+		t.Errorf("Expected etag \"2\" when read, got %v", etag)
+	}
+}
+
+func TestNestedOperationsDoNotReturnEtags(t *testing.T) {
+	store := newNamespacedStore(memdb.New(), "")
+	o := newOccurrence(t, store)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+	for i := 0; i < 2; i++ {
+		if _, err := store.UpdateNote(context.Background(), "p1", "n1", &pb.Note{}, nil); err != nil {
+			t.Fatalf("UpdateNote failed, %s", err)
+		}
+	}
+
+	// the note is at version 3 and the occurrence read to find it at version 1
+	ctx, stream := call("")
+	if _, err := store.GetOccurrenceNote(ctx, "p1", oID); err != nil {
+		t.Fatalf("GetOccurrenceNote failed, %s", err)
+	}
+	if etag := stream.header.Get(storage.EtagMetadataKey); len(etag) != 1 || etag[0] != `"3"` {
+		t.Errorf("Expected only the note's etag \"3\", got %v", etag)
+	}
+
+	ctx, stream = call("")
+	notes := map[string]*pb.Note{"n3": {}, "n4": {}}
+	if created, _ := store.BatchCreateNotes(ctx, "p1", "user", notes); len(created) != 2 {
+		t.Fatalf("Expected 2 notes to be created, got %d", len(created))
+	}
+	if etag := stream.header.Get(storage.EtagMetadataKey); len(etag) != 0 {
+		t.Errorf("Expected no etag for a batch, got %v", etag)
+	}
+}
+
+func TestIfMatchMismatchIsAborted(t *testing.T) {
+	store := newNamespacedStore(memdb.New(), "")
+	o := newOccurrence(t, store)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+	if _, err := store.UpdateNote(context.Background(), "p1", "n1", &pb.Note{}, nil); err != nil {
+		t.Fatalf("UpdateNote failed, %s", err)
+	}
+
+	// the note is at version 2 and the occurrence at version 1
+	ctx, _ := call(`"1"`)
+	if _, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{}, nil); status.Code(err) != codes.Aborted {
+		t.Errorf("Expected UpdateNote of an outdated etag to be aborted, got %v", err)
+	}
+	if err := store.DeleteNote(ctx, "p1", "n1"); status.Code(err) != codes.Aborted {
+		t.Errorf("Expected DeleteNote of an outdated etag to be aborted, got %v", err)
+	}
+
+	ctx, _ = call(`W/"2"`)
+	if _, err := store.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n1"}, nil); status.Code(err) != codes.Aborted {
+		t.Errorf("Expected UpdateOccurrence of an outdated etag to be aborted, got %v", err)
+	}
+	if err := store.DeleteOccurrence(ctx, "p1", oID); status.Code(err) != codes.Aborted {
+		t.Errorf("Expected DeleteOccurrence of an outdated etag to be aborted, got %v", err)
+	}
+
+	ctx, _ = call("not-a-version")
+	if _, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{}, nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid etag to be rejected, got %v", err)
+	}
+
+	ctx, _ = call(`"1"`)
+	if err := store.DeleteOccurrence(ctx, "p1", oID); err != nil {
+		t.Errorf("Expected DeleteOccurrence of the current etag to succeed, got %v", err)
+	}
+}
+
+// interleaving calls write before the first transaction it is asked to make, as if another server wrote the
+// same rows between the read and the write of an update.
+type interleaving struct {
+	*memdb.DB
+	write func()
+	read  func()
+}
+
+func (c *interleaving) TransactWriteItemsWithContext(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if write := c.write; write != nil {
+		c.write = nil
+		write()
+	}
+	return c.DB.TransactWriteItemsWithContext(ctx, input, opts...)
+}
+
+// GetItemWithContext calls read once the first item it is asked for has been read, as if another request wrote
+// the item between the read and the caching of what was read.
+func (c *interleaving) GetItemWithContext(ctx context.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output, err := c.DB.GetItemWithContext(ctx, input, opts...)
+	if read := c.read; read != nil {
+		c.read = nil
+		read()
+	}
+	return output, err
+}
+
+// bumpVersion increments the version of a row, as a write by another server would, without writing a revision.
+func bumpVersion(client *memdb.DB, pk, sk string) error {
+	key := map[string]*dynamodb.AttributeValue{
+		storage.PartitionKeyName: {S: aws.String(pk)},
+		storage.SortKeyName:      {S: aws.String(sk)},
+	}
+	result, err := client.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test_table"), Key: key})
+	if err != nil {
+		return err
+	}
+	version, err := strconv.Atoi(aws.StringValue(result.Item[storage.VersionKeyName].N))
+	if err != nil {
+		return err
+	}
+	result.Item[storage.VersionKeyName] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version + 1))}
+	_, err = client.PutItem(&dynamodb.PutItemInput{TableName: aws.String("test_table"), Item: result.Item})
+	return err
+}
+
+func TestWritesAreConditionalOnVersion(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	o := newOccurrence(t, newNamespacedStore(client, ""))
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+
+	interleaved := &interleaving{DB: client}
+	store := newNamespacedStore(client, "")
+	store.DynamoDBAPI = interleaved
+
+	for _, write := range []struct {
+		name  string
+		pk    string
+		sk    string
+		write func() error
+	}{
+		{
+			name: "UpdateNote",
+			pk:   "projects/p1/notes/n1",
+			sk:   storage.NoteSortKey,
+			write: func() error {
+				_, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{}, nil)
+				return err
+			},
+		},
+		{
+			name:  "DeleteNote",
+			pk:    "projects/p1/notes/n1",
+			sk:    storage.NoteSortKey,
+			write: func() error { return store.DeleteNote(ctx, "p1", "n1") },
+		},
+		{
+			name: "UpdateOccurrence",
+			pk:   o.Name,
+			sk:   storage.OccurrenceSortKey,
+			write: func() error {
+				_, err := store.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n2"}, nil)
+				return err
+			},
+		},
+		{
+			name:  "DeleteOccurrence",
+			pk:    o.Name,
+			sk:    storage.OccurrenceSortKey,
+			write: func() error { return store.DeleteOccurrence(ctx, "p1", oID) },
+		},
+	} {
+		var interleavedErr error
+		pk, sk := write.pk, write.sk
+		interleaved.write = func() { interleavedErr = bumpVersion(client, pk, sk) }
+		if err := write.write(); status.Code(err) != codes.Aborted {
+			t.Errorf("Expected %s of a row written concurrently to be aborted, got %v", write.name, err)
+		}
+		if interleavedErr != nil {
+			t.Fatalf("Concurrent write before %s failed, %s", write.name, interleavedErr)
+		}
+	}
+
+	if _, err := store.GetNote(ctx, "p1", "n1"); err != nil {
+		t.Errorf("Expected the note to remain, got %v", err)
+	}
+	if occurrence, err := store.GetOccurrence(ctx, "p1", oID); err != nil || occurrence.NoteName != "projects/p1/notes/n1" {
+		t.Errorf("Expected the occurrence to remain on its note, got %v, %v", occurrence, err)
+	}
+}
+
+func TestCacheIsNotPopulatedByReadsThatRacedAWrite(t *testing.T) {
+	ctx := context.Background()
+	interleaved := &interleaving{DB: memdb.New()}
+	store := storage.NewDynamoDbStoreWithClient(interleaved, &config.DynamoDbConfig{
+		TableName: "test_table",
+		Cache:     &config.CacheConfig{Size: 10},
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	if _, err := store.CreateNote(ctx, "p1", "n1", "user", &pb.Note{ShortDescription: "first"}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+
+	// the read of version 1 completes, then version 2 is written and invalidates the cache before version 1 is
+	// cached
+	var updateErr error
+	interleaved.read = func() {
+		_, updateErr = store.UpdateNote(ctx, "p1", "n1", &pb.Note{ShortDescription: "second"}, nil)
+	}
+	if note, err := store.GetNote(ctx, "p1", "n1"); err != nil || note.ShortDescription != "first" {
+		t.Fatalf("Expected the racing read to return version 1, got %v, %v", note, err)
+	}
+	if updateErr != nil {
+		t.Fatalf("UpdateNote failed, %s", updateErr)
+	}
+
+	if note, err := store.GetNote(ctx, "p1", "n1"); err != nil || note.ShortDescription != "second" {
+		t.Errorf("Expected version 2 once the write completed, got %v, %v", note, err)
+	}
+	if note, err := store.GetNote(ctx, "p1", "n1"); err != nil || note.ShortDescription != "second" || store.CacheStats().Hits != 1 {
+		t.Errorf("Expected version 2 to be cached, got %v, %v, %+v", note, err, store.CacheStats())
+	}
+}
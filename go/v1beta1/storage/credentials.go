@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	grafeasConfig "github.com/grafeas/grafeas/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRoleSessionName names the sessions of assumed roles unless the configuration gives a name.
+const DefaultRoleSessionName = "grafeas-dynamodb"
+
+// credentialsExpiryWindow is how long before they expire that assumed role credentials are refreshed, so that
+// requests in flight are not signed with credentials about to lapse.
+const credentialsExpiryWindow = time.Minute
+
+// NewSession creates an AWS session from the aws section of the configuration, calling AWS with the HTTP client
+// that its http section describes.  Its credentials are the static keys, profile or default chain of the SDK,
+// exchanged for those of a role if role_arn is set.  Assumed role credentials are refreshed automatically
+// before they expire.
+func NewSession(config *config.DynamoDbConfig) (*session.Session, error) {
+	awsConfig := aws.Config{}
+
+	err := grafeasConfig.ConvertGenericConfigToSpecificType(config.AWS, &awsConfig)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to create AWS Config from configuration file, %s", err))
+	}
+
+	options := session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            awsConfig,
+	}
+
+	if config.AWS != nil {
+		if err := config.AWS.Validate(); err != nil {
+			return nil, err
+		}
+		options.Profile = config.AWS.Profile
+		if config.AWS.HTTP != nil {
+			options.Config.HTTPClient, err = newHTTPClient(config.AWS.HTTP)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if config.AWS.HTTP != nil && config.AWS.HTTP.CABundle != "" {
+			// takes precedence over AWS_CA_BUNDLE
+			bundle, err := os.Open(config.AWS.HTTP.CABundle)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to read CA bundle, %s", err))
+			}
+			defer bundle.Close()
+			options.CustomCABundle = bundle
+		}
+		if config.AWS.AccessKeyID != "" {
+			options.Config.Credentials = credentials.NewStaticCredentials(config.AWS.AccessKeyID, config.AWS.SecretAccessKey, config.AWS.SessionToken)
+		}
+	}
+
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to create AWS session, %s", err))
+	}
+
+	if config.AWS == nil || config.AWS.RoleArn == "" {
+		return sess, nil
+	}
+
+	// STS is reached at its own endpoint rather than the one configured for DynamoDB
+	stsClient := sts.New(sess, &aws.Config{Endpoint: aws.String(config.AWS.StsEndpoint)})
+
+	sessionName := config.AWS.RoleSessionName
+	if sessionName == "" {
+		sessionName = DefaultRoleSessionName
+	}
+
+	var creds *credentials.Credentials
+	if config.AWS.WebIdentityTokenFile != "" {
+		provider := stscreds.NewWebIdentityRoleProvider(stsClient, config.AWS.RoleArn, sessionName, config.AWS.WebIdentityTokenFile)
+		provider.ExpiryWindow = credentialsExpiryWindow
+		creds = credentials.NewCredentials(provider)
+	} else {
+		creds = stscreds.NewCredentialsWithClient(stsClient, config.AWS.RoleArn, func(provider *stscreds.AssumeRoleProvider) {
+			provider.RoleSessionName = sessionName
+			provider.ExpiryWindow = credentialsExpiryWindow
+			if config.AWS.ExternalID != "" {
+				provider.ExternalID = aws.String(config.AWS.ExternalID)
+			}
+		})
+	}
+
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
+
+// logCredentialSources resolves the session's credentials and logs where they came from, so that a server
+// running as the wrong identity is noticed at startup rather than on its first denied request.  Secrets are
+// never logged, and neither is the access key ID.
+func logCredentialSources(logger *logrus.Logger, sess *session.Session, awsConfig *config.AwsConfig) {
+	fields := logrus.Fields{"region": aws.StringValue(sess.Config.Region)}
+	if awsConfig != nil {
+		for field, value := range map[string]string{
+			"profile":                 awsConfig.Profile,
+			"role_arn":                awsConfig.RoleArn,
+			"role_session_name":       awsConfig.RoleSessionName,
+			"web_identity_token_file": awsConfig.WebIdentityTokenFile,
+		} {
+			if value != "" {
+				fields[field] = value
+			}
+		}
+	}
+
+	value, err := sess.Config.Credentials.Get()
+	if err != nil {
+		logger.WithFields(fields).WithError(err).Warn("Unable to resolve AWS credentials")
+		return
+	}
+	fields["credentials_provider"] = value.ProviderName
+	logger.WithFields(fields).Info("Resolved AWS credentials")
+}
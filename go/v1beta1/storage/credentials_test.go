@@ -0,0 +1,133 @@
+package storage_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+)
+
+// newSTS serves AssumeRole and AssumeRoleWithWebIdentity, recording the requests made to it.
+func newSTS(t *testing.T) (*httptest.Server, *[]url.Values) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Unable to parse STS request, %s", err)
+		}
+		requests = append(requests, r.PostForm)
+		action := r.PostForm.Get("Action")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%[1]sResult><Credentials>
+<AccessKeyId>ASSUMED</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>2100-01-01T00:00:00Z</Expiration></Credentials></%[1]sResult></%[1]sResponse>`, action)
+	}))
+	return server, &requests
+}
+
+func newCredentialsConfig(awsConfig *config.AwsConfig) *config.DynamoDbConfig {
+	awsConfig.Region = aws.String("eu-west-2")
+	return &config.DynamoDbConfig{TableName: "test_table", AWS: awsConfig}
+}
+
+func TestSessionUsesStaticCredentials(t *testing.T) {
+	sess, err := storage.NewSession(newCredentialsConfig(&config.AwsConfig{
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := sess.Config.Credentials.Get()
+	if err != nil || value.ProviderName != credentials.StaticProviderName || value.AccessKeyID != "AKID" {
+		t.Errorf("Expected the static keys, got %+v, %v", value, err)
+	}
+}
+
+func TestSessionAssumesRole(t *testing.T) {
+	server, requests := newSTS(t)
+	defer server.Close()
+
+	sess, err := storage.NewSession(newCredentialsConfig(&config.AwsConfig{
+		Endpoint:        aws.String("http://localhost:8000"),
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		RoleArn:         "arn:aws:iam::123456789012:role/grafeas",
+		ExternalID:      "shared-secret",
+		StsEndpoint:     server.URL,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := sess.Config.Credentials.Get()
+	if err != nil || value.ProviderName != stscreds.ProviderName || value.AccessKeyID != "ASSUMED" {
+		t.Fatalf("Expected the credentials of the role, got %+v, %v", value, err)
+	}
+	if len(*requests) != 1 {
+		t.Fatalf("Expected the role to be assumed once, got %v", *requests)
+	}
+	request := (*requests)[0]
+	if request.Get("Action") != "AssumeRole" || request.Get("RoleArn") != "arn:aws:iam::123456789012:role/grafeas" ||
+		request.Get("ExternalId") != "shared-secret" || request.Get("RoleSessionName") != storage.DefaultRoleSessionName {
+		t.Errorf("Unexpected AssumeRole request, %v", request)
+	}
+}
+
+func TestSessionAssumesRoleWithWebIdentity(t *testing.T) {
+	server, requests := newSTS(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("oidc-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := storage.NewSession(newCredentialsConfig(&config.AwsConfig{
+		RoleArn:              "arn:aws:iam::123456789012:role/grafeas",
+		RoleSessionName:      "ci",
+		WebIdentityTokenFile: tokenFile,
+		StsEndpoint:          server.URL,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := sess.Config.Credentials.Get()
+	if err != nil || value.ProviderName != stscreds.WebIdentityProviderName || value.AccessKeyID != "ASSUMED" {
+		t.Fatalf("Expected the credentials of the role, got %+v, %v", value, err)
+	}
+	request := (*requests)[0]
+	if request.Get("Action") != "AssumeRoleWithWebIdentity" || request.Get("WebIdentityToken") != "oidc-token" ||
+		request.Get("RoleSessionName") != "ci" {
+		t.Errorf("Unexpected AssumeRoleWithWebIdentity request, %v", request)
+	}
+}
+
+func TestNewSessionRejectsInvalidCredentials(t *testing.T) {
+	for _, c := range []*config.AwsConfig{
+		{AccessKeyID: "AKID"},
+		{AccessKeyID: "AKID", SecretAccessKey: "secret", Profile: "dev"},
+		{WebIdentityTokenFile: "/var/run/token"},
+		{ExternalID: "shared-secret"},
+		{RoleArn: "arn:aws:iam::123456789012:role/grafeas", WebIdentityTokenFile: "/var/run/token", ExternalID: "shared-secret"},
+	} {
+		if _, err := storage.NewSession(newCredentialsConfig(c)); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"golang.org/x/net/context"
+)
+
+// QueryRouter serves the eventually consistent GSI queries made by the list methods, so that they can be
+// offloaded to a DynamoDB Accelerator (DAX) cluster.  Both dynamodbiface.DynamoDBAPI and the DAX client
+// implement it.  Strongly consistent reads always go to DynamoDB, as DAX does not cache them.
+type QueryRouter interface {
+	QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error)
+}
+
+// eventuallyConsistentQuery runs a query that does not need to read its own writes, through DAX if configured.
+func (db *DynamoDb) eventuallyConsistentQuery(ctx context.Context, input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	if db.EventualReads != nil && !aws.BoolValue(input.ConsistentRead) {
+		return db.EventualReads.QueryWithContext(ctx, input)
+	}
+	return db.QueryWithContext(ctx, input)
+}
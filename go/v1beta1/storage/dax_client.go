@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"github.com/aws/aws-dax-go/dax"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// newDaxClient connects to the DAX cluster at endpoint, e.g. "mycluster.abc123.dax-clusters.eu-west-1.amazonaws.com:8111".
+func newDaxClient(sess *session.Session, endpoint string) (QueryRouter, error) {
+	daxConfig := dax.DefaultConfig()
+	daxConfig.HostPorts = []string{endpoint}
+	daxConfig.Region = aws.StringValue(sess.Config.Region)
+	daxConfig.Credentials = sess.Config.Credentials
+	return dax.New(daxConfig)
+}
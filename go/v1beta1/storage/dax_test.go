@@ -0,0 +1,51 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+type recordingRouter struct {
+	queries []*dynamodb.QueryInput
+}
+
+func (r *recordingRouter) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	r.queries = append(r.queries, input)
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func TestListQueriesUseEventualReads(t *testing.T) {
+	router := &recordingRouter{}
+	db := &storage.DynamoDb{
+		TableName:     "test_table",
+		EventualReads: router,
+	}
+
+	ctx := context.Background()
+	if _, _, err := db.ListProjects(ctx, "", 10, ""); err != nil {
+		t.Fatalf("ListProjects failed, %s", err)
+	}
+	if _, _, err := db.ListNotes(ctx, "p1", "", "", 10); err != nil {
+		t.Fatalf("ListNotes failed, %s", err)
+	}
+	if _, _, err := db.ListOccurrences(ctx, "p1", "", "", 10); err != nil {
+		t.Fatalf("ListOccurrences failed, %s", err)
+	}
+	if _, _, err := db.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); err != nil {
+		t.Fatalf("ListNoteOccurrences failed, %s", err)
+	}
+
+	if len(router.queries) != 4 {
+		t.Fatalf("Got %d queries through the router, expected 4", len(router.queries))
+	}
+	for _, query := range router.queries {
+		if aws.StringValue(query.IndexName) != storage.GlobalSecondaryIndex1 {
+			t.Errorf("Expected only GSI queries to be routed, got index %q", aws.StringValue(query.IndexName))
+		}
+	}
+}
@@ -4,13 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
@@ -21,6 +23,9 @@ import (
 	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
 	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
 	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/encryption"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	fieldmaskpb "google.golang.org/genproto/protobuf/field_mask"
 	"google.golang.org/grpc/codes"
@@ -28,8 +33,31 @@ import (
 )
 
 type DynamoDb struct {
-	*dynamodb.DynamoDB
+	dynamodbiface.DynamoDBAPI
 	TableName string
+	// Namespace prefixes the keys of every row the store reads and writes, so that several deployments can
+	// share a table without seeing each other's rows.  Empty for none.
+	Namespace   string
+	KeyProvider encryption.KeyProvider
+	// EndUserID identifies the caller for writes where Grafeas does not supply a user ID.  If nil, the common
+	// name of the caller's TLS client certificate is used.
+	EndUserID func(ctx context.Context) string
+	// RevisionRetention is how long revisions of notes and occurrences are kept, or zero to keep them forever.
+	RevisionRetention time.Duration
+	// OccurrenceHooks write rows along with the occurrences that are created, updated or deleted, and are
+	// notified once those writes have committed.
+	OccurrenceHooks []OccurrenceHook
+	// EventualReads serves the eventually consistent queries of the list methods.  If nil, they go to DynamoDB.
+	EventualReads QueryRouter
+	// Logger records the store's errors and warnings.  If nil, the standard logrus logger is used.
+	Logger *logrus.Logger
+	// LogPayloads includes stored payloads in log entries, rather than just their size.
+	LogPayloads bool
+	// SlimLinks leaves the occurrence out of the rows linking occurrences to their notes, which then hold only
+	// its keys, version and audit attributes.  ListNoteOccurrences reads the occurrences from their own rows.
+	SlimLinks bool
+
+	cache *cache
 }
 
 func DynamodbStorageTypeProvider(storageType string, storageConfig *grafeasConfig.StorageConfiguration) (*storage.Storage, error) {
@@ -37,14 +65,12 @@ func DynamodbStorageTypeProvider(storageType string, storageConfig *grafeasConfi
 		return nil, errors.New(fmt.Sprintf("Unknown storage type %s, must be 'dynamodb'", storageType))
 	}
 
-	var storeConfig config.DynamoDbConfig
-
-	err := grafeasConfig.ConvertGenericConfigToSpecificType(storageConfig, &storeConfig)
+	storeConfig, err := ParseConfig(storageConfig)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Unable to create DynamoDbConfig, %s", err))
+		return nil, err
 	}
 
-	s := NewDynamoDbStore(&storeConfig)
+	s := NewDynamoDbStore(storeConfig)
 	storage := &storage.Storage{
 		Ps: s,
 		Gs: s,
@@ -53,35 +79,134 @@ func DynamodbStorageTypeProvider(storageType string, storageConfig *grafeasConfi
 	return storage, nil
 }
 
-func NewDynamoDbStore(config *config.DynamoDbConfig) *DynamoDb {
-	awsConfig := aws.Config{}
+// ParseConfig converts the generic Grafeas storage configuration into a DynamoDbConfig, overrides it with the
+// GRAFEAS_DYNAMODB_* environment variables and validates the result.
+func ParseConfig(storageConfig *grafeasConfig.StorageConfiguration) (*config.DynamoDbConfig, error) {
+	var storeConfig config.DynamoDbConfig
+
+	err := grafeasConfig.ConvertGenericConfigToSpecificType(storageConfig, &storeConfig)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to create DynamoDbConfig, %s", err))
+	}
+
+	err = storeConfig.ApplyEnvironment(os.Environ())
+	if err != nil {
+		return nil, err
+	}
 
-	err := grafeasConfig.ConvertGenericConfigToSpecificType(config.AWS, &awsConfig)
+	err = storeConfig.Validate()
 	if err != nil {
-		log.Panicf("Unable to create AWS Config from configuration file, %s", err)
+		return nil, err
+	}
+
+	return &storeConfig, nil
+}
+
+// NewDynamoDbStore creates a store that connects to DynamoDB as described by the configuration, creating the
+// table if it does not exist.
+func NewDynamoDbStore(config *config.DynamoDbConfig) *DynamoDb {
+	return NewDynamoDbStoreWithClient(NewDynamoDbClient(config), config)
+}
+
+// NewDynamoDbClient creates the DynamoDB client described by the aws section of the configuration, logging the
+// source of its credentials.  An endpoint of "memory://" selects an empty, in-memory emulation of DynamoDB.
+func NewDynamoDbClient(config *config.DynamoDbConfig) dynamodbiface.DynamoDBAPI {
+	if config.AWS != nil && aws.StringValue(config.AWS.Endpoint) == memdb.Endpoint {
+		return memdb.New()
 	}
 
-	options := &session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Config:            awsConfig,
+	logger, err := NewLogger(config.Logging)
+	if err != nil {
+		log.Panicf("Unable to create logger, %s", err)
 	}
 
-	sess := session.Must(session.NewSessionWithOptions(*options))
+	sess, err := NewSession(config)
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+	logCredentialSources(logger, sess, config.AWS)
 
 	dynamoDb := dynamodb.New(sess)
 	if dynamoDb == nil {
-		log.Panic("Could not create DynamoDB session")
+		logger.Panic("Could not create DynamoDB session")
+	}
+
+	return dynamoDb
+}
+
+// NewDynamoDbStoreWithClient creates a store that makes its DynamoDB calls through client, which may be an
+// instrumented, fault-injecting or in-memory implementation.  Other AWS services, such as KMS, are reached
+// through a session built from the configuration.
+func NewDynamoDbStoreWithClient(dynamoDb dynamodbiface.DynamoDBAPI, config *config.DynamoDbConfig) *DynamoDb {
+	logger, err := NewLogger(config.Logging)
+	if err != nil {
+		log.Panicf("Unable to create logger, %s", err)
+	}
+
+	if !validNamespace(config.Namespace) {
+		logger.Panicf("Invalid namespace %q, must not contain %q or %q", config.Namespace, NamespaceSeparator, PaginationString)
+	}
+
+	sess, err := NewSession(config)
+	if err != nil {
+		logger.Panic(err.Error())
+	}
+
+	keyProvider, err := newKeyProvider(sess, config.Encryption)
+	if err != nil {
+		logger.Panicf("Unable to create encryption provider, %s", err)
+	}
+
+	var revisionRetention time.Duration
+	if config.Revisions != nil && config.Revisions.Retention != "" {
+		revisionRetention, err = time.ParseDuration(config.Revisions.Retention)
+		if err != nil {
+			logger.Panicf("Unable to parse revision retention, %s", err)
+		}
 	}
 
 	err = createDynamoDbTables(dynamoDb, config)
 	if err != nil {
-		log.Panic(err.Error())
+		logger.Panic(err.Error())
+	}
+
+	if revisionRetention > 0 {
+		err = enableRevisionExpiry(dynamoDb, config.TableName)
+		if err != nil {
+			logger.Panicf("Unable to enable expiry of revisions, %s", err)
+		}
 	}
 
-	return &DynamoDb{
-		DynamoDB:  dynamoDb,
-		TableName: config.TableName,
+	db := &DynamoDb{
+		DynamoDBAPI:       dynamoDb,
+		TableName:         config.TableName,
+		Namespace:         config.Namespace,
+		KeyProvider:       keyProvider,
+		RevisionRetention: revisionRetention,
+		Logger:            logger,
+		LogPayloads:       config.Logging != nil && config.Logging.RedactPayloads != nil && !*config.Logging.RedactPayloads,
+		SlimLinks:         config.OccurrenceLinks == OccurrenceLinksSlim,
 	}
+
+	if config.Dax != nil && config.Dax.Endpoint != "" {
+		db.EventualReads, err = newDaxClient(sess, config.Dax.Endpoint)
+		if err != nil {
+			logger.Panicf("Unable to create DAX client, %s", err)
+		}
+	}
+
+	if config.Cache != nil && config.Cache.Size > 0 {
+		var ttl time.Duration
+		if config.Cache.TTL != "" {
+			ttl, err = time.ParseDuration(config.Cache.TTL)
+			if err != nil {
+				logger.Panicf("Unable to parse cache ttl, %s", err)
+			}
+		}
+		db.EnableCache(config.Cache.Size, ttl)
+	}
+
+	return db
 }
 
 type DataItem struct {
@@ -89,7 +214,14 @@ type DataItem struct {
 	SortKey      string
 	Data         string
 	NoteName     string
-	Json         string
+	Json         string `dynamodbav:",omitempty"`
+	KeyId        string `dynamodbav:",omitempty"`
+	Version      int64  `dynamodbav:",omitempty"`
+	CreatedBy    string `dynamodbav:",omitempty"`
+	CreatedAt    string `dynamodbav:",omitempty"`
+	UpdatedBy    string `dynamodbav:",omitempty"`
+	UpdatedAt    string `dynamodbav:",omitempty"`
+	EncryptedKey string `dynamodbav:",omitempty"`
 }
 
 const (
@@ -99,15 +231,33 @@ const (
 	SortKeyName           = "SortKey"
 	DataKeyName           = "Data"
 	JsonKeyName           = "Json"
+	KeyIdKeyName          = "KeyId"
+	EncryptedKeyKeyName   = "EncryptedKey"
+	VersionKeyName        = "Version"
+	CreatedByKeyName      = "CreatedBy"
+	CreatedAtKeyName      = "CreatedAt"
+	UpdatedByKeyName      = "UpdatedBy"
+	UpdatedAtKeyName      = "UpdatedAt"
+	ExpiresAtKeyName      = "ExpiresAt"
 	PaginationString      = "&"
 
 	// constants relating to table contents
 	projectSK    = "PROJECT"
 	occurrenceSK = "OCCURRENCE"
 	noteSK       = "NOTE"
+
+	// ProjectSortKey, NoteSortKey and OccurrenceSortKey identify the rows holding projects, notes and
+	// occurrences, as opposed to auxiliary rows such as occurrence notes and revisions
+	ProjectSortKey    = projectSK
+	NoteSortKey       = noteSK
+	OccurrenceSortKey = occurrenceSK
+
+	// WebhookKeyPrefix prefixes the partition keys of webhook delivery rows, which are sealed like the store's
+	// own rows
+	WebhookKeyPrefix = "WEBHOOK#"
 )
 
-func createDynamoDbTables(dynamoDb *dynamodb.DynamoDB, config *config.DynamoDbConfig) error {
+func createDynamoDbTables(dynamoDb dynamodbiface.DynamoDBAPI, config *config.DynamoDbConfig) error {
 	input := &dynamodb.CreateTableInput{
 		AttributeDefinitions: []*dynamodb.AttributeDefinition{
 			{
@@ -156,10 +306,18 @@ func createDynamoDbTables(dynamoDb *dynamodb.DynamoDB, config *config.DynamoDbCo
 			},
 		},
 		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
-		TableName:   aws.String(config.TableName),
+		StreamSpecification: &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(dynamodb.StreamViewTypeNewAndOldImages),
+		},
+		TableName: aws.String(config.TableName),
 	}
 	_, err := dynamoDb.CreateTable(input)
 	if err != nil {
+		// the table is shared by the deployments of other namespaces, or was created by a previous run
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceInUseException {
+			return nil
+		}
 		return err
 	}
 	return nil
@@ -167,25 +325,35 @@ func createDynamoDbTables(dynamoDb *dynamodb.DynamoDB, config *config.DynamoDbCo
 
 // CreateProject creates the specified project in the storage.
 func (db *DynamoDb) CreateProject(ctx context.Context, pID string, p *prpb.Project) (*prpb.Project, error) {
+	ctx, span := db.startOperation(ctx, "CreateProject", ProjectAttribute.String(pID))
+	defer span.End()
+
 	m := jsonpb.Marshaler{}
 	jsonObject, err := m.MarshalToString(p)
 	if err != nil {
-		log.Println("Unable to marshal project into json", err)
+		db.logError(ctx, err).Error("Unable to marshal project into json")
 		return nil, status.Error(codes.Internal, "Unable to marshal project into json")
 	}
 
 	// use Global Primary Index for find by ID
 	// use GSI for find all by type (PROJECT), sorted by name (Data)
 	dataItem := DataItem{
-		PartitionKey: name.FormatProject(pID),
-		SortKey:      projectSK,
-		Data:         name.FormatProject(pID),
-		Json:         jsonObject,
+		PartitionKey: db.key(name.FormatProject(pID)),
+		SortKey:      db.key(projectSK),
+		Data:         db.key(name.FormatProject(pID)),
+		Version:      1,
+	}
+	stampCreated(&dataItem, db.userOrCaller(ctx, ""), time.Now())
+
+	err = db.sealPayload(&dataItem, jsonObject)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to encrypt project")
+		return nil, status.Error(codes.Internal, "Failed to encrypt project")
 	}
 
 	av, err := dynamodbattribute.MarshalMap(dataItem)
 	if err != nil {
-		log.Println("Failed to marshal project into AttributeValues", err)
+		db.logError(ctx, err).Error("Failed to marshal project into AttributeValues")
 		return nil, status.Error(codes.Internal, "Failed to marshal project into AttributeValues")
 	}
 
@@ -195,62 +363,83 @@ func (db *DynamoDb) CreateProject(ctx context.Context, pID string, p *prpb.Proje
 		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s) AND attribute_not_exists(%s)", PartitionKeyName, SortKeyName)),
 	}
 
-	_, err = db.PutItem(input)
+	_, err = db.PutItemWithContext(ctx, input)
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
 			return nil, status.Errorf(codes.AlreadyExists, "Project with name %q already exists", pID)
 		} else {
-			log.Println("Failed to insert Project in database", err)
+			db.logError(ctx, err).Error("Failed to insert Project in database")
 			return nil, status.Error(codes.Internal, "Failed to insert Project in database")
 		}
 	}
 
+	setEtagHeader(ctx, dataItem.Version)
 	return p, nil
 }
 
 // GetProject gets the specified project from the storage.
 func (db *DynamoDb) GetProject(ctx context.Context, pID string) (*prpb.Project, error) {
+	ctx, span := db.startOperation(ctx, "GetProject", ProjectAttribute.String(pID))
+	defer span.End()
 
-	result, err := db.GetItem(&dynamodb.GetItemInput{
+	pName := name.FormatProject(pID)
+	if cached, version, ok := db.cache.get(pName); ok {
+		setEtagHeader(ctx, version)
+		return cached.(*prpb.Project), nil
+	}
+	generation := db.cache.begin()
+
+	result, err := db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(db.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(name.FormatProject(pID)),
+				S: aws.String(db.key(pName)),
 			},
 			SortKeyName: {
-				S: aws.String(projectSK),
+				S: aws.String(db.key(projectSK)),
 			},
 		},
 		ConsistentRead: aws.Bool(true),
 	})
 
 	if err != nil {
-		log.Printf("Error when seeking project %s, %s", pID, err)
+		db.logError(ctx, err).Error("Error when seeking project")
 		return nil, nil
 	}
 
 	dataItem := DataItem{}
 	err = dynamodbattribute.UnmarshalMap(result.Item, &dataItem)
 	if err != nil {
-		log.Panicf("Failed to unmarshal item, %v", err)
+		db.logError(ctx, err).Panic("Failed to unmarshal item")
 	}
 
 	if dataItem.PartitionKey == "" {
 		return nil, status.Errorf(codes.NotFound, "Project with name %q does not exist", pID)
 	}
 
+	jsonObject, err := db.openPayload(&dataItem)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to decrypt item")
+		return nil, status.Error(codes.Internal, "Failed to decrypt item")
+	}
+
 	var project prpb.Project
-	err = jsonpb.Unmarshal(strings.NewReader(dataItem.Json), &project)
+	err = jsonpb.Unmarshal(strings.NewReader(jsonObject), &project)
 	if err != nil {
-		log.Panicf("Failed to unmarshal json, %v", err)
+		db.logPayload(db.logError(ctx, err), jsonObject).Panic("Failed to unmarshal json")
 	}
 
+	db.cache.put(pName, &project, dataItem.Version, generation)
+	setEtagHeader(ctx, dataItem.Version)
 	return &project, nil
 
 }
 
 // ListProjects returns projects in the storage.
 func (db *DynamoDb) ListProjects(ctx context.Context, filter string, pageSize int, pageToken string) ([]*prpb.Project, string, error) {
+	ctx, span := db.startOperation(ctx, "ListProjects")
+	defer span.End()
+
 	var projects []*prpb.Project
 
 	queryInput := dynamodb.QueryInput{
@@ -261,7 +450,7 @@ func (db *DynamoDb) ListProjects(ctx context.Context, filter string, pageSize in
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":PROJECT": {
-				S: aws.String(projectSK),
+				S: aws.String(db.key(projectSK)),
 			},
 		},
 		KeyConditionExpression: aws.String("#PARTITION_KEY=:PROJECT"),
@@ -271,27 +460,27 @@ func (db *DynamoDb) ListProjects(ctx context.Context, filter string, pageSize in
 	if pageToken != "" {
 		tokenArray := strings.Split(pageToken, PaginationString)
 		if len(tokenArray) != 3 {
-			log.Print("Error when trying to parse page token")
+			db.logger(ctx).WithField("page_token", pageToken).Warn("Error when trying to parse page token")
 			return projects, "", nil
 		}
 
 		queryInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(tokenArray[0]),
+				S: aws.String(db.key(tokenArray[0])),
 			},
 			SortKeyName: {
-				S: aws.String(tokenArray[1]),
+				S: aws.String(db.key(tokenArray[1])),
 			},
 			DataKeyName: {
-				S: aws.String(tokenArray[2]),
+				S: aws.String(db.key(tokenArray[2])),
 			},
 		}
 	}
 
-	result, err := db.Query(&queryInput)
+	result, err := db.eventuallyConsistentQuery(ctx, &queryInput)
 
 	if err != nil {
-		log.Printf("Error when listing projects:\n%s", err)
+		db.logError(ctx, err).Error("Error when listing projects")
 		return projects, "", nil
 	}
 
@@ -299,13 +488,19 @@ func (db *DynamoDb) ListProjects(ctx context.Context, filter string, pageSize in
 		dataItem := DataItem{}
 		err = dynamodbattribute.UnmarshalMap(item, &dataItem)
 		if err != nil {
-			log.Panicf("Failed to unmarshal item, %v", err)
+			db.logError(ctx, err).Panic("Failed to unmarshal item")
+		}
+
+		jsonObject, err := db.openPayload(&dataItem)
+		if err != nil {
+			db.logError(ctx, err).Error("Failed to decrypt item")
+			return nil, "", status.Error(codes.Internal, "Failed to decrypt item")
 		}
 
 		var project prpb.Project
-		err = jsonpb.Unmarshal(strings.NewReader(dataItem.Json), &project)
+		err = jsonpb.Unmarshal(strings.NewReader(jsonObject), &project)
 		if err != nil {
-			log.Panicf("Failed to unmarshal json, %v", err)
+			db.logPayload(db.logError(ctx, err), jsonObject).Panic("Failed to unmarshal json")
 		}
 		projects = append(projects, &project)
 	}
@@ -316,9 +511,9 @@ func (db *DynamoDb) ListProjects(ctx context.Context, filter string, pageSize in
 		sk := *result.LastEvaluatedKey[SortKeyName].S
 		dk := *result.LastEvaluatedKey[DataKeyName].S
 		if pk == "" || sk == "" || dk == "" {
-			log.Panicf("Unable to unmarshal LastEvaluatedKey")
+			db.logger(ctx).Panic("Unable to unmarshal LastEvaluatedKey")
 		} else {
-			token = fmt.Sprintf("%s%s%s%s%s", pk, PaginationString, sk, PaginationString, dk)
+			token = fmt.Sprintf("%s%s%s%s%s", db.unkey(pk), PaginationString, db.unkey(sk), PaginationString, db.unkey(dk))
 		}
 	}
 
@@ -327,20 +522,24 @@ func (db *DynamoDb) ListProjects(ctx context.Context, filter string, pageSize in
 
 // DeleteProject deletes the specified project from the storage.
 func (db *DynamoDb) DeleteProject(ctx context.Context, pID string) error {
+	ctx, span := db.startOperation(ctx, "DeleteProject", ProjectAttribute.String(pID))
+	defer span.End()
+
 	input := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(name.FormatProject(pID)),
+				S: aws.String(db.key(name.FormatProject(pID))),
 			},
 			SortKeyName: {
-				S: aws.String(projectSK),
+				S: aws.String(db.key(projectSK)),
 			},
 		},
 		TableName:           aws.String(db.TableName),
 		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) AND attribute_exists(%s)", PartitionKeyName, SortKeyName)),
 	}
 
-	_, err := db.DeleteItem(input)
+	_, err := db.DeleteItemWithContext(ctx, input)
+	db.cache.remove(name.FormatProject(pID))
 	if err != nil {
 		return err
 	}
@@ -350,47 +549,60 @@ func (db *DynamoDb) DeleteProject(ctx context.Context, pID string) error {
 
 // GetOccurrence gets the specified occurrence from storage.
 func (db *DynamoDb) GetOccurrence(ctx context.Context, projectId, occId string) (*pb.Occurrence, error) {
+	ctx, span := db.startOperation(ctx, "GetOccurrence", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatOccurrence(projectId, occId)))
+	defer span.End()
+
 	oName := name.FormatOccurrence(projectId, occId)
-	result, err := db.GetItem(&dynamodb.GetItemInput{
+	result, err := db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(db.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(oName),
+				S: aws.String(db.key(oName)),
 			},
 			SortKeyName: {
-				S: aws.String(occurrenceSK),
+				S: aws.String(db.key(occurrenceSK)),
 			},
 		},
 		ConsistentRead: aws.Bool(true),
 	})
 
 	if err != nil {
-		log.Printf("Error when seeking occurrence %s, %s", oName, err)
+		db.logError(ctx, err).Error("Error when seeking occurrence")
 		return nil, nil
 	}
 
 	dataItem := DataItem{}
 	err = dynamodbattribute.UnmarshalMap(result.Item, &dataItem)
 	if err != nil {
-		log.Panicf("Failed to unmarshal item, %v", err)
+		db.logError(ctx, err).Panic("Failed to unmarshal item")
 	}
 
 	if dataItem.PartitionKey == "" {
 		return nil, status.Errorf(codes.NotFound, "Occurrence with name %s does not exist", oName)
 	}
 
+	jsonObject, err := db.openPayload(&dataItem)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to decrypt item")
+		return nil, status.Error(codes.Internal, "Failed to decrypt item")
+	}
+
 	var occurrence pb.Occurrence
-	err = jsonpb.Unmarshal(strings.NewReader(dataItem.Json), &occurrence)
+	err = jsonpb.Unmarshal(strings.NewReader(jsonObject), &occurrence)
 	if err != nil {
-		log.Panicf("Failed to unmarshal json, %v\n%s", err, dataItem.Json)
+		db.logPayload(db.logError(ctx, err), jsonObject).Panic("Failed to unmarshal json")
 	}
 
+	setEtagHeader(ctx, dataItem.Version)
 	return &occurrence, nil
 
 }
 
 // ListOccurrences lists occurrences for the specified project from storage.
 func (db *DynamoDb) ListOccurrences(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	ctx, span := db.startOperation(ctx, "ListOccurrences", ProjectAttribute.String(projectId))
+	defer span.End()
+
 	var occurrences []*pb.Occurrence
 
 	queryInput := dynamodb.QueryInput{
@@ -402,10 +614,10 @@ func (db *DynamoDb) ListOccurrences(ctx context.Context, projectId, filter, page
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":OCCURRENCE": {
-				S: aws.String(occurrenceSK),
+				S: aws.String(db.key(occurrenceSK)),
 			},
 			":PROJECT": {
-				S: aws.String(projectId),
+				S: aws.String(db.key(projectId)),
 			},
 		},
 		KeyConditionExpression: aws.String("#PARTITION_KEY=:OCCURRENCE AND #DATA=:PROJECT"),
@@ -415,27 +627,27 @@ func (db *DynamoDb) ListOccurrences(ctx context.Context, projectId, filter, page
 	if pageToken != "" {
 		tokenArray := strings.Split(pageToken, PaginationString)
 		if len(tokenArray) != 3 {
-			log.Print("Error when trying to parse page token")
+			db.logger(ctx).WithField("page_token", pageToken).Warn("Error when trying to parse page token")
 			return occurrences, "", nil
 		}
 
 		queryInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(tokenArray[0]),
+				S: aws.String(db.key(tokenArray[0])),
 			},
 			SortKeyName: {
-				S: aws.String(tokenArray[1]),
+				S: aws.String(db.key(tokenArray[1])),
 			},
 			DataKeyName: {
-				S: aws.String(tokenArray[2]),
+				S: aws.String(db.key(tokenArray[2])),
 			},
 		}
 	}
 
-	result, err := db.Query(&queryInput)
+	result, err := db.eventuallyConsistentQuery(ctx, &queryInput)
 
 	if err != nil {
-		log.Printf("Error when listing occurrences:\n%s", err)
+		db.logError(ctx, err).Error("Error when listing occurrences")
 		return occurrences, "", nil
 	}
 
@@ -443,13 +655,19 @@ func (db *DynamoDb) ListOccurrences(ctx context.Context, projectId, filter, page
 		dataItem := DataItem{}
 		err = dynamodbattribute.UnmarshalMap(item, &dataItem)
 		if err != nil {
-			log.Panicf("Failed to unmarshal item, %v", err)
+			db.logError(ctx, err).Panic("Failed to unmarshal item")
+		}
+
+		jsonObject, err := db.openPayload(&dataItem)
+		if err != nil {
+			db.logError(ctx, err).Error("Failed to decrypt item")
+			return nil, "", status.Error(codes.Internal, "Failed to decrypt item")
 		}
 
 		var occurrence pb.Occurrence
-		err = jsonpb.Unmarshal(strings.NewReader(dataItem.Json), &occurrence)
+		err = jsonpb.Unmarshal(strings.NewReader(jsonObject), &occurrence)
 		if err != nil {
-			log.Panicf("Failed to unmarshal json, %v", err)
+			db.logPayload(db.logError(ctx, err), jsonObject).Panic("Failed to unmarshal json")
 		}
 		occurrences = append(occurrences, &occurrence)
 	}
@@ -460,9 +678,9 @@ func (db *DynamoDb) ListOccurrences(ctx context.Context, projectId, filter, page
 		sk := *result.LastEvaluatedKey[SortKeyName].S
 		dk := *result.LastEvaluatedKey[DataKeyName].S
 		if pk == "" || sk == "" || dk == "" {
-			log.Panicf("Unable to unmarshal LastEvaluatedKey")
+			db.logger(ctx).Panic("Unable to unmarshal LastEvaluatedKey")
 		} else {
-			token = fmt.Sprintf("%s%s%s%s%s", pk, PaginationString, sk, PaginationString, dk)
+			token = fmt.Sprintf("%s%s%s%s%s", db.unkey(pk), PaginationString, db.unkey(sk), PaginationString, db.unkey(dk))
 		}
 	}
 
@@ -471,6 +689,9 @@ func (db *DynamoDb) ListOccurrences(ctx context.Context, projectId, filter, page
 
 // CreateOccurrence creates the specified occurrence in storage.
 func (db *DynamoDb) CreateOccurrence(ctx context.Context, projectId, userID string, o *pb.Occurrence) (*pb.Occurrence, error) {
+	ctx, span := db.startOperation(ctx, "CreateOccurrence", ProjectAttribute.String(projectId))
+	defer span.End()
+
 	o = proto.Clone(o).(*pb.Occurrence)
 	o.CreateTime = ptypes.TimestampNow()
 
@@ -486,42 +707,50 @@ func (db *DynamoDb) CreateOccurrence(ctx context.Context, projectId, userID stri
 	m := jsonpb.Marshaler{}
 	jsonObject, err := m.MarshalToString(o)
 	if err != nil {
-		log.Println("Unable to marshal occurrence into json", err)
+		db.logError(ctx, err).Error("Unable to marshal occurrence into json")
 		return nil, status.Error(codes.Internal, "Unable to marshal occurrence into json")
 	}
 
 	// use Global Primary Index for find by ID
 	// use GSI for find all by type (OCCURRENCE), within project (Data)
 	dataItem := DataItem{
-		PartitionKey: oName,
-		SortKey:      occurrenceSK,
-		Data:         projectId,
-		Json:         jsonObject,
+		PartitionKey: db.key(oName),
+		SortKey:      db.key(occurrenceSK),
+		Data:         db.key(projectId),
+		Version:      1,
+	}
+	stampCreated(&dataItem, db.userOrCaller(ctx, userID), time.Now())
+
+	err = db.sealPayload(&dataItem, jsonObject)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to encrypt occurrence")
+		return nil, status.Error(codes.Internal, "Failed to encrypt occurrence")
 	}
 
 	av, err := dynamodbattribute.MarshalMap(dataItem)
 	if err != nil {
-		log.Println("Failed to marshal occurrence into AttributeValues", err)
+		db.logError(ctx, err).Error("Failed to marshal occurrence into AttributeValues")
 		return nil, status.Error(codes.Internal, "Failed to marshal occurrence into AttributeValues")
 	}
 
 	// for notes within occurrence:
 	// GPI(pk, sk): occurrence ID, nID
 	// GSI(sk, data): NoteName, oName
-	// For GSI, we put oName in data to achieve sorting or occurrences
-	noteDataItem := DataItem{
-		PartitionKey: oName,
-		SortKey:      o.NoteName,
-		Data:         oName,
-		Json:         jsonObject,
-	}
+	// For GSI, we put oName in data to achieve sorting or occurrences, and the link holds a copy of the
+	// occurrence unless links are slim
+	noteDataItem := db.noteLinkItem(&dataItem, o.NoteName)
 
 	nav, err := dynamodbattribute.MarshalMap(noteDataItem)
 	if err != nil {
-		log.Println("Failed to marshal occurrence into AttributeValues", err)
+		db.logError(ctx, err).Error("Failed to marshal occurrence into AttributeValues")
 		return nil, status.Error(codes.Internal, "Failed to marshal occurrence into AttributeValues")
 	}
 
+	hookWrites, err := db.occurrenceHookWrites(ctx, OccurrenceCreated, o)
+	if err != nil {
+		return nil, err
+	}
+
 	input := &dynamodb.TransactWriteItemsInput{
 		TransactItems: []*dynamodb.TransactWriteItem{
 			{
@@ -539,31 +768,39 @@ func (db *DynamoDb) CreateOccurrence(ctx context.Context, projectId, userID stri
 			},
 		},
 	}
-	_, err = db.TransactWriteItems(input)
+	input.TransactItems = append(input.TransactItems, hookWrites...)
+	_, err = db.TransactWriteItemsWithContext(ctx, input)
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
 			return nil, status.Errorf(codes.AlreadyExists, "Occurrence with name %q already exists", projectId)
 		} else {
-			log.Println("Failed to insert Occurrence in database", err)
+			db.logError(ctx, err).Error("Failed to insert Occurrence in database")
 			return nil, status.Error(codes.Internal, "Failed to insert Occurrence in database")
 		}
 	}
 
+	db.notifyOccurrenceHooks(ctx, OccurrenceCreated, o)
+	setEtagHeader(ctx, dataItem.Version)
 	return o, nil
 }
 
 // BatchCreateOccurrences batch creates the specified occurrences in storage.
 func (db *DynamoDb) BatchCreateOccurrences(ctx context.Context, projectId string, userID string, occs []*pb.Occurrence) ([]*pb.Occurrence, []error) {
+	ctx, span := db.startOperation(ctx, "BatchCreateOccurrences", ProjectAttribute.String(projectId))
+	defer span.End()
+
 	clonedOccs := []*pb.Occurrence{}
 	for _, o := range occs {
 		clonedOccs = append(clonedOccs, proto.Clone(o).(*pb.Occurrence))
 	}
 	occs = clonedOccs
 
+	// a batch has no single version to return
+	batchCtx := withoutEtag(ctx)
 	errs := []error{}
 	created := []*pb.Occurrence{}
 	for _, o := range occs {
-		occ, err := db.CreateOccurrence(ctx, projectId, userID, o)
+		occ, err := db.CreateOccurrence(batchCtx, projectId, userID, o)
 		if err != nil {
 			// Occurrence already exists, skipping.
 			continue
@@ -577,7 +814,31 @@ func (db *DynamoDb) BatchCreateOccurrences(ctx context.Context, projectId string
 
 // UpdateOccurrence updates the specified occurrence in storage.
 func (db *DynamoDb) UpdateOccurrence(ctx context.Context, projectId, occId string, o *pb.Occurrence, mask *fieldmaskpb.FieldMask) (*pb.Occurrence, error) {
+	ctx, span := db.startOperation(ctx, "UpdateOccurrence", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatOccurrence(projectId, occId)))
+	defer span.End()
+
 	o = proto.Clone(o).(*pb.Occurrence)
+	oName := name.FormatOccurrence(projectId, occId)
+	o.Name = oName
+
+	current, err := db.currentVersion(ctx, oName, occurrenceSK)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	userID := db.userOrCaller(ctx, "")
+
+	// the occurrence may be moving to another note, in which case its old link is deleted with the update
+	var stored pb.Occurrence
+	err = db.unmarshalPayload(current, &stored)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to decode occurrence")
+		return nil, status.Error(codes.Internal, "Failed to decode occurrence")
+	}
+	links, err := db.occurrenceLinks(ctx, oName, stored.NoteName)
+	if err != nil {
+		return nil, err
+	}
 
 	// TODO(#312): implement the update operation
 	o.UpdateTime = ptypes.TimestampNow()
@@ -586,49 +847,66 @@ func (db *DynamoDb) UpdateOccurrence(ctx context.Context, projectId, occId strin
 	jsonObject, err := m.MarshalToString(o)
 
 	if err != nil {
-		log.Println("Unable to marshal occurrence into json", err)
+		db.logError(ctx, err).Error("Unable to marshal occurrence into json")
 		return nil, status.Error(codes.Internal, "Unable to marshal occurrence into json")
 	}
 
 	// use Global Primary Index for find by ID
 	// use GSI for find all by type (OCCURRENCE), within project (Data)
 	dataItem := DataItem{
-		PartitionKey: o.Name,
-		SortKey:      occurrenceSK,
-		Data:         projectId,
-		Json:         jsonObject,
+		PartitionKey: db.key(oName),
+		SortKey:      db.key(occurrenceSK),
+		Data:         db.key(projectId),
+		Version:      current.Version + 1,
+	}
+	stampUpdated(&dataItem, current, userID, now)
+
+	err = db.sealPayload(&dataItem, jsonObject)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to encrypt occurrence")
+		return nil, status.Error(codes.Internal, "Failed to encrypt occurrence")
 	}
 
 	av, err := dynamodbattribute.MarshalMap(dataItem)
 	if err != nil {
-		log.Println("Failed to marshal occurrence into AttributeValues", err)
+		db.logError(ctx, err).Error("Failed to marshal occurrence into AttributeValues")
 		return nil, status.Error(codes.Internal, "Failed to marshal occurrence into AttributeValues")
 	}
 
 	// for notes within occurrence:
 	// GPI(pk, sk): occurrence ID, nID
 	// GSI(sk, data): NoteName, oName
-	// For GSI, we put oName in data to achieve sorting or occurrences
-	noteDataItem := DataItem{
-		PartitionKey: o.Name,
-		SortKey:      o.NoteName,
-		Data:         o.Name,
-		Json:         jsonObject,
-	}
+	// For GSI, we put oName in data to achieve sorting or occurrences, and the link holds a copy of the
+	// occurrence unless links are slim
+	noteDataItem := db.noteLinkItem(&dataItem, o.NoteName)
 
 	nav, err := dynamodbattribute.MarshalMap(noteDataItem)
 	if err != nil {
-		log.Println("Failed to marshal occurrence into AttributeValues", err)
+		db.logError(ctx, err).Error("Failed to marshal occurrence into AttributeValues")
 		return nil, status.Error(codes.Internal, "Failed to marshal occurrence into AttributeValues")
 	}
 
+	revision, err := db.revisionPut(current, RevisionOperationUpdate, userID, now)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to marshal occurrence revision into AttributeValues")
+		return nil, status.Error(codes.Internal, "Failed to marshal occurrence revision into AttributeValues")
+	}
+
+	hookWrites, err := db.occurrenceHookWrites(ctx, OccurrenceUpdated, o)
+	if err != nil {
+		return nil, err
+	}
+
+	condition, conditionNames, conditionValues := versionCondition(current.Version)
 	input := &dynamodb.TransactWriteItemsInput{
 		TransactItems: []*dynamodb.TransactWriteItem{
 			{
 				Put: &dynamodb.Put{
-					Item:                av,
-					TableName:           aws.String(db.TableName),
-					ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) AND attribute_exists(%s)", PartitionKeyName, SortKeyName)),
+					Item:                      av,
+					TableName:                 aws.String(db.TableName),
+					ConditionExpression:       condition,
+					ExpressionAttributeNames:  conditionNames,
+					ExpressionAttributeValues: conditionValues,
 				},
 			},
 			{
@@ -637,33 +915,64 @@ func (db *DynamoDb) UpdateOccurrence(ctx context.Context, projectId, occId strin
 					TableName: aws.String(db.TableName),
 				},
 			},
+			revision,
 		},
 	}
-	_, err = db.TransactWriteItems(input)
+	input.TransactItems = append(input.TransactItems, db.linkDeletes(oName, links, o.NoteName)...)
+	input.TransactItems = append(input.TransactItems, hookWrites...)
+	_, err = db.TransactWriteItemsWithContext(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
-			return nil, status.Errorf(codes.AlreadyExists, "Occurrence with name %q already exists", projectId)
+		if IsConditionalCheckFailure(err) {
+			return nil, status.Errorf(codes.Aborted, "Occurrence with name %q was modified concurrently", oName)
 		} else {
-			log.Println("Failed to insert Occurrence in database", err)
+			db.logError(ctx, err).Error("Failed to insert Occurrence in database")
 			return nil, status.Error(codes.Internal, "Failed to insert Occurrence in database")
 		}
 	}
 
+	db.notifyOccurrenceHooks(ctx, OccurrenceUpdated, o)
+	setEtagHeader(ctx, dataItem.Version)
 	return o, nil
 }
 
-// DeleteOccurrence deletes the specified occurrence in storage.
+// DeleteOccurrence deletes the specified occurrence in storage.  The occurrence and every row linking it to a
+// note are deleted in a single transaction, conditional on the occurrence being unchanged since it was read for
+// its revision, so that either all of them are deleted or none are.
 func (db *DynamoDb) DeleteOccurrence(ctx context.Context, projectId, occId string) error {
+	ctx, span := db.startOperation(ctx, "DeleteOccurrence", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatOccurrence(projectId, occId)))
+	defer span.End()
+
 	oName := name.FormatOccurrence(projectId, occId)
 
-	// we need to delete both the main occurrence and the auxiliary entry that maps occurrence -> note
-	// we can't delete multiple items using a wildcard pk of the oID: we actually need to
-	// get the [pk, sk] pair for both entries and delete these, which requires a get/delete
-	o, err := db.GetOccurrence(ctx, projectId, occId)
+	current, err := db.currentVersion(ctx, oName, occurrenceSK)
 	if err != nil {
 		return err
 	}
 
+	var o pb.Occurrence
+	err = db.unmarshalPayload(current, &o)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to decode occurrence")
+		return status.Error(codes.Internal, "Failed to decode occurrence")
+	}
+
+	links, err := db.occurrenceLinks(ctx, oName, o.NoteName)
+	if err != nil {
+		return err
+	}
+
+	revision, err := db.revisionPut(current, RevisionOperationDelete, db.userOrCaller(ctx, ""), time.Now())
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to marshal occurrence revision into AttributeValues")
+		return status.Error(codes.Internal, "Failed to marshal occurrence revision into AttributeValues")
+	}
+
+	hookWrites, err := db.occurrenceHookWrites(ctx, OccurrenceDeleted, &o)
+	if err != nil {
+		return err
+	}
+
+	condition, conditionNames, conditionValues := versionCondition(current.Version)
 	input := &dynamodb.TransactWriteItemsInput{
 		TransactItems: []*dynamodb.TransactWriteItem{
 			{
@@ -671,79 +980,162 @@ func (db *DynamoDb) DeleteOccurrence(ctx context.Context, projectId, occId strin
 					TableName: aws.String(db.TableName),
 					Key: map[string]*dynamodb.AttributeValue{
 						PartitionKeyName: {
-							S: aws.String(oName),
-						},
-						SortKeyName: {
-							S: aws.String(occurrenceSK),
-						},
-					},
-					ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) AND attribute_exists(%s)", PartitionKeyName, SortKeyName)),
-				},
-			},
-			{
-				Delete: &dynamodb.Delete{
-					TableName: aws.String(db.TableName),
-					Key: map[string]*dynamodb.AttributeValue{
-						PartitionKeyName: {
-							S: aws.String(oName),
+							S: aws.String(db.key(oName)),
 						},
 						SortKeyName: {
-							S: aws.String(o.NoteName),
+							S: aws.String(db.key(occurrenceSK)),
 						},
 					},
+					ConditionExpression:       condition,
+					ExpressionAttributeNames:  conditionNames,
+					ExpressionAttributeValues: conditionValues,
 				},
 			},
+			revision,
 		},
 	}
-	_, err = db.TransactWriteItems(input)
+	input.TransactItems = append(input.TransactItems, db.linkDeletes(oName, links, "")...)
+	input.TransactItems = append(input.TransactItems, hookWrites...)
+	_, err = db.TransactWriteItemsWithContext(ctx, input)
 	if err != nil {
+		if IsConditionalCheckFailure(err) {
+			return status.Errorf(codes.Aborted, "Occurrence with name %q was modified concurrently", oName)
+		}
 		return err
 	}
 
+	db.notifyOccurrenceHooks(ctx, OccurrenceDeleted, &o)
 	return nil
 }
 
+// occurrenceLinks returns the names of the notes an occurrence is linked to, read from its link rows, together
+// with the note the stored occurrence refers to.  There is normally just the one, but an occurrence moved to
+// another note before moves deleted the old link may have several.  Links are only written by transactions
+// that change or check the version of the occurrence, so a write conditional on the version read beforehand
+// sees every link.
+func (db *DynamoDb) occurrenceLinks(ctx context.Context, oName, noteName string) ([]string, error) {
+	noteNames := []string{noteName}
+	queryInput := &dynamodb.QueryInput{
+		TableName: aws.String(db.TableName),
+		ExpressionAttributeNames: map[string]*string{
+			"#PARTITION_KEY": aws.String(PartitionKeyName),
+			"#SORT_KEY":      aws.String(SortKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":NAME": {
+				S: aws.String(db.key(oName)),
+			},
+			":NOTE_PREFIX": {
+				S: aws.String(db.key(name.FormatProject(""))),
+			},
+		},
+		KeyConditionExpression: aws.String("#PARTITION_KEY=:NAME AND begins_with(#SORT_KEY, :NOTE_PREFIX)"),
+		ProjectionExpression:   aws.String("#SORT_KEY"),
+		ConsistentRead:         aws.Bool(true),
+	}
+
+	err := db.QueryPagesWithContext(ctx, queryInput, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if sk := item[SortKeyName]; sk != nil && sk.S != nil && db.unkey(*sk.S) != noteName {
+				noteNames = append(noteNames, db.unkey(*sk.S))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to read occurrence notes")
+		return nil, status.Errorf(codes.Internal, "Failed to read notes of %s from database", oName)
+	}
+	return noteNames, nil
+}
+
+// linkDeletes returns the deletions of the rows linking an occurrence to notes, other than the link to the note
+// kept, if any.
+func (db *DynamoDb) linkDeletes(oName string, noteNames []string, keep string) []*dynamodb.TransactWriteItem {
+	var deletes []*dynamodb.TransactWriteItem
+	for _, noteName := range noteNames {
+		if noteName == keep {
+			continue
+		}
+		deletes = append(deletes, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(db.TableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					PartitionKeyName: {
+						S: aws.String(db.key(oName)),
+					},
+					SortKeyName: {
+						S: aws.String(db.key(noteName)),
+					},
+				},
+			},
+		})
+	}
+	return deletes
+}
+
 // GetNote gets the specified note from storage.
 func (db *DynamoDb) GetNote(ctx context.Context, projectId, nID string) (*pb.Note, error) {
-	result, err := db.GetItem(&dynamodb.GetItemInput{
+	ctx, span := db.startOperation(ctx, "GetNote", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatNote(projectId, nID)))
+	defer span.End()
+
+	nName := name.FormatNote(projectId, nID)
+	if cached, version, ok := db.cache.get(nName); ok {
+		setEtagHeader(ctx, version)
+		return cached.(*pb.Note), nil
+	}
+	generation := db.cache.begin()
+
+	result, err := db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(db.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(name.FormatNote(projectId, nID)),
+				S: aws.String(db.key(nName)),
 			},
 			SortKeyName: {
-				S: aws.String(noteSK),
+				S: aws.String(db.key(noteSK)),
 			},
 		},
 		ConsistentRead: aws.Bool(true),
 	})
 
 	if err != nil {
-		log.Printf("Error when seeking note %s/%s, %s", projectId, nID, err)
+		db.logError(ctx, err).Error("Error when seeking note")
 		return nil, nil
 	}
 
 	dataItem := DataItem{}
 	err = dynamodbattribute.UnmarshalMap(result.Item, &dataItem)
 	if err != nil {
-		log.Panicf("Failed to unmarshal item, %v", err)
+		db.logError(ctx, err).Panic("Failed to unmarshal item")
 	}
 
 	if dataItem.PartitionKey == "" {
 		return nil, status.Errorf(codes.NotFound, "Note with name %s/%s does not exist", projectId, nID)
 	}
 
+	jsonObject, err := db.openPayload(&dataItem)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to decrypt item")
+		return nil, status.Error(codes.Internal, "Failed to decrypt item")
+	}
+
 	var note pb.Note
-	err = jsonpb.Unmarshal(strings.NewReader(dataItem.Json), &note)
+	err = jsonpb.Unmarshal(strings.NewReader(jsonObject), &note)
 	if err != nil {
-		log.Panicf("Failed to unmarshal json, %v\n%s", err, dataItem.Json)
+		db.logPayload(db.logError(ctx, err), jsonObject).Panic("Failed to unmarshal json")
 	}
 
+	db.cache.put(nName, &note, dataItem.Version, generation)
+	setEtagHeader(ctx, dataItem.Version)
 	return &note, nil
 }
 
 // ListNotes lists notes for the specified project from storage.
 func (db *DynamoDb) ListNotes(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Note, string, error) {
+	ctx, span := db.startOperation(ctx, "ListNotes", ProjectAttribute.String(projectId))
+	defer span.End()
+
 	var notes []*pb.Note
 
 	queryInput := dynamodb.QueryInput{
@@ -755,10 +1147,10 @@ func (db *DynamoDb) ListNotes(ctx context.Context, projectId, filter, pageToken
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":NOTE": {
-				S: aws.String(noteSK),
+				S: aws.String(db.key(noteSK)),
 			},
 			":PROJECT": {
-				S: aws.String(projectId),
+				S: aws.String(db.key(projectId)),
 			},
 		},
 		KeyConditionExpression: aws.String("#PARTITION_KEY=:NOTE AND #DATA=:PROJECT"),
@@ -768,27 +1160,27 @@ func (db *DynamoDb) ListNotes(ctx context.Context, projectId, filter, pageToken
 	if pageToken != "" {
 		tokenArray := strings.Split(pageToken, PaginationString)
 		if len(tokenArray) != 3 {
-			log.Print("Error when trying to parse page token")
+			db.logger(ctx).WithField("page_token", pageToken).Warn("Error when trying to parse page token")
 			return notes, "", nil
 		}
 
 		queryInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(tokenArray[0]),
+				S: aws.String(db.key(tokenArray[0])),
 			},
 			SortKeyName: {
-				S: aws.String(tokenArray[1]),
+				S: aws.String(db.key(tokenArray[1])),
 			},
 			DataKeyName: {
-				S: aws.String(tokenArray[2]),
+				S: aws.String(db.key(tokenArray[2])),
 			},
 		}
 	}
 
-	result, err := db.Query(&queryInput)
+	result, err := db.eventuallyConsistentQuery(ctx, &queryInput)
 
 	if err != nil {
-		log.Printf("Error when listing notes:\n%s", err)
+		db.logError(ctx, err).Error("Error when listing notes")
 		return notes, "", nil
 	}
 
@@ -796,13 +1188,19 @@ func (db *DynamoDb) ListNotes(ctx context.Context, projectId, filter, pageToken
 		dataItem := DataItem{}
 		err = dynamodbattribute.UnmarshalMap(item, &dataItem)
 		if err != nil {
-			log.Panicf("Failed to unmarshal item, %v", err)
+			db.logError(ctx, err).Panic("Failed to unmarshal item")
+		}
+
+		jsonObject, err := db.openPayload(&dataItem)
+		if err != nil {
+			db.logError(ctx, err).Error("Failed to decrypt item")
+			return nil, "", status.Error(codes.Internal, "Failed to decrypt item")
 		}
 
 		var note pb.Note
-		err = jsonpb.Unmarshal(strings.NewReader(dataItem.Json), &note)
+		err = jsonpb.Unmarshal(strings.NewReader(jsonObject), &note)
 		if err != nil {
-			log.Panicf("Failed to unmarshal json, %v", err)
+			db.logPayload(db.logError(ctx, err), jsonObject).Panic("Failed to unmarshal json")
 		}
 		notes = append(notes, &note)
 	}
@@ -813,9 +1211,9 @@ func (db *DynamoDb) ListNotes(ctx context.Context, projectId, filter, pageToken
 		sk := *result.LastEvaluatedKey[SortKeyName].S
 		dk := *result.LastEvaluatedKey[DataKeyName].S
 		if pk == "" || sk == "" || dk == "" {
-			log.Panicf("Unable to unmarshal LastEvaluatedKey")
+			db.logger(ctx).Panic("Unable to unmarshal LastEvaluatedKey")
 		} else {
-			token = fmt.Sprintf("%s%s%s%s%s", pk, PaginationString, sk, PaginationString, dk)
+			token = fmt.Sprintf("%s%s%s%s%s", db.unkey(pk), PaginationString, db.unkey(sk), PaginationString, db.unkey(dk))
 		}
 	}
 
@@ -824,6 +1222,9 @@ func (db *DynamoDb) ListNotes(ctx context.Context, projectId, filter, pageToken
 
 // CreateNote creates the specified note in storage.
 func (db *DynamoDb) CreateNote(ctx context.Context, projectId, nID string, userID string, n *pb.Note) (*pb.Note, error) {
+	ctx, span := db.startOperation(ctx, "CreateNote", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatNote(projectId, nID)))
+	defer span.End()
+
 	n = proto.Clone(n).(*pb.Note)
 	nName := name.FormatNote(projectId, nID)
 	n.Name = nName
@@ -833,22 +1234,35 @@ func (db *DynamoDb) CreateNote(ctx context.Context, projectId, nID string, userI
 	jsonObject, err := m.MarshalToString(n)
 
 	if err != nil {
-		log.Println("Unable to marshal occurrence into json", err)
+		db.logError(ctx, err).Error("Unable to marshal occurrence into json")
 		return nil, status.Error(codes.Internal, "Unable to marshal occurrence into json")
 	}
 
+	// a note may be created again under the name of one that was deleted, so carries on from its revisions
+	version, err := db.FirstVersion(ctx, nName)
+	if err != nil {
+		return nil, err
+	}
+
 	// use Global Primary Index for find by ID, where ID is composite of project ID / note ID
 	// use GSI for find all by type (NOTE), within project (Data)
 	dataItem := DataItem{
-		PartitionKey: nName,
-		SortKey:      noteSK,
-		Data:         projectId,
-		Json:         jsonObject,
+		PartitionKey: db.key(nName),
+		SortKey:      db.key(noteSK),
+		Data:         db.key(projectId),
+		Version:      version,
+	}
+	stampCreated(&dataItem, db.userOrCaller(ctx, userID), time.Now())
+
+	err = db.sealPayload(&dataItem, jsonObject)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to encrypt note")
+		return nil, status.Error(codes.Internal, "Failed to encrypt note")
 	}
 
 	av, err := dynamodbattribute.MarshalMap(dataItem)
 	if err != nil {
-		log.Println("Failed to marshal note into AttributeValues", err)
+		db.logError(ctx, err).Error("Failed to marshal note into AttributeValues")
 		return nil, status.Error(codes.Internal, "Failed to marshal note into AttributeValues")
 	}
 
@@ -858,31 +1272,37 @@ func (db *DynamoDb) CreateNote(ctx context.Context, projectId, nID string, userI
 		ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s) AND attribute_not_exists(%s)", PartitionKeyName, SortKeyName)),
 	}
 
-	_, err = db.PutItem(input)
+	_, err = db.PutItemWithContext(ctx, input)
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
 			return nil, status.Errorf(codes.AlreadyExists, "Note with name %q already exists", n.Name)
 		} else {
-			log.Println("Failed to insert note in database", err)
+			db.logError(ctx, err).Error("Failed to insert note in database")
 			return nil, status.Error(codes.Internal, "Failed to insert Note in database")
 		}
 	}
 
+	setEtagHeader(ctx, dataItem.Version)
 	return n, nil
 }
 
 // BatchCreateNotes batch creates the specified notes in storage.
 func (db *DynamoDb) BatchCreateNotes(ctx context.Context, projectId string, userID string, notes map[string]*pb.Note) ([]*pb.Note, []error) {
+	ctx, span := db.startOperation(ctx, "BatchCreateNotes", ProjectAttribute.String(projectId))
+	defer span.End()
+
 	clonedNotes := map[string]*pb.Note{}
 	for nID, n := range notes {
 		clonedNotes[nID] = proto.Clone(n).(*pb.Note)
 	}
 	notes = clonedNotes
 
+	// a batch has no single version to return
+	batchCtx := withoutEtag(ctx)
 	errs := []error{}
 	created := []*pb.Note{}
 	for nID, n := range notes {
-		note, err := db.CreateNote(ctx, projectId, nID, userID, n)
+		note, err := db.CreateNote(batchCtx, projectId, nID, userID, n)
 		if err != nil {
 			// Note already exists, skipping.
 			continue
@@ -897,10 +1317,20 @@ func (db *DynamoDb) BatchCreateNotes(ctx context.Context, projectId string, user
 
 // UpdateNote updates the specified note in storage.
 func (db *DynamoDb) UpdateNote(ctx context.Context, projectId, nID string, n *pb.Note, mask *fieldmaskpb.FieldMask) (*pb.Note, error) {
+	ctx, span := db.startOperation(ctx, "UpdateNote", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatNote(projectId, nID)))
+	defer span.End()
+
 	n = proto.Clone(n).(*pb.Note)
 	nName := name.FormatNote(projectId, nID)
 	n.Name = nName
 
+	current, err := db.currentVersion(ctx, nName, noteSK)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	userID := db.userOrCaller(ctx, "")
+
 	// TODO(#312): implement the update operation
 	n.UpdateTime = ptypes.TimestampNow()
 
@@ -908,61 +1338,117 @@ func (db *DynamoDb) UpdateNote(ctx context.Context, projectId, nID string, n *pb
 	jsonObject, err := m.MarshalToString(n)
 
 	if err != nil {
-		log.Println("Unable to marshal occurrence into json", err)
+		db.logError(ctx, err).Error("Unable to marshal occurrence into json")
 		return nil, status.Error(codes.Internal, "Unable to marshal occurrence into json")
 	}
 
 	// use Global Primary Index for find by ID, where ID is composite of project ID / note ID
 	// use GSI for find all by type (NOTE), within project (Data)
 	dataItem := DataItem{
-		PartitionKey: nName,
-		SortKey:      noteSK,
-		Data:         projectId,
-		Json:         jsonObject,
+		PartitionKey: db.key(nName),
+		SortKey:      db.key(noteSK),
+		Data:         db.key(projectId),
+		Version:      current.Version + 1,
+	}
+	stampUpdated(&dataItem, current, userID, now)
+
+	err = db.sealPayload(&dataItem, jsonObject)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to encrypt note")
+		return nil, status.Error(codes.Internal, "Failed to encrypt note")
 	}
 
 	av, err := dynamodbattribute.MarshalMap(dataItem)
 	if err != nil {
-		log.Println("Failed to marshal note into AttributeValues", err)
+		db.logError(ctx, err).Error("Failed to marshal note into AttributeValues")
 		return nil, status.Error(codes.Internal, "Failed to marshal note into AttributeValues")
 	}
 
-	input := &dynamodb.PutItemInput{
-		Item:                av,
-		TableName:           aws.String(db.TableName),
-		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) AND attribute_exists(%s)", PartitionKeyName, SortKeyName)),
+	revision, err := db.revisionPut(current, RevisionOperationUpdate, userID, now)
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to marshal note revision into AttributeValues")
+		return nil, status.Error(codes.Internal, "Failed to marshal note revision into AttributeValues")
+	}
+
+	condition, conditionNames, conditionValues := versionCondition(current.Version)
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					Item:                      av,
+					TableName:                 aws.String(db.TableName),
+					ConditionExpression:       condition,
+					ExpressionAttributeNames:  conditionNames,
+					ExpressionAttributeValues: conditionValues,
+				},
+			},
+			revision,
+		},
 	}
 
-	_, err = db.PutItem(input)
+	_, err = db.TransactWriteItemsWithContext(ctx, input)
+	db.cache.remove(nName)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
-			return nil, status.Errorf(codes.AlreadyExists, "Note with name %q does not exist", n.Name)
+		if IsConditionalCheckFailure(err) {
+			return nil, status.Errorf(codes.Aborted, "Note with name %q was modified concurrently", n.Name)
 		} else {
-			log.Println("Failed to insert note in database", err)
+			db.logError(ctx, err).Error("Failed to insert note in database")
 			return nil, status.Error(codes.Internal, "Failed to insert Note in database")
 		}
 	}
 
+	setEtagHeader(ctx, dataItem.Version)
 	return n, nil
 }
 
 // DeleteNote deletes the specified note in storage.
 func (db *DynamoDb) DeleteNote(ctx context.Context, projectId, nID string) error {
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			PartitionKeyName: {
-				S: aws.String(name.FormatNote(projectId, nID)),
-			},
-			SortKeyName: {
-				S: aws.String(noteSK),
+	ctx, span := db.startOperation(ctx, "DeleteNote", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatNote(projectId, nID)))
+	defer span.End()
+
+	nName := name.FormatNote(projectId, nID)
+
+	// the stored note is kept as a revision, so needs to be read before it is deleted
+	current, err := db.currentVersion(ctx, nName, noteSK)
+	if err != nil {
+		return err
+	}
+
+	revision, err := db.revisionPut(current, RevisionOperationDelete, db.userOrCaller(ctx, ""), time.Now())
+	if err != nil {
+		db.logError(ctx, err).Error("Failed to marshal note revision into AttributeValues")
+		return status.Error(codes.Internal, "Failed to marshal note revision into AttributeValues")
+	}
+
+	condition, conditionNames, conditionValues := versionCondition(current.Version)
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					Key: map[string]*dynamodb.AttributeValue{
+						PartitionKeyName: {
+							S: aws.String(db.key(nName)),
+						},
+						SortKeyName: {
+							S: aws.String(db.key(noteSK)),
+						},
+					},
+					TableName:                 aws.String(db.TableName),
+					ConditionExpression:       condition,
+					ExpressionAttributeNames:  conditionNames,
+					ExpressionAttributeValues: conditionValues,
+				},
 			},
+			revision,
 		},
-		TableName:           aws.String(db.TableName),
-		ConditionExpression: aws.String(fmt.Sprintf("attribute_exists(%s) AND attribute_exists(%s)", PartitionKeyName, SortKeyName)),
 	}
 
-	_, err := db.DeleteItem(input)
+	_, err = db.TransactWriteItemsWithContext(ctx, input)
+	db.cache.remove(nName)
 	if err != nil {
+		if IsConditionalCheckFailure(err) {
+			return status.Errorf(codes.Aborted, "Note with name %q was modified concurrently", nName)
+		}
 		return err
 	}
 
@@ -971,7 +1457,10 @@ func (db *DynamoDb) DeleteNote(ctx context.Context, projectId, nID string) error
 
 // GetOccurrenceNote gets the note for the specified occurrence from storage.
 func (db *DynamoDb) GetOccurrenceNote(ctx context.Context, projectId, oID string) (*pb.Note, error) {
-	o, err := db.GetOccurrence(ctx, projectId, oID)
+	ctx, span := db.startOperation(ctx, "GetOccurrenceNote", ProjectAttribute.String(projectId), EntityAttribute.String(name.FormatOccurrence(projectId, oID)))
+	defer span.End()
+
+	o, err := db.GetOccurrence(withoutEtag(ctx), projectId, oID)
 	if err != nil {
 		return nil, err
 	}
@@ -986,6 +1475,9 @@ func (db *DynamoDb) GetOccurrenceNote(ctx context.Context, projectId, oID string
 
 // ListNoteOccurrences lists all occurrences across all projects for the specified note from storage.
 func (db *DynamoDb) ListNoteOccurrences(ctx context.Context, nPID, nID, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	ctx, span := db.startOperation(ctx, "ListNoteOccurrences", ProjectAttribute.String(nPID), EntityAttribute.String(name.FormatNote(nPID, nID)))
+	defer span.End()
+
 	var occurrences []*pb.Occurrence
 
 	noteName := name.FormatNote(nPID, nID)
@@ -998,7 +1490,7 @@ func (db *DynamoDb) ListNoteOccurrences(ctx context.Context, nPID, nID, filter,
 		},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":NOTE_NAME": {
-				S: &noteName,
+				S: aws.String(db.key(noteName)),
 			},
 		},
 		KeyConditionExpression: aws.String("#PARTITION_KEY=:NOTE_NAME"),
@@ -1008,41 +1500,57 @@ func (db *DynamoDb) ListNoteOccurrences(ctx context.Context, nPID, nID, filter,
 	if pageToken != "" {
 		tokenArray := strings.Split(pageToken, PaginationString)
 		if len(tokenArray) != 3 {
-			log.Print("Error when trying to parse page token")
+			db.logger(ctx).WithField("page_token", pageToken).Warn("Error when trying to parse page token")
 			return occurrences, "", nil
 		}
 
 		queryInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
 			PartitionKeyName: {
-				S: aws.String(tokenArray[0]),
+				S: aws.String(db.key(tokenArray[0])),
 			},
 			SortKeyName: {
-				S: aws.String(tokenArray[1]),
+				S: aws.String(db.key(tokenArray[1])),
 			},
 			DataKeyName: {
-				S: aws.String(tokenArray[2]),
+				S: aws.String(db.key(tokenArray[2])),
 			},
 		}
 	}
 
-	result, err := db.Query(&queryInput)
+	result, err := db.eventuallyConsistentQuery(ctx, &queryInput)
 
 	if err != nil {
-		log.Printf("Error when listing occurrences:\n%s", err)
+		db.logError(ctx, err).Error("Error when listing occurrences")
 		return occurrences, "", nil
 	}
 
+	var links []DataItem
 	for _, item := range result.Items {
 		dataItem := DataItem{}
 		err = dynamodbattribute.UnmarshalMap(item, &dataItem)
 		if err != nil {
-			log.Panicf("Failed to unmarshal item, %v", err)
+			db.logError(ctx, err).Panic("Failed to unmarshal item")
+		}
+		links = append(links, dataItem)
+	}
+
+	// slim links only identify their occurrences, which are read from their own rows
+	links, err = db.hydrateLinks(ctx, noteName, links)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, dataItem := range links {
+		jsonObject, err := db.openPayload(&dataItem)
+		if err != nil {
+			db.logError(ctx, err).Error("Failed to decrypt item")
+			return nil, "", status.Error(codes.Internal, "Failed to decrypt item")
 		}
 
 		var occurrence pb.Occurrence
-		err = jsonpb.Unmarshal(strings.NewReader(dataItem.Json), &occurrence)
+		err = jsonpb.Unmarshal(strings.NewReader(jsonObject), &occurrence)
 		if err != nil {
-			log.Panicf("Failed to unmarshal json, %v", err)
+			db.logPayload(db.logError(ctx, err), jsonObject).Panic("Failed to unmarshal json")
 		}
 		occurrences = append(occurrences, &occurrence)
 	}
@@ -1053,9 +1561,9 @@ func (db *DynamoDb) ListNoteOccurrences(ctx context.Context, nPID, nID, filter,
 		sk := *result.LastEvaluatedKey[SortKeyName].S
 		dk := *result.LastEvaluatedKey[DataKeyName].S
 		if pk == "" || sk == "" || dk == "" {
-			log.Panicf("Unable to unmarshal LastEvaluatedKey")
+			db.logger(ctx).Panic("Unable to unmarshal LastEvaluatedKey")
 		} else {
-			token = fmt.Sprintf("%s%s%s%s%s", pk, PaginationString, sk, PaginationString, dk)
+			token = fmt.Sprintf("%s%s%s%s%s", db.unkey(pk), PaginationString, db.unkey(sk), PaginationString, db.unkey(dk))
 		}
 	}
 
@@ -1064,5 +1572,8 @@ func (db *DynamoDb) ListNoteOccurrences(ctx context.Context, nPID, nID, filter,
 
 // GetVulnerabilityOccurrencesSummary gets a summary of vulnerability occurrences from storage.
 func (db *DynamoDb) GetVulnerabilityOccurrencesSummary(ctx context.Context, projectId, filter string) (*pb.VulnerabilityOccurrencesSummary, error) {
+	ctx, span := db.startOperation(ctx, "GetVulnerabilityOccurrencesSummary", ProjectAttribute.String(projectId))
+	defer span.End()
+
 	return &pb.VulnerabilityOccurrencesSummary{}, nil
 }
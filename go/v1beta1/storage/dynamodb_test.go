@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/grafeas/grafeas/go/v1beta1/api"
 	"github.com/grafeas/grafeas/go/v1beta1/project"
 	gs "github.com/grafeas/grafeas/go/v1beta1/storage"
@@ -12,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
 	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
 )
 
@@ -19,7 +21,7 @@ const (
 	dynamoDbTestingContainerPort = 8000
 )
 
-func dropDynamoDbTable(tableName string, dynamoDb *dynamodb.DynamoDB) {
+func dropDynamoDbTable(tableName string, dynamoDb dynamodbiface.DynamoDBAPI) {
 	_, err := dynamoDb.DeleteTable(&dynamodb.DeleteTableInput{
 		TableName: aws.String(tableName),
 	},
@@ -55,7 +57,42 @@ func TestDynamoDbStore(t *testing.T) {
 		ddb := storage.NewDynamoDbStore(dynamoDbConfig)
 		var g grafeas.Storage = ddb
 		var gp project.Storage = ddb
-		return g, gp, func() { dropDynamoDbTable(dynamoDbConfig.TableName, ddb.DynamoDB) }
+		return g, gp, func() { dropDynamoDbTable(dynamoDbConfig.TableName, ddb.DynamoDBAPI) }
+	}
+
+	gs.DoTestStorage(t, createDynamoDbStore)
+}
+
+func TestDynamoDbStoreInMemory(t *testing.T) {
+	createDynamoDbStore := func(t *testing.T) (grafeas.Storage, project.Storage, func()) {
+		ddb := storage.NewDynamoDbStore(&config.DynamoDbConfig{
+			TableName: "test_table",
+			AWS: &config.AwsConfig{
+				Endpoint: aws.String(memdb.Endpoint),
+				Region:   aws.String("eu-west-2"),
+			},
+		})
+		var g grafeas.Storage = ddb
+		var gp project.Storage = ddb
+		return g, gp, func() {}
+	}
+
+	gs.DoTestStorage(t, createDynamoDbStore)
+}
+
+func TestDynamoDbStoreNamespaced(t *testing.T) {
+	createDynamoDbStore := func(t *testing.T) (grafeas.Storage, project.Storage, func()) {
+		ddb := storage.NewDynamoDbStore(&config.DynamoDbConfig{
+			TableName: "test_table",
+			Namespace: "staging",
+			AWS: &config.AwsConfig{
+				Endpoint: aws.String(memdb.Endpoint),
+				Region:   aws.String("eu-west-2"),
+			},
+		})
+		var g grafeas.Storage = ddb
+		var gp project.Storage = ddb
+		return g, gp, func() {}
 	}
 
 	gs.DoTestStorage(t, createDynamoDbStore)
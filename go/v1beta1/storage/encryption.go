@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/grafeas/grafeas/go/name"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/encryption"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// newKeyProvider creates the KeyProvider described by the configuration, or nil if encryption is disabled.
+func newKeyProvider(sess *session.Session, encryptionConfig *config.EncryptionConfig) (encryption.KeyProvider, error) {
+	if encryptionConfig == nil || encryptionConfig.Provider == "" {
+		return nil, nil
+	}
+
+	switch encryptionConfig.Provider {
+	case "kms":
+		return encryption.NewKmsKeyProvider(kms.New(sess), encryptionConfig.KmsKeyId)
+	case "local":
+		return encryption.NewKeyFileProvider(encryptionConfig.KeyFile)
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown encryption provider %s, must be 'kms' or 'local'", encryptionConfig.Provider))
+	}
+}
+
+// sealPayload sets the json payload of the item, encrypting it under a new data key if encryption is enabled.
+func (db *DynamoDb) sealPayload(dataItem *DataItem, jsonObject string) error {
+	if db.KeyProvider == nil {
+		dataItem.Json = jsonObject
+		dataItem.KeyId = ""
+		dataItem.EncryptedKey = ""
+		return nil
+	}
+
+	envelope, err := encryption.Encrypt(db.KeyProvider, []byte(jsonObject))
+	if err != nil {
+		return err
+	}
+
+	dataItem.Json = base64.StdEncoding.EncodeToString(envelope.Ciphertext)
+	dataItem.KeyId = envelope.KeyID
+	dataItem.EncryptedKey = base64.StdEncoding.EncodeToString(envelope.EncryptedKey)
+	return nil
+}
+
+// openPayload returns the json payload of the item, decrypting it if it was stored encrypted.
+func (db *DynamoDb) openPayload(dataItem *DataItem) (string, error) {
+	if dataItem.KeyId == "" {
+		return dataItem.Json, nil
+	}
+
+	if db.KeyProvider == nil {
+		return "", errors.New(fmt.Sprintf("Item %s is encrypted but no encryption provider is configured", dataItem.PartitionKey))
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(dataItem.Json)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Unable to decode encrypted payload of %s, %s", dataItem.PartitionKey, err))
+	}
+
+	encryptedKey, err := base64.StdEncoding.DecodeString(dataItem.EncryptedKey)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Unable to decode data key of %s, %s", dataItem.PartitionKey, err))
+	}
+
+	plaintext, err := encryption.Decrypt(db.KeyProvider, &encryption.Envelope{
+		KeyID:        dataItem.KeyId,
+		EncryptedKey: encryptedKey,
+		Ciphertext:   ciphertext,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// OpenPayload returns the json payload of an item read from the table, or from its stream, decrypting it if
+// required.
+func (db *DynamoDb) OpenPayload(dataItem *DataItem) (string, error) {
+	return db.openPayload(dataItem)
+}
+
+// SealPayload sets the json payload of an item written outside of the store, encrypting it if required.
+func (db *DynamoDb) SealPayload(dataItem *DataItem, jsonObject string) error {
+	return db.sealPayload(dataItem, jsonObject)
+}
+
+// unmarshalPayload decodes the json payload of the item into message, decrypting it if required.
+func (db *DynamoDb) unmarshalPayload(dataItem *DataItem, message proto.Message) error {
+	jsonObject, err := db.openPayload(dataItem)
+	if err != nil {
+		return err
+	}
+
+	return jsonpb.Unmarshal(strings.NewReader(jsonObject), message)
+}
+
+// ReEncrypt rewrites every item whose payload is stored in plaintext or whose data key is wrapped by a master
+// key other than the current one.  It is used after enabling encryption or rotating the master key, and
+// returns the number of items rewritten.  Items modified concurrently are left for a subsequent pass.  Only the
+// projects, notes, occurrences and webhook deliveries of the store's namespace are rewritten, so a store
+// without a namespace leaves the rows of namespaced deployments sharing its table alone.
+func (db *DynamoDb) ReEncrypt(ctx context.Context) (int, error) {
+	ctx, span := db.startOperation(ctx, "ReEncrypt")
+	defer span.End()
+
+	if db.KeyProvider == nil {
+		return 0, errors.New("Unable to re-encrypt items, no encryption provider is configured")
+	}
+
+	scanInput := &dynamodb.ScanInput{
+		TableName: aws.String(db.TableName),
+		ExpressionAttributeNames: map[string]*string{
+			"#PARTITION_KEY": aws.String(PartitionKeyName),
+			"#JSON":          aws.String(JsonKeyName),
+			"#KEY_ID":        aws.String(KeyIdKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":PROJECTS": {
+				S: aws.String(db.key(name.FormatProject(""))),
+			},
+			":WEBHOOKS": {
+				S: aws.String(db.key(WebhookKeyPrefix)),
+			},
+			":CURRENT_KEY_ID": {
+				S: aws.String(db.KeyProvider.KeyID()),
+			},
+		},
+		FilterExpression: aws.String("(begins_with(#PARTITION_KEY, :PROJECTS) OR begins_with(#PARTITION_KEY, :WEBHOOKS)) AND " +
+			"attribute_exists(#JSON) AND (attribute_not_exists(#KEY_ID) OR #KEY_ID <> :CURRENT_KEY_ID)"),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rewritten := 0
+	var scanErr error
+	err := db.ScanPagesWithContext(ctx, scanInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if !db.ownsKey(aws.StringValue(item[PartitionKeyName].S)) {
+				continue
+			}
+			var ok bool
+			if ok, scanErr = db.reEncryptItem(ctx, item); scanErr != nil {
+				return false
+			}
+			if ok {
+				rewritten++
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return rewritten, err
+	}
+
+	return rewritten, scanErr
+}
+
+// reEncryptItem seals the payload of a single item under a new data key, returning false if the item was
+// changed by another writer in the meantime.  Only the payload attributes are replaced, so attributes that are
+// not part of DataItem are preserved.
+func (db *DynamoDb) reEncryptItem(ctx context.Context, item map[string]*dynamodb.AttributeValue) (bool, error) {
+	dataItem := DataItem{}
+	err := dynamodbattribute.UnmarshalMap(item, &dataItem)
+	if err != nil {
+		return false, err
+	}
+	previousJson := dataItem.Json
+
+	jsonObject, err := db.openPayload(&dataItem)
+	if err != nil {
+		return false, err
+	}
+
+	if err = db.sealPayload(&dataItem, jsonObject); err != nil {
+		return false, err
+	}
+
+	item[JsonKeyName] = &dynamodb.AttributeValue{S: aws.String(dataItem.Json)}
+	item[KeyIdKeyName] = &dynamodb.AttributeValue{S: aws.String(dataItem.KeyId)}
+	item[EncryptedKeyKeyName] = &dynamodb.AttributeValue{S: aws.String(dataItem.EncryptedKey)}
+
+	_, err = db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(db.TableName),
+		ExpressionAttributeNames: map[string]*string{
+			"#JSON": aws.String(JsonKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":PREVIOUS_JSON": {
+				S: aws.String(previousJson),
+			},
+		},
+		ConditionExpression: aws.String("#JSON = :PREVIOUS_JSON"),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
+			db.logger(ctx).WithFields(logrus.Fields{EntityField: db.unkey(dataItem.PartitionKey), "sort_key": db.unkey(dataItem.SortKey)}).Info("Item changed during re-encryption, skipping")
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
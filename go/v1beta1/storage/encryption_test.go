@@ -0,0 +1,125 @@
+package storage_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// writeKeyFile writes a key file holding the keys, the last of which wraps new data keys.
+func writeKeyFile(t *testing.T, dir string, keyIDs ...string) string {
+	var contents strings.Builder
+	for _, keyID := range keyIDs {
+		key := bytes.Repeat([]byte(keyID[len(keyID)-1:]), 32)
+		contents.WriteString(fmt.Sprintf("%s %s\n", keyID, base64.StdEncoding.EncodeToString(key)))
+	}
+
+	path := filepath.Join(dir, strings.Join(keyIDs, "_"))
+	if err := ioutil.WriteFile(path, []byte(contents.String()), 0600); err != nil {
+		t.Fatalf("Unable to write key file, %s", err)
+	}
+	return path
+}
+
+func newEncryptedStore(client *memdb.DB, keyFile string) *storage.DynamoDb {
+	return storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName:  "test_table",
+		Encryption: &config.EncryptionConfig{Provider: "local", KeyFile: keyFile},
+		AWS:        &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+}
+
+// keyIDs returns the key ID of every row with a payload, by partition key and sort key.
+func keyIDs(t *testing.T, client *memdb.DB) map[string]string {
+	result, err := client.Scan(&dynamodb.ScanInput{TableName: aws.String("test_table")})
+	if err != nil {
+		t.Fatalf("Scan failed, %s", err)
+	}
+	found := map[string]string{}
+	for _, item := range result.Items {
+		if item[storage.JsonKeyName] == nil {
+			continue
+		}
+		row := aws.StringValue(item[storage.PartitionKeyName].S) + " " + aws.StringValue(item[storage.SortKeyName].S)
+		found[row] = ""
+		if keyID := item[storage.KeyIdKeyName]; keyID != nil {
+			found[row] = aws.StringValue(keyID.S)
+		}
+	}
+	return found
+}
+
+func TestReEncryptRotatesKeys(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("Unable to create directory, %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := memdb.New()
+	before := newEncryptedStore(client, writeKeyFile(t, dir, "key-1"))
+	o := newOccurrence(t, before)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+
+	// a deployment of another namespace, without encryption, shares the table
+	other := newNamespacedStore(client, "other")
+	newOccurrence(t, other)
+
+	rotated := newEncryptedStore(client, writeKeyFile(t, dir, "key-1", "key-2"))
+	rewritten, err := rotated.ReEncrypt(ctx)
+	if err != nil {
+		t.Fatalf("ReEncrypt failed, %s", err)
+	}
+	if rewritten == 0 {
+		t.Errorf("Expected the rows of the store to be rewritten")
+	}
+
+	for row, keyID := range keyIDs(t, client) {
+		if strings.HasPrefix(row, "other#") {
+			if keyID != "" {
+				t.Errorf("Expected row %s of another namespace to be left in plaintext, got key %q", row, keyID)
+			}
+		} else if keyID != "key-2" {
+			t.Errorf("Expected row %s to be wrapped by the current key, got key %q", row, keyID)
+		}
+	}
+
+	if rewritten, err := rotated.ReEncrypt(ctx); err != nil || rewritten != 0 {
+		t.Errorf("Expected nothing left to rewrite, got %d, %v", rewritten, err)
+	}
+
+	// once rewritten, the previous key is no longer needed
+	after := newEncryptedStore(client, writeKeyFile(t, dir, "key-2"))
+	if _, err := after.GetOccurrence(ctx, "p1", oID); err != nil {
+		t.Errorf("GetOccurrence failed after rotation, %s", err)
+	}
+	if _, err := after.GetNote(ctx, "p1", "n1"); err != nil {
+		t.Errorf("GetNote failed after rotation, %s", err)
+	}
+	if occurrences, _, err := after.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); err != nil || len(occurrences) != 1 {
+		t.Errorf("Expected the occurrence to be listed after rotation, got %v, %v", occurrences, err)
+	}
+	if _, err := other.GetOccurrence(ctx, "p1", oID); err == nil {
+		t.Errorf("Expected the occurrence not to be found in another namespace")
+	}
+	if occurrences, _, err := other.ListOccurrences(ctx, "p1", "", "", 10); err != nil || len(occurrences) != 1 {
+		t.Errorf("Expected the other namespace to remain readable, got %v, %v", occurrences, err)
+	}
+	if _, err := after.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n2"}, nil); err != nil {
+		t.Errorf("UpdateOccurrence failed after rotation, %s", err)
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The events reported to an OccurrenceHook.
+const (
+	OccurrenceCreated = "OCCURRENCE_CREATED"
+	OccurrenceUpdated = "OCCURRENCE_UPDATED"
+	OccurrenceDeleted = "OCCURRENCE_DELETED"
+)
+
+// OccurrenceHook adds rows of its own to the transaction that creates, updates or deletes an occurrence, so
+// that they are written if and only if the occurrence is, and is told once that transaction has committed.
+// Hooks are called synchronously on the request path, so they should return quickly.
+type OccurrenceHook interface {
+	// OccurrenceWrites returns the rows to write along with the change to the occurrence.  An error fails the
+	// request, leaving the occurrence unchanged.
+	OccurrenceWrites(ctx context.Context, event string, o *pb.Occurrence) ([]*dynamodb.TransactWriteItem, error)
+	// OccurrenceCommitted is called once the change, and the rows returned for it, have been written.
+	OccurrenceCommitted(ctx context.Context, event string, o *pb.Occurrence)
+}
+
+// occurrenceHookWrites collects the rows every registered hook writes along with a change to an occurrence.
+func (db *DynamoDb) occurrenceHookWrites(ctx context.Context, event string, o *pb.Occurrence) ([]*dynamodb.TransactWriteItem, error) {
+	var writes []*dynamodb.TransactWriteItem
+	for _, hook := range db.OccurrenceHooks {
+		hookWrites, err := hook.OccurrenceWrites(ctx, event, o)
+		if err != nil {
+			db.logError(ctx, err).WithField(EntityField, o.Name).Errorf("Failed to prepare %s notifications", event)
+			return nil, status.Error(codes.Internal, "Failed to prepare occurrence notifications")
+		}
+		writes = append(writes, hookWrites...)
+	}
+	return writes, nil
+}
+
+// notifyOccurrenceHooks reports a committed occurrence write to every registered hook.
+func (db *DynamoDb) notifyOccurrenceHooks(ctx context.Context, event string, o *pb.Occurrence) {
+	for _, hook := range db.OccurrenceHooks {
+		hook.OccurrenceCommitted(ctx, event, o)
+	}
+}
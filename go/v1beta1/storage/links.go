@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/golang/protobuf/jsonpb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// OccurrenceLinksFull and OccurrenceLinksSlim are the layouts of the rows linking occurrences to their notes:
+	// a copy of the occurrence's own row, or its keys, version and audit attributes only.
+	OccurrenceLinksFull = "full"
+	OccurrenceLinksSlim = "slim"
+
+	// maxBatchGetKeys is the number of keys BatchGetItem accepts at a time.
+	maxBatchGetKeys = 100
+	// maxUnprocessedBackoff bounds the wait before keys DynamoDB left unprocessed are read again.
+	maxUnprocessedBackoff = time.Second
+)
+
+// noteLinkItem returns the row linking an occurrence to its note, laid out as the store is configured to lay
+// links out, given the occurrence's own row.
+func (db *DynamoDb) noteLinkItem(occurrence *DataItem, noteName string) DataItem {
+	link := *occurrence
+	link.SortKey = db.key(noteName)
+	link.Data = occurrence.PartitionKey
+	if db.SlimLinks {
+		link.Json = ""
+		link.KeyId = ""
+		link.EncryptedKey = ""
+	}
+	return link
+}
+
+// NoteLinkItem returns the row linking an occurrence to its note, for links written outside of the store, such
+// as by repairs and restores.
+func (db *DynamoDb) NoteLinkItem(occurrence *DataItem, noteName string) DataItem {
+	return db.noteLinkItem(occurrence, noteName)
+}
+
+// IsSlimLink reports whether a row linking an occurrence to its note holds only the occurrence's keys, rather
+// than a copy of the occurrence.
+func IsSlimLink(link *DataItem) bool {
+	return link.Json == ""
+}
+
+// hydrateLinks replaces the slim links among rows read from the index with the rows of the occurrences they
+// link to, read with BatchGetItem.  Links whose occurrence no longer exists, or has moved to a note other than
+// noteName since the index was read, are dropped.  Tables holding links of both layouts, such as while links
+// are being rewritten, are read alike.
+func (db *DynamoDb) hydrateLinks(ctx context.Context, noteName string, links []DataItem) ([]DataItem, error) {
+	var keys []map[string]*dynamodb.AttributeValue
+	requested := map[string]bool{}
+	for i := range links {
+		if IsSlimLink(&links[i]) && !requested[links[i].PartitionKey] {
+			requested[links[i].PartitionKey] = true
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				PartitionKeyName: {S: aws.String(links[i].PartitionKey)},
+				SortKeyName:      {S: aws.String(db.key(occurrenceSK))},
+			})
+		}
+	}
+	if len(keys) == 0 {
+		return links, nil
+	}
+
+	occurrences := map[string]DataItem{}
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		end := start + maxBatchGetKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := db.batchGetOccurrences(ctx, keys[start:end], occurrences); err != nil {
+			return nil, err
+		}
+	}
+
+	hydrated := make([]DataItem, 0, len(links))
+	for _, link := range links {
+		if !IsSlimLink(&link) {
+			hydrated = append(hydrated, link)
+			continue
+		}
+		occurrence, ok := occurrences[link.PartitionKey]
+		if !ok {
+			continue
+		}
+
+		jsonObject, err := db.openPayload(&occurrence)
+		if err != nil {
+			db.logError(ctx, err).Error("Failed to decrypt item")
+			return nil, status.Error(codes.Internal, "Failed to decrypt item")
+		}
+		var o pb.Occurrence
+		if err := jsonpb.Unmarshal(strings.NewReader(jsonObject), &o); err != nil {
+			db.logPayload(db.logError(ctx, err), jsonObject).Error("Failed to unmarshal json")
+			return nil, status.Error(codes.Internal, "Failed to unmarshal occurrence")
+		}
+		if o.NoteName != noteName {
+			continue
+		}
+		// the row is only held in memory, so it keeps the opened payload rather than being decrypted again
+		occurrence.Json, occurrence.KeyId, occurrence.EncryptedKey = jsonObject, "", ""
+		hydrated = append(hydrated, occurrence)
+	}
+	return hydrated, nil
+}
+
+// batchGetOccurrences reads the rows of the keys into occurrences, by partition key, reading the keys DynamoDB
+// leaves unprocessed again until none are left.
+func (db *DynamoDb) batchGetOccurrences(ctx context.Context, keys []map[string]*dynamodb.AttributeValue, occurrences map[string]DataItem) error {
+	requestItems := map[string]*dynamodb.KeysAndAttributes{
+		db.TableName: {Keys: keys},
+	}
+	backoff := 50 * time.Millisecond
+	for {
+		result, err := db.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{RequestItems: requestItems})
+		if err != nil {
+			db.logError(ctx, err).Error("Failed to read occurrences of links")
+			return status.Error(codes.Internal, "Failed to read occurrences of links")
+		}
+
+		for _, item := range result.Responses[db.TableName] {
+			dataItem := DataItem{}
+			if err := dynamodbattribute.UnmarshalMap(item, &dataItem); err != nil {
+				db.logError(ctx, err).Error("Failed to unmarshal item")
+				return status.Error(codes.Internal, "Failed to unmarshal item")
+			}
+			occurrences[dataItem.PartitionKey] = dataItem
+		}
+
+		unprocessed, ok := result.UnprocessedKeys[db.TableName]
+		if !ok || len(unprocessed.Keys) == 0 {
+			return nil
+		}
+		requestItems = map[string]*dynamodb.KeysAndAttributes{db.TableName: unprocessed}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxUnprocessedBackoff {
+			backoff = maxUnprocessedBackoff
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/google/uuid"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// The fields of the store's log entries.
+const (
+	OperationField     = "operation"
+	ProjectField       = "project_id"
+	EntityField        = "entity"
+	CorrelationIDField = "correlation_id"
+	AwsRequestIDField  = "aws_request_id"
+	PayloadField       = "payload"
+)
+
+// CorrelationIDMetadataKey is the gRPC metadata key of the ID that correlates the log entries of a request.  It
+// is read from the request, or generated if the request has none, and returned in the response headers.
+const CorrelationIDMetadataKey = "x-correlation-id"
+
+// CorrelationIDAttribute is set on the spans of store methods.
+const CorrelationIDAttribute = label.Key("grafeas.correlation_id")
+
+type correlationIDKey struct{}
+
+type loggerKey struct{}
+
+// NewLogger creates the logger described by the logging section of the configuration.
+func NewLogger(config *config.LoggingConfig) (*logrus.Logger, error) {
+	logger := logrus.New()
+	if config == nil {
+		return logger, nil
+	}
+
+	if config.Level != "" {
+		level, err := logrus.ParseLevel(config.Level)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse log level, %s", err))
+		}
+		logger.SetLevel(level)
+	}
+
+	switch strings.ToLower(config.Format) {
+	case "", "text":
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown log format %q, must be 'text' or 'json'", config.Format))
+	}
+
+	return logger, nil
+}
+
+// CorrelationID returns the correlation ID of the request running in ctx, or an empty string outside of a
+// store method.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// withCorrelationID ensures ctx carries a correlation ID, taking it from the incoming gRPC metadata if the
+// caller supplied one.
+func withCorrelationID(ctx context.Context) (context.Context, string) {
+	if id := CorrelationID(ctx); id != "" {
+		return ctx, id
+	}
+
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(CorrelationIDMetadataKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	// a no-op outside of a gRPC call
+	_ = grpc.SetHeader(ctx, metadata.Pairs(CorrelationIDMetadataKey, id))
+	return context.WithValue(ctx, correlationIDKey{}, id), id
+}
+
+// startOperation begins a store method: it starts the method's span and attaches a logger to the returned
+// context that records the operation, correlation ID and the project or entity named by the attributes.
+func (db *DynamoDb) startOperation(ctx context.Context, operation string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	ctx, correlationID := withCorrelationID(ctx)
+
+	fields := logrus.Fields{
+		OperationField:     operation,
+		CorrelationIDField: correlationID,
+	}
+	for _, attribute := range attributes {
+		switch attribute.Key {
+		case ProjectAttribute:
+			fields[ProjectField] = attribute.Value.AsString()
+		case EntityAttribute:
+			fields[EntityField] = attribute.Value.AsString()
+		}
+	}
+	ctx = context.WithValue(ctx, loggerKey{}, db.BaseLogger().WithFields(fields))
+
+	return startSpan(ctx, operation, append(attributes, CorrelationIDAttribute.String(correlationID))...)
+}
+
+// BaseLogger returns the store's Logger, or the standard logrus logger if it has none, for the components that
+// run alongside the store to log with.
+func (db *DynamoDb) BaseLogger() *logrus.Logger {
+	if db.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return db.Logger
+}
+
+// logger returns the logger of the store method running in ctx.
+func (db *DynamoDb) logger(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(db.BaseLogger())
+}
+
+// logError returns a log entry for an error, recording the ID of the AWS request that failed, if any.
+func (db *DynamoDb) logError(ctx context.Context, err error) *logrus.Entry {
+	entry := db.logger(ctx).WithError(err)
+	if requestFailure, ok := err.(awserr.RequestFailure); ok {
+		entry = entry.WithField(AwsRequestIDField, requestFailure.RequestID())
+	}
+	return entry
+}
+
+// logPayload returns a log entry recording a stored payload, which is redacted unless LogPayloads is set.
+func (db *DynamoDb) logPayload(entry *logrus.Entry, payload string) *logrus.Entry {
+	if !db.LogPayloads {
+		return entry.WithField(PayloadField, fmt.Sprintf("[REDACTED %d bytes]", len(payload)))
+	}
+	return entry.WithField(PayloadField, payload)
+}
@@ -0,0 +1,116 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+type failingRouter struct{}
+
+func (failingRouter) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return nil, awserr.NewRequestFailure(awserr.New(dynamodb.ErrCodeInternalServerError, "internal error", nil), 500, "REQUEST-1")
+}
+
+func TestLogEntriesAreCorrelated(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	db := &storage.DynamoDb{
+		TableName:     "test_table",
+		EventualReads: failingRouter{},
+		Logger:        logger,
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(storage.CorrelationIDMetadataKey, "abc-123"))
+	db.ListNotes(ctx, "p1", "", "", 10)
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Level != logrus.ErrorLevel {
+		t.Fatalf("Expected an error to be logged, got %v", entry)
+	}
+	for field, expected := range map[string]string{
+		storage.OperationField:     "ListNotes",
+		storage.ProjectField:       "p1",
+		storage.CorrelationIDField: "abc-123",
+		storage.AwsRequestIDField:  "REQUEST-1",
+	} {
+		if actual := entry.Data[field]; actual != expected {
+			t.Errorf("Got %s = %v, expected %q", field, actual, expected)
+		}
+	}
+}
+
+func TestPayloadsAreRedacted(t *testing.T) {
+	for _, logPayloads := range []bool{false, true} {
+		logger, hook := test.NewNullLogger()
+		client := memdb.New()
+		db := storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+			TableName: "test_table",
+			AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+		})
+		db.Logger = logger
+		db.LogPayloads = logPayloads
+
+		_, err := client.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String("test_table"),
+			Item: map[string]*dynamodb.AttributeValue{
+				storage.PartitionKeyName: {S: aws.String("projects/p1/notes/n1")},
+				storage.SortKeyName:      {S: aws.String(storage.NoteSortKey)},
+				storage.DataKeyName:      {S: aws.String("projects/p1")},
+				storage.JsonKeyName:      {S: aws.String(`{"secret":`)},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected an undecodable note to panic")
+				}
+			}()
+			db.GetNote(context.Background(), "p1", "n1")
+		}()
+
+		expected := "[REDACTED 10 bytes]"
+		if logPayloads {
+			expected = `{"secret":`
+		}
+		entry := hook.LastEntry()
+		if entry == nil || entry.Data[storage.PayloadField] != expected {
+			t.Errorf("Expected payload %q to be logged with LogPayloads %v, got %v", expected, logPayloads, entry)
+		}
+	}
+}
+
+func TestNewLoggerRejectsInvalidConfig(t *testing.T) {
+	for _, c := range []*config.LoggingConfig{
+		{Level: "loud"},
+		{Format: "xml"},
+	} {
+		if _, err := storage.NewLogger(c); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+
+	logger, err := storage.NewLogger(&config.LoggingConfig{Level: "warn", Format: "json"})
+	if err != nil {
+		t.Fatalf("NewLogger failed, %s", err)
+	}
+	if logger.Level != logrus.WarnLevel {
+		t.Errorf("Got level %s, expected warn", logger.Level)
+	}
+	if _, ok := logger.Formatter.(*logrus.JSONFormatter); !ok {
+		t.Errorf("Expected a JSON formatter, got %T", logger.Formatter)
+	}
+}
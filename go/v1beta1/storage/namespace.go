@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/grafeas/grafeas/go/name"
+)
+
+// NamespaceSeparator separates the namespace of a deployment from the rest of a key.
+const NamespaceSeparator = "#"
+
+// NamespacedKey prefixes key with namespace, so that deployments sharing a table never see each other's rows.
+// Keys are returned unchanged if the namespace is empty.
+func NamespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + NamespaceSeparator + key
+}
+
+// StripNamespace removes namespace from a key written with NamespacedKey, reporting false if the key belongs
+// to a different namespace.
+func StripNamespace(namespace, key string) (string, bool) {
+	if namespace == "" {
+		return key, true
+	}
+	if !strings.HasPrefix(key, namespace+NamespaceSeparator) {
+		return key, false
+	}
+	return key[len(namespace)+len(NamespaceSeparator):], true
+}
+
+// validNamespace reports whether namespace can be told apart from the keys it prefixes.
+func validNamespace(namespace string) bool {
+	return !strings.Contains(namespace, NamespaceSeparator) && !strings.Contains(namespace, PaginationString)
+}
+
+// key prefixes a partition key, sort key or Data value with the store's namespace.
+func (db *DynamoDb) key(value string) string {
+	return NamespacedKey(db.Namespace, value)
+}
+
+// unkey removes the store's namespace from a stored key.
+func (db *DynamoDb) unkey(value string) string {
+	value, _ = StripNamespace(db.Namespace, value)
+	return value
+}
+
+// ownsKey reports whether a stored partition key is that of a project, note, occurrence or webhook delivery of
+// the store's namespace.  Without a namespace, the keys of namespaced rows that begin like those of the store's
+// own rows are told apart by the separators that follow.
+func (db *DynamoDb) ownsKey(partitionKey string) bool {
+	key, ok := StripNamespace(db.Namespace, partitionKey)
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(key, WebhookKeyPrefix) {
+		return !strings.ContainsAny(key[len(WebhookKeyPrefix):], NamespaceSeparator+"/")
+	}
+	return strings.HasPrefix(key, name.FormatProject("")) && !strings.Contains(key, NamespaceSeparator)
+}
@@ -0,0 +1,95 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newNamespacedStore(client *memdb.DB, namespace string) *storage.DynamoDb {
+	return storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		Namespace: namespace,
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+}
+
+func TestNamespacesAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	dev := newNamespacedStore(client, "dev")
+	prod := newNamespacedStore(client, "prod")
+
+	if _, err := dev.CreateProject(ctx, "p1", &prpb.Project{Name: "projects/p1"}); err != nil {
+		t.Fatalf("CreateProject failed, %s", err)
+	}
+	for _, nID := range []string{"n1", "n2"} {
+		if _, err := dev.CreateNote(ctx, "p1", nID, "user", &pb.Note{}); err != nil {
+			t.Fatalf("CreateNote failed, %s", err)
+		}
+	}
+	if _, err := dev.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1"}); err != nil {
+		t.Fatalf("CreateOccurrence failed, %s", err)
+	}
+
+	result, err := client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("test_table"),
+		Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String("dev#projects/p1")},
+			storage.SortKeyName:      {S: aws.String("dev#PROJECT")},
+		},
+	})
+	if err != nil || result.Item == nil || aws.StringValue(result.Item[storage.DataKeyName].S) != "dev#projects/p1" {
+		t.Errorf("Expected the project to be stored under namespaced keys, got %v, %v", result, err)
+	}
+
+	if _, err := prod.GetProject(ctx, "p1"); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected the project of another namespace not to be found, got %v", err)
+	}
+	if projects, _, _ := prod.ListProjects(ctx, "", 10, ""); len(projects) != 0 {
+		t.Errorf("Expected no projects, got %v", projects)
+	}
+	if notes, _, _ := prod.ListNotes(ctx, "p1", "", "", 10); len(notes) != 0 {
+		t.Errorf("Expected no notes, got %v", notes)
+	}
+	if occurrences, _, _ := prod.ListOccurrences(ctx, "p1", "", "", 10); len(occurrences) != 0 {
+		t.Errorf("Expected no occurrences, got %v", occurrences)
+	}
+	if occurrences, _, _ := prod.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); len(occurrences) != 0 {
+		t.Errorf("Expected no note occurrences, got %v", occurrences)
+	}
+
+	if _, err := prod.CreateProject(ctx, "p1", &prpb.Project{Name: "projects/p1"}); err != nil {
+		t.Errorf("Expected a project of the same name to be created in another namespace, got %v", err)
+	}
+
+	// a page token of one namespace only resumes listing within the namespace it is used in
+	notes, token, err := dev.ListNotes(ctx, "p1", "", "", 1)
+	if err != nil || len(notes) != 1 || token == "" {
+		t.Fatalf("Expected a page of one note and a token, got %v, %q, %v", notes, token, err)
+	}
+	if notes, _, _ := prod.ListNotes(ctx, "p1", "", token, 10); len(notes) != 0 {
+		t.Errorf("Expected the token not to reach notes of another namespace, got %v", notes)
+	}
+	if notes, _, _ := dev.ListNotes(ctx, "p1", "", token, 10); len(notes) != 1 {
+		t.Errorf("Expected the token to resume listing, got %v", notes)
+	}
+}
+
+func TestInvalidNamespace(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a namespace containing the separator to be rejected")
+		}
+	}()
+	newNamespacedStore(memdb.New(), "dev#1")
+}
@@ -0,0 +1,220 @@
+package storage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+)
+
+// partition returns the sort keys of the rows of an occurrence, other than its revisions.
+func partition(t *testing.T, client *memdb.DB, oName string) []string {
+	result, err := client.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String("test_table"),
+		KeyConditionExpression:    aws.String("#PK = :NAME"),
+		ExpressionAttributeNames:  map[string]*string{"#PK": aws.String(storage.PartitionKeyName)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":NAME": {S: aws.String(oName)}},
+	})
+	if err != nil {
+		t.Fatalf("Query failed, %s", err)
+	}
+	var sortKeys []string
+	for _, item := range result.Items {
+		if sk := aws.StringValue(item[storage.SortKeyName].S); !strings.Contains(sk, "REVISION#") {
+			sortKeys = append(sortKeys, sk)
+		}
+	}
+	return sortKeys
+}
+
+func newOccurrence(t *testing.T, store *storage.DynamoDb) *pb.Occurrence {
+	ctx := context.Background()
+	for _, nID := range []string{"n1", "n2"} {
+		if _, err := store.CreateNote(ctx, "p1", nID, "user", &pb.Note{}); err != nil {
+			t.Fatalf("CreateNote failed, %s", err)
+		}
+	}
+	o, err := store.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1"})
+	if err != nil {
+		t.Fatalf("CreateOccurrence failed, %s", err)
+	}
+	return o
+}
+
+func TestUpdateOccurrenceMovesNoteLink(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := newNamespacedStore(client, "")
+	o := newOccurrence(t, store)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+
+	if _, err := store.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n2"}, nil); err != nil {
+		t.Fatalf("UpdateOccurrence failed, %s", err)
+	}
+
+	if occurrences, _, _ := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); len(occurrences) != 0 {
+		t.Errorf("Expected the occurrence to be unlinked from its old note, got %v", occurrences)
+	}
+	if occurrences, _, _ := store.ListNoteOccurrences(ctx, "p1", "n2", "", "", 10); len(occurrences) != 1 {
+		t.Errorf("Expected the occurrence to be linked to its new note, got %v", occurrences)
+	}
+	if sortKeys := partition(t, client, o.Name); len(sortKeys) != 2 {
+		t.Errorf("Expected only the occurrence and its link, got %v", sortKeys)
+	}
+}
+
+func TestUpdateOccurrenceDeletesStaleLinks(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := newNamespacedStore(client, "")
+	o := newOccurrence(t, store)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+
+	// a link left behind by a move that did not delete it
+	if _, err := client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("test_table"),
+		Item: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String(o.Name)},
+			storage.SortKeyName:      {S: aws.String("projects/p1/notes/n2")},
+			storage.DataKeyName:      {S: aws.String(o.Name)},
+			storage.JsonKeyName:      {S: aws.String(`{"name":"` + o.Name + `"}`)},
+		},
+	}); err != nil {
+		t.Fatalf("PutItem failed, %s", err)
+	}
+
+	if _, err := store.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n1"}, nil); err != nil {
+		t.Fatalf("UpdateOccurrence failed, %s", err)
+	}
+	if occurrences, _, _ := store.ListNoteOccurrences(ctx, "p1", "n2", "", "", 10); len(occurrences) != 0 {
+		t.Errorf("Expected the stale link to be deleted, got %v", occurrences)
+	}
+	if occurrences, _, _ := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); len(occurrences) != 1 {
+		t.Errorf("Expected the occurrence to remain linked to its note, got %v", occurrences)
+	}
+}
+
+func TestDeleteOccurrenceDeletesNoteLinks(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := newNamespacedStore(client, "ns")
+	o := newOccurrence(t, store)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+
+	if _, err := store.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n2"}, nil); err != nil {
+		t.Fatalf("UpdateOccurrence failed, %s", err)
+	}
+	if err := store.DeleteOccurrence(ctx, "p1", oID); err != nil {
+		t.Fatalf("DeleteOccurrence failed, %s", err)
+	}
+
+	if sortKeys := partition(t, client, "ns#"+o.Name); len(sortKeys) != 0 {
+		t.Errorf("Expected every row of the occurrence to be deleted, got %v", sortKeys)
+	}
+	for _, nID := range []string{"n1", "n2"} {
+		if occurrences, _, _ := store.ListNoteOccurrences(ctx, "p1", nID, "", "", 10); len(occurrences) != 0 {
+			t.Errorf("Expected no occurrences of note %s, got %v", nID, occurrences)
+		}
+	}
+}
+
+func TestSlimLinks(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	full := newNamespacedStore(client, "")
+	slim := storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName:       "test_table",
+		OccurrenceLinks: storage.OccurrenceLinksSlim,
+		AWS:             &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+
+	// an occurrence linked before links were slim, and one linked since
+	before := newOccurrence(t, full)
+	after, err := slim.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1", Remediation: "upgrade"})
+	if err != nil {
+		t.Fatalf("CreateOccurrence failed, %s", err)
+	}
+
+	result, err := client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("test_table"),
+		Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String(after.Name)},
+			storage.SortKeyName:      {S: aws.String("projects/p1/notes/n1")},
+		},
+	})
+	if err != nil || result.Item == nil {
+		t.Fatalf("Expected the occurrence to be linked to its note, got %v, %v", result, err)
+	}
+	if _, ok := result.Item[storage.JsonKeyName]; ok {
+		t.Errorf("Expected a slim link to leave out the occurrence, got %v", result.Item)
+	}
+
+	occurrences, _, err := slim.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10)
+	if err != nil {
+		t.Fatalf("ListNoteOccurrences failed, %s", err)
+	}
+	listed := map[string]*pb.Occurrence{}
+	for _, o := range occurrences {
+		listed[o.Name] = o
+	}
+	if len(listed) != 2 || listed[before.Name] == nil || listed[after.Name] == nil || listed[after.Name].Remediation != "upgrade" {
+		t.Errorf("Expected both occurrences to be listed whole, got %v", occurrences)
+	}
+
+	// a slim link whose occurrence has gone is left out
+	if _, err := client.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("test_table"),
+		Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String(after.Name)},
+			storage.SortKeyName:      {S: aws.String(storage.OccurrenceSortKey)},
+		},
+	}); err != nil {
+		t.Fatalf("DeleteItem failed, %s", err)
+	}
+	if occurrences, _, _ := slim.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); len(occurrences) != 1 || occurrences[0].Name != before.Name {
+		t.Errorf("Expected only the remaining occurrence to be listed, got %v", occurrences)
+	}
+}
+
+func TestSlimLinkToMovedOccurrence(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName:       "test_table",
+		OccurrenceLinks: storage.OccurrenceLinksSlim,
+		AWS:             &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	o := newOccurrence(t, store)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+
+	link, err := client.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("test_table"),
+		Key: map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String(o.Name)},
+			storage.SortKeyName:      {S: aws.String("projects/p1/notes/n1")},
+		},
+	})
+	if err != nil || link.Item == nil {
+		t.Fatalf("Expected the occurrence to be linked to its note, got %v, %v", link, err)
+	}
+	if _, err := store.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n2"}, nil); err != nil {
+		t.Fatalf("UpdateOccurrence failed, %s", err)
+	}
+
+	// the link to the old note, as read by a listing that raced the move
+	if _, err := client.PutItem(&dynamodb.PutItemInput{TableName: aws.String("test_table"), Item: link.Item}); err != nil {
+		t.Fatalf("PutItem failed, %s", err)
+	}
+	if occurrences, _, err := store.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10); err != nil || len(occurrences) != 0 {
+		t.Errorf("Expected the moved occurrence not to be listed under its old note, got %v, %v", occurrences, err)
+	}
+	if occurrences, _, err := store.ListNoteOccurrences(ctx, "p1", "n2", "", "", 10); err != nil || len(occurrences) != 1 || occurrences[0].Name != o.Name {
+		t.Errorf("Expected the moved occurrence to be listed under its new note, got %v, %v", occurrences, err)
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	tspb "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/grafeas/grafeas/go/name"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+)
+
+// RestoreItems returns the rows that hold a project, note or occurrence restored from a backup or another
+// store, laid out as CreateProject, CreateNote and CreateOccurrence lay them out: an occurrence has its own row
+// and the row linking it to its note, slim or not as the store is configured.  Unlike the create methods, the
+// resource keeps its name and its create and update times, which are also recorded as the audit times of the
+// rows.  Resources without a create time, which includes every project, are recorded as created now.  The
+// rows are at version 1; a note or occurrence whose name has revisions left by a deleted one needs the version
+// from FirstVersion instead.  The first row returned is the resource's own.
+func (db *DynamoDb) RestoreItems(resource proto.Message, userID string, now time.Time) ([]DataItem, error) {
+	m := jsonpb.Marshaler{}
+	jsonObject, err := m.MarshalToString(resource)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to marshal resource into json, %s", err))
+	}
+
+	var items []DataItem
+	switch r := resource.(type) {
+	case *prpb.Project:
+		if _, err := name.ParseProject(r.Name); err != nil {
+			return nil, err
+		}
+		item := DataItem{
+			PartitionKey: db.key(r.Name),
+			SortKey:      db.key(projectSK),
+			Data:         db.key(r.Name),
+		}
+		items = append(items, item)
+
+	case *pb.Note:
+		pID, _, err := name.ParseNote(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		item := DataItem{
+			PartitionKey: db.key(r.Name),
+			SortKey:      db.key(noteSK),
+			Data:         db.key(pID),
+		}
+		if err := stampRestored(&item, userID, r.CreateTime, r.UpdateTime); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+	case *pb.Occurrence:
+		pID, _, err := name.ParseOccurrence(r.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := name.ParseNote(r.NoteName); err != nil {
+			return nil, err
+		}
+		item := DataItem{
+			PartitionKey: db.key(r.Name),
+			SortKey:      db.key(occurrenceSK),
+			Data:         db.key(pID),
+		}
+		if err := stampRestored(&item, userID, r.CreateTime, r.UpdateTime); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+	default:
+		return nil, errors.New(fmt.Sprintf("Unable to restore %T, only projects, notes and occurrences can be restored", resource))
+	}
+
+	for i := range items {
+		items[i].Version = 1
+		if items[i].CreatedAt == "" {
+			stampCreated(&items[i], userID, now)
+		}
+		if err := db.sealPayload(&items[i], jsonObject); err != nil {
+			return nil, err
+		}
+	}
+	if o, ok := resource.(*pb.Occurrence); ok {
+		items = append(items, db.noteLinkItem(&items[0], o.NoteName))
+	}
+	return items, nil
+}
+
+// stampRestored records the create and update times of a restored resource as the audit times of its row,
+// leaving them unset if the resource has no create time.
+func stampRestored(dataItem *DataItem, userID string, createTime, updateTime *tspb.Timestamp) error {
+	if createTime == nil {
+		return nil
+	}
+	created, err := ptypes.Timestamp(createTime)
+	if err != nil {
+		return err
+	}
+	stampCreated(dataItem, userID, created)
+
+	if updateTime != nil {
+		updated, err := ptypes.Timestamp(updateTime)
+		if err != nil {
+			return err
+		}
+		dataItem.UpdatedAt = updated.UTC().Format(auditTimeFormat)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/grafeas/grafeas/go/name"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// revisionSKPrefix prefixes the sort key of revision rows, followed by the zero padded revision number so
+	// that revisions of an entity sort in order.
+	revisionSKPrefix = "REVISION#"
+
+	// RevisionOperationUpdate and RevisionOperationDelete record why a revision was written.
+	RevisionOperationUpdate = "UPDATE"
+	RevisionOperationDelete = "DELETE"
+)
+
+// RevisionItem is an immutable snapshot of a note or occurrence, written whenever that entity is updated or
+// deleted.  The embedded DataItem is the entity as it was stored before the change, with the sort key replaced
+// by the revision key and Data holding the time of the change.
+type RevisionItem struct {
+	DataItem
+	Operation string
+	RevisedBy string
+	RevisedAt string
+	ExpiresAt int64 `dynamodbav:",omitempty"`
+}
+
+// Revision is a previous state of a note or occurrence.  Exactly one of Note and Occurrence is set.
+type Revision struct {
+	Name       string
+	Revision   int64
+	Operation  string
+	RevisedBy  string
+	RevisedAt  time.Time
+	Note       *pb.Note
+	Occurrence *pb.Occurrence
+}
+
+func revisionSK(revision int64) string {
+	return fmt.Sprintf("%s%019d", revisionSKPrefix, revision)
+}
+
+// enableRevisionExpiry turns on DynamoDB TTL for revision rows if a retention period is configured.
+func enableRevisionExpiry(dynamoDb dynamodbiface.DynamoDBAPI, tableName string) error {
+	_, err := dynamoDb.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(ExpiresAtKeyName),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ValidationException" && strings.Contains(awsErr.Message(), "already enabled") {
+		return nil
+	}
+	return err
+}
+
+// revisionPut returns a transaction item that writes the stored state of an entity as a revision row.  The
+// write fails if that revision already exists, so revisions are never overwritten.
+func (db *DynamoDb) revisionPut(current *DataItem, operation string, userID string, now time.Time) (*dynamodb.TransactWriteItem, error) {
+	revisionItem := RevisionItem{
+		DataItem:  *current,
+		Operation: operation,
+		RevisedBy: userID,
+		RevisedAt: now.UTC().Format(auditTimeFormat),
+	}
+	revisionItem.SortKey = db.key(revisionSK(current.Version))
+	revisionItem.Data = revisionItem.RevisedAt
+
+	if db.RevisionRetention > 0 {
+		revisionItem.ExpiresAt = now.Add(db.RevisionRetention).Unix()
+	}
+
+	av, err := dynamodbattribute.MarshalMap(revisionItem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			Item:                av,
+			TableName:           aws.String(db.TableName),
+			ConditionExpression: aws.String(fmt.Sprintf("attribute_not_exists(%s) AND attribute_not_exists(%s)", PartitionKeyName, SortKeyName)),
+		},
+	}, nil
+}
+
+// FirstVersion returns the version to give a note or occurrence created with the given name.  Revisions of an
+// earlier entity of the same name outlive its deletion, so the new entity carries on from the last of them
+// rather than starting again at 1 and colliding with their keys.  Revisions are read whether they have expired
+// or not, as DynamoDB may not have removed them yet.
+func (db *DynamoDb) FirstVersion(ctx context.Context, entityName string) (int64, error) {
+	result, err := db.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName: aws.String(db.TableName),
+		ExpressionAttributeNames: map[string]*string{
+			"#PARTITION_KEY": aws.String(PartitionKeyName),
+			"#SORT_KEY":      aws.String(SortKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":NAME": {
+				S: aws.String(db.key(entityName)),
+			},
+			":REVISION": {
+				S: aws.String(db.key(revisionSKPrefix)),
+			},
+		},
+		KeyConditionExpression: aws.String("#PARTITION_KEY=:NAME AND begins_with(#SORT_KEY, :REVISION)"),
+		ProjectionExpression:   aws.String("#SORT_KEY"),
+		ScanIndexForward:       aws.Bool(false),
+		Limit:                  aws.Int64(1),
+		ConsistentRead:         aws.Bool(true),
+	})
+	if err != nil {
+		db.logError(ctx, err).WithField(EntityField, entityName).Error("Error when reading last revision")
+		return 0, status.Errorf(codes.Internal, "Failed to read revisions of %s", entityName)
+	}
+	if len(result.Items) == 0 {
+		return 1, nil
+	}
+
+	sk := db.unkey(aws.StringValue(result.Items[0][SortKeyName].S))
+	last, err := strconv.ParseInt(strings.TrimPrefix(sk, revisionSKPrefix), 10, 64)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "Invalid revision key %q", sk)
+	}
+	return last + 1, nil
+}
+
+// ListRevisions lists the revisions of the note or occurrence with the given name, oldest first.
+func (db *DynamoDb) ListRevisions(ctx context.Context, entityName string, pageSize int32, pageToken string) ([]*Revision, string, error) {
+	ctx, span := db.startOperation(ctx, "ListRevisions", EntityAttribute.String(entityName))
+	defer span.End()
+
+	var revisions []*Revision
+
+	queryInput := dynamodb.QueryInput{
+		TableName: aws.String(db.TableName),
+		ExpressionAttributeNames: map[string]*string{
+			"#PARTITION_KEY": aws.String(PartitionKeyName),
+			"#SORT_KEY":      aws.String(SortKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":NAME": {
+				S: aws.String(db.key(entityName)),
+			},
+			":REVISION": {
+				S: aws.String(db.key(revisionSKPrefix)),
+			},
+		},
+		KeyConditionExpression: aws.String("#PARTITION_KEY=:NAME AND begins_with(#SORT_KEY, :REVISION)"),
+		Limit:                  aws.Int64(int64(pageSize)),
+	}
+
+	if pageToken != "" {
+		queryInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			PartitionKeyName: {
+				S: aws.String(db.key(entityName)),
+			},
+			SortKeyName: {
+				S: aws.String(db.key(pageToken)),
+			},
+		}
+	}
+
+	result, err := db.QueryWithContext(ctx, &queryInput)
+	if err != nil {
+		db.logError(ctx, err).Error("Error when listing revisions")
+		return nil, "", status.Error(codes.Internal, "Failed to list revisions")
+	}
+
+	now := time.Now().Unix()
+	for _, item := range result.Items {
+		revisionItem := RevisionItem{}
+		err = dynamodbattribute.UnmarshalMap(item, &revisionItem)
+		if err != nil {
+			db.logError(ctx, err).Panic("Failed to unmarshal item")
+		}
+
+		// expired rows are removed by DynamoDB in the background, so may still be returned for a while
+		if revisionItem.ExpiresAt != 0 && revisionItem.ExpiresAt <= now {
+			continue
+		}
+
+		revision, err := db.newRevision(ctx, &revisionItem)
+		if err != nil {
+			return nil, "", err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	var token = ""
+	if result.LastEvaluatedKey != nil {
+		token = db.unkey(*result.LastEvaluatedKey[SortKeyName].S)
+	}
+
+	return revisions, token, nil
+}
+
+// GetRevision gets a single revision of the note or occurrence with the given name.
+func (db *DynamoDb) GetRevision(ctx context.Context, entityName string, revision int64) (*Revision, error) {
+	ctx, span := db.startOperation(ctx, "GetRevision", EntityAttribute.String(entityName))
+	defer span.End()
+
+	result, err := db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(db.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PartitionKeyName: {
+				S: aws.String(db.key(entityName)),
+			},
+			SortKeyName: {
+				S: aws.String(db.key(revisionSK(revision))),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		db.logError(ctx, err).WithField("revision", revision).Error("Error when seeking revision")
+		return nil, status.Error(codes.Internal, "Failed to get revision")
+	}
+
+	revisionItem := RevisionItem{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &revisionItem)
+	if err != nil {
+		db.logError(ctx, err).Panic("Failed to unmarshal item")
+	}
+
+	if revisionItem.PartitionKey == "" || (revisionItem.ExpiresAt != 0 && revisionItem.ExpiresAt <= time.Now().Unix()) {
+		return nil, status.Errorf(codes.NotFound, "Revision %d of %q does not exist", revision, entityName)
+	}
+
+	return db.newRevision(ctx, &revisionItem)
+}
+
+func (db *DynamoDb) newRevision(ctx context.Context, revisionItem *RevisionItem) (*Revision, error) {
+	revisedAt, _ := time.Parse(auditTimeFormat, revisionItem.RevisedAt)
+	entityName := db.unkey(revisionItem.PartitionKey)
+	number, err := strconv.ParseInt(strings.TrimPrefix(db.unkey(revisionItem.SortKey), revisionSKPrefix), 10, 64)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Invalid revision key %q", revisionItem.SortKey)
+	}
+
+	revision := &Revision{
+		Name:      entityName,
+		Revision:  number,
+		Operation: revisionItem.Operation,
+		RevisedBy: revisionItem.RevisedBy,
+		RevisedAt: revisedAt,
+	}
+
+	if _, _, err := name.ParseOccurrence(entityName); err == nil {
+		revision.Occurrence = &pb.Occurrence{}
+		err = db.unmarshalPayload(&revisionItem.DataItem, revision.Occurrence)
+	} else {
+		revision.Note = &pb.Note{}
+		err = db.unmarshalPayload(&revisionItem.DataItem, revision.Note)
+	}
+	if err != nil {
+		db.logError(ctx, err).WithFields(logrus.Fields{EntityField: entityName, "revision": number}).Error("Failed to decode revision")
+		return nil, status.Error(codes.Internal, "Failed to decode revision")
+	}
+
+	return revision, nil
+}
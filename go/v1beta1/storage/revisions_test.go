@@ -0,0 +1,207 @@
+package storage_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRevisionsOfUpdatesAndDeletes(t *testing.T) {
+	ctx := context.Background()
+	store := newNamespacedStore(memdb.New(), "ns")
+	store.EndUserID = func(ctx context.Context) string { return "bob" }
+	o := newOccurrence(t, store)
+	oID := o.Name[strings.LastIndex(o.Name, "/")+1:]
+
+	if _, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{ShortDescription: "second"}, nil); err != nil {
+		t.Fatalf("UpdateNote failed, %s", err)
+	}
+	if err := store.DeleteNote(ctx, "p1", "n1"); err != nil {
+		t.Fatalf("DeleteNote failed, %s", err)
+	}
+	if _, err := store.UpdateOccurrence(ctx, "p1", oID, &pb.Occurrence{NoteName: "projects/p1/notes/n2"}, nil); err != nil {
+		t.Fatalf("UpdateOccurrence failed, %s", err)
+	}
+	if err := store.DeleteOccurrence(ctx, "p1", oID); err != nil {
+		t.Fatalf("DeleteOccurrence failed, %s", err)
+	}
+
+	// each revision is the version that was replaced
+	notes, _, err := store.ListRevisions(ctx, "projects/p1/notes/n1", 10, "")
+	if err != nil {
+		t.Fatalf("ListRevisions failed, %s", err)
+	}
+	if len(notes) != 2 ||
+		notes[0].Revision != 1 || notes[0].Operation != storage.RevisionOperationUpdate || notes[0].Note.GetShortDescription() != "" ||
+		notes[1].Revision != 2 || notes[1].Operation != storage.RevisionOperationDelete || notes[1].Note.GetShortDescription() != "second" {
+		t.Errorf("Expected revisions 1 and 2 of the note, updated then deleted, got %v", notes)
+	}
+	for _, revision := range notes {
+		if revision.Name != "projects/p1/notes/n1" || revision.RevisedBy != "bob" || revision.RevisedAt.IsZero() || revision.Occurrence != nil {
+			t.Errorf("Expected a note revision made by bob, got %+v", revision)
+		}
+	}
+
+	occurrences, _, err := store.ListRevisions(ctx, o.Name, 10, "")
+	if err != nil {
+		t.Fatalf("ListRevisions failed, %s", err)
+	}
+	if len(occurrences) != 2 ||
+		occurrences[0].Revision != 1 || occurrences[0].Occurrence.GetNoteName() != "projects/p1/notes/n1" ||
+		occurrences[1].Revision != 2 || occurrences[1].Occurrence.GetNoteName() != "projects/p1/notes/n2" || occurrences[1].Operation != storage.RevisionOperationDelete {
+		t.Errorf("Expected revisions 1 and 2 of the occurrence, moved then deleted, got %v", occurrences)
+	}
+
+	revision, err := store.GetRevision(ctx, o.Name, 1)
+	if err != nil || revision.Occurrence.GetNoteName() != "projects/p1/notes/n1" || revision.Operation != storage.RevisionOperationUpdate {
+		t.Errorf("Expected the first revision of the occurrence, got %v, %v", revision, err)
+	}
+	if _, err := store.GetRevision(ctx, o.Name, 3); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected a revision that was never written not to be found, got %v", err)
+	}
+}
+
+func TestRevisionsOfRecreatedNote(t *testing.T) {
+	ctx := context.Background()
+	store := newNamespacedStore(memdb.New(), "")
+	if _, err := store.CreateNote(ctx, "p1", "n1", "user", &pb.Note{ShortDescription: "first"}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	if err := store.DeleteNote(ctx, "p1", "n1"); err != nil {
+		t.Fatalf("DeleteNote failed, %s", err)
+	}
+
+	// the note created again carries on from the revisions of the deleted one, so can be updated and deleted
+	if _, err := store.CreateNote(ctx, "p1", "n1", "user", &pb.Note{ShortDescription: "second"}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	if _, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{ShortDescription: "third"}, nil); err != nil {
+		t.Fatalf("Expected the recreated note to be updated, got %s", err)
+	}
+	if err := store.DeleteNote(ctx, "p1", "n1"); err != nil {
+		t.Fatalf("Expected the recreated note to be deleted, got %s", err)
+	}
+
+	revisions, _, err := store.ListRevisions(ctx, "projects/p1/notes/n1", 10, "")
+	if err != nil {
+		t.Fatalf("ListRevisions failed, %s", err)
+	}
+	var descriptions []string
+	for i, revision := range revisions {
+		if revision.Revision != int64(i+1) {
+			t.Errorf("Expected revision %d, got %d", i+1, revision.Revision)
+		}
+		descriptions = append(descriptions, revision.Operation+" "+revision.Note.GetShortDescription())
+	}
+	if fmt.Sprint(descriptions) != "[DELETE first UPDATE second DELETE third]" {
+		t.Errorf("Expected the revisions of both notes in order, got %v", descriptions)
+	}
+}
+
+func TestListRevisionsPages(t *testing.T) {
+	ctx := context.Background()
+	store := newNamespacedStore(memdb.New(), "")
+	if _, err := store.CreateNote(ctx, "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{ShortDescription: fmt.Sprint(i)}, nil); err != nil {
+			t.Fatalf("UpdateNote failed, %s", err)
+		}
+	}
+
+	var numbers []int64
+	token := ""
+	for pages := 1; ; pages++ {
+		revisions, next, err := store.ListRevisions(ctx, "projects/p1/notes/n1", 2, token)
+		if err != nil {
+			t.Fatalf("ListRevisions failed, %s", err)
+		}
+		if len(revisions) > 2 {
+			t.Errorf("Expected pages of at most 2 revisions, got %d", len(revisions))
+		}
+		for _, revision := range revisions {
+			numbers = append(numbers, revision.Revision)
+		}
+		if next == "" || pages > 5 {
+			break
+		}
+		token = next
+	}
+	if fmt.Sprint(numbers) != "[1 2 3 4 5]" {
+		t.Errorf("Expected revisions 1 to 5 in order, got %v", numbers)
+	}
+}
+
+func TestRevisionRetention(t *testing.T) {
+	ctx := context.Background()
+	client := memdb.New()
+	store := storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		Revisions: &config.RevisionsConfig{Retention: "24h"},
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	if _, err := store.CreateNote(ctx, "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := store.UpdateNote(ctx, "p1", "n1", &pb.Note{}, nil); err != nil {
+			t.Fatalf("UpdateNote failed, %s", err)
+		}
+	}
+
+	key := func(revision int) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			storage.PartitionKeyName: {S: aws.String("projects/p1/notes/n1")},
+			storage.SortKeyName:      {S: aws.String(fmt.Sprintf("REVISION#%019d", revision))},
+		}
+	}
+	result, err := client.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test_table"), Key: key(1)})
+	if err != nil || result.Item == nil {
+		t.Fatalf("Expected revision 1 to be stored, got %v, %v", result, err)
+	}
+	expiresAt, err := strconv.ParseInt(aws.StringValue(result.Item[storage.ExpiresAtKeyName].N), 10, 64)
+	if want := time.Now().Add(24 * time.Hour).Unix(); err != nil || expiresAt < want-60 || expiresAt > want {
+		t.Errorf("Expected revision 1 to expire in 24 hours, got %v, %v", result.Item[storage.ExpiresAtKeyName], err)
+	}
+
+	// expired revisions are left out until DynamoDB deletes them
+	result.Item[storage.ExpiresAtKeyName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))}
+	if _, err := client.PutItem(&dynamodb.PutItemInput{TableName: aws.String("test_table"), Item: result.Item}); err != nil {
+		t.Fatalf("PutItem failed, %s", err)
+	}
+	if revisions, _, err := store.ListRevisions(ctx, "projects/p1/notes/n1", 10, ""); err != nil || len(revisions) != 1 || revisions[0].Revision != 2 {
+		t.Errorf("Expected only revision 2 to be listed, got %v, %v", revisions, err)
+	}
+	if _, err := store.GetRevision(ctx, "projects/p1/notes/n1", 1); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected an expired revision not to be found, got %v", err)
+	}
+
+	// without a retention period, revisions are kept forever
+	forever := newNamespacedStore(client, "forever")
+	if _, err := forever.CreateNote(ctx, "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	if _, err := forever.UpdateNote(ctx, "p1", "n1", &pb.Note{}, nil); err != nil {
+		t.Fatalf("UpdateNote failed, %s", err)
+	}
+	result, err = client.GetItem(&dynamodb.GetItemInput{TableName: aws.String("test_table"), Key: map[string]*dynamodb.AttributeValue{
+		storage.PartitionKeyName: {S: aws.String("forever#projects/p1/notes/n1")},
+		storage.SortKeyName:      {S: aws.String(fmt.Sprintf("forever#REVISION#%019d", 1))},
+	}})
+	if err != nil || result.Item == nil || result.Item[storage.ExpiresAtKeyName] != nil {
+		t.Errorf("Expected a revision without expiry, got %v, %v", result, err)
+	}
+}
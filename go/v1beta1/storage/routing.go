@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafeas/grafeas/go/name"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"golang.org/x/net/context"
+	fieldmaskpb "google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultTarget names the table configured at the top level of DynamoDbConfig, which stores the projects that
+// no route matches.
+const DefaultTarget = "default"
+
+// targetTokenSeparator separates the target from the page token of that target in the page tokens of methods
+// that list across targets.
+const targetTokenSeparator = "|"
+
+// Target is a table that projects can be routed to, together with the configuration of its store.
+type Target struct {
+	Name   string
+	Config *config.DynamoDbConfig
+}
+
+// Targets returns the default target followed by each configured target.  The configuration of a target is
+// that of the top level, with the table, AWS settings and encryption replaced by those of the target.
+func Targets(storeConfig *config.DynamoDbConfig) ([]Target, error) {
+	defaultConfig := *storeConfig
+	defaultConfig.Targets = nil
+	defaultConfig.Routes = nil
+	targets := []Target{{Name: DefaultTarget, Config: &defaultConfig}}
+
+	names := map[string]bool{DefaultTarget: true}
+	for _, targetConfig := range storeConfig.Targets {
+		if targetConfig.Name == "" || strings.Contains(targetConfig.Name, targetTokenSeparator) {
+			return nil, errors.New(fmt.Sprintf("Invalid target name %q, must be non-empty and not contain %q", targetConfig.Name, targetTokenSeparator))
+		}
+		if names[targetConfig.Name] {
+			return nil, errors.New(fmt.Sprintf("Duplicate target name %s", targetConfig.Name))
+		}
+		names[targetConfig.Name] = true
+		if targetConfig.TableName == "" {
+			return nil, errors.New(fmt.Sprintf("Target %s has no table", targetConfig.Name))
+		}
+
+		c := defaultConfig
+		c.TableName = targetConfig.TableName
+		if targetConfig.AWS != nil {
+			c.AWS = targetConfig.AWS
+		}
+		if targetConfig.Encryption != nil {
+			c.Encryption = targetConfig.Encryption
+		}
+		targets = append(targets, Target{Name: targetConfig.Name, Config: &c})
+	}
+
+	return targets, nil
+}
+
+type route struct {
+	target  string
+	prefix  string
+	pattern *regexp.Regexp
+}
+
+func (r *route) matches(pID string) bool {
+	if r.pattern != nil {
+		return r.pattern.MatchString(pID)
+	}
+	return strings.HasPrefix(pID, r.prefix)
+}
+
+// Router stores each project in the table of the first route that matches it, or in the default table.  Methods
+// that list across projects, ListProjects and ListNoteOccurrences, list every table in turn, so their page
+// tokens name the table to resume from.
+type Router struct {
+	stores map[string]*DynamoDb
+	order  []string
+	routes []route
+}
+
+// NewRouter creates the store of each target with newStore, and routes projects between them as configured.
+func NewRouter(storeConfig *config.DynamoDbConfig, newStore func(target string, targetConfig *config.DynamoDbConfig) *DynamoDb) (*Router, error) {
+	targets, err := Targets(storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Router{stores: map[string]*DynamoDb{}}
+	for _, target := range targets {
+		r.order = append(r.order, target.Name)
+	}
+
+	for i, routeConfig := range storeConfig.Routes {
+		if _, ok := findTarget(targets, routeConfig.Target); !ok {
+			return nil, errors.New(fmt.Sprintf("Route %d has unknown target %q", i, routeConfig.Target))
+		}
+		if (routeConfig.ProjectPrefix == "") == (routeConfig.ProjectRegex == "") {
+			return nil, errors.New(fmt.Sprintf("Route %d must have exactly one of project_prefix and project_regex", i))
+		}
+
+		rt := route{target: routeConfig.Target, prefix: routeConfig.ProjectPrefix}
+		if routeConfig.ProjectRegex != "" {
+			rt.pattern, err = regexp.Compile(routeConfig.ProjectRegex)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to parse project_regex of route %d, %s", i, err))
+			}
+		}
+		r.routes = append(r.routes, rt)
+	}
+
+	for _, target := range targets {
+		r.stores[target.Name] = newStore(target.Name, target.Config)
+	}
+
+	return r, nil
+}
+
+func findTarget(targets []Target, name string) (Target, bool) {
+	for _, target := range targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+// Store returns the store of the named target, or nil if there is none.
+func (r *Router) Store(target string) *DynamoDb {
+	return r.stores[target]
+}
+
+// Stores returns the store of every target, the default first.
+func (r *Router) Stores() []*DynamoDb {
+	var stores []*DynamoDb
+	for _, target := range r.order {
+		stores = append(stores, r.stores[target])
+	}
+	return stores
+}
+
+// TargetOf returns the name of the target that stores the project.
+func (r *Router) TargetOf(pID string) string {
+	for _, rt := range r.routes {
+		if rt.matches(pID) {
+			return rt.target
+		}
+	}
+	return DefaultTarget
+}
+
+func (r *Router) route(pID string) *DynamoDb {
+	return r.stores[r.TargetOf(pID)]
+}
+
+// listPage lists a page of at most pageSize items from a single store, returning the number of items listed and
+// the store's next page token.
+type listPage func(db *DynamoDb, pageToken string, pageSize int) (int, string, error)
+
+// listAll lists the stores in turn, starting from the position in pageToken, until pageSize items have been
+// listed or a store has more items than fit in the page.
+func (r *Router) listAll(pageToken string, pageSize int, list listPage) (string, error) {
+	start, storeToken := 0, ""
+	if pageToken != "" {
+		tokenArray := strings.SplitN(pageToken, targetTokenSeparator, 2)
+		if len(tokenArray) != 2 {
+			return "", status.Error(codes.InvalidArgument, "Invalid page token")
+		}
+		for start < len(r.order) && r.order[start] != tokenArray[0] {
+			start++
+		}
+		if start == len(r.order) {
+			return "", status.Error(codes.InvalidArgument, "Invalid page token")
+		}
+		storeToken = tokenArray[1]
+	}
+
+	remaining := pageSize
+	for i := start; i < len(r.order); i++ {
+		listed, next, err := list(r.stores[r.order[i]], storeToken, remaining)
+		if err != nil {
+			return "", err
+		}
+		if next != "" {
+			return r.order[i] + targetTokenSeparator + next, nil
+		}
+		storeToken = ""
+
+		remaining -= listed
+		if pageSize > 0 && remaining <= 0 {
+			if i+1 < len(r.order) {
+				return r.order[i+1] + targetTokenSeparator, nil
+			}
+			return "", nil
+		}
+	}
+
+	return "", nil
+}
+
+// CreateProject creates a project in the table it is routed to.
+func (r *Router) CreateProject(ctx context.Context, pID string, p *prpb.Project) (*prpb.Project, error) {
+	return r.route(pID).CreateProject(ctx, pID, p)
+}
+
+// GetProject gets a project from the table it is routed to.
+func (r *Router) GetProject(ctx context.Context, pID string) (*prpb.Project, error) {
+	return r.route(pID).GetProject(ctx, pID)
+}
+
+// ListProjects lists the projects of every table.
+func (r *Router) ListProjects(ctx context.Context, filter string, pageSize int, pageToken string) ([]*prpb.Project, string, error) {
+	var projects []*prpb.Project
+	token, err := r.listAll(pageToken, pageSize, func(db *DynamoDb, pageToken string, pageSize int) (int, string, error) {
+		page, next, err := db.ListProjects(ctx, filter, pageSize, pageToken)
+		projects = append(projects, page...)
+		return len(page), next, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return projects, token, nil
+}
+
+// DeleteProject deletes a project from the table it is routed to.
+func (r *Router) DeleteProject(ctx context.Context, pID string) error {
+	return r.route(pID).DeleteProject(ctx, pID)
+}
+
+// GetOccurrence gets an occurrence from the table of its project.
+func (r *Router) GetOccurrence(ctx context.Context, projectId, occId string) (*pb.Occurrence, error) {
+	return r.route(projectId).GetOccurrence(ctx, projectId, occId)
+}
+
+// ListOccurrences lists the occurrences of a project from the table of that project.
+func (r *Router) ListOccurrences(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	return r.route(projectId).ListOccurrences(ctx, projectId, filter, pageToken, pageSize)
+}
+
+// CreateOccurrence creates an occurrence in the table of its project.
+func (r *Router) CreateOccurrence(ctx context.Context, projectId, userID string, o *pb.Occurrence) (*pb.Occurrence, error) {
+	return r.route(projectId).CreateOccurrence(ctx, projectId, userID, o)
+}
+
+// BatchCreateOccurrences creates occurrences in the table of their project.
+func (r *Router) BatchCreateOccurrences(ctx context.Context, projectId string, userID string, occs []*pb.Occurrence) ([]*pb.Occurrence, []error) {
+	return r.route(projectId).BatchCreateOccurrences(ctx, projectId, userID, occs)
+}
+
+// UpdateOccurrence updates an occurrence in the table of its project.
+func (r *Router) UpdateOccurrence(ctx context.Context, projectId, occId string, o *pb.Occurrence, mask *fieldmaskpb.FieldMask) (*pb.Occurrence, error) {
+	return r.route(projectId).UpdateOccurrence(ctx, projectId, occId, o, mask)
+}
+
+// DeleteOccurrence deletes an occurrence from the table of its project.
+func (r *Router) DeleteOccurrence(ctx context.Context, projectId, occId string) error {
+	return r.route(projectId).DeleteOccurrence(ctx, projectId, occId)
+}
+
+// GetNote gets a note from the table of its project.
+func (r *Router) GetNote(ctx context.Context, projectId, nID string) (*pb.Note, error) {
+	return r.route(projectId).GetNote(ctx, projectId, nID)
+}
+
+// ListNotes lists the notes of a project from the table of that project.
+func (r *Router) ListNotes(ctx context.Context, projectId, filter, pageToken string, pageSize int32) ([]*pb.Note, string, error) {
+	return r.route(projectId).ListNotes(ctx, projectId, filter, pageToken, pageSize)
+}
+
+// CreateNote creates a note in the table of its project.
+func (r *Router) CreateNote(ctx context.Context, projectId, nID string, userID string, n *pb.Note) (*pb.Note, error) {
+	return r.route(projectId).CreateNote(ctx, projectId, nID, userID, n)
+}
+
+// BatchCreateNotes creates notes in the table of their project.
+func (r *Router) BatchCreateNotes(ctx context.Context, projectId string, userID string, notes map[string]*pb.Note) ([]*pb.Note, []error) {
+	return r.route(projectId).BatchCreateNotes(ctx, projectId, userID, notes)
+}
+
+// UpdateNote updates a note in the table of its project.
+func (r *Router) UpdateNote(ctx context.Context, projectId, nID string, n *pb.Note, mask *fieldmaskpb.FieldMask) (*pb.Note, error) {
+	return r.route(projectId).UpdateNote(ctx, projectId, nID, n, mask)
+}
+
+// DeleteNote deletes a note from the table of its project.
+func (r *Router) DeleteNote(ctx context.Context, projectId, nID string) error {
+	return r.route(projectId).DeleteNote(ctx, projectId, nID)
+}
+
+// GetOccurrenceNote gets the note of an occurrence, which may be in a different table to the occurrence.
+func (r *Router) GetOccurrenceNote(ctx context.Context, projectId, oID string) (*pb.Note, error) {
+	o, err := r.GetOccurrence(withoutEtag(ctx), projectId, oID)
+	if err != nil {
+		return nil, err
+	}
+	nPID, nID, err := name.ParseNote(o.NoteName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Invalid note name %q", o.NoteName)
+	}
+	return r.GetNote(ctx, nPID, nID)
+}
+
+// ListNoteOccurrences lists the occurrences of a note in every table, as occurrences may be stored in a
+// different table to their note.
+func (r *Router) ListNoteOccurrences(ctx context.Context, nPID, nID, filter, pageToken string, pageSize int32) ([]*pb.Occurrence, string, error) {
+	var occurrences []*pb.Occurrence
+	token, err := r.listAll(pageToken, int(pageSize), func(db *DynamoDb, pageToken string, pageSize int) (int, string, error) {
+		page, next, err := db.ListNoteOccurrences(ctx, nPID, nID, filter, pageToken, int32(pageSize))
+		occurrences = append(occurrences, page...)
+		return len(page), next, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return occurrences, token, nil
+}
+
+// GetVulnerabilityOccurrencesSummary summarises the vulnerability occurrences of a project from the table of
+// that project.
+func (r *Router) GetVulnerabilityOccurrencesSummary(ctx context.Context, projectId, filter string) (*pb.VulnerabilityOccurrencesSummary, error) {
+	return r.route(projectId).GetVulnerabilityOccurrencesSummary(ctx, projectId, filter)
+}
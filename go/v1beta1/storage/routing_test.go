@@ -0,0 +1,148 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/grafeas/grafeas/go/name"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	prpb "github.com/grafeas/grafeas/proto/v1beta1/project_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newRoutingConfig() *config.DynamoDbConfig {
+	return &config.DynamoDbConfig{
+		TableName: "grafeas",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+		Targets: []config.TargetConfig{
+			{Name: "regulated", TableName: "grafeas_regulated"},
+		},
+		Routes: []config.RouteConfig{
+			{Target: "regulated", ProjectPrefix: "secure-"},
+			{Target: "regulated", ProjectRegex: "^pci-[0-9]+$"},
+		},
+	}
+}
+
+// newRouter creates a router whose targets are each held by a separate in-memory client, as if in different
+// accounts.
+func newRouter(t *testing.T, storeConfig *config.DynamoDbConfig) (*storage.Router, map[string]string) {
+	tables := map[string]string{}
+	router, err := storage.NewRouter(storeConfig, func(target string, targetConfig *config.DynamoDbConfig) *storage.DynamoDb {
+		tables[target] = targetConfig.TableName
+		return storage.NewDynamoDbStoreWithClient(memdb.New(), targetConfig)
+	})
+	if err != nil {
+		t.Fatalf("NewRouter failed, %s", err)
+	}
+	return router, tables
+}
+
+func TestRouterRoutesProjects(t *testing.T) {
+	ctx := context.Background()
+	router, tables := newRouter(t, newRoutingConfig())
+
+	if tables[storage.DefaultTarget] != "grafeas" || tables["regulated"] != "grafeas_regulated" {
+		t.Errorf("Expected each target to have its own table, got %v", tables)
+	}
+
+	for pID, target := range map[string]string{
+		"p1":         storage.DefaultTarget,
+		"secure-p2":  "regulated",
+		"pci-3":      "regulated",
+		"pci-3-test": storage.DefaultTarget,
+	} {
+		if _, err := router.CreateProject(ctx, pID, &prpb.Project{Name: "projects/" + pID}); err != nil {
+			t.Fatalf("CreateProject failed, %s", err)
+		}
+		if _, err := router.Store(target).GetProject(ctx, pID); err != nil {
+			t.Errorf("Expected %s to be stored in %s, got %v", pID, target, err)
+		}
+		if _, err := router.GetProject(ctx, pID); err != nil {
+			t.Errorf("Expected %s to be found through the router, got %v", pID, err)
+		}
+	}
+
+	var names []string
+	token := ""
+	for page := 0; page < 10; page++ {
+		projects, next, err := router.ListProjects(ctx, "", 1, token)
+		if err != nil {
+			t.Fatalf("ListProjects failed, %s", err)
+		}
+		for _, p := range projects {
+			names = append(names, p.Name)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if len(names) != 4 {
+		t.Errorf("Expected the projects of every table to be listed once, got %v", names)
+	}
+
+	if _, _, err := router.ListProjects(ctx, "", 1, "unknown|"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected a page token of an unknown target to be rejected, got %v", err)
+	}
+}
+
+func TestRouterFindsOccurrencesAcrossTables(t *testing.T) {
+	ctx := context.Background()
+	router, _ := newRouter(t, newRoutingConfig())
+
+	if _, err := router.CreateNote(ctx, "p1", "n1", "user", &pb.Note{}); err != nil {
+		t.Fatalf("CreateNote failed, %s", err)
+	}
+	var occurrences []*pb.Occurrence
+	for _, pID := range []string{"p1", "secure-p2"} {
+		o, err := router.CreateOccurrence(ctx, pID, "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1"})
+		if err != nil {
+			t.Fatalf("CreateOccurrence failed, %s", err)
+		}
+		occurrences = append(occurrences, o)
+	}
+
+	listed, _, err := router.ListNoteOccurrences(ctx, "p1", "n1", "", "", 10)
+	if err != nil || len(listed) != 2 {
+		t.Errorf("Expected the occurrences of both tables, got %v, %v", listed, err)
+	}
+
+	_, oID, err := name.ParseOccurrence(occurrences[1].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := router.Store(storage.DefaultTarget).GetOccurrence(ctx, "secure-p2", oID); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected the regulated occurrence not to be in the default table, got %v", err)
+	}
+
+	n, err := router.GetOccurrenceNote(ctx, "secure-p2", oID)
+	if err != nil || n.Name != "projects/p1/notes/n1" {
+		t.Errorf("Expected the note to be found in another table, got %v, %v", n, err)
+	}
+}
+
+func TestNewRouterRejectsInvalidConfig(t *testing.T) {
+	for _, mutate := range []func(*config.DynamoDbConfig){
+		func(c *config.DynamoDbConfig) { c.Routes[0].Target = "missing" },
+		func(c *config.DynamoDbConfig) { c.Routes[0].ProjectRegex = "^secure-" },
+		func(c *config.DynamoDbConfig) { c.Routes[1].ProjectRegex = "(" },
+		func(c *config.DynamoDbConfig) { c.Targets[0].Name = storage.DefaultTarget },
+		func(c *config.DynamoDbConfig) { c.Targets[0].TableName = "" },
+	} {
+		storeConfig := newRoutingConfig()
+		mutate(storeConfig)
+		_, err := storage.NewRouter(storeConfig, func(string, *config.DynamoDbConfig) *storage.DynamoDb {
+			t.Fatal("Expected no stores to be created for an invalid configuration")
+			return nil
+		})
+		if err == nil {
+			t.Errorf("Expected an error for %+v", storeConfig)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/label"
+	"golang.org/x/net/context"
+)
+
+// TracerName is the instrumentation name of the spans started by the store.
+const TracerName = "github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+
+// Span attributes set on the spans of store methods.
+const (
+	ProjectAttribute = label.Key("grafeas.project_id")
+	EntityAttribute  = label.Key("grafeas.entity")
+)
+
+// startSpan starts the span of a store method as a child of any span in ctx, so that the DynamoDB calls made
+// with the returned context are nested beneath it.  Spans are only recorded once a tracer provider has been
+// registered with global.SetTracerProvider.
+func startSpan(ctx context.Context, method string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	return global.Tracer(TracerName).Start(ctx, "DynamoDb."+method, trace.WithAttributes(attributes...))
+}
@@ -0,0 +1,61 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/net/context"
+)
+
+type spanRecordingRouter struct {
+	spans []trace.SpanContext
+}
+
+func (r *spanRecordingRouter) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	r.spans = append(r.spans, trace.SpanFromContext(ctx).SpanContext())
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func TestMethodsStartSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithSyncer(exporter),
+	))
+	defer global.SetTracerProvider(trace.NoopTracerProvider())
+
+	router := &spanRecordingRouter{}
+	db := &storage.DynamoDb{
+		TableName:     "test_table",
+		EventualReads: router,
+	}
+
+	if _, _, err := db.ListNotes(context.Background(), "p1", "", "", 10); err != nil {
+		t.Fatalf("ListNotes failed, %s", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "DynamoDb.ListNotes" {
+		t.Fatalf("Expected a single ListNotes span, got %v", spans)
+	}
+	if len(router.spans) != 1 || router.spans[0].SpanID != spans[0].SpanContext.SpanID {
+		t.Errorf("Expected the query to be made in the context of the ListNotes span")
+	}
+
+	found := false
+	for _, kv := range spans[0].Attributes {
+		if kv.Key == storage.ProjectAttribute && kv.Value.AsString() == "p1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the span to carry the project, got %v", spans[0].Attributes)
+	}
+}
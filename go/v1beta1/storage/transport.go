@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+)
+
+// The defaults of Go's own transport, used for the settings that the configuration leaves out.
+const (
+	defaultConnectTimeout      = 30 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// newHTTPClient creates the HTTP client that AWS is called with, as tuned by the http section of the aws
+// configuration.  Its CA bundle is loaded by the session, as AWS_CA_BUNDLE is.
+func newHTTPClient(httpConfig *config.HTTPConfig) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   defaultConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   httpConfig.MaxIdleConnsPerHost,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if httpConfig.ConnectTimeout != "" {
+		timeout, err := time.ParseDuration(httpConfig.ConnectTimeout)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse connect timeout, %s", err))
+		}
+		dialer.Timeout = timeout
+		transport.TLSHandshakeTimeout = timeout
+	}
+	if httpConfig.ResponseTimeout != "" {
+		timeout, err := time.ParseDuration(httpConfig.ResponseTimeout)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse response timeout, %s", err))
+		}
+		transport.ResponseHeaderTimeout = timeout
+	}
+	if httpConfig.ProxyURL != "" {
+		proxyURL, err := url.Parse(httpConfig.ProxyURL)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to parse proxy URL, %s", err))
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport.TLSClientConfig = &tls.Config{}
+	if httpConfig.ClientCertificate != "" {
+		certificate, err := tls.LoadX509KeyPair(httpConfig.ClientCertificate, httpConfig.ClientKey)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to load client certificate, %s", err))
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,24 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+)
+
+// newHTTPClient is normally given a validated configuration, but must not ignore settings it cannot parse.
+func TestNewHTTPClientRejectsInvalidSettings(t *testing.T) {
+	for _, c := range []*config.HTTPConfig{
+		{ConnectTimeout: "soon"},
+		{ResponseTimeout: "later"},
+		{ProxyURL: "http://[proxy"},
+	} {
+		if _, err := newHTTPClient(c); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+
+	if _, err := newHTTPClient(&config.HTTPConfig{ConnectTimeout: "1s", ResponseTimeout: "2s", ProxyURL: "http://proxy:3128"}); err != nil {
+		t.Errorf("Expected valid settings to be accepted, got %s", err)
+	}
+}
@@ -0,0 +1,178 @@
+package storage_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+)
+
+// listTables answers ListTables as DynamoDB does.
+func listTables(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	fmt.Fprint(w, `{"TableNames":["grafeas"]}`)
+}
+
+// callTables lists the tables of the endpoint with a client configured by httpConfig, without retrying.
+func callTables(t *testing.T, endpoint string, httpConfig *config.HTTPConfig) error {
+	sess, err := storage.NewSession(&config.DynamoDbConfig{
+		TableName: "grafeas",
+		AWS: &config.AwsConfig{
+			Endpoint:        aws.String(endpoint),
+			Region:          aws.String("eu-west-2"),
+			AccessKeyID:     "AKID",
+			SecretAccessKey: "secret",
+			HTTP:            httpConfig,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dynamodb.New(sess).ListTablesWithContext(aws.BackgroundContext(), &dynamodb.ListTablesInput{}, func(r *request.Request) {
+		r.Retryer = client.DefaultRetryer{NumMaxRetries: 0}
+	})
+	return err
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writePEM(t *testing.T, path, blockType string, bytes []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPClientTrustsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(listTables))
+	defer server.Close()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	if err := callTables(t, server.URL, &config.HTTPConfig{}); err == nil {
+		t.Errorf("Expected the certificate of the private CA not to be trusted by default")
+	}
+
+	bundle := filepath.Join(dir, "ca.pem")
+	writePEM(t, bundle, "CERTIFICATE", server.Certificate().Raw)
+	if err := callTables(t, server.URL, &config.HTTPConfig{CABundle: bundle}); err != nil {
+		t.Errorf("Expected the CA bundle to be trusted, got %s", err)
+	}
+}
+
+func TestHTTPClientPresentsClientCertificate(t *testing.T) {
+	var presented int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented = len(r.TLS.PeerCertificates)
+		listTables(w, r)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "grafeas"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpConfig := &config.HTTPConfig{
+		CABundle:          filepath.Join(dir, "ca.pem"),
+		ClientCertificate: filepath.Join(dir, "client.pem"),
+		ClientKey:         filepath.Join(dir, "client-key.pem"),
+	}
+	writePEM(t, httpConfig.CABundle, "CERTIFICATE", server.Certificate().Raw)
+	writePEM(t, httpConfig.ClientCertificate, "CERTIFICATE", certificate)
+	writePEM(t, httpConfig.ClientKey, "EC PRIVATE KEY", keyBytes)
+
+	if err := callTables(t, server.URL, httpConfig); err != nil || presented != 1 {
+		t.Errorf("Expected the client certificate to be presented, got %d certificates, %v", presented, err)
+	}
+}
+
+func TestHTTPClientUsesProxy(t *testing.T) {
+	var host string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		listTables(w, r)
+	}))
+	defer proxy.Close()
+
+	if err := callTables(t, "http://dynamodb.vpce.internal:8000", &config.HTTPConfig{ProxyURL: proxy.URL}); err != nil {
+		t.Fatalf("Expected the request to be made through the proxy, got %s", err)
+	}
+	if host != "dynamodb.vpce.internal:8000" {
+		t.Errorf("Expected the proxy to be asked for the endpoint, got %q", host)
+	}
+}
+
+func TestHTTPClientTimesOutResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		listTables(w, r)
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	if err := callTables(t, server.URL, &config.HTTPConfig{ResponseTimeout: "50ms"}); err == nil {
+		t.Errorf("Expected the slow response to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Expected the request to be abandoned after the response timeout, took %s", elapsed)
+	}
+}
+
+func TestNewSessionRejectsInvalidHTTPConfig(t *testing.T) {
+	for _, c := range []*config.HTTPConfig{
+		{ConnectTimeout: "soon"},
+		{ProxyURL: "proxy:3128"},
+		{ClientCertificate: "client.pem"},
+		{ClientCertificate: "/does/not/exist.pem", ClientKey: "/does/not/exist.pem"},
+		{CABundle: "/does/not/exist.pem"},
+	} {
+		storeConfig := newCredentialsConfig(&config.AwsConfig{HTTP: c})
+		if _, err := storage.NewSession(storeConfig); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/awsrequest"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/semconv"
+	"golang.org/x/net/context"
+)
+
+// TracerName is the instrumentation name of the spans of DynamoDB calls.
+const TracerName = "github.com/john-tipper/grafeas-dynamodb/go/v1beta1/tracing"
+
+// Attributes set on the spans of DynamoDB calls, in addition to the database semantic conventions.
+const (
+	TableAttribute            = label.Key("aws.dynamodb.table_name")
+	IndexAttribute            = label.Key("aws.dynamodb.index_name")
+	ItemCountAttribute        = label.Key("aws.dynamodb.item_count")
+	ConsumedCapacityAttribute = label.Key("aws.dynamodb.consumed_capacity")
+	RetryCountAttribute       = label.Key("aws.dynamodb.retry_count")
+)
+
+// spanKey holds the span of a call in its request context, so that it is only ended by the handlers that
+// started it.
+type spanKey struct{}
+
+// InstrumentClient starts a span for every call made by a DynamoDB client, as a child of any span in the
+// context of the call.  Calls are made to return their consumed capacity, unless they already ask for it.
+func InstrumentClient(client *dynamodb.DynamoDB) {
+	client.Handlers.Validate.PushFrontNamed(request.NamedHandler{
+		Name: "grafeas.tracing.Start",
+		Fn:   start,
+	})
+	client.Handlers.Build.PushFrontNamed(request.NamedHandler{
+		Name: "grafeas.tracing.ReturnConsumedCapacity",
+		Fn:   awsrequest.ReturnConsumedCapacity,
+	})
+	client.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{
+		Name: "grafeas.tracing.CompleteAttempt",
+		Fn:   completeAttempt,
+	})
+	client.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "grafeas.tracing.Complete",
+		Fn:   complete,
+	})
+}
+
+func start(r *request.Request) {
+	attributes := []label.KeyValue{
+		semconv.DBSystemKey.String("dynamodb"),
+		semconv.DBOperationKey.String(r.Operation.Name),
+		TableAttribute.String(awsrequest.TableName(r)),
+	}
+	if index := awsrequest.IndexName(r); index != "" {
+		attributes = append(attributes, IndexAttribute.String(index))
+	}
+
+	ctx, span := global.Tracer(TracerName).Start(r.Context(), "DynamoDB."+r.Operation.Name,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	r.SetContext(context.WithValue(ctx, spanKey{}, span))
+}
+
+// completeAttempt records each failed attempt, such as one that was throttled, as an event on the span.
+func completeAttempt(r *request.Request) {
+	span, ok := r.Context().Value(spanKey{}).(trace.Span)
+	if !ok || r.Error == nil {
+		return
+	}
+
+	attributes := []label.KeyValue{
+		label.Int("attempt", r.RetryCount+1),
+		label.Bool("throttled", request.IsErrorThrottle(r.Error)),
+	}
+	if awsErr, ok := r.Error.(awserr.Error); ok {
+		attributes = append(attributes, label.String("error.code", awsErr.Code()))
+	}
+	span.AddEvent(r.Context(), "Attempt failed", attributes...)
+}
+
+func complete(r *request.Request) {
+	span, ok := r.Context().Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+
+	span.SetAttributes(RetryCountAttribute.Int(r.RetryCount))
+	if r.Error != nil {
+		span.RecordError(r.Context(), r.Error)
+		span.SetStatus(codes.Error, r.Error.Error())
+		return
+	}
+
+	if count, ok := awsrequest.ItemCount(r.Data); ok {
+		span.SetAttributes(ItemCountAttribute.Int64(count))
+	}
+	if capacity := awsrequest.ConsumedCapacity(r.Data); len(capacity) > 0 {
+		var units float64
+		for _, c := range capacity {
+			units += aws.Float64Value(c.CapacityUnits)
+		}
+		span.SetAttributes(ConsumedCapacityAttribute.Float64(units))
+	}
+}
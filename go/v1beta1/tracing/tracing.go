@@ -0,0 +1,110 @@
+// Package tracing exports OpenTelemetry spans for the DynamoDB store: one for each storage method, with a child
+// span for each DynamoDB call that it makes.
+package tracing
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+)
+
+// The exporters that spans can be sent to.
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Provider is a tracer provider that batches spans to the configured exporter.
+type Provider struct {
+	*sdktrace.TracerProvider
+	processor *sdktrace.BatchSpanProcessor
+	exporter  export.SpanExporter
+	file      io.Closer
+}
+
+// NewProvider creates a tracer provider for the configured exporter.  It must be registered with
+// global.SetTracerProvider for the store's spans to be recorded.
+func NewProvider(config *config.TracingConfig) (*Provider, error) {
+	p := &Provider{}
+	var err error
+
+	switch config.Exporter {
+	case ExporterOTLP:
+		options := []otlp.ExporterOption{otlp.WithHeaders(config.Headers)}
+		if config.Endpoint != "" {
+			options = append(options, otlp.WithAddress(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlp.WithInsecure())
+		} else {
+			options = append(options, otlp.WithTLSCredentials(credentials.NewClientTLSFromCert(nil, "")))
+		}
+		p.exporter, err = otlp.NewExporter(options...)
+	case ExporterStdout:
+		p.exporter, err = stdout.NewExporter(stdout.WithoutMetricExport())
+	case ExporterFile:
+		if config.Path == "" {
+			return nil, errors.New("A path is required for the file exporter")
+		}
+		var file *os.File
+		file, err = os.OpenFile(config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to open trace file, %s", err))
+		}
+		p.file = file
+		p.exporter, err = stdout.NewExporter(stdout.WithWriter(file), stdout.WithoutMetricExport())
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown trace exporter %q, must be one of %q, %q or %q",
+			config.Exporter, ExporterOTLP, ExporterStdout, ExporterFile))
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to create %s trace exporter, %s", config.Exporter, err))
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if config.SampleRatio != nil {
+		if *config.SampleRatio < 0 || *config.SampleRatio > 1 {
+			return nil, errors.New(fmt.Sprintf("Sample ratio must be between 0 and 1, got %v", *config.SampleRatio))
+		}
+		sampler = sdktrace.TraceIDRatioBased(*config.SampleRatio)
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "grafeas"
+	}
+
+	p.processor = sdktrace.NewBatchSpanProcessor(p.exporter)
+	p.TracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{
+			DefaultSampler: sdktrace.ParentBased(sampler),
+			Resource:       resource.New(semconv.ServiceNameKey.String(serviceName)),
+		}),
+		sdktrace.WithSpanProcessor(p.processor),
+	)
+	return p, nil
+}
+
+// Shutdown flushes any spans that have not yet been exported and stops the exporter.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.UnregisterSpanProcessor(p.processor)
+	err := p.exporter.Shutdown(ctx)
+	if p.file != nil {
+		if closeErr := p.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package tracing_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/tracing"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/label"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	"go.opentelemetry.io/otel/sdk/export/trace/tracetest"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/net/context"
+)
+
+func attribute(span *export.SpanData, key label.Key) (label.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return label.Value{}, false
+}
+
+func TestInstrumentClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte(`{"Items":[{"PartitionKey":{"S":"a"}},{"PartitionKey":{"S":"b"}}],"Count":2,` +
+			`"ConsumedCapacity":{"TableName":"grafeas","CapacityUnits":1.5}}`))
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	global.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithSyncer(exporter),
+	))
+
+	ddb := dynamodb.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})))
+	tracing.InstrumentClient(ddb)
+
+	ctx, parent := global.Tracer("test").Start(context.Background(), "ListNotes")
+	_, err := ddb.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("grafeas"),
+		IndexName:              aws.String("GSI_1"),
+		KeyConditionExpression: aws.String("SortKey = :SK"),
+	})
+	parent.End()
+	if err != nil {
+		t.Fatalf("Query failed, %s", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "DynamoDB.Query" {
+		t.Fatalf("Expected a Query span and its parent, got %v", spans)
+	}
+	query := spans[0]
+	if query.ParentSpanID != parent.SpanContext().SpanID {
+		t.Errorf("Expected the Query span to be a child of the span in its context")
+	}
+
+	for key, expected := range map[label.Key]string{
+		tracing.TableAttribute:            "grafeas",
+		tracing.IndexAttribute:            "GSI_1",
+		tracing.ItemCountAttribute:        "2",
+		tracing.ConsumedCapacityAttribute: "1.5",
+	} {
+		if value, ok := attribute(query, key); !ok || value.Emit() != expected {
+			t.Errorf("Got %s = %q, expected %q", key, value.Emit(), expected)
+		}
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spans.json")
+
+	provider, err := tracing.NewProvider(&config.TracingConfig{Exporter: tracing.ExporterFile, Path: path})
+	if err != nil {
+		t.Fatalf("NewProvider failed, %s", err)
+	}
+
+	_, span := provider.Tracer("test").Start(context.Background(), "GetNote")
+	span.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed, %s", err)
+	}
+
+	spans, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spans), `"Name":"GetNote"`) {
+		t.Errorf("Expected the span to be written to the file, got %s", spans)
+	}
+}
+
+func TestNewProviderRejectsInvalidConfig(t *testing.T) {
+	ratio := 1.5
+	for _, c := range []*config.TracingConfig{
+		{Exporter: "zipkin"},
+		{Exporter: tracing.ExporterFile},
+		{Exporter: tracing.ExporterStdout, SampleRatio: &ratio},
+	} {
+		if _, err := tracing.NewProvider(c); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+}
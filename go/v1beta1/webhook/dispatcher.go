@@ -0,0 +1,537 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/google/uuid"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// deliveryPKPrefix prefixes the partition key of delivery rows, followed by the delivery ID.
+	deliveryPKPrefix = storage.WebhookKeyPrefix
+	// pendingSK is the sort key of deliveries that are still to be attempted.  Data holds the time of the next
+	// attempt, so due deliveries can be found through the GSI.
+	pendingSK = "WEBHOOK_DELIVERY"
+	// deadLetterSK is the sort key of deliveries whose attempts are exhausted.  Data holds the time of the last
+	// attempt.
+	deadLetterSK = "WEBHOOK_DEAD_LETTER"
+
+	// deliveryTimeFormat is fixed width, so that times sort lexicographically.
+	deliveryTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+	attemptsKeyName = "Attempts"
+)
+
+const (
+	// DefaultPollInterval is how often the dispatcher looks for due deliveries when it has not been woken.
+	DefaultPollInterval = 5 * time.Second
+	// DefaultLease is how long a claimed delivery is hidden from other dispatchers while it is attempted.
+	DefaultLease = time.Minute
+)
+
+// PayloadCodec seals and opens the payload of delivery rows, so that they are encrypted like the rest of the
+// table.  *storage.DynamoDb implements it.
+type PayloadCodec interface {
+	SealPayload(dataItem *storage.DataItem, jsonObject string) error
+	OpenPayload(dataItem *storage.DataItem) (string, error)
+}
+
+// Delivery is a single event to be sent to a single endpoint.
+type Delivery struct {
+	ID       string
+	Endpoint string
+	Event    string
+	Body     []byte
+	Attempts int
+	// LastError is the reason the last attempt failed.
+	LastError string
+	// Time is when the next attempt is due, or for a dead letter, when the last attempt was made.
+	Time time.Time
+}
+
+// deliveryItem is the row that persists a delivery.
+type deliveryItem struct {
+	storage.DataItem
+	Endpoint  string
+	Event     string
+	Attempts  int
+	LastError string `dynamodbav:",omitempty"`
+}
+
+// payload is the body of each request.
+type payload struct {
+	Event      string          `json:"event"`
+	Time       string          `json:"time"`
+	Occurrence json.RawMessage `json:"occurrence"`
+}
+
+// Dispatcher queues occurrence events for the endpoints that match them, in the transactions that change the
+// occurrences, and sends them in the background.  It implements storage.OccurrenceHook.  The keys of delivery
+// rows are prefixed with Namespace, as those of the store's entities are.
+type Dispatcher struct {
+	Client       dynamodbiface.DynamoDBAPI
+	TableName    string
+	Namespace    string
+	Codec        PayloadCodec
+	Endpoints    []*Endpoint
+	HTTPClient   *http.Client
+	PollInterval time.Duration
+	Lease        time.Duration
+	// Logger records failed deliveries.  If nil, the standard logrus logger is used.
+	Logger *logrus.Logger
+
+	wake chan struct{}
+}
+
+// NewDispatcher creates a dispatcher that persists its deliveries in the store's table.
+func NewDispatcher(store *storage.DynamoDb, webhooks []config.WebhookConfig) (*Dispatcher, error) {
+	d := &Dispatcher{
+		Client:       store.DynamoDBAPI,
+		TableName:    store.TableName,
+		Namespace:    store.Namespace,
+		Codec:        store,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		PollInterval: DefaultPollInterval,
+		Lease:        DefaultLease,
+		Logger:       store.BaseLogger(),
+		wake:         make(chan struct{}, 1),
+	}
+
+	if len(webhooks) > config.MaxWebhooks {
+		return nil, errors.New(fmt.Sprintf("%d webhooks are configured, at most %d are allowed", len(webhooks), config.MaxWebhooks))
+	}
+
+	names := map[string]bool{}
+	for _, webhookConfig := range webhooks {
+		endpoint, err := NewEndpoint(webhookConfig)
+		if err != nil {
+			return nil, err
+		}
+		if names[endpoint.Name] {
+			return nil, errors.New(fmt.Sprintf("Duplicate webhook name %s", endpoint.Name))
+		}
+		names[endpoint.Name] = true
+		d.Endpoints = append(d.Endpoints, endpoint)
+	}
+
+	return d, nil
+}
+
+// OccurrenceWrites returns a delivery row of the event for each endpoint that matches the occurrence.  The rows
+// are written in the transaction that changes the occurrence, so a delivery is queued if and only if the change
+// is made.
+func (d *Dispatcher) OccurrenceWrites(ctx context.Context, event string, o *pb.Occurrence) ([]*dynamodb.TransactWriteItem, error) {
+	var body []byte
+	var writes []*dynamodb.TransactWriteItem
+	for _, endpoint := range d.Endpoints {
+		if !endpoint.Matches(o) {
+			continue
+		}
+
+		if body == nil {
+			var err error
+			if body, err = newPayload(event, o); err != nil {
+				return nil, errors.New(fmt.Sprintf("Unable to create webhook payload for %s, %s", o.Name, err))
+			}
+		}
+
+		put, err := d.deliveryPut(endpoint.Name, event, body)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Unable to queue %s of %s for webhook %s, %s", event, o.Name, endpoint.Name, err))
+		}
+		writes = append(writes, &dynamodb.TransactWriteItem{Put: put})
+	}
+
+	return writes, nil
+}
+
+// OccurrenceCommitted wakes the dispatcher, so that the deliveries written with the occurrence are sent
+// without waiting for the next poll.
+func (d *Dispatcher) OccurrenceCommitted(ctx context.Context, event string, o *pb.Occurrence) {
+	d.notify()
+}
+
+func newPayload(event string, o *pb.Occurrence) ([]byte, error) {
+	marshaler := jsonpb.Marshaler{}
+	occurrence, err := marshaler.MarshalToString(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(payload{
+		Event:      event,
+		Time:       time.Now().UTC().Format(time.RFC3339Nano),
+		Occurrence: json.RawMessage(occurrence),
+	})
+}
+
+// Enqueue persists a delivery to an endpoint, due immediately, and wakes the dispatcher.
+func (d *Dispatcher) Enqueue(ctx context.Context, endpointName, event string, body []byte) error {
+	put, err := d.deliveryPut(endpointName, event, body)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                put.TableName,
+		Item:                     put.Item,
+		ConditionExpression:      put.ConditionExpression,
+		ExpressionAttributeNames: put.ExpressionAttributeNames,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.notify()
+	return nil
+}
+
+// deliveryPut returns the write of a new delivery to an endpoint, due immediately.
+func (d *Dispatcher) deliveryPut(endpointName, event string, body []byte) (*dynamodb.Put, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	item := &deliveryItem{
+		DataItem: storage.DataItem{
+			PartitionKey: storage.NamespacedKey(d.Namespace, deliveryPKPrefix+id.String()),
+			SortKey:      storage.NamespacedKey(d.Namespace, pendingSK),
+			Data:         formatTime(time.Now()),
+		},
+		Endpoint: endpointName,
+		Event:    event,
+	}
+	if err := d.Codec.SealPayload(&item.DataItem, string(body)); err != nil {
+		return nil, err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.Put{
+		TableName:           aws.String(d.TableName),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(#PK)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#PK": aws.String(storage.PartitionKeyName),
+		},
+	}, nil
+}
+
+func (d *Dispatcher) notify() {
+	if d.wake == nil {
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries until the context is cancelled.  Deliveries left by a previous run, including those
+// that were in flight when it stopped, are picked up once their lease expires.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	if d.wake == nil {
+		d.wake = make(chan struct{}, 1)
+	}
+	for {
+		if err := d.ProcessDue(ctx); err != nil {
+			d.logger().WithError(err).Error("Unable to process webhook deliveries")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.wake:
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+// ProcessDue makes a single attempt at every delivery that is due.  A delivery whose attempt cannot be recorded
+// is logged and left to be retried once its lease expires, rather than holding up the rest.
+func (d *Dispatcher) ProcessDue(ctx context.Context) error {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.TableName),
+		IndexName:              aws.String(storage.GlobalSecondaryIndex1),
+		KeyConditionExpression: aws.String("#SK = :SK AND #DATA <= :NOW"),
+		ExpressionAttributeNames: map[string]*string{
+			"#SK":   aws.String(storage.SortKeyName),
+			"#DATA": aws.String(storage.DataKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":SK": {
+				S: aws.String(storage.NamespacedKey(d.Namespace, pendingSK)),
+			},
+			":NOW": {
+				S: aws.String(formatTime(time.Now())),
+			},
+		},
+	}
+
+	var due []*deliveryItem
+	err := d.Client.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, av := range page.Items {
+			var item deliveryItem
+			if err := dynamodbattribute.UnmarshalMap(av, &item); err != nil {
+				d.logger().WithError(err).Warn("Skipping unreadable webhook delivery")
+				continue
+			}
+			due = append(due, &item)
+		}
+		return true
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to query due webhook deliveries, %s", err))
+	}
+
+	for _, item := range due {
+		if err := d.attempt(ctx, item); err != nil {
+			d.logger().WithError(err).Errorf("Unable to attempt webhook delivery %s", item.PartitionKey)
+		}
+	}
+
+	return nil
+}
+
+// attempt claims a delivery, so that no other dispatcher attempts it concurrently, then sends it and records the
+// outcome.
+func (d *Dispatcher) attempt(ctx context.Context, item *deliveryItem) error {
+	claimed, err := d.claim(ctx, item)
+	if err != nil || !claimed {
+		return err
+	}
+
+	delivery, err := d.delivery(item)
+	if err != nil {
+		return d.fail(ctx, item, nil, err)
+	}
+
+	endpoint := d.endpoint(item.Endpoint)
+	if endpoint == nil {
+		return d.fail(ctx, item, nil, errors.New("Webhook is no longer configured"))
+	}
+
+	if err := endpoint.Post(ctx, d.HTTPClient, delivery); err != nil {
+		return d.fail(ctx, item, endpoint, err)
+	}
+
+	_, err = d.Client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.TableName),
+		Key:       deliveryKey(item),
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Unable to remove sent webhook delivery %s, %s", delivery.ID, err))
+	}
+
+	return nil
+}
+
+// claim counts the attempt and hides the delivery for the duration of the lease.  It returns false if another
+// dispatcher claimed the delivery first.
+func (d *Dispatcher) claim(ctx context.Context, item *deliveryItem) (bool, error) {
+	previousData := item.Data
+	previousAttempts := item.Attempts
+
+	item.Attempts++
+	item.Data = formatTime(time.Now().Add(d.Lease))
+
+	err := d.put(ctx, item, previousData, previousAttempts)
+	if err != nil {
+		if storage.IsConditionalCheckFailure(err) {
+			return false, nil
+		}
+		return false, errors.New(fmt.Sprintf("Unable to claim webhook delivery %s, %s", item.PartitionKey, err))
+	}
+
+	return true, nil
+}
+
+// fail records a failed attempt, scheduling a retry or, once the attempts are exhausted, replacing the delivery
+// with a dead letter.
+func (d *Dispatcher) fail(ctx context.Context, item *deliveryItem, endpoint *Endpoint, cause error) error {
+	d.logger().WithError(cause).Warnf("Webhook delivery %s to %s failed on attempt %d", item.PartitionKey, item.Endpoint, item.Attempts)
+
+	claimedData := item.Data
+	item.LastError = cause.Error()
+
+	if endpoint != nil && item.Attempts < endpoint.MaxAttempts {
+		item.Data = formatTime(time.Now().Add(endpoint.backoff(item.Attempts)))
+		if err := d.put(ctx, item, claimedData, item.Attempts); err != nil && !storage.IsConditionalCheckFailure(err) {
+			return errors.New(fmt.Sprintf("Unable to reschedule webhook delivery %s, %s", item.PartitionKey, err))
+		}
+		return nil
+	}
+
+	deadLetter := *item
+	deadLetter.SortKey = storage.NamespacedKey(d.Namespace, deadLetterSK)
+	deadLetter.Data = formatTime(time.Now())
+	av, err := dynamodbattribute.MarshalMap(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName:                 aws.String(d.TableName),
+					Key:                       deliveryKey(item),
+					ConditionExpression:       aws.String("#DATA = :DATA"),
+					ExpressionAttributeNames:  map[string]*string{"#DATA": aws.String(storage.DataKeyName)},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":DATA": {S: aws.String(claimedData)}},
+				},
+			},
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(d.TableName),
+					Item:      av,
+				},
+			},
+		},
+	})
+	if err != nil && !storage.IsConditionalCheckFailure(err) {
+		return errors.New(fmt.Sprintf("Unable to dead-letter webhook delivery %s, %s", item.PartitionKey, err))
+	}
+
+	return nil
+}
+
+// put writes a pending delivery, provided it has not been changed since it was read.
+func (d *Dispatcher) put(ctx context.Context, item *deliveryItem, previousData string, previousAttempts int) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.TableName),
+		Item:                av,
+		ConditionExpression: aws.String("#DATA = :DATA AND #ATTEMPTS = :ATTEMPTS"),
+		ExpressionAttributeNames: map[string]*string{
+			"#DATA":     aws.String(storage.DataKeyName),
+			"#ATTEMPTS": aws.String(attemptsKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":DATA": {
+				S: aws.String(previousData),
+			},
+			":ATTEMPTS": {
+				N: aws.String(strconv.Itoa(previousAttempts)),
+			},
+		},
+	})
+	return err
+}
+
+// ListDeadLetters returns the deliveries whose attempts have been exhausted, oldest first.
+func (d *Dispatcher) ListDeadLetters(ctx context.Context) ([]*Delivery, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.TableName),
+		IndexName:              aws.String(storage.GlobalSecondaryIndex1),
+		KeyConditionExpression: aws.String("#SK = :SK"),
+		ExpressionAttributeNames: map[string]*string{
+			"#SK": aws.String(storage.SortKeyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":SK": {
+				S: aws.String(storage.NamespacedKey(d.Namespace, deadLetterSK)),
+			},
+		},
+	}
+
+	var deliveries []*Delivery
+	var unmarshalErr error
+	err := d.Client.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, av := range page.Items {
+			var item deliveryItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(av, &item); unmarshalErr != nil {
+				return false
+			}
+			var delivery *Delivery
+			if delivery, unmarshalErr = d.delivery(&item); unmarshalErr != nil {
+				return false
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		return true
+	})
+	if err == nil {
+		err = unmarshalErr
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unable to list webhook dead letters, %s", err))
+	}
+
+	return deliveries, nil
+}
+
+// delivery decodes a delivery row.
+func (d *Dispatcher) delivery(item *deliveryItem) (*Delivery, error) {
+	body, err := d.Codec.OpenPayload(&item.DataItem)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveryTime, _ := time.Parse(deliveryTimeFormat, item.Data)
+	pk, _ := storage.StripNamespace(d.Namespace, item.PartitionKey)
+	return &Delivery{
+		ID:        strings.TrimPrefix(pk, deliveryPKPrefix),
+		Endpoint:  item.Endpoint,
+		Event:     item.Event,
+		Body:      []byte(body),
+		Attempts:  item.Attempts,
+		LastError: item.LastError,
+		Time:      deliveryTime,
+	}, nil
+}
+
+func (d *Dispatcher) logger() *logrus.Logger {
+	if d.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return d.Logger
+}
+
+func (d *Dispatcher) endpoint(name string) *Endpoint {
+	for _, endpoint := range d.Endpoints {
+		if endpoint.Name == name {
+			return endpoint
+		}
+	}
+	return nil
+}
+
+func deliveryKey(item *deliveryItem) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		storage.PartitionKeyName: {
+			S: aws.String(item.PartitionKey),
+		},
+		storage.SortKeyName: {
+			S: aws.String(item.SortKey),
+		},
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(deliveryTimeFormat)
+}
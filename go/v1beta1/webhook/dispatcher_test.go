@@ -0,0 +1,130 @@
+package webhook_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/memdb"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/storage"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/webhook"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"golang.org/x/net/context"
+)
+
+// failingDeletes fails the first DeleteItem, as if the table were briefly unavailable.
+type failingDeletes struct {
+	*memdb.DB
+	failed bool
+}
+
+func (c *failingDeletes) DeleteItemWithContext(ctx context.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if !c.failed {
+		c.failed = true
+		return nil, errors.New("table unavailable")
+	}
+	return c.DB.DeleteItemWithContext(ctx, input, opts...)
+}
+
+func TestProcessDueContinuesAfterFailedAttempt(t *testing.T) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	store := storage.NewDynamoDbStoreWithClient(&failingDeletes{DB: memdb.New()}, &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	logger, hook := test.NewNullLogger()
+	store.Logger = logger
+	dispatcher, err := webhook.NewDispatcher(store, []config.WebhookConfig{{Name: "test", URL: server.URL}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed, %s", err)
+	}
+	dispatcher.HTTPClient = server.Client()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := dispatcher.Enqueue(ctx, "test", "OCCURRENCE_CREATED", []byte(`{}`)); err != nil {
+			t.Fatalf("Enqueue failed, %s", err)
+		}
+	}
+
+	if err := dispatcher.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue failed, %s", err)
+	}
+	if received != 2 {
+		t.Errorf("Expected both deliveries to be sent despite the first failing to be removed, got %d", received)
+	}
+	if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.ErrorLevel || entry.Data[logrus.ErrorKey] == nil {
+		t.Errorf("Expected the failed attempt to be logged with the store's logger, got %v", entry)
+	}
+}
+
+// failingTransactions fails every transaction, as if the table were unavailable.
+type failingTransactions struct {
+	*memdb.DB
+	fail bool
+}
+
+func (c *failingTransactions) TransactWriteItemsWithContext(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if c.fail {
+		return nil, errors.New("table unavailable")
+	}
+	return c.DB.TransactWriteItemsWithContext(ctx, input, opts...)
+}
+
+func TestDeliveriesAreQueuedWithOccurrence(t *testing.T) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := &failingTransactions{DB: memdb.New()}
+	store := storage.NewDynamoDbStoreWithClient(client, &config.DynamoDbConfig{
+		TableName: "test_table",
+		AWS:       &config.AwsConfig{Region: aws.String("eu-west-2")},
+	})
+	dispatcher, err := webhook.NewDispatcher(store, []config.WebhookConfig{{Name: "test", URL: server.URL}})
+	if err != nil {
+		t.Fatalf("NewDispatcher failed, %s", err)
+	}
+	dispatcher.HTTPClient = server.Client()
+	store.OccurrenceHooks = append(store.OccurrenceHooks, dispatcher)
+
+	// an occurrence that is not written queues nothing
+	ctx := context.Background()
+	client.fail = true
+	if _, err := store.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1"}); err == nil {
+		t.Fatal("Expected CreateOccurrence to fail")
+	}
+	if err := dispatcher.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue failed, %s", err)
+	}
+	if received != 0 {
+		t.Errorf("Expected no delivery for an occurrence that was not created, got %d", received)
+	}
+
+	client.fail = false
+	if _, err := store.CreateOccurrence(ctx, "p1", "user", &pb.Occurrence{NoteName: "projects/p1/notes/n1"}); err != nil {
+		t.Fatalf("CreateOccurrence failed, %s", err)
+	}
+	if err := dispatcher.ProcessDue(ctx); err != nil {
+		t.Fatalf("ProcessDue failed, %s", err)
+	}
+	if received != 1 {
+		t.Errorf("Expected the delivery written with the occurrence to be sent, got %d", received)
+	}
+}
@@ -0,0 +1,192 @@
+// Package webhook sends occurrence events to HTTP endpoints.  Deliveries are persisted in the Grafeas table
+// before they are attempted, retried with exponential backoff and moved to a dead-letter row once their
+// attempts are exhausted, so events are not lost when an endpoint is unavailable or the server restarts.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/grafeas/grafeas/go/name"
+	"github.com/grafeas/grafeas/proto/v1beta1/common_go_proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/vulnerability_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"golang.org/x/net/context"
+)
+
+// The headers sent with each delivery.
+const (
+	EventHeader     = "X-Grafeas-Event"
+	DeliveryHeader  = "X-Grafeas-Delivery"
+	SignatureHeader = "X-Grafeas-Signature"
+)
+
+const (
+	defaultMaxAttempts    = 10
+	defaultInitialBackoff = time.Second
+	maxBackoff            = time.Hour
+)
+
+// Endpoint is an HTTP endpoint that is sent the events of the occurrences it matches.
+type Endpoint struct {
+	Name           string
+	URL            string
+	Secret         string
+	Projects       []string
+	NoteKinds      []common_go_proto.NoteKind
+	MinSeverity    vulnerability_go_proto.Severity
+	MaxAttempts    int
+	InitialBackoff time.Duration
+}
+
+// NewEndpoint creates an Endpoint from its configuration, applying defaults.
+func NewEndpoint(webhookConfig config.WebhookConfig) (*Endpoint, error) {
+	if webhookConfig.Name == "" || webhookConfig.URL == "" {
+		return nil, errors.New("Webhooks must have a name and a url")
+	}
+
+	endpoint := &Endpoint{
+		Name:           webhookConfig.Name,
+		URL:            webhookConfig.URL,
+		Secret:         webhookConfig.Secret,
+		Projects:       webhookConfig.Projects,
+		MaxAttempts:    webhookConfig.MaxAttempts,
+		InitialBackoff: defaultInitialBackoff,
+	}
+
+	for _, kind := range webhookConfig.NoteKinds {
+		value, ok := common_go_proto.NoteKind_value[kind]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown note kind %s for webhook %s", kind, webhookConfig.Name))
+		}
+		endpoint.NoteKinds = append(endpoint.NoteKinds, common_go_proto.NoteKind(value))
+	}
+
+	if webhookConfig.MinSeverity != "" {
+		value, ok := vulnerability_go_proto.Severity_value[webhookConfig.MinSeverity]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unknown severity %s for webhook %s", webhookConfig.MinSeverity, webhookConfig.Name))
+		}
+		endpoint.MinSeverity = vulnerability_go_proto.Severity(value)
+	}
+
+	if endpoint.MaxAttempts <= 0 {
+		endpoint.MaxAttempts = defaultMaxAttempts
+	}
+
+	if webhookConfig.InitialBackoff != "" {
+		backoff, err := time.ParseDuration(webhookConfig.InitialBackoff)
+		if err != nil || backoff <= 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid initial backoff %q for webhook %s", webhookConfig.InitialBackoff, webhookConfig.Name))
+		}
+		endpoint.InitialBackoff = backoff
+	}
+
+	return endpoint, nil
+}
+
+// Matches reports whether the endpoint should be sent events for the occurrence.
+func (e *Endpoint) Matches(o *pb.Occurrence) bool {
+	if len(e.Projects) > 0 {
+		projectID, _, err := name.ParseOccurrence(o.GetName())
+		if err != nil || !containsString(e.Projects, projectID) {
+			return false
+		}
+	}
+
+	if len(e.NoteKinds) > 0 {
+		matched := false
+		for _, kind := range e.NoteKinds {
+			if o.GetKind() == kind {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if e.MinSeverity != vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED {
+		return severity(o) >= e.MinSeverity
+	}
+
+	return true
+}
+
+// severity returns the effective severity of a vulnerability occurrence, falling back to the severity of the
+// vulnerability itself.
+func severity(o *pb.Occurrence) vulnerability_go_proto.Severity {
+	details := o.GetVulnerability()
+	if details.GetEffectiveSeverity() != vulnerability_go_proto.Severity_SEVERITY_UNSPECIFIED {
+		return details.GetEffectiveSeverity()
+	}
+	return details.GetSeverity()
+}
+
+// backoff returns how long to wait before retrying a delivery that has failed the given number of times.
+func (e *Endpoint) backoff(attempts int) time.Duration {
+	backoff := e.InitialBackoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// Post sends a delivery to the endpoint, signing the body with the endpoint's secret.  Any response other than
+// a 2xx is an error.
+func (e *Endpoint) Post(ctx context.Context, client *http.Client, delivery *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	if e.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(e.Secret), delivery.Body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("Endpoint responded with %s", resp.Status))
+	}
+
+	return nil
+}
+
+// Sign returns the signature header value of a request body, "sha256=" followed by the hex encoded HMAC-SHA256
+// of the body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package webhook_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafeas/grafeas/proto/v1beta1/common_go_proto"
+	pb "github.com/grafeas/grafeas/proto/v1beta1/grafeas_go_proto"
+	"github.com/grafeas/grafeas/proto/v1beta1/vulnerability_go_proto"
+	"github.com/john-tipper/grafeas-dynamodb/go/config"
+	"github.com/john-tipper/grafeas-dynamodb/go/v1beta1/webhook"
+	"golang.org/x/net/context"
+)
+
+func vulnerabilityOccurrence(oName string, severity vulnerability_go_proto.Severity) *pb.Occurrence {
+	return &pb.Occurrence{
+		Name: oName,
+		Kind: common_go_proto.NoteKind_VULNERABILITY,
+		Details: &pb.Occurrence_Vulnerability{
+			Vulnerability: &vulnerability_go_proto.Details{
+				Severity: severity,
+			},
+		},
+	}
+}
+
+func TestEndpointMatches(t *testing.T) {
+	endpoint, err := webhook.NewEndpoint(config.WebhookConfig{
+		Name:        "security",
+		URL:         "http://localhost/hook",
+		Projects:    []string{"p1"},
+		NoteKinds:   []string{"VULNERABILITY"},
+		MinSeverity: "HIGH",
+	})
+	if err != nil {
+		t.Fatalf("NewEndpoint failed, %s", err)
+	}
+
+	for _, c := range []struct {
+		description string
+		occurrence  *pb.Occurrence
+		expected    bool
+	}{
+		{"critical vulnerability", vulnerabilityOccurrence("projects/p1/occurrences/o1", vulnerability_go_proto.Severity_CRITICAL), true},
+		{"high vulnerability", vulnerabilityOccurrence("projects/p1/occurrences/o1", vulnerability_go_proto.Severity_HIGH), true},
+		{"medium vulnerability", vulnerabilityOccurrence("projects/p1/occurrences/o1", vulnerability_go_proto.Severity_MEDIUM), false},
+		{"other project", vulnerabilityOccurrence("projects/p2/occurrences/o1", vulnerability_go_proto.Severity_CRITICAL), false},
+		{"other kind", &pb.Occurrence{Name: "projects/p1/occurrences/o1", Kind: common_go_proto.NoteKind_BUILD}, false},
+	} {
+		if actual := endpoint.Matches(c.occurrence); actual != c.expected {
+			t.Errorf("Matches(%s) = %v, expected %v", c.description, actual, c.expected)
+		}
+	}
+}
+
+func TestNewEndpointRejectsInvalidConfig(t *testing.T) {
+	for _, c := range []config.WebhookConfig{
+		{URL: "http://localhost/hook"},
+		{Name: "bad-kind", URL: "http://localhost/hook", NoteKinds: []string{"NOT_A_KIND"}},
+		{Name: "bad-severity", URL: "http://localhost/hook", MinSeverity: "SEVERE"},
+		{Name: "bad-backoff", URL: "http://localhost/hook", InitialBackoff: "soon"},
+	} {
+		if _, err := webhook.NewEndpoint(c); err == nil {
+			t.Errorf("Expected NewEndpoint to reject %+v", c)
+		}
+	}
+}
+
+func TestPostSignsBody(t *testing.T) {
+	body := []byte(`{"event":"OCCURRENCE_CREATED"}`)
+	status := http.StatusNoContent
+
+	var received *http.Request
+	var receivedBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	endpoint, err := webhook.NewEndpoint(config.WebhookConfig{Name: "test", URL: server.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatalf("NewEndpoint failed, %s", err)
+	}
+
+	delivery := &webhook.Delivery{ID: "d1", Event: "OCCURRENCE_CREATED", Body: body}
+	if err := endpoint.Post(context.Background(), server.Client(), delivery); err != nil {
+		t.Fatalf("Post failed, %s", err)
+	}
+
+	if string(receivedBody) != string(body) {
+		t.Errorf("Received body %s, expected %s", receivedBody, body)
+	}
+	if received.Header.Get(webhook.DeliveryHeader) != "d1" || received.Header.Get(webhook.EventHeader) != "OCCURRENCE_CREATED" {
+		t.Errorf("Delivery headers not set, got %v", received.Header)
+	}
+	if signature := received.Header.Get(webhook.SignatureHeader); signature != webhook.Sign([]byte("s3cret"), body) {
+		t.Errorf("Received signature %s, expected %s", signature, webhook.Sign([]byte("s3cret"), body))
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := endpoint.Post(context.Background(), server.Client(), delivery); err == nil {
+		t.Error("Expected Post to fail when the endpoint responds with an error")
+	}
+}